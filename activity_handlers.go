@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/group"
+	"splitwise/models"
+)
+
+// recordActivity adds a change to the activity feed of the group with the ID.
+// actor is the ID of the user who made it, or 0 for changes the server made on
+// schedule. The change has already been saved by then, so failing to record
+// it is logged rather than failing the request.
+func recordActivity(groupID, actor int32, verb group.Verb, subject group.Subject, subjectID int, summary string) {
+	activity := group.NewActivity(groupID, actor, verb, subject, subjectID, summary)
+	if err := db.Activities.Add(activity); err != nil {
+		errorLogger.Println("Error recording activity in group", groupID, ":", err)
+	}
+}
+
+// describeExpense names the expense in activity summaries.
+func describeExpense(e *models.Expense) string {
+	if e.Description == "" {
+		return fmt.Sprintf("expense %d of %s %s", e.ID, e.Amount, e.Currency)
+	}
+	return fmt.Sprintf("%q for %s %s", e.Description, e.Amount, e.Currency)
+}
+
+// describePayment names the payment in activity summaries.
+func describePayment(p *models.Payment) string {
+	return fmt.Sprintf("payment of %s %s from %s to %s", p.Amount, p.Currency, p.Payer.Name, p.Payee.Name)
+}
+
+// listGroupActivity handles GET /v1/groups/:id/activity, which lists the
+// changes made in the group in the order they were made. ?actor= only lists
+// those made by the user with that ID, ?subject= those made to one kind of
+// thing, such as expense or member, and ?from= and ?to= those made in a range
+// of time.
+func listGroupActivity(c echo.Context) error {
+	g, err := groupFromParam(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	q := newListQuery(c)
+	actor := q.id("actor")
+	subject := group.Subject(q.c.QueryParam("subject"))
+	timestamp := q.timeRange("from", "to")
+
+	activities, err := db.Activities.List()
+	if err != nil {
+		return internalError("Error listing activity", err)
+	}
+	var matching []*group.Activity
+	for _, a := range activities {
+		if a.GroupID == g.ID && (actor == 0 || a.Actor == actor) && (subject == "" || a.Subject == subject) && timestamp.contains(a.Timestamp) {
+			matching = append(matching, a)
+		}
+	}
+	result, err := paginate(q, matching, activitySorts)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Listing Activity For Group: ", g.ID)
+	return c.JSON(http.StatusOK, result)
+}
+
+// activitySorts are the orders activity can be listed in. IDs are handed out
+// as changes are made, so sorting by ID is sorting by time.
+var activitySorts = sortFields[*group.Activity]{
+	"id": func(a *group.Activity) sortKey { return sortKey{ID: a.ID} },
+}
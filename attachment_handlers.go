@@ -0,0 +1,351 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"splitwise/blob"
+	"splitwise/group"
+	"splitwise/models"
+	"strings"
+	"sync"
+)
+
+// maxAttachmentSize is the largest file that can be attached, and
+// maxUploadSize the largest request body uploading one, which leaves room for
+// the rest of the multipart form.
+const (
+	maxAttachmentSize = 10 << 20
+	maxUploadSize     = maxAttachmentSize + 64<<10
+)
+
+// attachmentTypes are the kinds of file that can be attached: photos of
+// receipts and PDFs.
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// uploadExpenseAttachment handles POST /v1/expenses/:id/attachments.
+func uploadExpenseAttachment(c echo.Context) error { return uploadAttachment(c, expenseEntry) }
+
+// getExpenseAttachment handles GET /v1/expenses/:id/attachments/:attachmentId,
+// which downloads the file.
+func getExpenseAttachment(c echo.Context) error { return downloadAttachment(c, expenseEntry) }
+
+// deleteExpenseAttachment handles DELETE
+// /v1/expenses/:id/attachments/:attachmentId.
+func deleteExpenseAttachment(c echo.Context) error { return removeAttachment(c, expenseEntry) }
+
+// uploadPaymentAttachment handles POST /v1/payments/:id/attachments.
+func uploadPaymentAttachment(c echo.Context) error { return uploadAttachment(c, paymentEntry) }
+
+// getPaymentAttachment handles GET /v1/payments/:id/attachments/:attachmentId,
+// which downloads the file.
+func getPaymentAttachment(c echo.Context) error { return downloadAttachment(c, paymentEntry) }
+
+// deletePaymentAttachment handles DELETE
+// /v1/payments/:id/attachments/:attachmentId.
+func deletePaymentAttachment(c echo.Context) error { return removeAttachment(c, paymentEntry) }
+
+// uploadAttachment adds the uploaded file to the entry's attachments. Only
+// those who can change the entry can attach files to it. Uploading a file
+// that is already attached responds with the existing attachment.
+//
+// Uploads are read and put in the blob store before taking dataMu, which is
+// only held to attach the file, so that slow uploads don't hold up other
+// requests.
+func uploadAttachment(c echo.Context, find func(echo.Context) (*entry, error)) error {
+	dataMu.RLock()
+	err := checkCanAttach(c, find)
+	dataMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	name, contentType, content, err := receiveFile(c)
+	if err != nil {
+		return err
+	}
+	checksum := blob.Checksum(content)
+	uploading.start(checksum)
+	if err := blobs.Put(checksum, content); err != nil {
+		uploading.finish(checksum)
+		return internalError("Error storing attachment content", err)
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+	attachment, created, err := attachFile(c, find, name, contentType, int64(len(content)), checksum)
+	uploading.finish(checksum)
+	if !created {
+		releaseBlob(checksum)
+	}
+	if err != nil {
+		return err
+	}
+	if !created {
+		return c.JSON(http.StatusOK, attachment)
+	}
+	return c.JSON(http.StatusCreated, attachment)
+}
+
+// checkCanAttach returns an error unless the signed in user can attach files
+// to the entry.
+func checkCanAttach(c echo.Context, find func(echo.Context) (*entry, error)) error {
+	e, err := find(c)
+	if err != nil {
+		return err
+	}
+	return authorizeChange(c, e.group, e.payer, nil)
+}
+
+// attachFile attaches the uploaded file, whose content is already in the blob
+// store, to the entry, unless it is already attached. It reports whether it
+// made a new attachment. The caller must hold dataMu.
+func attachFile(c echo.Context, find func(echo.Context) (*entry, error), name, contentType string, size int64, checksum string) (*models.Attachment, bool, error) {
+	// The entry may have changed while the file was uploaded
+	e, err := find(c)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := authorizeChange(c, e.group, e.payer, nil); err != nil {
+		return nil, false, err
+	}
+	for _, existing := range *e.attachments {
+		if existing.Checksum == checksum {
+			return existing, false, nil
+		}
+	}
+
+	state, err := auditState(e.target)
+	if err != nil {
+		return nil, false, auditStateError(err)
+	}
+	attachment := models.NewAttachment(name, contentType, size, checksum, currentUser(c).Id)
+	*e.attachments = append(*e.attachments, attachment)
+	if err := e.save(attachment.UploadedBy, state); err != nil {
+		*e.attachments = (*e.attachments)[:len(*e.attachments)-1]
+		return nil, false, internalError("Error storing attachment", err)
+	}
+	recordActivity(e.group.ID, attachment.UploadedBy, group.Updated, e.subject, e.subjectID, fmt.Sprintf("Attached %s to %s", attachment.Name, e.describe))
+	infoLogger.Println("Attached", attachment.Name, "With Id: ", attachment.ID)
+	return attachment, true, nil
+}
+
+// uploadBlobs counts, by checksum, the uploads whose content is in the blob
+// store but not attached yet, so that releaseBlob leaves it there meanwhile.
+type uploadBlobs struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+var uploading = &uploadBlobs{counts: make(map[string]int)}
+
+// start records that content with the checksum is about to be put in the blob
+// store.
+func (u *uploadBlobs) start(checksum string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.counts[checksum]++
+}
+
+// finish records that the upload has been attached or given up on.
+func (u *uploadBlobs) finish(checksum string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.counts[checksum]--; u.counts[checksum] <= 0 {
+		delete(u.counts, checksum)
+	}
+}
+
+// receiveFile reads the file in the "file" field of a multipart form, and
+// checks its size and what kind of file it is.
+func receiveFile(c echo.Context) (name, contentType string, content []byte, err error) {
+	r := c.Request()
+	r.Body = http.MaxBytesReader(c.Response(), r.Body, maxUploadSize)
+	tooLarge := newAPIError(http.StatusRequestEntityTooLarge, codeInvalid, "file", fmt.Sprintf("Attachments can be at most %d MiB", maxAttachmentSize>>20))
+	header, err := c.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return "", "", nil, tooLarge
+	case errors.Is(err, http.ErrNotMultipart):
+		return "", "", nil, newAPIError(http.StatusUnsupportedMediaType, codeInvalidBody, "", "Request body must be a multipart form")
+	case errors.Is(err, http.ErrMissingFile):
+		return "", "", nil, newAPIError(http.StatusBadRequest, codeRequired, "file", "File is required")
+	case err != nil:
+		return "", "", nil, newAPIError(http.StatusBadRequest, codeInvalidBody, "", err.Error())
+	case header.Size > maxAttachmentSize:
+		return "", "", nil, tooLarge
+	case header.Size == 0:
+		return "", "", nil, invalid("file", "File is empty")
+	}
+	file, err := header.Open()
+	if err != nil {
+		return "", "", nil, internalError("Error reading upload", err)
+	}
+	defer file.Close()
+	if content, err = io.ReadAll(file); err != nil {
+		return "", "", nil, internalError("Error reading upload", err)
+	}
+
+	// The type is worked out from the content, and must agree with what the
+	// client declared, if anything
+	contentType, _, _ = mime.ParseMediaType(http.DetectContentType(content))
+	if !attachmentTypes[contentType] {
+		return "", "", nil, newAPIError(http.StatusUnsupportedMediaType, codeInvalid, "file", "Only JPEG, PNG, GIF and WebP images and PDFs can be attached")
+	}
+	if declared, _, _ := mime.ParseMediaType(header.Header.Get(echo.HeaderContentType)); declared != "" && declared != echo.MIMEOctetStream && declared != contentType {
+		return "", "", nil, invalid("file", fmt.Sprintf("File was sent as %s but is %s", declared, contentType))
+	}
+
+	name = filepath.Base(strings.ReplaceAll(header.Filename, `\`, "/"))
+	if name == "." || name == "/" {
+		name = "attachment"
+	}
+	return name, contentType, content, nil
+}
+
+// downloadAttachment responds with the file of the entry's attachment named
+// by the :attachmentId path parameter.
+func downloadAttachment(c echo.Context, find func(echo.Context) (*entry, error)) error {
+	_, attachment, err := attachmentFromParams(c, find)
+	if err != nil {
+		return err
+	}
+	content, err := blobs.Get(attachment.Checksum)
+	if errors.Is(err, blob.ErrNotFound) {
+		return notFound("attachmentId", fmt.Sprintf("Content of attachment %d not found", attachment.ID))
+	}
+	if err != nil {
+		return internalError("Error reading attachment content", err)
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	return c.Blob(http.StatusOK, attachment.ContentType, content)
+}
+
+// removeAttachment removes the attachment named by the :attachmentId path
+// parameter from the entry. Only those who can change the entry can remove
+// its attachments.
+func removeAttachment(c echo.Context, find func(echo.Context) (*entry, error)) error {
+	e, attachment, err := attachmentFromParams(c, find)
+	if err != nil {
+		return err
+	}
+	if err := authorizeChange(c, e.group, e.payer, nil); err != nil {
+		return err
+	}
+
+	state, err := auditState(e.target)
+	if err != nil {
+		return auditStateError(err)
+	}
+	previous := *e.attachments
+	var kept []*models.Attachment
+	for _, other := range previous {
+		if other != attachment {
+			kept = append(kept, other)
+		}
+	}
+	*e.attachments = kept
+	if err := e.save(currentUser(c).Id, state); err != nil {
+		*e.attachments = previous
+		return internalError("Error storing attachment", err)
+	}
+	releaseBlob(attachment.Checksum)
+	recordActivity(e.group.ID, currentUser(c).Id, group.Updated, e.subject, e.subjectID, fmt.Sprintf("Removed %s from %s", attachment.Name, e.describe))
+	infoLogger.Println("Removed Attachment With Id: ", attachment.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// attachmentFromParams finds the expense or payment named by the :id path
+// parameter and its attachment named by :attachmentId.
+func attachmentFromParams(c echo.Context, find func(echo.Context) (*entry, error)) (*entry, *models.Attachment, error) {
+	e, err := find(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := intParam(c, "attachmentId")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, attachment := range *e.attachments {
+		if attachment.ID == id {
+			return e, attachment, nil
+		}
+	}
+	return nil, nil, notFound("attachmentId", fmt.Sprintf("Attachment %d not found", id))
+}
+
+// releaseBlobs deletes the content of the attachments from the blob store,
+// except for any that is still attached to something else.
+func releaseBlobs(attachments []*models.Attachment) {
+	for _, attachment := range attachments {
+		releaseBlob(attachment.Checksum)
+	}
+}
+
+// releaseBlob deletes the content with the checksum from the blob store,
+// unless it is still attached to an expense or payment. Failing to delete it
+// only leaves an unused file behind, so errors are logged rather than
+// returned.
+func releaseBlob(checksum string) {
+	// Holding uploading.mu keeps an upload from putting the same content in
+	// the store between checking and deleting it
+	uploading.mu.Lock()
+	defer uploading.mu.Unlock()
+	if uploading.counts[checksum] > 0 {
+		return
+	}
+	inUse, err := blobInUse(checksum)
+	if err != nil {
+		errorLogger.Println("Error checking whether attachment content is in use:", err)
+		return
+	}
+	if inUse {
+		return
+	}
+	if err := blobs.Delete(checksum); err != nil {
+		errorLogger.Println("Error deleting attachment content:", err)
+	}
+}
+
+// blobInUse reports whether any expense or payment has an attachment with the
+// checksum.
+func blobInUse(checksum string) (bool, error) {
+	expenses, err := db.Expenses.List()
+	if err != nil {
+		return false, err
+	}
+	for _, expense := range expenses {
+		if hasChecksum(expense.Attachments, checksum) {
+			return true, nil
+		}
+	}
+	payments, err := db.Payments.List()
+	if err != nil {
+		return false, err
+	}
+	for _, payment := range payments {
+		if hasChecksum(payment.Attachments, checksum) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func hasChecksum(attachments []*models.Attachment, checksum string) bool {
+	for _, attachment := range attachments {
+		if attachment.Checksum == checksum {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+// Package audit keeps a tamper-evident log of changes to users, groups,
+// expenses and payments. Every entry carries the hash of the one before it,
+// so changing, removing or reordering an entry breaks the chain from there
+// on, which Verify detects.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Action is what was done to the entity.
+type Action string
+
+const (
+	Created Action = "created"
+	Updated Action = "updated"
+	Deleted Action = "deleted"
+)
+
+// Entity is the kind of thing that was changed.
+type Entity string
+
+const (
+	User    Entity = "user"
+	Group   Entity = "group"
+	Expense Entity = "expense"
+	Payment Entity = "payment"
+)
+
+// Entry records one change. Before and After hold the entity as it was saved,
+// encoded as JSON.
+type Entry struct {
+	Seq       int // Position in the log, counting from 1
+	Timestamp time.Time
+	Actor     int32 `json:",omitempty"` // ID of the user who made the change, left out for changes the server made on schedule
+	Action    Action
+	Entity    Entity
+	EntityID  int
+	Before    json.RawMessage `json:",omitempty"` // Left out for creations
+	After     json.RawMessage `json:",omitempty"` // Left out for deletions
+	PrevHash  string          `json:",omitempty"` // Hash of the entry before, left out for the first
+	Hash      string          // Hash of every other field
+}
+
+// Next returns the entry for a change made just now, chained after prev,
+// which is nil for the first entry of a log.
+func Next(prev *Entry, actor int32, action Action, entity Entity, entityID int, before, after json.RawMessage) *Entry {
+	e := &Entry{
+		Seq: 1,
+		// Databases keep times to the millisecond, and the hash must come
+		// out the same once the entry is read back
+		Timestamp: time.Now().UTC().Truncate(time.Millisecond),
+		Actor:     actor,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Before:    before,
+		After:     after,
+	}
+	if prev != nil {
+		e.Seq, e.PrevHash = prev.Seq+1, prev.Hash
+	}
+	e.Hash = e.Sum()
+	return e
+}
+
+// Sum returns the hash of every field of the entry but Hash, hex encoded.
+func (e *Entry) Sum() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%d\n%s\n%s\n%d\n", e.Seq, e.Timestamp.UTC().Format(time.RFC3339Nano), e.Actor, e.Action, e.Entity, e.EntityID)
+	// Values are prefixed with their length so that no two entries hash the
+	// same input
+	fmt.Fprintf(h, "%d:%s\n%d:%s\n", len(e.Before), e.Before, len(e.After), e.After)
+	fmt.Fprint(h, e.PrevHash)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ChainError reports the first entry of a log that doesn't fit the chain.
+type ChainError struct {
+	Seq    int // Position the entry was found at, counting from 1
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit log entry %d: %s", e.Seq, e.Reason)
+}
+
+// Verify checks that entries, a whole log in order, form an unbroken chain:
+// each is numbered after the one before, points at its hash, and still
+// hashes to its own Hash. It returns a *ChainError otherwise. Entries cut
+// off the end of the log leave an unbroken chain behind, so the hash of the
+// last entry should be kept somewhere else to check against.
+func Verify(entries []*Entry) error {
+	prevHash := ""
+	for i, e := range entries {
+		switch {
+		case e.Seq != i+1:
+			return &ChainError{Seq: i + 1, Reason: fmt.Sprintf("numbered %d, so entries are missing or out of order", e.Seq)}
+		case e.PrevHash != prevHash:
+			return &ChainError{Seq: e.Seq, Reason: "does not follow the entry before it"}
+		case e.Sum() != e.Hash:
+			return &ChainError{Seq: e.Seq, Reason: "has been altered since it was recorded"}
+		}
+		prevHash = e.Hash
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// chain returns a log of three entries: a user signing up, renaming
+// themselves and deleting themselves.
+func chain() []*Entry {
+	created := Next(nil, 1, Created, User, 1, nil, json.RawMessage(`{"id":1,"name":"Alice"}`))
+	updated := Next(created, 1, Updated, User, 1, created.After, json.RawMessage(`{"id":1,"name":"Alicia"}`))
+	deleted := Next(updated, 1, Deleted, User, 1, updated.After, nil)
+	return []*Entry{created, updated, deleted}
+}
+
+func TestNext(t *testing.T) {
+	entries := chain()
+	for i, e := range entries {
+		if e.Seq != i+1 {
+			t.Errorf("entries[%d].Seq = %d, want %d", i, e.Seq, i+1)
+		}
+		if e.Hash == "" || e.Hash != e.Sum() {
+			t.Errorf("entries[%d].Hash = %q, want %q", i, e.Hash, e.Sum())
+		}
+	}
+	if entries[0].PrevHash != "" {
+		t.Errorf("first entry's PrevHash = %q, want none", entries[0].PrevHash)
+	}
+	if entries[2].PrevHash != entries[1].Hash {
+		t.Errorf("PrevHash = %q, want the hash of the entry before, %q", entries[2].PrevHash, entries[1].Hash)
+	}
+	if entries[0].Timestamp.Location() != time.UTC || entries[0].Timestamp.Nanosecond()%int(time.Millisecond) != 0 {
+		t.Errorf("Timestamp = %v, want UTC to the millisecond", entries[0].Timestamp)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(entries []*Entry) []*Entry
+		wantSeq int // 0 if the chain is unbroken
+	}{
+		{name: "Unbroken", tamper: func(entries []*Entry) []*Entry { return entries }},
+		{name: "Empty", tamper: func([]*Entry) []*Entry { return nil }},
+		{name: "Last Entry Cut Off", tamper: func(entries []*Entry) []*Entry { return entries[:2] }},
+		{name: "Value Altered", wantSeq: 2, tamper: func(entries []*Entry) []*Entry {
+			entries[1].After = json.RawMessage(`{"id":1,"name":"Mallory"}`)
+			return entries
+		}},
+		{name: "Actor Altered", wantSeq: 1, tamper: func(entries []*Entry) []*Entry {
+			entries[0].Actor = 2
+			return entries
+		}},
+		{name: "Timestamp Altered", wantSeq: 3, tamper: func(entries []*Entry) []*Entry {
+			entries[2].Timestamp = entries[2].Timestamp.Add(-time.Hour)
+			return entries
+		}},
+		{name: "Entry Rehashed", wantSeq: 3, tamper: func(entries []*Entry) []*Entry {
+			entries[1].After = json.RawMessage(`{"id":1,"name":"Mallory"}`)
+			entries[1].Hash = entries[1].Sum()
+			return entries
+		}},
+		{name: "Entry Removed", wantSeq: 2, tamper: func(entries []*Entry) []*Entry {
+			return []*Entry{entries[0], entries[2]}
+		}},
+		{name: "Entries Swapped", wantSeq: 2, tamper: func(entries []*Entry) []*Entry {
+			return []*Entry{entries[0], entries[2], entries[1]}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.tamper(chain()))
+			var chainErr *ChainError
+			switch {
+			case tt.wantSeq == 0 && err != nil:
+				t.Errorf("Verify() error = %v, want none", err)
+			case tt.wantSeq != 0 && !errors.As(err, &chainErr):
+				t.Errorf("Verify() error = %v, want a *ChainError", err)
+			case tt.wantSeq != 0 && chainErr.Seq != tt.wantSeq:
+				t.Errorf("Verify() error = %v, want one about entry %d", err, tt.wantSeq)
+			}
+		})
+	}
+}
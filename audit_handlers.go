@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/models"
+	"splitwise/store"
+	"strings"
+)
+
+// admins holds the email addresses of the users who administer the server and
+// can read the audit log, from the comma-separated ADMIN_EMAILS. Nobody does
+// unless it is set.
+var admins = map[string]bool{}
+
+// parseAdmins reads a comma-separated list of email addresses.
+func parseAdmins(list string) map[string]bool {
+	emails := make(map[string]bool)
+	for _, email := range strings.Split(list, ",") {
+		if email = normalizeEmail(email); email != "" {
+			emails[email] = true
+		}
+	}
+	return emails
+}
+
+// isAdmin reports whether the user administers the server.
+func isAdmin(user *models.User) bool {
+	return user.Email != "" && admins[user.Email]
+}
+
+// auditTarget returns what the audit log calls v, a user, group, expense or
+// payment, and its state as the log keeps it: its saved form, which refers to
+// other records by ID. Users are kept without their password hash.
+func auditTarget(v interface{}) (entity audit.Entity, id int, state json.RawMessage, err error) {
+	var record interface{}
+	switch v := v.(type) {
+	case *models.User:
+		user := store.NewUserRecord(v)
+		user.PasswordHash = nil
+		entity, id, record = audit.User, int(v.Id), user
+	case *group.Group:
+		entity, id, record = audit.Group, int(v.ID), store.NewGroupRecord(v)
+	case *models.Expense:
+		entity, id, record = audit.Expense, v.ID, store.NewExpenseRecord(v)
+	case *models.Payment:
+		entity, id, record = audit.Payment, v.ID, store.NewPaymentRecord(v)
+	default:
+		panic(fmt.Sprintf("cannot audit changes to %T", v))
+	}
+	if state, err = json.Marshal(record); err != nil {
+		return "", 0, nil, fmt.Errorf("encoding %s %d for the audit log: %w", entity, id, err)
+	}
+	return entity, id, state, nil
+}
+
+// auditState returns the state of v, a user, group, expense or payment, to
+// record with an auditChange as it was before the change.
+func auditState(v interface{}) (json.RawMessage, error) {
+	_, _, state, err := auditTarget(v)
+	return state, err
+}
+
+// auditChange is a change to v, a user, group, expense or payment, for
+// recordAudit. before is v's auditState before the change, nil for creations.
+type auditChange struct {
+	action audit.Action
+	v      interface{}
+	before json.RawMessage
+}
+
+// recordAudit appends the changes, made by the user with the ID actor or by
+// the server on schedule if it is 0, to the audit log of tx. Callers record
+// them in the transaction that saves the changes, so that neither is saved
+// without the other, and hold dataMu, which keeps the log in the order
+// changes were made. The changes are chained here, since reads inside a
+// transaction don't see the entries appended in it.
+func recordAudit(tx *store.Store, actor int32, changes ...auditChange) error {
+	last, err := tx.AuditLog.Last()
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("reading the audit log: %w", err)
+	}
+	for _, change := range changes {
+		entity, id, after, err := auditTarget(change.v)
+		if err != nil {
+			return err
+		}
+		if change.action == audit.Deleted {
+			after = nil
+		}
+		last = audit.Next(last, actor, change.action, entity, id, change.before, after)
+		if err := tx.AuditLog.Append(last); err != nil {
+			return fmt.Errorf("recording %s %s %d in the audit log: %w", change.action, entity, id, err)
+		}
+	}
+	return nil
+}
+
+// auditStateError is returned by handlers that can't encode what they are
+// about to change for the audit log, and so don't change it.
+func auditStateError(err error) error {
+	return internalError("Error recording the change in the audit log", err)
+}
+
+// requireAdmin rejects requests from users who don't administer the server.
+func requireAdmin(c echo.Context) error {
+	if !isAdmin(currentUser(c)) {
+		return forbidden("Only administrators can read the audit log")
+	}
+	return nil
+}
+
+// listAuditLog handles GET /v1/admin/audit, which lists the audit log in the
+// order changes were made. ?actor= only lists changes made by the user with
+// that ID, ?action= and ?entity= those of one kind, such as deleted or
+// expense, ?entityId= those made to the entity with that ID, and ?from= and
+// ?to= those made in a range of time.
+func listAuditLog(c echo.Context) error {
+	if err := requireAdmin(c); err != nil {
+		return err
+	}
+	q := newListQuery(c)
+	actor := q.id("actor")
+	action := audit.Action(c.QueryParam("action"))
+	entity := audit.Entity(c.QueryParam("entity"))
+	entityID := int(q.id("entityId"))
+	timestamp := q.timeRange("from", "to")
+
+	entries, err := db.AuditLog.List()
+	if err != nil {
+		return internalError("Error listing the audit log", err)
+	}
+	var matching []*audit.Entry
+	for _, e := range entries {
+		if (actor == 0 || e.Actor == actor) && (action == "" || e.Action == action) && (entity == "" || e.Entity == entity) &&
+			(entityID == 0 || e.EntityID == entityID) && timestamp.contains(e.Timestamp) {
+			matching = append(matching, e)
+		}
+	}
+	result, err := paginate(q, matching, auditSorts)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Listing Audit Log")
+	return c.JSON(http.StatusOK, result)
+}
+
+// auditSorts are the orders the audit log can be listed in. Entries are
+// numbered as changes are made, so sorting by their sequence number as ID is
+// sorting by time.
+var auditSorts = sortFields[*audit.Entry]{
+	"id": func(e *audit.Entry) sortKey { return sortKey{ID: e.Seq} },
+}
+
+// auditReport is the response body of verifyAuditLog.
+type auditReport struct {
+	Valid    bool
+	Entries  int               // Number of entries checked
+	LastHash string            `json:",omitempty"` // Hash of the last entry, to keep elsewhere and check later logs against
+	Problem  *audit.ChainError `json:",omitempty"` // First entry that breaks the chain, if any
+}
+
+// verifyAuditLog handles GET /v1/admin/audit/verify, which checks that no
+// entry of the audit log has been altered, removed or reordered.
+func verifyAuditLog(c echo.Context) error {
+	if err := requireAdmin(c); err != nil {
+		return err
+	}
+	entries, err := db.AuditLog.List()
+	if err != nil {
+		return internalError("Error listing the audit log", err)
+	}
+	report := auditReport{Valid: true, Entries: len(entries)}
+	if len(entries) > 0 {
+		report.LastHash = entries[len(entries)-1].Hash
+	}
+	if err := audit.Verify(entries); err != nil {
+		report.Valid = false
+		if !errors.As(err, &report.Problem) {
+			return internalError("Error verifying the audit log", err)
+		}
+		warnLogger.Println("Audit Log Failed Verification:", err)
+	}
+	infoLogger.Println("Verified Audit Log")
+	return c.JSON(http.StatusOK, report)
+}
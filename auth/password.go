@@ -0,0 +1,58 @@
+// Package auth hashes passwords and issues the signed tokens that
+// authenticate requests.
+package auth
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"sync"
+)
+
+// MinPasswordLength is the shortest password HashPassword accepts.
+const MinPasswordLength = 8
+
+// PasswordCost is the bcrypt cost passwords are hashed with. Tests lower it to
+// keep hashing fast.
+var PasswordCost = bcrypt.DefaultCost
+
+// dummyHash is what passwords are compared against when there is no hash to
+// check them against, so that failing takes as long as a wrong password does.
+// It is made on first use, at PasswordCost.
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+var (
+	// ErrPasswordTooShort is returned when hashing a password shorter than
+	// MinPasswordLength.
+	ErrPasswordTooShort = errors.New("password must be at least 8 characters")
+	// ErrWrongPassword is returned when a password doesn't match its hash.
+	ErrWrongPassword = errors.New("wrong password")
+)
+
+// HashPassword returns the bcrypt hash of the password.
+func HashPassword(password string) ([]byte, error) {
+	if len(password) < MinPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+}
+
+// CheckPassword returns ErrWrongPassword unless password matches the hash.
+// Users without a hash, and users that don't exist, whose hash is nil, can't
+// sign in with any password. Checking takes as long for them as for anyone
+// else, so that it doesn't reveal who has signed up.
+func CheckPassword(hash []byte, password string) error {
+	if len(hash) == 0 {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("no password"), PasswordCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return ErrWrongPassword
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	PasswordCost = bcrypt.MinCost
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if err := CheckPassword(hash, "correct horse"); err != nil {
+		t.Errorf("CheckPassword() of the right password error = %v", err)
+	}
+	if err := CheckPassword(hash, "wrong horse"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("CheckPassword() of a wrong password error = %v, want %v", err, ErrWrongPassword)
+	}
+	if err := CheckPassword(nil, ""); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("CheckPassword() without a hash error = %v, want %v", err, ErrWrongPassword)
+	}
+	if _, err := HashPassword("short"); !errors.Is(err, ErrPasswordTooShort) {
+		t.Errorf("HashPassword() of a short password error = %v, want %v", err, ErrPasswordTooShort)
+	}
+}
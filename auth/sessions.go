@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, badly signed or
+// expired, or whose session has ended.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Token types, so that an access token can't refresh a session and a refresh
+// token can't authenticate a request.
+const (
+	accessToken  = "access"
+	refreshToken = "refresh"
+)
+
+// Tokens are HS256 JSON Web Tokens, and every one has this header.
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// claims is the payload of a token.
+type claims struct {
+	Subject   int32  `json:"sub"` // ID of the signed in user
+	Session   string `json:"sid"`
+	Type      string `json:"typ"`
+	ID        string `json:"jti"` // Tells refresh tokens apart, so each is only used once
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Tokens are returned when a user signs in or refreshes their session.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	TokenType    string // Always "Bearer"
+	ExpiresIn    int    // Seconds until AccessToken expires
+}
+
+// Sessions issues and checks the tokens of signed in users. Access tokens
+// authenticate requests and are short lived. Refresh tokens last longer and
+// are exchanged for new tokens, each one only once: using one a second time
+// ends the session, since it means the token was copied.
+//
+// Sessions are kept in memory, so they all end when the process exits. Every
+// method is safe for concurrent use.
+type Sessions struct {
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+
+	key []byte
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+type session struct {
+	user    int32
+	refresh string    // ID of the only refresh token that can still be used
+	expires time.Time // When that refresh token expires
+}
+
+// NewSessions returns Sessions that sign tokens with key.
+func NewSessions(key []byte) *Sessions {
+	return &Sessions{
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 30 * 24 * time.Hour,
+		key:        key,
+		now:        time.Now,
+		sessions:   make(map[string]*session),
+	}
+}
+
+// NewKey returns a random key for signing tokens.
+func NewKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Start signs the user in, starting a new session.
+func (s *Sessions) Start(userID int32) (Tokens, error) {
+	id, err := randomID()
+	if err != nil {
+		return Tokens{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	session := &session{user: userID}
+	s.sessions[id] = session
+	return s.issue(id, session)
+}
+
+// Authenticate returns the user and session an access token belongs to.
+func (s *Sessions) Authenticate(token string) (userID int32, sessionID string, err error) {
+	c, err := s.verify(token, accessToken)
+	if err != nil {
+		return 0, "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[c.Session]; !ok {
+		return 0, "", ErrInvalidToken
+	}
+	return c.Subject, c.Session, nil
+}
+
+// Refresh exchanges a refresh token for new tokens in the same session.
+func (s *Sessions) Refresh(token string) (Tokens, error) {
+	c, err := s.verify(token, refreshToken)
+	if err != nil {
+		return Tokens{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[c.Session]
+	if !ok {
+		return Tokens{}, ErrInvalidToken
+	}
+	if session.refresh != c.ID {
+		delete(s.sessions, c.Session)
+		return Tokens{}, ErrInvalidToken
+	}
+	return s.issue(c.Session, session)
+}
+
+// End signs the session out, so none of its tokens work any more.
+func (s *Sessions) End(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+}
+
+// EndAll signs the user out of every session.
+func (s *Sessions) EndAll(userID int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if session.user == userID {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// issue returns new tokens for the session, and makes the new refresh token
+// the only one that can refresh it. The caller must hold s.mu.
+func (s *Sessions) issue(sessionID string, session *session) (Tokens, error) {
+	refreshID, err := randomID()
+	if err != nil {
+		return Tokens{}, err
+	}
+	now := s.now()
+	access := claims{Subject: session.user, Session: sessionID, Type: accessToken, IssuedAt: now.Unix(), ExpiresAt: now.Add(s.AccessTTL).Unix()}
+	refresh := claims{Subject: session.user, Session: sessionID, Type: refreshToken, ID: refreshID, IssuedAt: now.Unix(), ExpiresAt: now.Add(s.RefreshTTL).Unix()}
+	session.refresh, session.expires = refreshID, now.Add(s.RefreshTTL)
+	return Tokens{
+		AccessToken:  s.sign(access),
+		RefreshToken: s.sign(refresh),
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.AccessTTL / time.Second),
+	}, nil
+}
+
+// prune forgets sessions that can no longer be refreshed. The caller must
+// hold s.mu.
+func (s *Sessions) prune() {
+	now := s.now()
+	for id, session := range s.sessions {
+		if !now.Before(session.expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (s *Sessions) sign(c claims) string {
+	payload, _ := json.Marshal(c)
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(s.signature(unsigned))
+}
+
+func (s *Sessions) signature(unsigned string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+// verify checks the token's signature, type and expiry, and returns its
+// claims.
+func (s *Sessions) verify(token, tokenType string) (claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return claims{}, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, s.signature(parts[0]+"."+parts[1])) {
+		return claims{}, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims{}, ErrInvalidToken
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return claims{}, ErrInvalidToken
+	}
+	if c.Type != tokenType || s.now().Unix() >= c.ExpiresAt {
+		return claims{}, ErrInvalidToken
+	}
+	return c, nil
+}
+
+func randomID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("generating an ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
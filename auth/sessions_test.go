@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSessions(now *time.Time) *Sessions {
+	s := NewSessions([]byte("test key"))
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func TestSessions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newTestSessions(&now)
+
+	tokens, err := s.Start(7)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if tokens.TokenType != "Bearer" || tokens.ExpiresIn != 900 {
+		t.Errorf("Start() = %+v, want Bearer tokens expiring in 900 seconds", tokens)
+	}
+	userID, sessionID, err := s.Authenticate(tokens.AccessToken)
+	if err != nil || userID != 7 || sessionID == "" {
+		t.Fatalf("Authenticate() = %d, %q, %v, want user 7", userID, sessionID, err)
+	}
+	if _, _, err := s.Authenticate(tokens.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authenticate() of a refresh token error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	// Access tokens expire, and refreshing issues new ones in the same session
+	now = now.Add(s.AccessTTL)
+	if _, _, err := s.Authenticate(tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authenticate() of an expired token error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := s.Refresh(tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Refresh() of an access token error = %v, want %v", err, ErrInvalidToken)
+	}
+	refreshed, err := s.Refresh(tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if _, gotSession, err := s.Authenticate(refreshed.AccessToken); err != nil || gotSession != sessionID {
+		t.Errorf("Authenticate() after Refresh() = %q, %v, want session %q", gotSession, err, sessionID)
+	}
+
+	// Using a refresh token twice ends the session
+	if _, err := s.Refresh(tokens.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("second Refresh() with the same token error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, _, err := s.Authenticate(refreshed.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authenticate() after reusing a refresh token error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestSessions_End(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newTestSessions(&now)
+
+	first, _ := s.Start(1)
+	second, _ := s.Start(1)
+	other, _ := s.Start(2)
+	_, firstSession, _ := s.Authenticate(first.AccessToken)
+
+	s.End(firstSession)
+	if _, _, err := s.Authenticate(first.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authenticate() after End() error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := s.Refresh(first.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Refresh() after End() error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, _, err := s.Authenticate(second.AccessToken); err != nil {
+		t.Errorf("Authenticate() of another session error = %v", err)
+	}
+
+	s.EndAll(1)
+	if _, _, err := s.Authenticate(second.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authenticate() after EndAll() error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, _, err := s.Authenticate(other.AccessToken); err != nil {
+		t.Errorf("Authenticate() of another user after EndAll() error = %v", err)
+	}
+}
+
+func TestSessions_Tampering(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newTestSessions(&now)
+	tokens, _ := s.Start(1)
+	parts := strings.Split(tokens.AccessToken, ".")
+
+	forged := NewSessions([]byte("another key"))
+	forged.now = s.now
+	forgedTokens, _ := forged.Start(1)
+
+	for name, token := range map[string]string{
+		"empty":          "",
+		"not a token":    "abc",
+		"other key":      forgedTokens.AccessToken,
+		"swapped claims": parts[0] + "." + strings.Split(forgedTokens.AccessToken, ".")[1] + "." + parts[2],
+		"no signature":   parts[0] + "." + parts[1] + ".",
+	} {
+		if _, _, err := s.Authenticate(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Authenticate() of %s error = %v, want %v", name, err, ErrInvalidToken)
+		}
+	}
+}
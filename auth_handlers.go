@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/auth"
+	"splitwise/models"
+	"strings"
+)
+
+// Keys of the signed in user and their session in the echo.Context.
+const (
+	userKey    = "user"
+	sessionKey = "session"
+)
+
+// authenticate rejects requests without a valid access token, and makes the
+// signed in user available to handlers through currentUser.
+func authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !ok {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+			return unauthorized("Sign in and send the access token as Authorization: Bearer <token>")
+		}
+		userID, sessionID, err := sessions.Authenticate(token)
+		var user *models.User
+		if err == nil {
+			user = findUserByID(userID)
+		}
+		if user == nil {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return unauthorized("Access token is invalid or has expired")
+		}
+		c.Set(userKey, user)
+		c.Set(sessionKey, sessionID)
+		return next(c)
+	}
+}
+
+// currentUser returns the user who signed the request.
+func currentUser(c echo.Context) *models.User {
+	return c.Get(userKey).(*models.User)
+}
+
+// login handles POST /v1/auth/login, which signs the user in with their email
+// and password.
+func login(c echo.Context) error {
+	var req loginRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	// The password is checked even when nobody has the email, so that how
+	// long failing takes doesn't give away which emails are signed up. It is
+	// checked without holding dataMu, so that signing in doesn't hold up
+	// other requests.
+	var userID int32
+	var hash []byte
+	dataMu.RLock()
+	if user := findUserByEmail(normalizeEmail(req.Email)); user != nil {
+		userID, hash = user.Id, user.PasswordHash
+	}
+	dataMu.RUnlock()
+	if auth.CheckPassword(hash, req.Password) != nil {
+		return unauthorized("Email or password is wrong")
+	}
+
+	tokens, err := sessions.Start(userID)
+	if err != nil {
+		return internalError("Error starting session", err)
+	}
+	infoLogger.Println("Signed In User With Id: ", userID)
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// refreshSession handles POST /v1/auth/refresh, which exchanges a refresh
+// token for new tokens.
+func refreshSession(c echo.Context) error {
+	var req refreshRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	tokens, err := sessions.Refresh(req.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		return unauthorized("Refresh token is invalid or has expired")
+	} else if err != nil {
+		return internalError("Error refreshing session", err)
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// logout handles POST /v1/auth/logout, which ends the session the request was
+// signed with.
+func logout(c echo.Context) error {
+	sessions.End(c.Get(sessionKey).(string))
+	infoLogger.Println("Signed Out User With Id: ", currentUser(c).Id)
+	return c.NoContent(http.StatusNoContent)
+}
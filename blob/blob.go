@@ -0,0 +1,155 @@
+// Package blob stores the content of attachments, such as photos of receipts,
+// by the SHA-256 checksum of the content. Identical files are only stored
+// once, however many expenses and payments they are attached to.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNotFound is returned when getting content that isn't stored.
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps content by its checksum. Putting content that is already stored
+// does nothing.
+type Store interface {
+	Put(checksum string, content []byte) error
+	Get(checksum string) ([]byte, error)
+	Delete(checksum string) error
+}
+
+// Checksum returns the hex encoded SHA-256 checksum of the content, which is
+// what stores keep it under.
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// validChecksum reports whether s looks like a checksum made by Checksum, so
+// that it is safe to use in a file name.
+func validChecksum(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == 2*sha256.Size
+}
+
+var errInvalidChecksum = errors.New("invalid checksum")
+
+// Memory is a Store that keeps content in memory, for when nothing needs to
+// outlive the process.
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{blobs: make(map[string][]byte)}
+}
+
+func (m *Memory) Put(checksum string, content []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[checksum]; !ok {
+		m.blobs[checksum] = append([]byte(nil), content...)
+	}
+	return nil
+}
+
+func (m *Memory) Get(checksum string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	content, ok := m.blobs[checksum]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return content, nil
+}
+
+func (m *Memory) Delete(checksum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, checksum)
+	return nil
+}
+
+// Dir is a Store that keeps each blob in a file of a local directory, named
+// after its checksum and spread over subdirectories by its first two
+// characters.
+type Dir struct {
+	path string
+}
+
+// NewDir returns a store keeping files in the directory at path, creating it
+// if needed.
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path}, nil
+}
+
+func (d *Dir) file(checksum string) (string, error) {
+	if !validChecksum(checksum) {
+		return "", errInvalidChecksum
+	}
+	return filepath.Join(d.path, checksum[:2], checksum), nil
+}
+
+// Put writes the content to a temporary file first and renames it into place,
+// so that a crash never leaves part of a blob behind.
+func (d *Dir) Put(checksum string, content []byte) error {
+	path, err := d.file(checksum)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), checksum+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d *Dir) Get(checksum string) ([]byte, error) {
+	path, err := d.file(checksum)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return content, err
+}
+
+func (d *Dir) Delete(checksum string) error {
+	path, err := d.file(checksum)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
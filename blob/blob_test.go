@@ -0,0 +1,66 @@
+package blob
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	dir, err := NewDir(filepath.Join(t.TempDir(), "blobs"))
+	if err != nil {
+		t.Fatalf("NewDir() error = %v", err)
+	}
+	for name, s := range map[string]Store{"Memory": NewMemory(), "Dir": dir} {
+		t.Run(name, func(t *testing.T) {
+			content := []byte("%PDF-1.4 receipt")
+			checksum := Checksum(content)
+			if _, err := s.Get(checksum); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() before Put() error = %v, want %v", err, ErrNotFound)
+			}
+			for i := 0; i < 2; i++ {
+				if err := s.Put(checksum, content); err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+			}
+			if got, err := s.Get(checksum); err != nil || string(got) != string(content) {
+				t.Errorf("Get() = %q, %v, want %q", got, err, content)
+			}
+			if err := s.Delete(checksum); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := s.Get(checksum); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() after Delete() error = %v, want %v", err, ErrNotFound)
+			}
+			if err := s.Delete(checksum); err != nil {
+				t.Errorf("second Delete() error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestDir_FileLayout(t *testing.T) {
+	path := t.TempDir()
+	d, err := NewDir(path)
+	if err != nil {
+		t.Fatalf("NewDir() error = %v", err)
+	}
+	content := []byte("receipt")
+	checksum := Checksum(content)
+	if err := d.Put(checksum, content); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(path, checksum[:2]))
+	if err != nil || len(entries) != 1 || entries[0].Name() != checksum {
+		t.Errorf("files = %v, %v, want just %s", entries, err, checksum)
+	}
+
+	// Keys that aren't checksums could name files outside the directory
+	if err := d.Put("../../etc/passwd", content); err == nil {
+		t.Error("Put() of a path succeeded, want an error")
+	}
+	if _, err := d.Get("ab"); err == nil {
+		t.Error("Get() of a short key succeeded, want an error")
+	}
+}
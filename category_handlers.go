@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/group"
+)
+
+// groupCategories is the response body of getGroupCategories and
+// updateGroupCategories.
+type groupCategories struct {
+	Group      int32 // ID of the group
+	Categories []string
+	Rules      []group.CategoryRule // Tried in order on expenses added without a category
+}
+
+func newGroupCategories(g *group.Group) groupCategories {
+	return groupCategories{Group: g.ID, Categories: g.Categories, Rules: g.CategoryRules}
+}
+
+// getGroupCategories handles GET /v1/groups/:id/categories.
+func getGroupCategories(c echo.Context) error {
+	g, err := groupFromParam(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Retrieved Categories For Group: ", g.ID)
+	return c.JSON(http.StatusOK, newGroupCategories(g))
+}
+
+// updateGroupCategories handles PUT /v1/groups/:id/categories, which replaces
+// the group's categories and categorization rules. Expenses keep the
+// categories they already have, even ones the group no longer has.
+func updateGroupCategories(c echo.Context) error {
+	g, err := groupFromParam(c, manageCategories)
+	if err != nil {
+		return err
+	}
+	req := categoriesRequest{group: g}
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	state, err := auditState(g)
+	if err != nil {
+		return auditStateError(err)
+	}
+	categories, rules := g.Categories, g.CategoryRules
+	g.Categories, g.CategoryRules = req.categories, req.rules
+	if err := saveGroup(currentUser(c).Id, g, state); err != nil {
+		g.Categories, g.CategoryRules = categories, rules
+		return internalError("Error storing group", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Updated, group.GroupSubject, int(g.ID), "Changed the categories and the rules that assign them")
+	infoLogger.Println("Updated Categories For Group: ", g.ID)
+	return c.JSON(http.StatusOK, newGroupCategories(g))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/group"
+	"splitwise/models"
+)
+
+// entry is an expense or payment as the handlers of its comments and
+// attachments see it: what they need to authorize, save and record changes to
+// them.
+type entry struct {
+	group       *group.Group
+	payer       *models.User
+	comments    *[]*models.Comment
+	attachments *[]*models.Attachment
+	subject     group.Subject // What the entry is, for the activity feed
+	subjectID   int
+	describe    string
+	// save stores a change the user with the ID actor made to the entry,
+	// together with its entry in the audit log. state is the target's
+	// auditState before the change.
+	save   func(actor int32, state json.RawMessage) error
+	target interface{} // The expense or payment itself, for the audit log
+}
+
+// expenseEntry returns the expense named by the :id path parameter.
+func expenseEntry(c echo.Context) (*entry, error) {
+	expense, g, err := expenseFromParam(c)
+	if err != nil {
+		return nil, err
+	}
+	return &entry{
+		group:       g,
+		payer:       expense.PaidBy,
+		comments:    &expense.Comments,
+		attachments: &expense.Attachments,
+		subject:     group.ExpenseSubject,
+		subjectID:   expense.ID,
+		describe:    describeExpense(expense),
+		save:        func(actor int32, state json.RawMessage) error { return saveExpense(actor, expense, state) },
+		target:      expense,
+	}, nil
+}
+
+// paymentEntry returns the payment named by the :id path parameter.
+func paymentEntry(c echo.Context) (*entry, error) {
+	payment, g, err := paymentFromParam(c)
+	if err != nil {
+		return nil, err
+	}
+	return &entry{
+		group:       g,
+		payer:       payment.Payer,
+		comments:    &payment.Comments,
+		attachments: &payment.Attachments,
+		subject:     group.PaymentSubject,
+		subjectID:   payment.ID,
+		describe:    describePayment(payment),
+		save:        func(actor int32, state json.RawMessage) error { return savePayment(actor, payment, state) },
+		target:      payment,
+	}, nil
+}
+
+// listExpenseComments handles GET /v1/expenses/:id/comments.
+func listExpenseComments(c echo.Context) error { return listComments(c, expenseEntry) }
+
+// addExpenseComment handles POST /v1/expenses/:id/comments.
+func addExpenseComment(c echo.Context) error { return addComment(c, expenseEntry) }
+
+// editExpenseComment handles PUT /v1/expenses/:id/comments/:commentId.
+func editExpenseComment(c echo.Context) error { return editComment(c, expenseEntry) }
+
+// deleteExpenseComment handles DELETE /v1/expenses/:id/comments/:commentId.
+func deleteExpenseComment(c echo.Context) error { return deleteComment(c, expenseEntry) }
+
+// listPaymentComments handles GET /v1/payments/:id/comments.
+func listPaymentComments(c echo.Context) error { return listComments(c, paymentEntry) }
+
+// addPaymentComment handles POST /v1/payments/:id/comments.
+func addPaymentComment(c echo.Context) error { return addComment(c, paymentEntry) }
+
+// editPaymentComment handles PUT /v1/payments/:id/comments/:commentId.
+func editPaymentComment(c echo.Context) error { return editComment(c, paymentEntry) }
+
+// deletePaymentComment handles DELETE /v1/payments/:id/comments/:commentId.
+func deletePaymentComment(c echo.Context) error { return deleteComment(c, paymentEntry) }
+
+// commentSorts are the orders comments can be listed in.
+var commentSorts = sortFields[*models.Comment]{
+	"id": func(comment *models.Comment) sortKey { return sortKey{ID: comment.ID} },
+}
+
+// listComments lists the entry's comments, oldest first unless ?sort= says
+// otherwise. ?author= only lists those written by the user with that ID.
+func listComments(c echo.Context, find func(echo.Context) (*entry, error)) error {
+	e, err := find(c)
+	if err != nil {
+		return err
+	}
+	q := newListQuery(c)
+	author := q.id("author")
+	var matching []*models.Comment
+	for _, comment := range *e.comments {
+		if author == 0 || comment.Author == author {
+			matching = append(matching, comment)
+		}
+	}
+	result, err := paginate(q, matching, commentSorts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// addComment adds a comment by the signed in user to the end of the entry's
+// comments.
+func addComment(c echo.Context, find func(echo.Context) (*entry, error)) error {
+	e, err := find(c)
+	if err != nil {
+		return err
+	}
+	if err := authorize(c, e.group, addComments, nil); err != nil {
+		return err
+	}
+	var req commentRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	author := currentUser(c).Id
+	state, err := auditState(e.target)
+	if err != nil {
+		return auditStateError(err)
+	}
+	comment := models.NewComment(author, req.Body)
+	*e.comments = append(*e.comments, comment)
+	if err := e.save(author, state); err != nil {
+		*e.comments = (*e.comments)[:len(*e.comments)-1]
+		return internalError("Error storing comment", err)
+	}
+	recordActivity(e.group.ID, author, group.Created, group.CommentSubject, comment.ID, "Commented on "+e.describe)
+	infoLogger.Println("Added Comment With Id: ", comment.ID)
+	return c.JSON(http.StatusCreated, comment)
+}
+
+// editComment replaces the body of the comment named by the :commentId path
+// parameter. Only its author can edit it.
+func editComment(c echo.Context, find func(echo.Context) (*entry, error)) error {
+	e, comment, err := commentFromParams(c, find)
+	if err != nil {
+		return err
+	}
+	user := currentUser(c)
+	if comment.Author != user.Id {
+		return forbidden("Only the author of a comment can edit it")
+	}
+	var req commentRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	state, err := auditState(e.target)
+	if err != nil {
+		return auditStateError(err)
+	}
+	previous := *comment
+	comment.Edit(req.Body)
+	if err := e.save(user.Id, state); err != nil {
+		*comment = previous
+		return internalError("Error storing comment", err)
+	}
+	recordActivity(e.group.ID, user.Id, group.Updated, group.CommentSubject, comment.ID, "Edited a comment on "+e.describe)
+	infoLogger.Println("Edited Comment With Id: ", comment.ID)
+	return c.JSON(http.StatusOK, comment)
+}
+
+// deleteComment removes the comment named by the :commentId path parameter
+// from the entry. Authors can delete their own comments, and admins anyone's.
+func deleteComment(c echo.Context, find func(echo.Context) (*entry, error)) error {
+	e, comment, err := commentFromParams(c, find)
+	if err != nil {
+		return err
+	}
+	user := currentUser(c)
+	if comment.Author != user.Id {
+		if err := authorize(c, e.group, moderateComments, nil); err != nil {
+			return err
+		}
+	}
+
+	state, err := auditState(e.target)
+	if err != nil {
+		return auditStateError(err)
+	}
+	previous := *e.comments
+	var kept []*models.Comment
+	for _, other := range previous {
+		if other != comment {
+			kept = append(kept, other)
+		}
+	}
+	*e.comments = kept
+	if err := e.save(user.Id, state); err != nil {
+		*e.comments = previous
+		return internalError("Error storing comment", err)
+	}
+	recordActivity(e.group.ID, user.Id, group.Deleted, group.CommentSubject, comment.ID, "Deleted a comment on "+e.describe)
+	infoLogger.Println("Deleted Comment With Id: ", comment.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// commentFromParams finds the expense or payment named by the :id path
+// parameter and its comment named by :commentId.
+func commentFromParams(c echo.Context, find func(echo.Context) (*entry, error)) (*entry, *models.Comment, error) {
+	e, err := find(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := intParam(c, "commentId")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, comment := range *e.comments {
+		if comment.ID == id {
+			return e, comment, nil
+		}
+	}
+	return nil, nil, notFound("commentId", fmt.Sprintf("Comment %d not found", id))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+// Error codes let clients tell errors apart without parsing messages.
+const (
+	codeInvalidBody  = "invalid_body" // The body isn't valid JSON or has the wrong types
+	codeRequired     = "required"     // A required field is missing
+	codeInvalid      = "invalid"      // A field has a value that isn't allowed
+	codeNotFound     = "not_found"    // Something the request refers to doesn't exist
+	codeConflict     = "conflict"     // The request conflicts with the current state
+	codeUnauthorized = "unauthorized" // The request isn't signed in, or the credentials are wrong
+	codeForbidden    = "forbidden"    // The signed in user isn't allowed to do this
+	codeInternal     = "internal"     // Something went wrong on the server
+)
+
+// fieldError is one problem with a request. Field is the path to the field
+// in the request, such as "splitBetween[2]" or "items[0].consumers[1]", and is
+// empty for problems that aren't about a single field.
+type fieldError struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// errorResponse is the body of every error response.
+type errorResponse struct {
+	Errors []fieldError `json:"errors"`
+}
+
+// apiError is returned by handlers to respond with an HTTP status and the
+// problems that caused it. handleError writes it out.
+type apiError struct {
+	Status int
+	Errors []fieldError
+}
+
+func (e *apiError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		messages[i] = fe.Message
+		if fe.Field != "" {
+			messages[i] = fe.Field + ": " + fe.Message
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// newAPIError returns an error responding with a single problem.
+func newAPIError(status int, code, field, message string) *apiError {
+	return &apiError{Status: status, Errors: []fieldError{{Code: code, Field: field, Message: message}}}
+}
+
+func notFound(field, message string) *apiError {
+	return newAPIError(http.StatusNotFound, codeNotFound, field, message)
+}
+
+func invalid(field, message string) *apiError {
+	return newAPIError(http.StatusBadRequest, codeInvalid, field, message)
+}
+
+func conflict(field, message string) *apiError {
+	return newAPIError(http.StatusConflict, codeConflict, field, message)
+}
+
+func unauthorized(message string) *apiError {
+	return newAPIError(http.StatusUnauthorized, codeUnauthorized, "", message)
+}
+
+func forbidden(message string) *apiError {
+	return newAPIError(http.StatusForbidden, codeForbidden, "", message)
+}
+
+// internalError logs err and returns an error responding with 500. Only
+// context is sent to the client, since err can give away details of the store.
+func internalError(context string, err error) *apiError {
+	errorLogger.Println(context+":", err)
+	return newAPIError(http.StatusInternalServerError, codeInternal, "", context)
+}
+
+// handleError is the server's echo.HTTPErrorHandler. It writes every error,
+// including Echo's own such as unknown routes, as an errorResponse.
+func handleError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var response *apiError
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &response):
+	case errors.As(err, &httpErr):
+		code := strings.ToLower(strings.ReplaceAll(http.StatusText(httpErr.Code), " ", "_"))
+		response = newAPIError(httpErr.Code, code, "", fmt.Sprint(httpErr.Message))
+	default:
+		response = internalError("Unhandled error", err)
+	}
+
+	if len(response.Errors) > 0 {
+		warnLogger.Println("Request failed:", response)
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(response.Status)
+	} else {
+		err = c.JSON(response.Status, errorResponse{Errors: response.Errors})
+	}
+	if err != nil {
+		errorLogger.Println("Error writing error response:", err)
+	}
+}
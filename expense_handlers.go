@@ -0,0 +1,572 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"slices"
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/journal"
+	"splitwise/models"
+	"splitwise/store"
+	"strings"
+	"time"
+)
+
+func createExpense(c echo.Context) error {
+	// Find the group
+	g, err := groupFromParam(c, addEntries)
+	if err != nil {
+		return err
+	}
+
+	// Create the expense, paid by the signed in user and shared by members
+	req := expenseRequest{paidBy: currentUser(c), group: g}
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	expense := req.expense
+
+	// Save the expense together with the group it is added to
+	actor := currentUser(c).Id
+	state, err := auditState(g)
+	if err != nil {
+		return auditStateError(err)
+	}
+	g.AddExpense(expense)
+	if err := db.Transaction(func(tx *store.Store) error {
+		if err := tx.Expenses.Add(expense); err != nil {
+			return err
+		}
+		if err := tx.Groups.Update(g); err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditChange{audit.Created, expense, nil}, auditChange{audit.Updated, g, state})
+	}); err != nil {
+		g.RemoveExpense(expense.ID)
+		return internalError("Error storing expense", err)
+	}
+
+	// Post the expense to the journal, which updates the balances. An expense
+	// that can't be posted is taken back out of the store.
+	if _, err := balanceJournal.PostExpense(g.ID, g.Currency, expense); err != nil {
+		if err := removeExpense(actor, expense, g); err != nil {
+			errorLogger.Println("Error removing expense", expense.ID, "that couldn't be posted:", err)
+		}
+		return internalError("Error posting expense to journal in CreateExpense", err)
+	}
+
+	recordActivity(g.ID, currentUser(c).Id, group.Created, group.ExpenseSubject, expense.ID, "Added "+describeExpense(expense))
+	infoLogger.Println("Added Expense to Group:", g.Name)
+	return c.JSON(http.StatusCreated, expense)
+}
+
+func getExpense(c echo.Context) error {
+	expense, _, err := expenseFromParam(c)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Retrieved Expense With Id: ", expense.ID)
+	return c.JSON(http.StatusOK, expense)
+}
+
+// replaceExpense handles PUT /v1/expenses/:id, which replaces every detail of the
+// expense with the request body.
+func replaceExpense(c echo.Context) error {
+	expense, g, err := expenseFromParam(c)
+	if err != nil {
+		return err
+	}
+	return updateExpense(c, expense, g, &expenseRequest{paidBy: expense.PaidBy})
+}
+
+// patchExpense handles PATCH /v1/expenses/:id, which only changes the fields in
+// the request body and keeps the rest of the expense as it is.
+func patchExpense(c echo.Context) error {
+	expense, g, err := expenseFromParam(c)
+	if err != nil {
+		return err
+	}
+	return updateExpense(c, expense, g, newExpenseRequest(expense))
+}
+
+// updateExpense applies the details bound into req to the expense and saves
+// it, then reverses its effect on balances in the journal and posts the
+// updated expense.
+func updateExpense(c echo.Context, expense *models.Expense, g *group.Group, req *expenseRequest) error {
+	if err := authorizeChange(c, g, expense.PaidBy, nil); err != nil {
+		return err
+	}
+	req.group, req.current = g, expense
+	if err := bindRequest(c, req); err != nil {
+		return err
+	}
+
+	if expense.IsPartiallySettled() {
+		return conflict("", fmt.Sprintf("Cannot update expense %d: %v", expense.ID, models.ErrExpenseSettled))
+	}
+	actor := currentUser(c).Id
+	state, err := auditState(expense)
+	if err != nil {
+		return auditStateError(err)
+	}
+	previous := *expense
+	if err := expense.Update(req.expense); err != nil {
+		return conflict("", err.Error())
+	}
+	if err := saveExpense(actor, expense, state); err != nil {
+		*expense = previous
+		return internalError("Error storing expense", err)
+	}
+
+	// The journal only changes once the new details are saved. If they can't
+	// be posted, the expense goes back to how it was, in memory, in the store
+	// and in the journal
+	reversals, err := balanceJournal.Reverse(journal.ExpenseEntry, expense.ID)
+	if err == nil {
+		_, err = balanceJournal.PostExpense(g.ID, g.Currency, expense)
+	}
+	if err != nil {
+		updated, stateErr := auditState(expense)
+		*expense = previous
+		if len(reversals) > 0 {
+			if _, err := balanceJournal.PostExpense(g.ID, g.Currency, expense); err != nil {
+				errorLogger.Println("Error posting expense", expense.ID, "back to the journal:", err)
+			}
+		}
+		if stateErr == nil {
+			stateErr = saveExpense(actor, expense, updated)
+		}
+		if stateErr != nil {
+			errorLogger.Println("Error storing expense", expense.ID, "as it was:", stateErr)
+		}
+		return internalError("Error posting expense to journal in UpdateExpense", err)
+	}
+
+	recordActivity(g.ID, currentUser(c).Id, group.Updated, group.ExpenseSubject, expense.ID, "Changed "+describeExpense(expense))
+	infoLogger.Println("Updated Expense With Id: ", expense.ID)
+	return c.JSON(http.StatusOK, expense)
+}
+
+// deleteExpense reverses the expense's effect on balances in the journal and
+// removes it from its group. Expenses that any payment settles, even in part,
+// can't be deleted, since the payment would then name a missing expense.
+func deleteExpense(c echo.Context) error {
+	expense, g, err := expenseFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := authorizeChange(c, g, expense.PaidBy, nil); err != nil {
+		return err
+	}
+	listed, err := listedByPayment(expense.ID)
+	if err != nil {
+		return internalError("Error listing payments", err)
+	}
+	if listed || expense.IsPartiallySettled() {
+		return conflict("", fmt.Sprintf("Cannot delete expense %d: %v", expense.ID, models.ErrExpenseSettled))
+	}
+
+	// The expense's effect on balances is reversed first, so that it is only
+	// deleted once the reversal is saved. If it can't be deleted, it is posted
+	// again.
+	if _, err := balanceJournal.Reverse(journal.ExpenseEntry, expense.ID); err != nil {
+		return internalError("Error reversing expense in the journal", err)
+	}
+
+	// Delete the expense together with the group's reference to it
+	if err := removeExpense(currentUser(c).Id, expense, g); err != nil {
+		if _, err := balanceJournal.PostExpense(g.ID, g.Currency, expense); err != nil {
+			errorLogger.Println("Error posting expense", expense.ID, "back to the journal:", err)
+		}
+		return internalError("Error deleting expense", err)
+	}
+	releaseBlobs(expense.Attachments)
+	recordActivity(g.ID, currentUser(c).Id, group.Deleted, group.ExpenseSubject, expense.ID, "Deleted "+describeExpense(expense))
+
+	infoLogger.Println("Deleted Expense With Id: ", expense.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// saveExpense stores the changes the user with the ID actor made to the
+// expense, together with their entry in the audit log. state is the
+// expense's auditState before them.
+func saveExpense(actor int32, expense *models.Expense, state json.RawMessage) error {
+	return db.Transaction(func(tx *store.Store) error {
+		if err := tx.Expenses.Update(expense); err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditChange{audit.Updated, expense, state})
+	})
+}
+
+// removeExpense deletes the expense and takes it out of its group, in memory
+// and in the store, recording both in the audit log. The group is left as it
+// was if they can't be saved.
+func removeExpense(actor int32, expense *models.Expense, g *group.Group) error {
+	expenseState, err := auditState(expense)
+	if err != nil {
+		return err
+	}
+	groupState, err := auditState(g)
+	if err != nil {
+		return err
+	}
+	expenses := append([]*models.Expense(nil), g.Expenses...)
+	g.RemoveExpense(expense.ID)
+	if err := db.Transaction(func(tx *store.Store) error {
+		if err := tx.Expenses.Delete(expense.ID); err != nil {
+			return err
+		}
+		if err := tx.Groups.Update(g); err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditChange{audit.Deleted, expense, expenseState}, auditChange{audit.Updated, g, groupState})
+	}); err != nil {
+		g.Expenses = expenses
+		return err
+	}
+	return nil
+}
+
+// listedByPayment reports whether any payment lists the expense.
+func listedByPayment(expenseID int) (bool, error) {
+	payments, err := db.Payments.List()
+	if err != nil {
+		return false, err
+	}
+	for _, payment := range payments {
+		for _, expense := range payment.Expenses {
+			if expense.ID == expenseID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// expenseFromParam finds the expense named by the :id path parameter and the
+// group it is in, which the signed in user must be able to see.
+func expenseFromParam(c echo.Context) (*models.Expense, *group.Group, error) {
+	id, err := id32Param(c, "id")
+	if err != nil {
+		return nil, nil, err
+	}
+	missing := notFound("id", fmt.Sprintf("Expense %d not found", id))
+	expense := findExpenseByID(id)
+	if expense == nil {
+		return nil, nil, missing
+	}
+	g := findGroupByExpense(expense)
+	if g == nil {
+		return nil, nil, internalError("Error finding expense group", fmt.Errorf("expense %d has no group", expense.ID))
+	}
+	if err := authorize(c, g, viewGroup, missing); err != nil {
+		return nil, nil, err
+	}
+	return expense, g, nil
+}
+
+// expenseRequest is the body of POST /v1/groups/:id/expenses and of PUT and
+// PATCH /v1/expenses/:id. Every expense is paid by the signed in user, is
+// shared by members of its group, and optionally has a description, category,
+// tags, currency, splitType, rounding and roundingSeed. The currency is the
+// group's unless given, and the category is assigned by the group's rules
+// unless given.
+// Itemized expenses are then built from receipt line items and charges, every
+// other split type from an amount, splitBetween and splitValues, e.g.
+//
+//	{"amount":"30.00","splitBetween":[1,2],"splitType":"Exact","splitValues":["10.00","20.00"]}
+//	{"splitType":"Itemized","items":[{"description":"Steak","amount":"25.00","consumers":[1]}],"charges":[{"kind":"Tax","amount":"2.50"}]}
+//
+// splitRates is still accepted in place of splitValues for clients that only
+// know about relative weights, and replaces splitValues when both are given.
+type expenseRequest struct {
+	Description  string            `json:"description"`
+	Category     string            `json:"category"`
+	Tags         []string          `json:"tags"`
+	Amount       *models.Money     `json:"amount"`
+	Currency     string            `json:"currency"`
+	SplitBetween []int32           `json:"splitBetween"`
+	SplitType    string            `json:"splitType"`
+	SplitValues  []json.Number     `json:"splitValues"`
+	SplitRates   []json.Number     `json:"splitRates"`
+	Rounding     string            `json:"rounding"`
+	RoundingSeed *int64            `json:"roundingSeed"`
+	Items        []lineItemRequest `json:"items"`
+	Charges      []*models.Charge  `json:"charges"`
+
+	paidBy  *models.User
+	group   *group.Group    // Everyone sharing the expense must be a member
+	current *models.Expense // The expense being updated, if any
+	expense *models.Expense
+}
+
+// lineItemRequest is a receipt line item in an expenseRequest, with consumers
+// given by user ID.
+type lineItemRequest struct {
+	Description string       `json:"description"`
+	Amount      models.Money `json:"amount"`
+	Consumers   []int32      `json:"consumers"`
+}
+
+// newExpenseRequest returns the request that would recreate the expense.
+func newExpenseRequest(expense *models.Expense) *expenseRequest {
+	seed := expense.RoundingSeed
+	req := &expenseRequest{
+		paidBy:       expense.PaidBy,
+		Description:  expense.Description,
+		Category:     expense.Category,
+		Tags:         expense.Tags,
+		Currency:     string(expense.Currency),
+		SplitType:    string(expense.SplitType),
+		Rounding:     string(expense.Rounding),
+		RoundingSeed: &seed,
+	}
+	for _, user := range expense.SplitBetween {
+		req.SplitBetween = append(req.SplitBetween, user.Id)
+	}
+	if expense.SplitType != models.SplitItemized {
+		amount := expense.Amount
+		req.Amount = &amount
+		for _, value := range expense.SplitValues() {
+			req.SplitValues = append(req.SplitValues, json.Number(value))
+		}
+		return req
+	}
+
+	// The amount is left out so that it follows the items
+	for _, item := range expense.Items {
+		itemReq := lineItemRequest{Description: item.Description, Amount: item.Amount}
+		for _, consumer := range item.Consumers {
+			itemReq.Consumers = append(itemReq.Consumers, consumer.Id)
+		}
+		req.Items = append(req.Items, itemReq)
+	}
+	req.Charges = expense.Charges
+	return req
+}
+
+// validate builds the expense the request describes.
+func (r *expenseRequest) validate(v *validation) {
+	paidBy := r.paidBy
+	splitType, err := models.ParseSplitType(r.SplitType)
+	if err != nil {
+		v.add(codeInvalid, "splitType", err.Error())
+	}
+	rounding, err := models.ParseRoundingPolicy(r.Rounding)
+	if err != nil {
+		v.add(codeInvalid, "rounding", err.Error())
+	}
+	roundingSeed := time.Now().UnixNano()
+	if r.RoundingSeed != nil {
+		roundingSeed = *r.RoundingSeed
+	}
+	r.Description = strings.TrimSpace(r.Description)
+	v.check(len([]rune(r.Description)) <= maxDescriptionLength, "description", fmt.Sprintf("Description must be at most %d characters", maxDescriptionLength))
+	name := strings.TrimSpace(r.Category)
+	category, ok := r.group.Category(name)
+	if r.current != nil && name == r.current.Category {
+		// Updates keep the category the expense has, even once the group
+		// no longer does
+		category, ok = r.current.Category, true
+	}
+	if r.Category != "" && !ok {
+		v.add(codeInvalid, "category", fmt.Sprintf("Category %q is not one of group %d's categories", r.Category, r.group.ID))
+	}
+	tags := validateTags(v, r.Tags)
+	currency, rate := r.group.Currency, models.OneRate
+	if r.Currency != "" {
+		currency = validateCurrency(v, r.Currency)
+	}
+	if r.current != nil && currency == r.current.Currency {
+		// Updates keep the rate the expense was made at
+		rate = r.current.Rate
+	} else if currency != "" {
+		rate = validateRate(v, currency, r.group)
+	}
+
+	if splitType == models.SplitItemized {
+		r.expense = r.itemizedExpense(v, paidBy, currency)
+	} else {
+		r.expense = r.splitExpense(v, paidBy, splitType, currency)
+	}
+	if r.expense == nil {
+		return
+	}
+	if err := r.expense.SetRounding(rounding, roundingSeed); err != nil {
+		v.add(codeInvalid, "rounding", err.Error())
+	}
+	r.expense.Rate = rate
+	r.expense.Description, r.expense.Tags = r.Description, tags
+	r.expense.Category = category
+	if r.Category == "" {
+		r.expense.Category = r.group.Categorize(r.expense)
+	}
+}
+
+// splitExpense builds an expense in currency from the amount, splitBetween and
+// split values.
+func (r *expenseRequest) splitExpense(v *validation, paidBy *models.User, splitType models.SplitType, currency models.Currency) *models.Expense {
+	if r.Amount == nil {
+		v.add(codeRequired, "amount", "Amount is required")
+	} else {
+		v.check(*r.Amount > 0, "amount", "Amount must be greater than zero")
+		if err := currency.ValidateAmount(*r.Amount); err != nil {
+			v.add(codeInvalid, "amount", err.Error())
+		}
+	}
+	if len(r.SplitBetween) == 0 {
+		v.add(codeRequired, "splitBetween", "At least one user to split between is required")
+	}
+	splitBetween := r.users(v, "splitBetween", r.SplitBetween)
+
+	valuesField, values := "splitValues", r.SplitValues
+	if r.SplitRates != nil {
+		valuesField, values = "splitRates", r.SplitRates
+	}
+	if len(v.errors) > 0 {
+		return nil
+	}
+
+	splitValues := make([]string, len(values))
+	for i, value := range values {
+		splitValues[i] = value.String()
+	}
+	infoLogger.Println("Split Type: ", splitType, " Split Values: ", splitValues)
+	expense, err := models.NewSplitExpense(*r.Amount, currency, paidBy, splitBetween, splitType, splitValues)
+	if err != nil {
+		v.add(codeInvalid, valuesField, err.Error())
+	}
+	return expense
+}
+
+// itemizedExpense builds an expense in currency from the receipt line items and
+// charges. If an amount is also given it must match the receipt total.
+func (r *expenseRequest) itemizedExpense(v *validation, paidBy *models.User, currency models.Currency) *models.Expense {
+	if len(r.Items) == 0 {
+		v.add(codeRequired, "items", "At least one line item is required")
+	}
+	items := make([]*models.LineItem, len(r.Items))
+	for i, item := range r.Items {
+		consumers := r.users(v, fmt.Sprintf("items[%d].consumers", i), item.Consumers)
+		items[i] = &models.LineItem{Description: item.Description, Amount: item.Amount, Consumers: consumers}
+	}
+	if len(v.errors) > 0 {
+		return nil
+	}
+
+	expense, err := models.NewItemizedExpense(currency, paidBy, items, r.Charges)
+	if err != nil {
+		v.add(codeInvalid, "items", err.Error())
+		return nil
+	}
+	if r.Amount != nil && *r.Amount != expense.Amount {
+		v.add(codeInvalid, "amount", fmt.Sprintf("Amount %s does not match the receipt total %s", *r.Amount, expense.Amount))
+	}
+	return expense
+}
+
+// users looks up the users the field lists, which must be members of the
+// group and must not repeat.
+func (r *expenseRequest) users(v *validation, field string, ids []int32) []*models.User {
+	seen := make(map[int32]bool)
+	for i, id := range ids {
+		if seen[id] {
+			v.add(codeInvalid, fmt.Sprintf("%s[%d]", field, i), fmt.Sprintf("User %d is listed more than once", id))
+		}
+		seen[id] = true
+	}
+	return v.members(field, ids, r.group)
+}
+
+// listExpenses handles GET /v1/expenses, which lists the expenses in the
+// signed in user's groups.
+func listExpenses(c echo.Context) error {
+	q := newListQuery(c)
+	filter := newExpenseFilter(q)
+	groups, err := memberGroups(currentUser(c))
+	if err != nil {
+		return internalError("Error listing groups", err)
+	}
+	var expenses []*models.Expense
+	for _, g := range groups {
+		if filter.group == 0 || g.ID == filter.group {
+			expenses = append(expenses, g.Expenses...)
+		}
+	}
+	return respondWithExpenses(c, q, filter, expenses)
+}
+
+// respondWithExpenses responds with the page of expenses that match the
+// filter.
+func respondWithExpenses(c echo.Context, q *listQuery, filter expenseFilter, expenses []*models.Expense) error {
+	var matching []*models.Expense
+	for _, expense := range expenses {
+		if filter.matches(expense) {
+			matching = append(matching, expense)
+		}
+	}
+	result, err := paginate(q, matching, expenseSorts)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Listing Expenses")
+	return c.JSON(http.StatusOK, result)
+}
+
+// expenseFilter selects expenses by the query parameters of a list request.
+type expenseFilter struct {
+	group       int32 // Only expenses in this group; callers pick the expenses to filter by it
+	payer       int32
+	participant int32 // Only expenses this user paid for or shares
+	timestamp   timeRange
+	amount      amountRange
+	settled     *bool    // Only expenses that everyone has, or hasn't, paid their share of
+	category    string   // Only expenses in this category, ignoring case
+	tags        []string // Only expenses with every one of these tags
+}
+
+func newExpenseFilter(q *listQuery) expenseFilter {
+	return expenseFilter{
+		group:       q.id("group"),
+		payer:       q.id("payer"),
+		participant: q.id("participant"),
+		timestamp:   q.timeRange("from", "to"),
+		amount:      q.amountRange("minAmount", "maxAmount"),
+		settled:     q.bool("settled"),
+		category:    q.c.QueryParam("category"),
+		tags:        q.c.QueryParams()["tag"],
+	}
+}
+
+func (f expenseFilter) matches(expense *models.Expense) bool {
+	if f.payer != 0 && expense.PaidBy.Id != f.payer {
+		return false
+	}
+	if f.participant != 0 && expense.PaidBy.Id != f.participant && !containsUser(expense.SplitBetween, f.participant) {
+		return false
+	}
+	if f.settled != nil && expense.IsSettled() != *f.settled {
+		return false
+	}
+	if f.category != "" && !strings.EqualFold(expense.Category, f.category) {
+		return false
+	}
+	for _, tag := range f.tags {
+		if !slices.Contains(expense.Tags, strings.ToLower(tag)) {
+			return false
+		}
+	}
+	return f.timestamp.contains(expense.Timestamp) && f.amount.contains(expense.Amount)
+}
+
+// expenseSorts are the orders expenses can be listed in.
+var expenseSorts = sortFields[*models.Expense]{
+	"id":        func(e *models.Expense) sortKey { return sortKey{ID: e.ID} },
+	"timestamp": func(e *models.Expense) sortKey { return sortKey{Number: e.Timestamp.UnixNano(), ID: e.ID} },
+	"amount":    func(e *models.Expense) sortKey { return sortKey{Number: int64(e.Amount), ID: e.ID} },
+	"remaining": func(e *models.Expense) sortKey { return sortKey{Number: int64(e.RemainingAmount), ID: e.ID} },
+}
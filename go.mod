@@ -4,7 +4,9 @@ go 1.21
 
 require (
 	github.com/labstack/echo/v4 v4.12.0
+	go.etcd.io/bbolt v1.3.10
 	go.mongodb.org/mongo-driver v1.16.1
+	golang.org/x/crypto v0.22.0
 )
 
 require (
@@ -20,7 +22,6 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
-	golang.org/x/crypto v0.22.0 // indirect
 	golang.org/x/net v0.24.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
@@ -0,0 +1,68 @@
+package group
+
+import "time"
+
+var activityIDCounter int
+
+// Verb is what happened to the subject of an activity.
+type Verb string
+
+const (
+	Created Verb = "created"
+	Updated Verb = "updated"
+	Deleted Verb = "deleted"
+	Joined  Verb = "joined" // A member was added to the group, or joined it themselves
+	Left    Verb = "left"   // A member was removed from the group, or left it themselves
+)
+
+// Subject is the kind of thing an activity happened to.
+type Subject string
+
+const (
+	GroupSubject            Subject = "group"
+	MemberSubject           Subject = "member"
+	ExpenseSubject          Subject = "expense"
+	PaymentSubject          Subject = "payment"
+	RecurringExpenseSubject Subject = "recurringExpense"
+	CommentSubject          Subject = "comment"
+)
+
+// Activity records a change made in a group, for its activity feed.
+type Activity struct {
+	ID        int
+	GroupID   int32
+	Actor     int32 `json:",omitempty"` // ID of the user who made the change, left out for changes the server made on schedule
+	Verb      Verb
+	Subject   Subject
+	SubjectID int    // ID of what changed: a user for members, and the group itself for the group
+	Summary   string // What happened, in words
+	Timestamp time.Time
+}
+
+// NewActivity returns an activity for a change made just now.
+func NewActivity(groupID, actor int32, verb Verb, subject Subject, subjectID int, summary string) *Activity {
+	mu.Lock()
+	activityIDCounter++
+	id := activityIDCounter
+	mu.Unlock()
+	return &Activity{
+		ID:        id,
+		GroupID:   groupID,
+		Actor:     actor,
+		Verb:      verb,
+		Subject:   subject,
+		SubjectID: subjectID,
+		Summary:   summary,
+		Timestamp: time.Now(),
+	}
+}
+
+// ResumeActivityIDs makes NewActivity number new activities after id, so that
+// activities loaded from storage keep their IDs.
+func ResumeActivityIDs(id int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if id > activityIDCounter {
+		activityIDCounter = id
+	}
+}
@@ -0,0 +1,56 @@
+package group
+
+import (
+	"splitwise/models"
+	"strings"
+)
+
+// DefaultCategories are the categories a new group starts with.
+var DefaultCategories = []string{"Food", "Groceries", "Transport", "Housing", "Utilities", "Entertainment", "Shopping", "Travel", "Health", "Other"}
+
+// CategoryRule assigns Category to expenses that are added without one. A rule
+// with both keywords and a payer only matches expenses that have both.
+type CategoryRule struct {
+	Category string
+	Keywords []string `json:",omitempty"` // Matches descriptions containing any of them, ignoring case
+	PaidBy   int32    `json:",omitempty"` // Matches expenses paid by the user with this ID
+}
+
+// Matches reports whether the rule assigns its category to the expense.
+func (r CategoryRule) Matches(e *models.Expense) bool {
+	if r.PaidBy != 0 && (e.PaidBy == nil || e.PaidBy.Id != r.PaidBy) {
+		return false
+	}
+	if len(r.Keywords) == 0 {
+		return r.PaidBy != 0
+	}
+	description := strings.ToLower(e.Description)
+	for _, keyword := range r.Keywords {
+		if strings.Contains(description, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Category returns the group's category with the name, ignoring case, and
+// whether there is one.
+func (g *Group) Category(name string) (string, bool) {
+	for _, category := range g.Categories {
+		if strings.EqualFold(category, name) {
+			return category, true
+		}
+	}
+	return "", false
+}
+
+// Categorize returns the category of the first of the group's rules that
+// matches the expense, or "" if none does.
+func (g *Group) Categorize(e *models.Expense) string {
+	for _, rule := range g.CategoryRules {
+		if rule.Matches(e) {
+			return rule.Category
+		}
+	}
+	return ""
+}
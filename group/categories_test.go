@@ -0,0 +1,47 @@
+package group
+
+import (
+	"splitwise/models"
+	"testing"
+)
+
+func TestGroup_Categorize(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	g := NewGroup("House", []*models.User{alice, bob})
+	g.CategoryRules = []CategoryRule{
+		{Category: "Housing", Keywords: []string{"rent"}, PaidBy: bob.Id},
+		{Category: "Transport", Keywords: []string{"Uber", "taxi"}},
+		{Category: "Groceries", PaidBy: alice.Id},
+	}
+	tests := []struct {
+		name        string
+		description string
+		paidBy      *models.User
+		want        string
+	}{
+		{name: "Keyword In Any Case", description: "UBER to the airport", paidBy: bob, want: "Transport"},
+		{name: "Keyword And Payer", description: "March rent", paidBy: bob, want: "Housing"},
+		{name: "Keyword Without Its Payer", description: "March rent", paidBy: alice, want: "Groceries"},
+		{name: "First Match Wins", description: "Taxi", paidBy: alice, want: "Transport"},
+		{name: "No Match", description: "Cinema", paidBy: bob, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &models.Expense{Description: tt.description, PaidBy: tt.paidBy}
+			if got := g.Categorize(e); got != tt.want {
+				t.Errorf("Categorize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroup_Category(t *testing.T) {
+	g := NewGroup("Trip", nil)
+	if got, ok := g.Category("transport"); !ok || got != "Transport" {
+		t.Errorf("Category(transport) = %q, %v, want Transport", got, ok)
+	}
+	if _, ok := g.Category("Rockets"); ok {
+		t.Error("Category(Rockets) found a category the group doesn't have")
+	}
+}
@@ -4,36 +4,136 @@ import (
 	"errors"
 	"fmt"
 	"splitwise/models"
+	"sync"
 )
 
+var (
+	groupIDCounter int32
+	mu             sync.Mutex
+)
+
+// Group is a set of users sharing expenses. Groups are identified by ID, since
+// several groups may have the same name.
 type Group struct {
-	Name     string
-	Members  []*models.User
-	Expenses []*models.Expense // To keep track of all expenses related to the group
+	ID            int32
+	Name          string
+	Currency      models.Currency                 // Currency balances are kept in, which other currencies are converted to
+	Rates         map[models.Currency]models.Rate `json:",omitempty"` // Rates entered by hand: what one unit of each currency is worth in Currency
+	Members       []*models.User
+	FormerMembers []*models.User    `json:",omitempty"` // Users who left or were removed, whom earlier expenses and payments may still name
+	Roles         map[int32]Role    // Role of each member by user ID
+	Expenses      []*models.Expense // To keep track of all expenses related to the group
+	Transfers     []DebtTransfer    `json:",omitempty"` // Debts members handed over to others, oldest first
+	Categories    []string          // Categories the group's expenses can be in
+	CategoryRules []CategoryRule    `json:",omitempty"` // Assign categories to expenses added without one, first match first
 }
 
 func NewGroup(name string, members []*models.User) *Group {
+	mu.Lock()
+	groupIDCounter++
+	id := groupIDCounter
+	mu.Unlock()
 	return &Group{
-		Name:     name,
-		Members:  members,
-		Expenses: []*models.Expense{},
+		ID:         id,
+		Name:       name,
+		Currency:   models.DefaultCurrency,
+		Members:    members,
+		Expenses:   []*models.Expense{},
+		Categories: append([]string(nil), DefaultCategories...),
+	}
+}
+
+// ResumeGroupIDs makes NewGroup number new groups after id, so that groups
+// loaded from storage keep their IDs.
+func ResumeGroupIDs(id int32) {
+	mu.Lock()
+	defer mu.Unlock()
+	if id > groupIDCounter {
+		groupIDCounter = id
+	}
+}
+
+// HasMember reports whether the user is one of the group's members.
+func (g *Group) HasMember(userID int32) bool {
+	for _, member := range g.Members {
+		if member.Id == userID {
+			return true
+		}
 	}
+	return false
 }
 
+// IsFormerMember reports whether the user was a member of the group and left
+// or was removed.
+func (g *Group) IsFormerMember(userID int32) bool {
+	return indexOf(g.FormerMembers, userID) >= 0
+}
+
+// AddMember adds the user to the group. Former members who join again are no
+// longer former members.
 func (g *Group) AddMember(user *models.User) {
 	g.Members = append(g.Members, user)
-
+	g.ForgetFormerMember(user.Id)
 }
 
+// RemoveMember removes the user from the group, together with their role, and
+// keeps them as a former member.
 func (g *Group) RemoveMember(userID int32) error {
-	for i, member := range g.Members {
-		if member.Id == userID {
-			g.Members = append(g.Members[:i], g.Members[i+1:]...)
-			// to remove member, present at index i, and to concatenate the remaining
-			return nil
+	i := indexOf(g.Members, userID)
+	if i < 0 {
+		return errors.New("member not found")
+	}
+	member := g.Members[i]
+	g.Members = append(g.Members[:i], g.Members[i+1:]...)
+	delete(g.Roles, userID)
+	if !g.IsFormerMember(userID) {
+		g.FormerMembers = append(g.FormerMembers, member)
+	}
+	return nil
+}
+
+// ForgetFormerMember stops keeping the user as a former member, such as when
+// they delete their account.
+func (g *Group) ForgetFormerMember(userID int32) {
+	if i := indexOf(g.FormerMembers, userID); i >= 0 {
+		g.FormerMembers = append(g.FormerMembers[:i], g.FormerMembers[i+1:]...)
+	}
+}
+
+// Membership is a copy of who is and was in a group, and with which roles.
+type Membership struct {
+	members, formerMembers []*models.User
+	roles                  map[int32]Role
+}
+
+// Membership returns a copy of the group's members, former members and roles,
+// to restore if saving a change to them fails.
+func (g *Group) Membership() Membership {
+	m := Membership{
+		members:       append([]*models.User(nil), g.Members...),
+		formerMembers: append([]*models.User(nil), g.FormerMembers...),
+		roles:         make(map[int32]Role, len(g.Roles)),
+	}
+	for id, role := range g.Roles {
+		m.roles[id] = role
+	}
+	return m
+}
+
+// RestoreMembership puts back the members, former members and roles m was
+// copied from.
+func (g *Group) RestoreMembership(m Membership) {
+	g.Members, g.FormerMembers, g.Roles = m.members, m.formerMembers, m.roles
+}
+
+// indexOf returns the index of the user with the ID in users, or -1.
+func indexOf(users []*models.User, id int32) int {
+	for i, user := range users {
+		if user.Id == id {
+			return i
 		}
 	}
-	return errors.New("member not found")
+	return -1
 }
 
 func (g *Group) ListMembers() []models.User {
@@ -48,10 +148,20 @@ func (g *Group) ListMembers() []models.User {
 func (g *Group) PrintGroupInfo() {
 	fmt.Printf("Group Name: %s\n", g.Name)
 	for _, member := range g.Members {
-		fmt.Printf("ID: %d, Name: %s, Balance: %.2f\n", member.Id, member.Name, member.Balance)
+		fmt.Printf("ID: %d, Name: %s, Balance: %s\n", member.Id, member.Name, member.Balance)
 	}
 }
 
 func (g *Group) AddExpense(expense *models.Expense) {
 	g.Expenses = append(g.Expenses, expense)
 }
+
+func (g *Group) RemoveExpense(expenseID int) error {
+	for i, expense := range g.Expenses {
+		if expense.ID == expenseID {
+			g.Expenses = append(g.Expenses[:i], g.Expenses[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("expense not found")
+}
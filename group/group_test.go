@@ -39,3 +39,81 @@ func TestGroup_AddMember(t *testing.T) {
 		})
 	}
 }
+
+func TestGroup_Roles(t *testing.T) {
+	alice, bob, carol := &models.User{Id: 1, Name: "Alice"}, &models.User{Id: 2, Name: "Bob"}, &models.User{Id: 3, Name: "Carol"}
+	g := &Group{Name: "Trip", Members: []*models.User{alice, bob}}
+	g.SetRole(alice.Id, Owner)
+
+	tests := []struct {
+		user int32
+		want Role
+	}{
+		{user: alice.Id, want: Owner},
+		{user: bob.Id, want: Member}, // Members without a role of their own
+		{user: carol.Id, want: ""},   // Not a member
+	}
+	for _, tt := range tests {
+		if got := g.RoleOf(tt.user); got != tt.want {
+			t.Errorf("RoleOf(%d) = %q, want %q", tt.user, got, tt.want)
+		}
+	}
+	if got := g.Owners(); !reflect.DeepEqual(got, []int32{alice.Id}) {
+		t.Errorf("Owners() = %v, want [%d]", got, alice.Id)
+	}
+
+	g.RemoveMember(alice.Id)
+	g.AddMember(alice)
+	if got := g.RoleOf(alice.Id); got != Member {
+		t.Errorf("RoleOf() after leaving and joining again = %q, want %q", got, Member)
+	}
+}
+
+func TestGroup_FormerMembers(t *testing.T) {
+	alice, bob := &models.User{Id: 1, Name: "Alice"}, &models.User{Id: 2, Name: "Bob"}
+	g := &Group{Name: "Trip", Members: []*models.User{alice, bob}}
+	g.SetRole(bob.Id, Admin)
+	before := g.Membership()
+
+	if err := g.RemoveMember(bob.Id); err != nil {
+		t.Fatalf("RemoveMember() error = %v", err)
+	}
+	if g.HasMember(bob.Id) || !g.IsFormerMember(bob.Id) {
+		t.Errorf("after RemoveMember(), Members = %v and FormerMembers = %v, want Bob only a former member", g.Members, g.FormerMembers)
+	}
+	if err := g.RemoveMember(bob.Id); err == nil {
+		t.Errorf("RemoveMember() of a former member error = nil, want an error")
+	}
+
+	g.AddMember(bob)
+	if !g.HasMember(bob.Id) || g.IsFormerMember(bob.Id) {
+		t.Errorf("after joining again, FormerMembers = %v, want Bob only a member", g.FormerMembers)
+	}
+
+	g.RemoveMember(alice.Id)
+	g.RestoreMembership(before)
+	if !g.HasMember(alice.Id) || g.IsFormerMember(alice.Id) || g.RoleOf(bob.Id) != Admin {
+		t.Errorf("after RestoreMembership(), group = %+v, want Alice a member again and Bob an admin", g)
+	}
+}
+
+func TestRole_AtLeast(t *testing.T) {
+	tests := []struct {
+		role, other Role
+		want        bool
+	}{
+		{role: Owner, other: Admin, want: true},
+		{role: Admin, other: Admin, want: true},
+		{role: Member, other: Admin, want: false},
+		{role: Viewer, other: Member, want: false},
+		{role: "", other: Viewer, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.AtLeast(tt.other); got != tt.want {
+			t.Errorf("%q.AtLeast(%q) = %v, want %v", tt.role, tt.other, got, tt.want)
+		}
+	}
+	if _, err := ParseRole("superuser"); err == nil {
+		t.Errorf("ParseRole(%q) error = nil, want an error", "superuser")
+	}
+}
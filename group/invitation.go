@@ -0,0 +1,98 @@
+package group
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+var invitationIDCounter int
+
+// InvitationStatus says whether an invitation can still be used.
+type InvitationStatus string
+
+const (
+	Pending  InvitationStatus = "pending"
+	Accepted InvitationStatus = "accepted" // Only invitations addressed to someone are used up
+	Declined InvitationStatus = "declined"
+	Revoked  InvitationStatus = "revoked"
+	Expired  InvitationStatus = "expired" // Never saved: pending invitations become expired when they run out
+)
+
+// Invitation invites someone to join a group with a role. An invitation
+// addressed to an email address can be used once, by the user who signs in or
+// up with that address. One that isn't is a join link, which anyone with its
+// token can use until it expires or is revoked.
+type Invitation struct {
+	ID        int
+	GroupID   int32
+	Email     string `json:",omitempty"` // Who the invitation is for, left out for join links
+	Role      Role
+	InvitedBy int32 // ID of the user who made the invitation
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Status    InvitationStatus
+	Uses      int    // How many users have joined the group with it
+	TokenHash string `json:"-"` // Tokens are only shown once, so only their hash is kept
+}
+
+// NewInvitation returns a pending invitation that expires after ttl, and the
+// token that redeems it.
+func NewInvitation(groupID int32, email string, role Role, invitedBy int32, ttl time.Duration) (*Invitation, string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("generating an invitation token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	mu.Lock()
+	invitationIDCounter++
+	id := invitationIDCounter
+	mu.Unlock()
+	now := time.Now()
+	return &Invitation{
+		ID:        id,
+		GroupID:   groupID,
+		Email:     email,
+		Role:      role,
+		InvitedBy: invitedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		Status:    Pending,
+		TokenHash: HashToken(token),
+	}, token, nil
+}
+
+// ResumeInvitationIDs makes NewInvitation number new invitations after id, so
+// that invitations loaded from storage keep their IDs.
+func ResumeInvitationIDs(id int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if id > invitationIDCounter {
+		invitationIDCounter = id
+	}
+}
+
+// HashToken returns the hash invitations keep of their token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsLink reports whether the invitation is a join link rather than addressed
+// to someone.
+func (i *Invitation) IsLink() bool {
+	return i.Email == ""
+}
+
+// StatusAt returns the invitation's status at the time, which is expired if
+// it was still pending when it ran out.
+func (i *Invitation) StatusAt(now time.Time) InvitationStatus {
+	if i.Status == Pending && !now.Before(i.ExpiresAt) {
+		return Expired
+	}
+	return i.Status
+}
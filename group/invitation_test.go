@@ -0,0 +1,46 @@
+package group
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewInvitation(t *testing.T) {
+	invitation, token, err := NewInvitation(1, "", Member, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("NewInvitation() error = %v", err)
+	}
+	other, otherToken, err := NewInvitation(1, "bob@example.com", Viewer, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("NewInvitation() error = %v", err)
+	}
+	if token == "" || token == otherToken || other.ID == invitation.ID {
+		t.Errorf("NewInvitation() gave IDs %d and %d with tokens %q and %q, want them all different", invitation.ID, other.ID, token, otherToken)
+	}
+	if invitation.TokenHash != HashToken(token) || invitation.TokenHash == token {
+		t.Errorf("TokenHash = %q, want the hash of the token", invitation.TokenHash)
+	}
+	if !invitation.IsLink() || other.IsLink() {
+		t.Errorf("IsLink() = %v and %v, want only the invitation without an email to be a link", invitation.IsLink(), other.IsLink())
+	}
+}
+
+func TestInvitation_StatusAt(t *testing.T) {
+	expires := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		status InvitationStatus
+		at     time.Time
+		want   InvitationStatus
+	}{
+		{status: Pending, at: expires.Add(-time.Second), want: Pending},
+		{status: Pending, at: expires, want: Expired},
+		{status: Accepted, at: expires.Add(time.Hour), want: Accepted},
+		{status: Revoked, at: expires.Add(-time.Hour), want: Revoked},
+	}
+	for _, tt := range tests {
+		invitation := &Invitation{Status: tt.status, ExpiresAt: expires}
+		if got := invitation.StatusAt(tt.at); got != tt.want {
+			t.Errorf("%s invitation StatusAt(%v) = %q, want %q", tt.status, tt.at, got, tt.want)
+		}
+	}
+}
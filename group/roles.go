@@ -0,0 +1,62 @@
+package group
+
+import "fmt"
+
+// Role is what a member may do in a group. Each role may do everything the
+// roles below it may.
+type Role string
+
+const (
+	Viewer Role = "viewer" // Sees the group, its expenses, payments and balances
+	Member Role = "member" // Also adds expenses and payments, and changes their own
+	Admin  Role = "admin"  // Also renames the group, manages members and changes anyone's expenses and payments
+	Owner  Role = "owner"  // Also deletes the group and grants or revokes the admin and owner roles
+)
+
+var roleRanks = map[Role]int{Viewer: 1, Member: 2, Admin: 3, Owner: 4}
+
+// ParseRole returns the role with the given name.
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q, expected viewer, member, admin or owner", name)
+	}
+	return role, nil
+}
+
+// AtLeast reports whether the role may do everything other may. No role,
+// which is what non-members have, is below every other.
+func (r Role) AtLeast(other Role) bool {
+	return roleRanks[r] >= roleRanks[other]
+}
+
+// RoleOf returns the member's role, or "" if the user isn't a member. Members
+// without a role of their own are plain members.
+func (g *Group) RoleOf(userID int32) Role {
+	if !g.HasMember(userID) {
+		return ""
+	}
+	if role, ok := g.Roles[userID]; ok {
+		return role
+	}
+	return Member
+}
+
+// SetRole gives the member the role.
+func (g *Group) SetRole(userID int32, role Role) {
+	if g.Roles == nil {
+		g.Roles = make(map[int32]Role)
+	}
+	g.Roles[userID] = role
+}
+
+// Owners returns the IDs of the group's owners.
+func (g *Group) Owners() []int32 {
+	var owners []int32
+	for _, member := range g.Members {
+		if g.RoleOf(member.Id) == Owner {
+			owners = append(owners, member.Id)
+		}
+	}
+	return owners
+}
@@ -0,0 +1,27 @@
+package group
+
+import (
+	"sort"
+	"splitwise/models"
+	"time"
+)
+
+// DebtTransfer records that a member handed every debt they had in the group,
+// owed to them or by them, over to another member, usually so that they could
+// leave.
+type DebtTransfer struct {
+	From      int32                  // ID of the member whose debts were handed over
+	To        int32                  // ID of the member who took them over
+	Amounts   map[int32]models.Money // What each user owed From, by user ID, negative if From owed them
+	Timestamp time.Time
+}
+
+// Counterparties returns the IDs of the users From had debts with, in order.
+func (t DebtTransfer) Counterparties() []int32 {
+	ids := make([]int32, 0, len(t.Amounts))
+	for id := range t.Amounts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
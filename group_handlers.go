@@ -0,0 +1,652 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/ledger"
+	"splitwise/models"
+	"splitwise/store"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// createGroup handles POST /v1/groups. The signed in user joins the group as
+// its owner, and everyone else listed as a member.
+func createGroup(c echo.Context) error {
+	var req createGroupRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	creator := currentUser(c)
+	members := req.members
+	if !containsUser(members, creator.Id) {
+		members = append([]*models.User{creator}, members...)
+	}
+	createdGroup := group.NewGroup(req.Name, members)
+	createdGroup.Currency = req.currency
+	createdGroup.SetRole(creator.Id, group.Owner)
+	if err := db.Transaction(func(tx *store.Store) error {
+		if err := tx.Groups.Add(createdGroup); err != nil {
+			return err
+		}
+		return recordAudit(tx, creator.Id, auditChange{audit.Created, createdGroup, nil})
+	}); err != nil {
+		return internalError("Error storing group", err)
+	}
+	recordActivity(createdGroup.ID, creator.Id, group.Created, group.GroupSubject, int(createdGroup.ID), fmt.Sprintf("Created the group %q", createdGroup.Name))
+	infoLogger.Println("Created Group With Id: ", createdGroup.ID)
+	return c.JSON(http.StatusCreated, createdGroup)
+}
+
+func getGroup(c echo.Context) error {
+	eachGroup, err := groupFromParam(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Retrieved Group With Id: ", eachGroup.ID)
+	return c.JSON(http.StatusOK, eachGroup)
+}
+
+// listGroups handles GET /v1/groups, which lists the signed in user's groups.
+// ?member= only lists those the user with that ID is also a member of.
+func listGroups(c echo.Context) error {
+	q := newListQuery(c)
+	member := q.id("member")
+	groups, err := memberGroups(currentUser(c))
+	if err != nil {
+		return internalError("Error listing groups", err)
+	}
+	var matching []*group.Group
+	for _, g := range groups {
+		if member == 0 || g.HasMember(member) {
+			matching = append(matching, g)
+		}
+	}
+	result, err := paginate(q, matching, groupSorts)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Listing Groups")
+	return c.JSON(http.StatusOK, result)
+}
+
+// groupSorts are the orders groups can be listed in.
+var groupSorts = sortFields[*group.Group]{
+	"id":   func(g *group.Group) sortKey { return sortKey{ID: int(g.ID)} },
+	"name": func(g *group.Group) sortKey { return sortKey{Text: strings.ToLower(g.Name), ID: int(g.ID)} },
+}
+
+// updateGroup handles PUT /v1/groups/:id, which renames the group or changes
+// its currency. The currency can only change while the group has no expenses,
+// payments or debt transfers, since their rates are to the old one, and
+// changing it forgets the rates entered by hand for the same reason.
+func updateGroup(c echo.Context) error {
+	g, err := groupFromParam(c, renameGroup)
+	if err != nil {
+		return err
+	}
+	var req updateGroupRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	currency, rates := g.Currency, g.Rates
+	if req.currency != "" && req.currency != g.Currency {
+		if err := authorize(c, g, manageRates, nil); err != nil {
+			return err
+		}
+		if inUse, err := hasEntries(g); err != nil {
+			return internalError("Error listing payments", err)
+		} else if inUse {
+			return conflict("currency", fmt.Sprintf("Cannot change the currency of group %d: it already has expenses, payments or debt transfers", g.ID))
+		}
+		currency, rates = req.currency, nil
+	}
+
+	state, err := auditState(g)
+	if err != nil {
+		return auditStateError(err)
+	}
+	previous := *g
+	g.Name, g.Currency, g.Rates = req.Name, currency, rates
+	if err := saveGroup(currentUser(c).Id, g, state); err != nil {
+		g.Name, g.Currency, g.Rates = previous.Name, previous.Currency, previous.Rates
+		return internalError("Error storing group", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Updated, group.GroupSubject, int(g.ID), groupChanges(&previous, g))
+	infoLogger.Println("Updated Group With Id: ", g.ID)
+	return c.JSON(http.StatusOK, g)
+}
+
+// deleteGroup handles DELETE /v1/groups/:id, together with its invitations,
+// recurring expenses and activity. Groups that still have expenses or
+// payments can't be deleted.
+func deleteGroup(c echo.Context) error {
+	g, err := groupFromParam(c, removeGroup)
+	if err != nil {
+		return err
+	}
+	if len(g.Expenses) > 0 {
+		return conflict("", fmt.Sprintf("Cannot delete group %d: it still has expenses", g.ID))
+	}
+	if paid, err := hasPayments(g); err != nil {
+		return internalError("Error listing payments", err)
+	} else if paid {
+		return conflict("", fmt.Sprintf("Cannot delete group %d: it still has payments", g.ID))
+	}
+
+	invitations, err := db.Invitations.List()
+	if err != nil {
+		return internalError("Error listing invitations", err)
+	}
+	recurring, err := db.RecurringExpenses.List()
+	if err != nil {
+		return internalError("Error listing recurring expenses", err)
+	}
+	activities, err := db.Activities.List()
+	if err != nil {
+		return internalError("Error listing activity", err)
+	}
+	state, err := auditState(g)
+	if err != nil {
+		return auditStateError(err)
+	}
+	if err := db.Transaction(func(tx *store.Store) error {
+		for _, invitation := range invitations {
+			if invitation.GroupID != g.ID {
+				continue
+			}
+			if err := tx.Invitations.Delete(invitation.ID); err != nil {
+				return err
+			}
+		}
+		for _, r := range recurring {
+			if r.GroupID != g.ID {
+				continue
+			}
+			if err := tx.RecurringExpenses.Delete(r.ID); err != nil {
+				return err
+			}
+		}
+		for _, a := range activities {
+			if a.GroupID != g.ID {
+				continue
+			}
+			if err := tx.Activities.Delete(a.ID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Groups.Delete(g.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, currentUser(c).Id, auditChange{audit.Deleted, g, state})
+	}); err != nil {
+		return internalError("Error deleting group", err)
+	}
+	infoLogger.Println("Deleted Group With Id: ", g.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// groupChanges describes how updateGroup changed the group, for its activity
+// feed.
+func groupChanges(previous, g *group.Group) string {
+	var changes []string
+	if g.Name != previous.Name {
+		changes = append(changes, fmt.Sprintf("Renamed the group from %q to %q", previous.Name, g.Name))
+	}
+	if g.Currency != previous.Currency {
+		changes = append(changes, fmt.Sprintf("Changed the currency from %s to %s", previous.Currency, g.Currency))
+	}
+	if len(changes) == 0 {
+		return "Updated the group without changing it"
+	}
+	return strings.Join(changes, "; ")
+}
+
+// hasEntries reports whether the group has any expenses, payments or debt
+// transfers.
+func hasEntries(g *group.Group) (bool, error) {
+	if len(g.Expenses) > 0 || len(g.Transfers) > 0 {
+		return true, nil
+	}
+	return hasPayments(g)
+}
+
+// hasPayments reports whether any payment was made in the group.
+func hasPayments(g *group.Group) (bool, error) {
+	payments, err := db.Payments.List()
+	if err != nil {
+		return false, err
+	}
+	for _, payment := range payments {
+		if payment.GroupID == g.ID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listMembers handles GET /v1/groups/:id/members. ?former=true lists the
+// group's former members instead.
+func listMembers(c echo.Context) error {
+	g, err := groupFromParam(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	q := newListQuery(c)
+	members := g.Members
+	if former := q.bool("former"); former != nil && *former {
+		members = g.FormerMembers
+	}
+	result, err := paginate(q, members, userSorts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// addMember handles POST /v1/groups/:id/members, which adds a member with the
+// given role, a plain member by default.
+func addMember(c echo.Context) error {
+	g, err := groupFromParam(c, manageMembers)
+	if err != nil {
+		return err
+	}
+	req := memberRequest{role: group.Member}
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	if req.role.AtLeast(group.Admin) {
+		if err := authorize(c, g, manageAdmins, nil); err != nil {
+			return err
+		}
+	}
+	if g.HasMember(req.user.Id) {
+		return conflict("user", fmt.Sprintf("User %d is already a member of group %d", req.user.Id, g.ID))
+	}
+
+	state, err := auditState(g)
+	if err != nil {
+		return auditStateError(err)
+	}
+	before := g.Membership()
+	g.AddMember(req.user)
+	g.SetRole(req.user.Id, req.role)
+	if err := saveGroup(currentUser(c).Id, g, state); err != nil {
+		g.RestoreMembership(before)
+		return internalError("Error storing group", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Joined, group.MemberSubject, int(req.user.Id), fmt.Sprintf("Added %s as %s", req.user.Name, req.role))
+	infoLogger.Println("Added User", req.user.Id, "To Group", g.ID, "As", req.role)
+	return c.JSON(http.StatusCreated, req.user)
+}
+
+// updateMember handles PUT /v1/groups/:id/members/:userId, which changes the
+// member's role. Only owners can make or unmake admins and owners, and the
+// last owner can't give up the role.
+func updateMember(c echo.Context) error {
+	g, member, err := memberFromParams(c, manageMembers)
+	if err != nil {
+		return err
+	}
+	var req roleRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	previous := g.RoleOf(member.Id)
+	if previous.AtLeast(group.Admin) || req.role.AtLeast(group.Admin) {
+		if err := authorize(c, g, manageAdmins, nil); err != nil {
+			return err
+		}
+	}
+	if previous == group.Owner && req.role != group.Owner && len(g.Owners()) == 1 {
+		return conflict("role", fmt.Sprintf("User %d is the only owner of group %d; make someone else an owner first", member.Id, g.ID))
+	}
+
+	state, err := auditState(g)
+	if err != nil {
+		return auditStateError(err)
+	}
+	g.SetRole(member.Id, req.role)
+	if err := saveGroup(currentUser(c).Id, g, state); err != nil {
+		g.SetRole(member.Id, previous)
+		return internalError("Error storing group", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Updated, group.MemberSubject, int(member.Id), fmt.Sprintf("Made %s %s instead of %s", member.Name, req.role, previous))
+	infoLogger.Println("Made User", member.Id, req.role, "Of Group", g.ID)
+	return c.JSON(http.StatusOK, g)
+}
+
+// removeMember handles DELETE /v1/groups/:id/members/:userId. Any member can
+// leave, but only admins can remove others. Removed members are kept as former
+// members, so the expenses and payments they were part of still name them.
+// Members whose balance in the group isn't zero can't be removed until they
+// settle up or transfer their debts, and neither can the last owner.
+func removeMember(c echo.Context) error {
+	g, member, err := memberFromParams(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	if err := authorizeRemoval(c, g, member); err != nil {
+		return err
+	}
+	if reason := removalBlocker(g, member); reason != "" {
+		return conflict("userId", fmt.Sprintf("Cannot remove user %d: %s", member.Id, reason))
+	}
+
+	state, err := auditState(g)
+	if err != nil {
+		return auditStateError(err)
+	}
+	before := g.Membership()
+	g.RemoveMember(member.Id)
+	if err := saveGroup(currentUser(c).Id, g, state); err != nil {
+		g.RestoreMembership(before)
+		return internalError("Error storing group", err)
+	}
+	summary := "Left the group"
+	if member != currentUser(c) {
+		summary = "Removed " + member.Name
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Left, group.MemberSubject, int(member.Id), summary)
+	infoLogger.Println("Removed User", member.Id, "From Group", g.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// authorizeRemoval returns an error unless the signed in user may remove the
+// member, or hand their debts over to someone else. Anyone may do so for
+// themselves.
+func authorizeRemoval(c echo.Context, g *group.Group, member *models.User) error {
+	if member == currentUser(c) {
+		return nil
+	}
+	if g.RoleOf(member.Id).AtLeast(group.Admin) {
+		return authorize(c, g, manageAdmins, nil)
+	}
+	return authorize(c, g, manageMembers, nil)
+}
+
+// removalBlocker says why the member can't be removed from the group yet, or
+// returns "" if they can.
+func removalBlocker(g *group.Group, member *models.User) string {
+	if g.RoleOf(member.Id) == group.Owner && len(g.Owners()) == 1 {
+		return fmt.Sprintf("they are the only owner of group %d; make someone else an owner first", g.ID)
+	}
+	if balance := debtLedger.Balance(g.ID, member.Id); balance != 0 {
+		return fmt.Sprintf("their balance in the group is %s; settle up or transfer their debts to another member first", balance)
+	}
+	recurring, err := db.RecurringExpenses.List()
+	logLookupError(err)
+	for _, r := range recurring {
+		if r.GroupID == g.ID && containsUser(recurringUsers(r), member.Id) {
+			return fmt.Sprintf("they are part of recurring expense %d; change or delete it first", r.ID)
+		}
+	}
+	return ""
+}
+
+// removalCheck is the response body of checkRemoval.
+type removalCheck struct {
+	Group     int32 // ID of the group
+	User      *models.User
+	Balance   models.Money  // Net position in the group, positive if owed money
+	Debts     []ledger.Debt // Every debt the member has in the group
+	CanRemove bool
+	Reason    string            `json:",omitempty"` // Why the member can't be removed yet
+	Drafts    []*models.Payment // Payments that bring the member's balance to zero, ready to submit to POST /v1/payments
+}
+
+// checkRemoval handles GET /v1/groups/:id/members/:userId/removal, which says
+// whether the member can be removed or leave, and if not, what would settle
+// them up.
+func checkRemoval(c echo.Context) error {
+	g, member, err := memberFromParams(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	check := removalCheck{Group: g.ID, User: member, Balance: debtLedger.Balance(g.ID, member.Id), Reason: removalBlocker(g, member)}
+	check.CanRemove = check.Reason == ""
+	check.Debts = memberDebts(g, member)
+	// The settle plan brings everyone's balance to zero, so the transfers the
+	// member is part of bring theirs to zero
+	for _, transfer := range debtLedger.SettlePlan(g.ID, false) {
+		if transfer.From.Id == member.Id || transfer.To.Id == member.Id {
+			check.Drafts = append(check.Drafts, draftPayment(g, transfer))
+		}
+	}
+	return c.JSON(http.StatusOK, check)
+}
+
+// memberDebts lists the debts the member has in the group.
+func memberDebts(g *group.Group, member *models.User) []ledger.Debt {
+	var debts []ledger.Debt
+	for _, debt := range debtLedger.GroupDebts(g.ID) {
+		if debt.From.Id == member.Id || debt.To.Id == member.Id {
+			debts = append(debts, debt)
+		}
+	}
+	return debts
+}
+
+// transferDebts handles POST /v1/groups/:id/members/:userId/transfer, which
+// hands every debt the member has in the group, owed to them or by them, over
+// to another member, so that the member can leave. Only admins can transfer
+// debts: the debts include what others owe the member, which nobody else may
+// take for themselves, and neither member is asked to agree.
+func transferDebts(c echo.Context) error {
+	g, member, err := memberFromParams(c, manageMembers)
+	if err != nil {
+		return err
+	}
+	req := transferRequest{from: member, group: g}
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	debts := memberDebts(g, member)
+	if len(debts) == 0 {
+		return conflict("userId", fmt.Sprintf("User %d has no debts in group %d to transfer", member.Id, g.ID))
+	}
+
+	transfer := group.DebtTransfer{From: member.Id, To: req.to.Id, Amounts: make(map[int32]models.Money), Timestamp: time.Now()}
+	for _, debt := range debts {
+		if debt.To.Id == member.Id {
+			transfer.Amounts[debt.From.Id] = debt.Amount
+		} else {
+			transfer.Amounts[debt.To.Id] = -debt.Amount
+		}
+	}
+	actor := currentUser(c).Id
+	state, err := auditState(g)
+	if err != nil {
+		return auditStateError(err)
+	}
+	g.Transfers = append(g.Transfers, transfer)
+	if err := saveGroup(actor, g, state); err != nil {
+		g.Transfers = g.Transfers[:len(g.Transfers)-1]
+		return internalError("Error storing group", err)
+	}
+	// A transfer that can't be posted is taken off the group again
+	if err := postTransfer(g, transfer); err != nil {
+		transferred, stateErr := auditState(g)
+		g.Transfers = g.Transfers[:len(g.Transfers)-1]
+		if stateErr == nil {
+			stateErr = saveGroup(actor, g, transferred)
+		}
+		if stateErr != nil {
+			errorLogger.Println("Error storing group", g.ID, "without the debt transfer:", stateErr)
+		}
+		return internalError("Error posting transfer to journal", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Updated, group.MemberSubject, int(member.Id), fmt.Sprintf("Transferred the debts of %s to %s", member.Name, req.to.Name))
+	infoLogger.Println("Transferred Debts Of User", member.Id, "To User", req.to.Id, "In Group", g.ID)
+	return c.JSON(http.StatusOK, newGroupBalances(g))
+}
+
+// saveGroup stores the changes the user with the ID actor made to the group,
+// together with their entry in the audit log. state is the group's
+// auditState before them.
+func saveGroup(actor int32, g *group.Group, state json.RawMessage) error {
+	return db.Transaction(func(tx *store.Store) error {
+		if err := tx.Groups.Update(g); err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditChange{audit.Updated, g, state})
+	})
+}
+
+// postTransfer posts the debt transfer to the journal.
+func postTransfer(g *group.Group, transfer group.DebtTransfer) error {
+	from, to := findUserByID(transfer.From), findUserByID(transfer.To)
+	var counterparties []*models.User
+	var amounts []models.Money
+	for _, id := range transfer.Counterparties() {
+		counterparty := findUserByID(id)
+		if counterparty == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		counterparties = append(counterparties, counterparty)
+		amounts = append(amounts, transfer.Amounts[id])
+	}
+	_, err := balanceJournal.PostTransfer(g.ID, g.Currency, from, to, counterparties, amounts)
+	return err
+}
+
+// listGroupExpenses handles GET /v1/groups/:id/expenses, which takes the same
+// filters as GET /v1/expenses.
+func listGroupExpenses(c echo.Context) error {
+	g, err := groupFromParam(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	q := newListQuery(c)
+	return respondWithExpenses(c, q, newExpenseFilter(q), g.Expenses)
+}
+
+// groupBalances is the response body of getGroupBalances. Balances and debts
+// are in the group's currency.
+type groupBalances struct {
+	Group    int32 // ID of the group
+	Currency models.Currency
+	Balances map[int32]models.Money                     // Net position of each member, positive if owed money
+	Original map[int32]map[models.Currency]models.Money `json:",omitempty"` // Balances in the currencies they were run up in, by user ID and then currency
+	Debts    []ledger.Debt
+}
+
+// getGroupBalances handles GET /v1/groups/:id/balances. ?original=true also
+// shows each balance in the currencies of the expenses and payments behind
+// it, before they were converted to the group's currency.
+func getGroupBalances(c echo.Context) error {
+	eachGroup, err := groupFromParam(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	result := newGroupBalances(eachGroup)
+	if originalStr := c.QueryParam("original"); originalStr != "" {
+		original, err := strconv.ParseBool(originalStr)
+		if err != nil {
+			return invalid("original", "original must be true or false")
+		}
+		if original {
+			result.Original = originalBalances.Balances(eachGroup.ID)
+		}
+	}
+	infoLogger.Println("Retrieved Balances For Group: ", eachGroup.ID)
+	return c.JSON(http.StatusOK, result)
+}
+
+// newGroupBalances returns the group's balances, including those of members
+// with nothing owed either way.
+func newGroupBalances(g *group.Group) groupBalances {
+	balances := debtLedger.Balances(g.ID)
+	for _, member := range g.Members {
+		balances[member.Id] += 0
+	}
+	return groupBalances{Group: g.ID, Currency: g.Currency, Balances: balances, Debts: debtLedger.GroupDebts(g.ID)}
+}
+
+// settlePlan is the response body of getSettlePlan.
+type settlePlan struct {
+	Group     int32 // ID of the group
+	KeepPairs bool
+	Transfers []ledger.Transfer
+	Drafts    []*models.Payment // One payment per transfer, ready to submit to POST /v1/payments
+}
+
+func getSettlePlan(c echo.Context) error {
+	settleGroup, err := groupFromParam(c, viewGroup)
+	if err != nil {
+		return err
+	}
+
+	keepPairs := false
+	if keepPairsStr := c.QueryParam("keepPairs"); keepPairsStr != "" {
+		keepPairs, err = strconv.ParseBool(keepPairsStr)
+		if err != nil {
+			return invalid("keepPairs", "keepPairs must be true or false")
+		}
+	}
+
+	plan := settlePlan{Group: settleGroup.ID, KeepPairs: keepPairs, Transfers: debtLedger.SettlePlan(settleGroup.ID, keepPairs)}
+	for _, transfer := range plan.Transfers {
+		plan.Drafts = append(plan.Drafts, draftPayment(settleGroup, transfer))
+	}
+
+	infoLogger.Println("Computed Settle Plan For Group: ", settleGroup.ID)
+	return c.JSON(http.StatusOK, plan)
+}
+
+// draftPayment returns a payment making the transfer. Drafts settle up
+// balances in the group rather than specific expenses.
+func draftPayment(g *group.Group, transfer ledger.Transfer) *models.Payment {
+	return &models.Payment{
+		Payer:    transfer.From,
+		Payee:    transfer.To,
+		Amount:   transfer.Amount,
+		Currency: g.Currency,
+		Rate:     models.OneRate,
+		Mode:     models.Cash,
+		Note:     "Settle up",
+		GroupID:  g.ID,
+	}
+}
+
+// groupFromParam finds the group named by the :id path parameter, and checks
+// that the signed in user may take the action in it.
+func groupFromParam(c echo.Context, a action) (*group.Group, error) {
+	id, err := id32Param(c, "id")
+	if err != nil {
+		return nil, err
+	}
+	missing := notFound("id", fmt.Sprintf("Group %d not found", id))
+	g := findGroupByID(id)
+	if g == nil {
+		return nil, missing
+	}
+	if err := authorize(c, g, a, missing); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// memberFromParams finds the group named by the :id path parameter and its
+// member named by :userId, and checks that the signed in user may take the
+// action in the group.
+func memberFromParams(c echo.Context, a action) (*group.Group, *models.User, error) {
+	g, err := groupFromParam(c, a)
+	if err != nil {
+		return nil, nil, err
+	}
+	userID, err := id32Param(c, "userId")
+	if err != nil {
+		return nil, nil, err
+	}
+	member := findUserByID(userID)
+	if member == nil || !g.HasMember(member.Id) {
+		return nil, nil, notFound("userId", fmt.Sprintf("User %d is not a member of group %d", userID, g.ID))
+	}
+	return g, member, nil
+}
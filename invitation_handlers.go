@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/models"
+	"splitwise/store"
+	"time"
+)
+
+// Invitations expire after defaultInvitationDays unless asked otherwise, and
+// after maxInvitationDays at most.
+const (
+	defaultInvitationDays = 7
+	maxInvitationDays     = 30
+)
+
+// invitationResponse is how invitations are returned. Token and JoinPath are
+// only returned when the invitation is made.
+type invitationResponse struct {
+	*group.Invitation
+	Status    group.InvitationStatus // Shadows the stored status, so expired invitations say so
+	GroupName string
+	Token     string `json:",omitempty"`
+	JoinPath  string `json:",omitempty"` // Where to preview (GET) or use (POST) the invitation
+}
+
+func newInvitationResponse(invitation *group.Invitation) invitationResponse {
+	response := invitationResponse{Invitation: invitation, Status: invitation.StatusAt(time.Now())}
+	if g := findGroupByID(invitation.GroupID); g != nil {
+		response.GroupName = g.Name
+	}
+	return response
+}
+
+// createInvitation handles POST /v1/groups/:id/invitations. An invitation with
+// an email address can only be used by whoever signs in or up with it; one
+// without is a join link anyone with the token can use. The token is only ever
+// returned here.
+func createInvitation(c echo.Context) error {
+	g, err := groupFromParam(c, manageMembers)
+	if err != nil {
+		return err
+	}
+	req := createInvitationRequest{role: group.Member, ExpiresInDays: defaultInvitationDays}
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	if req.role.AtLeast(group.Admin) {
+		if err := authorize(c, g, manageAdmins, nil); err != nil {
+			return err
+		}
+	}
+	if req.Email != "" {
+		if user := findUserByEmail(req.Email); user != nil && g.HasMember(user.Id) {
+			return conflict("email", fmt.Sprintf("User %d is already a member of group %d", user.Id, g.ID))
+		}
+	}
+
+	invitation, token, err := group.NewInvitation(g.ID, req.Email, req.role, currentUser(c).Id, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		return internalError("Error making invitation", err)
+	}
+	if err := db.Invitations.Add(invitation); err != nil {
+		return internalError("Error storing invitation", err)
+	}
+	infoLogger.Println("Created Invitation", invitation.ID, "To Group", g.ID)
+	response := newInvitationResponse(invitation)
+	response.Token, response.JoinPath = token, "/v1/join/"+token
+	return c.JSON(http.StatusCreated, response)
+}
+
+// listGroupInvitations handles GET /v1/groups/:id/invitations. ?status= only
+// lists invitations with that status.
+func listGroupInvitations(c echo.Context) error {
+	g, err := groupFromParam(c, manageMembers)
+	if err != nil {
+		return err
+	}
+	invitations, err := db.Invitations.List()
+	if err != nil {
+		return internalError("Error listing invitations", err)
+	}
+	status := group.InvitationStatus(c.QueryParam("status"))
+	now := time.Now()
+	var matching []*group.Invitation
+	for _, invitation := range invitations {
+		if invitation.GroupID == g.ID && (status == "" || invitation.StatusAt(now) == status) {
+			matching = append(matching, invitation)
+		}
+	}
+	return respondWithInvitations(c, newListQuery(c), matching)
+}
+
+// revokeInvitation handles DELETE /v1/groups/:id/invitations/:invitationId.
+// The invitation is kept, so that it shows up as revoked, but can't be used
+// any more.
+func revokeInvitation(c echo.Context) error {
+	g, err := groupFromParam(c, manageMembers)
+	if err != nil {
+		return err
+	}
+	id, err := intParam(c, "invitationId")
+	if err != nil {
+		return err
+	}
+	invitation, err := db.Invitations.Get(id)
+	if err != nil || invitation.GroupID != g.ID {
+		return notFound("invitationId", fmt.Sprintf("Invitation %d not found in group %d", id, g.ID))
+	}
+	if invitation.Role.AtLeast(group.Admin) {
+		if err := authorize(c, g, manageAdmins, nil); err != nil {
+			return err
+		}
+	}
+	if status := invitation.StatusAt(time.Now()); status != group.Pending {
+		return conflict("invitationId", fmt.Sprintf("Invitation %d is already %s", id, status))
+	}
+
+	invitation.Status = group.Revoked
+	if err := db.Invitations.Update(invitation); err != nil {
+		invitation.Status = group.Pending
+		return internalError("Error storing invitation", err)
+	}
+	infoLogger.Println("Revoked Invitation", invitation.ID, "To Group", g.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// listMyInvitations handles GET /v1/invitations, which lists the pending
+// invitations addressed to the signed in user.
+func listMyInvitations(c echo.Context) error {
+	invitations, err := db.Invitations.List()
+	if err != nil {
+		return internalError("Error listing invitations", err)
+	}
+	email := currentUser(c).Email
+	now := time.Now()
+	var matching []*group.Invitation
+	for _, invitation := range invitations {
+		if invitation.Email == email && invitation.StatusAt(now) == group.Pending {
+			matching = append(matching, invitation)
+		}
+	}
+	return respondWithInvitations(c, newListQuery(c), matching)
+}
+
+// invitationSorts are the orders invitations can be listed in.
+var invitationSorts = sortFields[*group.Invitation]{
+	"id":        func(i *group.Invitation) sortKey { return sortKey{ID: i.ID} },
+	"expiresAt": func(i *group.Invitation) sortKey { return sortKey{Number: i.ExpiresAt.UnixNano(), ID: i.ID} },
+}
+
+// respondWithInvitations responds with the page of invitations, each with its
+// status as of now.
+func respondWithInvitations(c echo.Context, q *listQuery, invitations []*group.Invitation) error {
+	result, err := paginate(q, invitations, invitationSorts)
+	if err != nil {
+		return err
+	}
+	items := result.Items.([]*group.Invitation)
+	responses := make([]invitationResponse, len(items))
+	for i, invitation := range items {
+		responses[i] = newInvitationResponse(invitation)
+	}
+	result.Items = responses
+	return c.JSON(http.StatusOK, result)
+}
+
+// acceptInvitation handles POST /v1/invitations/:id/accept, which makes the
+// signed in user a member of the group they were invited to. Emails aren't
+// verified, so the user must also give the invitation's token, which only
+// whoever really has the address was sent.
+func acceptInvitation(c echo.Context) error {
+	invitation, err := addressedInvitationFromParam(c)
+	if err != nil {
+		return err
+	}
+	var req acceptInvitationRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	if group.HashToken(req.Token) != invitation.TokenHash {
+		return forbidden("Token does not match the invitation")
+	}
+	return join(c, invitation)
+}
+
+// declineInvitation handles POST /v1/invitations/:id/decline.
+func declineInvitation(c echo.Context) error {
+	invitation, err := addressedInvitationFromParam(c)
+	if err != nil {
+		return err
+	}
+	invitation.Status = group.Declined
+	if err := db.Invitations.Update(invitation); err != nil {
+		invitation.Status = group.Pending
+		return internalError("Error storing invitation", err)
+	}
+	infoLogger.Println("Declined Invitation", invitation.ID)
+	return c.JSON(http.StatusOK, newInvitationResponse(invitation))
+}
+
+// addressedInvitationFromParam finds the pending invitation named by the :id
+// path parameter, which must be addressed to the signed in user.
+func addressedInvitationFromParam(c echo.Context) (*group.Invitation, error) {
+	id, err := idParam(c)
+	if err != nil {
+		return nil, err
+	}
+	invitation, err := db.Invitations.Get(id)
+	if err != nil || invitation.Email != currentUser(c).Email {
+		return nil, notFound("id", fmt.Sprintf("Invitation %d not found", id))
+	}
+	if err := checkUsable(invitation); err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// previewInvitation handles GET /v1/join/:token, which shows the invitation and
+// the group it is to, so the user can decide whether to join.
+func previewInvitation(c echo.Context) error {
+	invitation, err := invitationFromToken(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newInvitationResponse(invitation))
+}
+
+// joinWithToken handles POST /v1/join/:token, which makes the signed in user a
+// member of the group the invitation is to. Invitations addressed to someone
+// can only be used by them.
+func joinWithToken(c echo.Context) error {
+	invitation, err := invitationFromToken(c)
+	if err != nil {
+		return err
+	}
+	if !invitation.IsLink() && invitation.Email != currentUser(c).Email {
+		return forbidden("This invitation is addressed to someone else")
+	}
+	if err := checkUsable(invitation); err != nil {
+		return err
+	}
+	return join(c, invitation)
+}
+
+// invitationFromToken finds the invitation whose token is the :token path
+// parameter.
+func invitationFromToken(c echo.Context) (*group.Invitation, error) {
+	invitation, err := db.Invitations.FindByToken(group.HashToken(c.Param("token")))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, notFound("token", "Invitation not found")
+	} else if err != nil {
+		return nil, internalError("Error finding invitation", err)
+	}
+	return invitation, nil
+}
+
+// checkUsable returns an error unless the invitation is still pending.
+func checkUsable(invitation *group.Invitation) error {
+	if status := invitation.StatusAt(time.Now()); status != group.Pending {
+		return conflict("", fmt.Sprintf("Invitation %d is %s", invitation.ID, status))
+	}
+	return nil
+}
+
+// join makes the signed in user a member of the group with the invitation's
+// role, and responds with the group.
+func join(c echo.Context, invitation *group.Invitation) error {
+	user := currentUser(c)
+	g := findGroupByID(invitation.GroupID)
+	if g == nil {
+		return notFound("", fmt.Sprintf("Group %d not found", invitation.GroupID))
+	}
+	if g.HasMember(user.Id) {
+		return conflict("", fmt.Sprintf("User %d is already a member of group %d", user.Id, g.ID))
+	}
+	if err := joinGroup(db, user, g, invitation); err != nil {
+		return internalError("Error storing group", err)
+	}
+	infoLogger.Println("User", user.Id, "Joined Group", g.ID, "With Invitation", invitation.ID)
+	return c.JSON(http.StatusOK, g)
+}
+
+// joinGroup adds the user to the group with the invitation's role and uses the
+// invitation up, saving both together, and records them joining in the group's
+// activity and the audit log. Nothing changes if that fails.
+func joinGroup(s *store.Store, user *models.User, g *group.Group, invitation *group.Invitation) error {
+	state, err := auditState(g)
+	if err != nil {
+		return err
+	}
+	status, before := invitation.Status, g.Membership()
+	g.AddMember(user)
+	g.SetRole(user.Id, invitation.Role)
+	invitation.Uses++
+	if !invitation.IsLink() {
+		invitation.Status = group.Accepted
+	}
+	if err := s.Transaction(func(tx *store.Store) error {
+		if err := tx.Groups.Update(g); err != nil {
+			return err
+		}
+		if err := tx.Invitations.Update(invitation); err != nil {
+			return err
+		}
+		return recordAudit(tx, user.Id, auditChange{audit.Updated, g, state})
+	}); err != nil {
+		g.RestoreMembership(before)
+		invitation.Uses--
+		invitation.Status = status
+		return err
+	}
+	recordActivity(g.ID, user.Id, group.Joined, group.MemberSubject, int(user.Id), fmt.Sprintf("Joined as %s with invitation %d", invitation.Role, invitation.ID))
+	return nil
+}
@@ -0,0 +1,97 @@
+package journal
+
+import (
+	"splitwise/models"
+	"sync"
+)
+
+// UserBalances is a projection that keeps models.User.Balance equal to the sum
+// of the user's postings across every group.
+type UserBalances struct {
+	mu    sync.Mutex
+	users map[int32]*models.User
+}
+
+// NewUserBalances creates an empty UserBalances projection.
+func NewUserBalances() *UserBalances {
+	return &UserBalances{users: make(map[int32]*models.User)}
+}
+
+// Reset sets the balance of every user seen so far back to zero.
+func (b *UserBalances) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, user := range b.users {
+		user.Balance = 0
+	}
+}
+
+// Apply adds each posting to its account holder's balance.
+func (b *UserBalances) Apply(entry Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, posting := range entry.Postings {
+		b.users[posting.Account.Id] = posting.Account
+		posting.Account.Balance += posting.Amount
+	}
+}
+
+// OriginalBalances is a projection that keeps each user's balance in every
+// group in the currencies of the expenses and payments behind it, before they
+// were converted to the group's currency.
+type OriginalBalances struct {
+	mu       sync.Mutex
+	balances map[int32]map[int32]map[models.Currency]models.Money // By group, then user, then currency
+}
+
+// NewOriginalBalances creates an empty OriginalBalances projection.
+func NewOriginalBalances() *OriginalBalances {
+	return &OriginalBalances{balances: make(map[int32]map[int32]map[models.Currency]models.Money)}
+}
+
+// Reset forgets every balance.
+func (b *OriginalBalances) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.balances = make(map[int32]map[int32]map[models.Currency]models.Money)
+}
+
+// Apply adds the original amount of each posting to its account holder's
+// balance in the posting's currency.
+func (b *OriginalBalances) Apply(entry Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, posting := range entry.Postings {
+		users := b.balances[posting.Group]
+		if users == nil {
+			users = make(map[int32]map[models.Currency]models.Money)
+			b.balances[posting.Group] = users
+		}
+		currencies := users[posting.Account.Id]
+		if currencies == nil {
+			currencies = make(map[models.Currency]models.Money)
+			users[posting.Account.Id] = currencies
+		}
+		currencies[posting.Currency] += posting.Original
+	}
+}
+
+// Balances returns a copy of every user's balance in the group by user ID and
+// then currency, leaving out currencies a user's balance is zero in.
+func (b *OriginalBalances) Balances(group int32) map[int32]map[models.Currency]models.Money {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make(map[int32]map[models.Currency]models.Money)
+	for user, currencies := range b.balances[group] {
+		for currency, balance := range currencies {
+			if balance == 0 {
+				continue
+			}
+			if result[user] == nil {
+				result[user] = make(map[models.Currency]models.Money)
+			}
+			result[user][currency] = balance
+		}
+	}
+	return result
+}
@@ -0,0 +1,331 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"splitwise/models"
+	"sync"
+	"time"
+)
+
+// EntryKind identifies what caused a journal entry.
+type EntryKind string
+
+const (
+	ExpenseEntry  EntryKind = "Expense"
+	PaymentEntry  EntryKind = "Payment"
+	ReversalEntry EntryKind = "Reversal"
+	TransferEntry EntryKind = "Transfer"
+)
+
+// Posting is one side of a debt between two users in a group. Amount is from
+// the Account holder's point of view: positive if Counterparty owes Account,
+// negative if Account owes Counterparty. It is in the group's currency,
+// converted from Original, the amount in the Currency of the expense or
+// payment.
+type Posting struct {
+	Group        int32 // ID of the group the debt is in
+	Account      *models.User
+	Counterparty *models.User
+	Amount       models.Money
+	Currency     models.Currency
+	Original     models.Money
+}
+
+// Entry is a balanced set of postings recorded for a single expense or payment.
+// The postings of an entry always sum to zero.
+type Entry struct {
+	ID        int
+	Kind      EntryKind
+	Reference int // ID of the expense or payment that caused the entry, or of the user whose debts were transferred
+	Reverses  int // For reversal entries, the ID of the entry being reversed
+	Timestamp time.Time
+	Postings  []Posting
+}
+
+// Projection is state derived from the journal, such as user balances. A
+// projection must be rebuildable by calling Reset and then Apply for every
+// entry in order.
+type Projection interface {
+	Reset()
+	Apply(entry Entry)
+}
+
+// Log is where a journal saves its entries so that they outlast the process,
+// such as the journal repository of a store.
+type Log interface {
+	Append(e *Entry) error
+	List() ([]*Entry, error)
+}
+
+// Journal is an append-only, double-entry record of every balance change.
+// Balances are never changed in place; they are projections of the journal.
+type Journal struct {
+	mu          sync.Mutex
+	entries     []Entry
+	projections []Projection
+	log         Log // Nil until Load
+}
+
+// New creates an empty Journal that keeps the given projections up to date.
+func New(projections ...Projection) *Journal {
+	return &Journal{projections: projections}
+}
+
+// Post validates and appends an entry, then applies it to every projection.
+func (j *Journal) Post(kind EntryKind, reference int, postings []Posting) (Entry, error) {
+	if len(postings) == 0 {
+		return Entry{}, errors.New("journal entries need at least one posting")
+	}
+	total := models.Money(0)
+	for _, posting := range postings {
+		if posting.Account == nil || posting.Counterparty == nil {
+			return Entry{}, errors.New("postings need an account and a counterparty")
+		}
+		total += posting.Amount
+	}
+	if total != 0 {
+		return Entry{}, fmt.Errorf("journal entry is unbalanced by %s", total)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.append(Entry{Kind: kind, Reference: reference, Postings: postings})
+}
+
+// append numbers and timestamps an entry, saves it to the log, appends it and
+// applies it to every projection. An entry the log fails to save is not
+// appended. The caller must hold j.mu.
+func (j *Journal) append(entry Entry) (Entry, error) {
+	entry.ID = 1
+	if n := len(j.entries); n > 0 {
+		entry.ID = j.entries[n-1].ID + 1
+	}
+	entry.Timestamp = time.Now()
+	if j.log != nil {
+		if err := j.log.Append(&entry); err != nil {
+			return Entry{}, fmt.Errorf("saving journal entry %d: %w", entry.ID, err)
+		}
+	}
+	j.entries = append(j.entries, entry)
+	for _, projection := range j.projections {
+		projection.Apply(entry)
+	}
+	return entry, nil
+}
+
+// Load replaces the journal's entries with those saved in log, in the order
+// they were posted, and rebuilds every projection from them. From then on,
+// every entry is saved to log before it is posted.
+func (j *Journal) Load(log Log) error {
+	saved, err := log.List()
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = make([]Entry, len(saved))
+	for i, entry := range saved {
+		j.entries[i] = *entry
+	}
+	j.log = log
+	j.rebuild()
+	return nil
+}
+
+// Reverse undoes every entry of the given kind and reference that has not been
+// reversed yet, such as the entry posted for an expense that is being edited or
+// deleted. Each one gets a reversal entry with its postings negated, so the
+// original entries stay in the journal untouched. If a reversal can't be
+// saved, those before it are kept and the error is returned.
+func (j *Journal) Reverse(kind EntryKind, reference int) ([]Entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	reversed := make(map[int]bool)
+	for _, entry := range j.entries {
+		if entry.Kind == ReversalEntry {
+			reversed[entry.Reverses] = true
+		}
+	}
+
+	var reversals []Entry
+	for _, entry := range j.entries {
+		if entry.Kind != kind || entry.Reference != reference || reversed[entry.ID] {
+			continue
+		}
+		postings := make([]Posting, len(entry.Postings))
+		for i, posting := range entry.Postings {
+			posting.Amount, posting.Original = -posting.Amount, -posting.Original
+			postings[i] = posting
+		}
+		reversal, err := j.append(Entry{
+			Kind:      ReversalEntry,
+			Reference: reference,
+			Reverses:  entry.ID,
+			Postings:  postings,
+		})
+		if err != nil {
+			return reversals, err
+		}
+		reversals = append(reversals, reversal)
+	}
+	return reversals, nil
+}
+
+// PostExpense records that every user sharing the expense owes the payer their
+// share of it, converted to currency, the group's currency, at the expense's
+// rate. Each share is converted on its own, so the converted shares may not
+// add up to the converted amount exactly.
+func (j *Journal) PostExpense(group int32, currency models.Currency, e *models.Expense) (Entry, error) {
+	if e.PaidBy == nil {
+		return Entry{}, errors.New("paidBy cannot be nil")
+	}
+	shares, err := e.Shares()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var postings []Posting
+	for i, user := range e.SplitBetween {
+		if user.Id != e.PaidBy.Id && shares[i] != 0 {
+			owed, err := debt(group, currency, user, e.PaidBy, shares[i], e.Currency, e.Rate)
+			if err != nil {
+				return Entry{}, err
+			}
+			postings = append(postings, owed...)
+		}
+	}
+	if len(postings) == 0 {
+		// Nobody but the payer shares the expense, so no balance changes
+		return Entry{}, nil
+	}
+	return j.Post(ExpenseEntry, e.ID, postings)
+}
+
+// PostPayment records that the payment's payer paid its payee, which reduces
+// what the payer owes the payee in the group. A payment that settles expenses
+// takes off each of its allocations converted at the rate of the expense it
+// went to, so that settling an expense in full clears exactly what it added
+// whatever the rate has done since. A payment that settles up the group is
+// converted at the payment's own rate. Amounts are converted to currency, the
+// group's currency.
+func (j *Journal) PostPayment(group int32, currency models.Currency, p *models.Payment) (Entry, error) {
+	if p.Payer == nil || p.Payee == nil {
+		return Entry{}, errors.New("payer and payee cannot be nil")
+	}
+	if len(p.Allocations) == 0 {
+		paid, err := debt(group, currency, p.Payee, p.Payer, p.Amount, p.Currency, p.Rate)
+		if err != nil {
+			return Entry{}, err
+		}
+		return j.Post(PaymentEntry, p.ID, paid)
+	}
+
+	rates := make(map[int]models.Rate, len(p.Expenses))
+	for _, expense := range p.Expenses {
+		rates[expense.ID] = expense.Rate
+	}
+	var postings []Posting
+	for _, allocation := range p.Allocations {
+		rate, ok := rates[allocation.Expense]
+		if !ok {
+			return Entry{}, fmt.Errorf("payment %d is allocated to expense %d, which it does not settle", p.ID, allocation.Expense)
+		}
+		if allocation.Amount != 0 {
+			paid, err := debt(group, currency, p.Payee, p.Payer, allocation.Amount, p.Currency, rate)
+			if err != nil {
+				return Entry{}, err
+			}
+			postings = append(postings, paid...)
+		}
+	}
+	return j.Post(PaymentEntry, p.ID, postings)
+}
+
+// PostTransfer records that to took over every debt from had in the group.
+// amounts[i] is what counterparties[i] owed from in the group's currency,
+// negative if from owed them. Debts between from and to are cleared, since
+// nobody owes themselves.
+func (j *Journal) PostTransfer(group int32, currency models.Currency, from, to *models.User, counterparties []*models.User, amounts []models.Money) (Entry, error) {
+	if from == nil || to == nil {
+		return Entry{}, errors.New("from and to cannot be nil")
+	}
+	if len(counterparties) != len(amounts) {
+		return Entry{}, errors.New("every counterparty needs an amount")
+	}
+
+	var postings []Posting
+	for i, counterparty := range counterparties {
+		if amounts[i] == 0 {
+			continue
+		}
+		owed, err := debt(group, currency, from, counterparty, amounts[i], currency, models.OneRate)
+		if err != nil {
+			return Entry{}, err
+		}
+		postings = append(postings, owed...)
+		if counterparty.Id != to.Id {
+			owed, err := debt(group, currency, counterparty, to, amounts[i], currency, models.OneRate)
+			if err != nil {
+				return Entry{}, err
+			}
+			postings = append(postings, owed...)
+		}
+	}
+	if len(postings) == 0 {
+		return Entry{}, nil
+	}
+	return j.Post(TransferEntry, int(from.Id), postings)
+}
+
+// debt returns the two postings recording that debtor owes creditor original,
+// an amount in currency worth rate each in groupCurrency, the group's currency.
+func debt(group int32, groupCurrency models.Currency, debtor, creditor *models.User, original models.Money, currency models.Currency, rate models.Rate) ([]Posting, error) {
+	amount, err := rate.Convert(original, groupCurrency)
+	if err != nil {
+		return nil, err
+	}
+	return []Posting{
+		{Group: group, Account: creditor, Counterparty: debtor, Amount: amount, Currency: currency, Original: original},
+		{Group: group, Account: debtor, Counterparty: creditor, Amount: -amount, Currency: currency, Original: -original},
+	}, nil
+}
+
+// Entries returns a copy of every entry in the order they were posted.
+func (j *Journal) Entries() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Entry(nil), j.entries...)
+}
+
+// Rebuild resets every projection and replays the whole journal into it.
+func (j *Journal) Rebuild() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.rebuild()
+}
+
+// rebuild resets and replays every projection. The caller must hold j.mu.
+func (j *Journal) rebuild() {
+	for _, projection := range j.projections {
+		projection.Reset()
+		for _, entry := range j.entries {
+			projection.Apply(entry)
+		}
+	}
+}
+
+// TrialBalance returns the sum of every posting in the journal, which is zero
+// as long as every entry balances.
+func (j *Journal) TrialBalance() models.Money {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	total := models.Money(0)
+	for _, entry := range j.entries {
+		for _, posting := range entry.Postings {
+			total += posting.Amount
+		}
+	}
+	return total
+}
@@ -0,0 +1,321 @@
+package journal
+
+import (
+	"errors"
+	"math"
+	"splitwise/models"
+	"testing"
+)
+
+// tripGroup is the ID of the group debts are recorded in.
+const tripGroup int32 = 1
+
+// owes returns the postings recording that debtor owes creditor amount in the
+// trip group's currency.
+func owes(t *testing.T, debtor, creditor *models.User, amount models.Money) []Posting {
+	t.Helper()
+	postings, err := debt(tripGroup, models.DefaultCurrency, debtor, creditor, amount, models.DefaultCurrency, models.OneRate)
+	if err != nil {
+		t.Fatalf("debt() error = %v", err)
+	}
+	return postings
+}
+
+func TestJournal_Post(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+
+	tests := []struct {
+		name     string
+		postings []Posting
+		wantErr  bool
+	}{
+		{name: "Balanced", postings: owes(t, bob, alice, 5000)},
+		{name: "Unbalanced", postings: []Posting{{Group: tripGroup, Account: alice, Counterparty: bob, Amount: 5000}}, wantErr: true},
+		{name: "Missing Counterparty", postings: []Posting{{Group: tripGroup, Account: alice, Amount: 0}}, wantErr: true},
+		{name: "Empty", postings: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := New()
+			_, err := j.Post(ExpenseEntry, 1, tt.postings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Post() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			wantEntries := 1
+			if tt.wantErr {
+				wantEntries = 0
+			}
+			if got := len(j.Entries()); got != wantEntries {
+				t.Errorf("len(Entries()) = %d, want %d", got, wantEntries)
+			}
+		})
+	}
+}
+
+func TestJournal_UserBalances(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	carol := &models.User{Id: 3, Name: "Carol"}
+	j := New(NewUserBalances())
+
+	dinner := &models.Expense{ID: 1, Amount: 100000, PaidBy: alice, SplitBetween: []*models.User{alice, bob, carol}, SplitRate: []int64{1, 1, 1}}
+	if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, dinner); err != nil {
+		t.Fatalf("PostExpense() error = %v", err)
+	}
+	payment := &models.Payment{ID: 1, Payer: bob, Payee: alice, Amount: 33330}
+	if _, err := j.PostPayment(tripGroup, models.DefaultCurrency, payment); err != nil {
+		t.Fatalf("PostPayment() error = %v", err)
+	}
+
+	check := func(when string) {
+		t.Helper()
+		if alice.Balance != 33330 || bob.Balance != 0 || carol.Balance != -33330 {
+			t.Errorf("%s: balances = %v, %v, %v, want 33.33, 0.00, -33.33", when, alice.Balance, bob.Balance, carol.Balance)
+		}
+		if total := j.TrialBalance(); total != 0 {
+			t.Errorf("%s: TrialBalance() = %v, want 0.00", when, total)
+		}
+	}
+	check("after posting")
+
+	// Balances are a projection, so tampering with them is undone by a rebuild
+	alice.Balance, bob.Balance = 1, 2
+	j.Rebuild()
+	check("after Rebuild()")
+}
+
+func TestJournal_Reverse(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	j := New(NewUserBalances())
+
+	dinner := &models.Expense{ID: 1, Amount: 100000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitRate: []int64{1, 1}}
+	if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, dinner); err != nil {
+		t.Fatalf("PostExpense() error = %v", err)
+	}
+
+	reversals, err := j.Reverse(ExpenseEntry, dinner.ID)
+	if err != nil {
+		t.Fatalf("Reverse() error = %v", err)
+	}
+	if len(reversals) != 1 || reversals[0].Reverses != 1 || reversals[0].Kind != ReversalEntry {
+		t.Fatalf("Reverse() = %v, want one reversal of entry 1", reversals)
+	}
+	if alice.Balance != 0 || bob.Balance != 0 {
+		t.Errorf("balances after Reverse() = %v, %v, want 0.00, 0.00", alice.Balance, bob.Balance)
+	}
+
+	// Reversed entries are not reversed twice
+	if got, _ := j.Reverse(ExpenseEntry, dinner.ID); len(got) != 0 {
+		t.Errorf("second Reverse() = %v, want no entries", got)
+	}
+
+	// Reposting the edited expense only applies the new amount
+	dinner.Amount = 60000
+	if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, dinner); err != nil {
+		t.Fatalf("PostExpense() error = %v", err)
+	}
+	if alice.Balance != 30000 || bob.Balance != -30000 {
+		t.Errorf("balances after edit = %v, %v, want 30.00, -30.00", alice.Balance, bob.Balance)
+	}
+	if got := len(j.Entries()); got != 3 {
+		t.Errorf("len(Entries()) = %d, want 3", got)
+	}
+}
+
+// sliceLog is a Log kept in memory, which fails to save entries while err is
+// set.
+type sliceLog struct {
+	entries []*Entry
+	err     error
+}
+
+func (l *sliceLog) Append(e *Entry) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.entries = append(l.entries, e)
+	return nil
+}
+
+func (l *sliceLog) List() ([]*Entry, error) {
+	return l.entries, nil
+}
+
+func TestJournal_Load(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	log := &sliceLog{}
+	j := New(NewUserBalances())
+	if err := j.Load(log); err != nil {
+		t.Fatalf("Load() of an empty log error = %v", err)
+	}
+
+	dinner := &models.Expense{ID: 1, Amount: 100000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitRate: []int64{1, 1}}
+	if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, dinner); err != nil {
+		t.Fatalf("PostExpense() error = %v", err)
+	}
+	if _, err := j.Reverse(ExpenseEntry, dinner.ID); err != nil {
+		t.Fatalf("Reverse() error = %v", err)
+	}
+	dinner.Amount = 60000
+	if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, dinner); err != nil {
+		t.Fatalf("PostExpense() error = %v", err)
+	}
+	if len(log.entries) != 3 {
+		t.Fatalf("saved entries = %v, want all 3", log.entries)
+	}
+
+	// A journal loaded from the log has the same entries and balances, and
+	// numbers new entries after them
+	alice.Balance, bob.Balance = 0, 0
+	loaded := New(NewUserBalances())
+	if err := loaded.Load(log); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if alice.Balance != 30000 || bob.Balance != -30000 {
+		t.Errorf("balances after Load() = %v, %v, want 30.00, -30.00", alice.Balance, bob.Balance)
+	}
+	if got := loaded.Entries(); len(got) != 3 || got[1].Reverses != 1 {
+		t.Errorf("Entries() after Load() = %v, want the expense, its reversal and the edit", got)
+	}
+	entry, err := loaded.Post(PaymentEntry, 1, owes(t, alice, bob, 30000))
+	if err != nil || entry.ID != 4 {
+		t.Errorf("Post() after Load() = %+v, %v, want entry 4", entry, err)
+	}
+
+	// Entries that can't be saved aren't posted
+	log.err = errors.New("disk full")
+	if _, err := loaded.Reverse(PaymentEntry, 1); !errors.Is(err, log.err) {
+		t.Errorf("Reverse() error = %v, want %v", err, log.err)
+	}
+	if alice.Balance != 0 || len(loaded.Entries()) != 4 {
+		t.Errorf("after a failed save, Alice's balance = %v with %d entries, want 0.00 with 4", alice.Balance, len(loaded.Entries()))
+	}
+}
+
+func TestJournal_PostTransfer(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	carol := &models.User{Id: 3, Name: "Carol"}
+	j := New(NewUserBalances())
+
+	dinner := &models.Expense{ID: 1, Amount: 90000, PaidBy: alice, SplitBetween: []*models.User{alice, bob, carol}, SplitRate: []int64{1, 1, 1}}
+	if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, dinner); err != nil {
+		t.Fatalf("PostExpense() error = %v", err)
+	}
+
+	// Carol takes over what Bob owes Alice
+	entry, err := j.PostTransfer(tripGroup, models.DefaultCurrency, bob, carol, []*models.User{alice}, []models.Money{-30000})
+	if err != nil {
+		t.Fatalf("PostTransfer() error = %v", err)
+	}
+	if entry.Kind != TransferEntry || entry.Reference != int(bob.Id) {
+		t.Errorf("PostTransfer() = %+v, want a transfer entry referring to Bob", entry)
+	}
+	if alice.Balance != 60000 || bob.Balance != 0 || carol.Balance != -60000 {
+		t.Errorf("balances after transfer = %v, %v, %v, want 60.00, 0.00, -60.00", alice.Balance, bob.Balance, carol.Balance)
+	}
+
+	// Debts with the member taking them over are cleared
+	if _, err := j.PostTransfer(tripGroup, models.DefaultCurrency, carol, alice, []*models.User{alice}, []models.Money{-60000}); err != nil {
+		t.Fatalf("PostTransfer() error = %v", err)
+	}
+	if alice.Balance != 0 || carol.Balance != 0 {
+		t.Errorf("balances after transfer to the creditor = %v, %v, want 0.00, 0.00", alice.Balance, carol.Balance)
+	}
+	if total := j.TrialBalance(); total != 0 {
+		t.Errorf("TrialBalance() = %v, want 0.00", total)
+	}
+}
+
+func TestJournal_Currencies(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	originals := NewOriginalBalances()
+	j := New(NewUserBalances(), originals)
+
+	// Dinner in dollars worth 83.25 rupees each, repaid in part when they were
+	// worth 84
+	dinner := &models.Expense{ID: 1, Amount: 30000, Currency: "USD", Rate: 8325000000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitRate: []int64{1, 1}}
+	if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, dinner); err != nil {
+		t.Fatalf("PostExpense() error = %v", err)
+	}
+	payment := &models.Payment{ID: 1, Payer: bob, Payee: alice, Amount: 5000, Currency: "USD", Rate: 8400000000}
+	if _, err := j.PostPayment(tripGroup, models.DefaultCurrency, payment); err != nil {
+		t.Fatalf("PostPayment() error = %v", err)
+	}
+	if alice.Balance != 828750 || bob.Balance != -828750 {
+		t.Errorf("balances = %v, %v, want 828.75, -828.75", alice.Balance, bob.Balance)
+	}
+	if got := originals.Balances(tripGroup)[bob.Id]["USD"]; got != -10000 {
+		t.Errorf("Bob's balance in USD = %v, want -10.00", got)
+	}
+
+	// Reversing the payment restores the original balance too
+	if _, err := j.Reverse(PaymentEntry, payment.ID); err != nil {
+		t.Fatalf("Reverse() error = %v", err)
+	}
+	if got := originals.Balances(tripGroup)[alice.Id]; len(got) != 1 || got["USD"] != 15000 {
+		t.Errorf("Alice's balances after reversal = %v, want 15.00 USD", got)
+	}
+	if bob.Balance != -1248750 {
+		t.Errorf("Bob's balance after reversal = %v, want -1248.75", bob.Balance)
+	}
+}
+
+func TestJournal_MinorUnits(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	j := New(NewUserBalances())
+
+	// Lunch in dollars in a group that keeps its books in yen, at 150.55 yen
+	// to the dollar: Bob's 5.00 share is 752.75 yen, which rounds to 753
+	lunch := &models.Expense{ID: 1, Amount: 10000, Currency: "USD", Rate: 15055000000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitRate: []int64{1, 1}}
+	if _, err := j.PostExpense(tripGroup, "JPY", lunch); err != nil {
+		t.Fatalf("PostExpense() error = %v", err)
+	}
+	if alice.Balance != 753000 || bob.Balance != -753000 {
+		t.Errorf("balances = %v, %v, want 753.00, -753.00", alice.Balance, bob.Balance)
+	}
+
+	// Shares too large to convert are an error, and nothing is posted
+	huge := &models.Expense{ID: 2, Amount: math.MaxInt64 / 10 * 10, Currency: "USD", Rate: 15055000000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitRate: []int64{1, 1}}
+	if _, err := j.PostExpense(tripGroup, "JPY", huge); err == nil {
+		t.Error("PostExpense() of an expense too large to convert succeeded, want an error")
+	}
+	if len(j.Entries()) != 1 || bob.Balance != -753000 {
+		t.Errorf("after a failed post, Bob's balance = %v with %d entries, want -753.00 with 1", bob.Balance, len(j.Entries()))
+	}
+}
+
+func TestJournal_PostPayment_Settlement(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	j := New(NewUserBalances())
+
+	// Two dinners in dollars, made when they were worth 83.25 and 83.50
+	// rupees, settled in full once they are worth 84
+	first := &models.Expense{ID: 1, Amount: 30000, Currency: "USD", Rate: 8325000000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitRate: []int64{1, 1}}
+	second := &models.Expense{ID: 2, Amount: 10000, Currency: "USD", Rate: 8350000000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitRate: []int64{1, 1}}
+	for _, e := range []*models.Expense{first, second} {
+		if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, e); err != nil {
+			t.Fatalf("PostExpense() error = %v", err)
+		}
+	}
+	payment := &models.Payment{ID: 1, Payer: bob, Payee: alice, Amount: 20000, Currency: "USD", Rate: 8400000000,
+		Expenses: []*models.Expense{first, second}, Allocations: []models.Allocation{{Expense: 1, Amount: 15000}, {Expense: 2, Amount: 5000}}}
+	if _, err := j.PostPayment(tripGroup, models.DefaultCurrency, payment); err != nil {
+		t.Fatalf("PostPayment() error = %v", err)
+	}
+	if alice.Balance != 0 || bob.Balance != 0 {
+		t.Errorf("balances = %v, %v, want 0.00, 0.00", alice.Balance, bob.Balance)
+	}
+
+	stray := &models.Payment{ID: 2, Payer: bob, Payee: alice, Amount: 1000, Currency: "USD", Allocations: []models.Allocation{{Expense: 3, Amount: 1000}}}
+	if _, err := j.PostPayment(tripGroup, models.DefaultCurrency, stray); err == nil {
+		t.Error("PostPayment() of a payment allocated to an expense it doesn't settle succeeded, want an error")
+	}
+}
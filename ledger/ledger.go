@@ -0,0 +1,193 @@
+package ledger
+
+import (
+	"sort"
+	"splitwise/journal"
+	"splitwise/models"
+	"sync"
+)
+
+// pair identifies two users in a fixed order so that a debt between them is
+// stored once, with Low < High.
+type pair struct {
+	Low  int32
+	High int32
+}
+
+// Debt is an amount one user owes another inside a group.
+type Debt struct {
+	Group  int32 // ID of the group
+	From   *models.User
+	To     *models.User
+	Amount models.Money
+}
+
+// Ledger tracks pairwise debts between users, scoped to a group. Debts in the
+// same group are netted, so there is at most one Debt between two users in a
+// group, while debts in different groups are kept apart.
+//
+// Ledger is a journal.Projection: it is only ever changed by applying journal
+// entries, and can be rebuilt from the journal at any time.
+type Ledger struct {
+	mu    sync.RWMutex
+	users map[int32]*models.User
+	// debts holds, per group ID and pair, how much pair.Low owes pair.High.
+	// A negative amount means pair.High owes pair.Low.
+	debts map[int32]map[pair]models.Money
+}
+
+// New creates an empty Ledger.
+func New() *Ledger {
+	return &Ledger{
+		users: make(map[int32]*models.User),
+		debts: make(map[int32]map[pair]models.Money),
+	}
+}
+
+// Reset clears every debt.
+func (l *Ledger) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debts = make(map[int32]map[pair]models.Money)
+}
+
+// Apply records the debts in a journal entry. Every debt is posted twice, once
+// for each side, so only the debtor's side is used.
+func (l *Ledger) Apply(entry journal.Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, posting := range entry.Postings {
+		if posting.Amount < 0 && posting.Account.Id != posting.Counterparty.Id {
+			l.record(posting.Group, posting.Account, posting.Counterparty, -posting.Amount)
+		}
+	}
+}
+
+// record adds amount to what debtor owes creditor in the group. A negative
+// amount reduces the debt.
+func (l *Ledger) record(group int32, debtor, creditor *models.User, amount models.Money) {
+	l.users[debtor.Id] = debtor
+	l.users[creditor.Id] = creditor
+
+	key := pair{Low: debtor.Id, High: creditor.Id}
+	if debtor.Id > creditor.Id {
+		key = pair{Low: creditor.Id, High: debtor.Id}
+		amount = -amount
+	}
+
+	groupDebts, ok := l.debts[group]
+	if !ok {
+		groupDebts = make(map[pair]models.Money)
+		l.debts[group] = groupDebts
+	}
+	groupDebts[key] += amount
+	if groupDebts[key] == 0 {
+		delete(groupDebts, key)
+	}
+}
+
+// Owes returns how much debtor owes creditor in the group. The result is
+// negative if creditor owes debtor.
+func (l *Ledger) Owes(group int32, debtor, creditor int32) models.Money {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if debtor < creditor {
+		return l.debts[group][pair{Low: debtor, High: creditor}]
+	}
+	return -l.debts[group][pair{Low: creditor, High: debtor}]
+}
+
+// Balance returns the user's net position in the group: positive if the user
+// is owed money overall, negative if they owe money.
+func (l *Ledger) Balance(group int32, userID int32) models.Money {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.balance(group, userID)
+}
+
+func (l *Ledger) balance(group int32, userID int32) models.Money {
+	balance := models.Money(0)
+	for key, amount := range l.debts[group] {
+		switch userID {
+		case key.Low:
+			balance -= amount
+		case key.High:
+			balance += amount
+		}
+	}
+	return balance
+}
+
+// Balances returns the net position of every user with an outstanding debt in
+// the group, keyed by user ID.
+func (l *Ledger) Balances(group int32) map[int32]models.Money {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	balances := make(map[int32]models.Money)
+	for key, amount := range l.debts[group] {
+		balances[key.Low] -= amount
+		balances[key.High] += amount
+	}
+	return balances
+}
+
+// GroupDebts lists who owes whom in the group.
+func (l *Ledger) GroupDebts(group int32) []Debt {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var debts []Debt
+	for key, amount := range l.debts[group] {
+		debts = append(debts, l.debt(group, key, amount))
+	}
+	sortDebts(debts)
+	return debts
+}
+
+// UserDebts lists every debt the user is part of, across all groups.
+func (l *Ledger) UserDebts(userID int32) []Debt {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var debts []Debt
+	for group, groupDebts := range l.debts {
+		for key, amount := range groupDebts {
+			if key.Low == userID || key.High == userID {
+				debts = append(debts, l.debt(group, key, amount))
+			}
+		}
+	}
+	sortDebts(debts)
+	return debts
+}
+
+// UserBalances returns the user's net position in every group they have an
+// outstanding debt in, keyed by group ID.
+func (l *Ledger) UserBalances(userID int32) map[int32]models.Money {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	balances := make(map[int32]models.Money)
+	for group := range l.debts {
+		if balance := l.balance(group, userID); balance != 0 {
+			balances[group] = balance
+		}
+	}
+	return balances
+}
+
+func (l *Ledger) debt(group int32, key pair, amount models.Money) Debt {
+	if amount < 0 {
+		return Debt{Group: group, From: l.users[key.High], To: l.users[key.Low], Amount: -amount}
+	}
+	return Debt{Group: group, From: l.users[key.Low], To: l.users[key.High], Amount: amount}
+}
+
+func sortDebts(debts []Debt) {
+	sort.Slice(debts, func(i, j int) bool {
+		if debts[i].Group != debts[j].Group {
+			return debts[i].Group < debts[j].Group
+		}
+		if debts[i].From.Id != debts[j].From.Id {
+			return debts[i].From.Id < debts[j].From.Id
+		}
+		return debts[i].To.Id < debts[j].To.Id
+	})
+}
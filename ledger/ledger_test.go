@@ -0,0 +1,102 @@
+package ledger
+
+import (
+	"reflect"
+	"splitwise/journal"
+	"splitwise/models"
+	"testing"
+)
+
+// IDs of the groups debts are recorded in
+const houseGroup, tripGroup int32 = 1, 2
+
+func TestLedger_ExpenseDebts(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	carol := &models.User{Id: 3, Name: "Carol"}
+
+	l := New()
+	j := journal.New(l)
+	trip := &models.Expense{Amount: 300000, PaidBy: alice, SplitBetween: []*models.User{alice, bob, carol}, SplitRate: []int64{1, 1, 1}}
+	dinner := &models.Expense{Amount: 60000, PaidBy: bob, SplitBetween: []*models.User{alice, bob}, SplitRate: []int64{1, 1}}
+	rent := &models.Expense{Amount: 500000, PaidBy: carol, SplitBetween: []*models.User{alice, carol}, SplitRate: []int64{1, 1}}
+	for _, e := range []*models.Expense{trip, dinner} {
+		if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, e); err != nil {
+			t.Fatalf("PostExpense() error = %v", err)
+		}
+	}
+	if _, err := j.PostExpense(houseGroup, models.DefaultCurrency, rent); err != nil {
+		t.Fatalf("PostExpense() error = %v", err)
+	}
+
+	wantTrip := []Debt{
+		{Group: tripGroup, From: bob, To: alice, Amount: 70000},
+		{Group: tripGroup, From: carol, To: alice, Amount: 100000},
+	}
+	if got := l.GroupDebts(tripGroup); !reflect.DeepEqual(got, wantTrip) {
+		t.Errorf("GroupDebts(Trip) = %v, want %v", got, wantTrip)
+	}
+
+	wantAlice := []Debt{
+		{Group: houseGroup, From: alice, To: carol, Amount: 250000},
+		{Group: tripGroup, From: bob, To: alice, Amount: 70000},
+		{Group: tripGroup, From: carol, To: alice, Amount: 100000},
+	}
+	if got := l.UserDebts(alice.Id); !reflect.DeepEqual(got, wantAlice) {
+		t.Errorf("UserDebts(Alice) = %v, want %v", got, wantAlice)
+	}
+
+	if got := l.Owes(tripGroup, alice.Id, bob.Id); got != -70000 {
+		t.Errorf("Owes(Trip, Alice, Bob) = %v, want -70.00", got)
+	}
+	wantBalances := map[int32]models.Money{tripGroup: 170000, houseGroup: -250000}
+	if got := l.UserBalances(alice.Id); !reflect.DeepEqual(got, wantBalances) {
+		t.Errorf("UserBalances(Alice) = %v, want %v", got, wantBalances)
+	}
+
+	total := models.Money(0)
+	for _, balance := range l.Balances(tripGroup) {
+		total += balance
+	}
+	if total != 0 {
+		t.Errorf("Trip balances sum to %v, want 0.00", total)
+	}
+
+	// Rebuilding from the journal gives the same debts
+	j.Rebuild()
+	if got := l.UserDebts(alice.Id); !reflect.DeepEqual(got, wantAlice) {
+		t.Errorf("UserDebts(Alice) after Rebuild() = %v, want %v", got, wantAlice)
+	}
+}
+
+func TestLedger_PaymentDebts(t *testing.T) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+
+	tests := []struct {
+		name    string
+		payment models.Money
+		want    []Debt
+	}{
+		{name: "Partial Payment", payment: 20000, want: []Debt{{Group: tripGroup, From: bob, To: alice, Amount: 30000}}},
+		{name: "Full Payment", payment: 50000, want: nil},
+		{name: "Overpayment Reverses The Debt", payment: 60000, want: []Debt{{Group: tripGroup, From: alice, To: bob, Amount: 10000}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New()
+			j := journal.New(l)
+			debt := &models.Expense{Amount: 100000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitRate: []int64{1, 1}}
+			if _, err := j.PostExpense(tripGroup, models.DefaultCurrency, debt); err != nil {
+				t.Fatalf("PostExpense() error = %v", err)
+			}
+			if _, err := j.PostPayment(tripGroup, models.DefaultCurrency, &models.Payment{Payer: bob, Payee: alice, Amount: tt.payment}); err != nil {
+				t.Fatalf("PostPayment() error = %v", err)
+			}
+			if got := l.GroupDebts(tripGroup); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GroupDebts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package ledger
+
+import (
+	"sort"
+	"splitwise/models"
+)
+
+// exactSimplifyLimit is the largest number of users with a non-zero balance for
+// which SettlePlan searches for the true minimum number of transfers. The
+// search is exponential in the number of users, so larger groups fall back to
+// a greedy plan that needs at most one transfer fewer than that many users.
+const exactSimplifyLimit = 16
+
+// Transfer is a suggested payment that moves Amount from From to To.
+type Transfer struct {
+	From   *models.User
+	To     *models.User
+	Amount models.Money
+}
+
+// SettlePlan returns transfers that clear every balance in the group.
+//
+// With keepPairs set, users only pay people they already have a debt with, so
+// the plan is simply the netted pairwise debts. Otherwise debts are simplified
+// across the group to the smallest set of transfers: users are split into as
+// many separate sets whose balances sum to zero as possible, and each set of n
+// users is then settled with n-1 transfers.
+func (l *Ledger) SettlePlan(group int32, keepPairs bool) []Transfer {
+	if keepPairs {
+		var transfers []Transfer
+		for _, debt := range l.GroupDebts(group) {
+			transfers = append(transfers, Transfer{From: debt.From, To: debt.To, Amount: debt.Amount})
+		}
+		return transfers
+	}
+
+	l.mu.RLock()
+	users := make(map[int32]*models.User, len(l.users))
+	for id, user := range l.users {
+		users[id] = user
+	}
+	l.mu.RUnlock()
+
+	var ids []int32
+	balances := l.Balances(group)
+	for id, balance := range balances {
+		if balance != 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var sets [][]int32
+	if len(ids) <= exactSimplifyLimit {
+		sets = zeroSumSets(ids, balances)
+	} else {
+		sets = [][]int32{ids}
+	}
+
+	var transfers []Transfer
+	for _, set := range sets {
+		for _, t := range settleSet(set, balances) {
+			transfers = append(transfers, Transfer{From: users[t.from], To: users[t.to], Amount: t.amount})
+		}
+	}
+	return transfers
+}
+
+// zeroSumSets partitions ids into the largest possible number of sets whose
+// balances each sum to zero. A set of n users needs n-1 transfers, so the most
+// sets gives the fewest transfers overall.
+func zeroSumSets(ids []int32, balances map[int32]models.Money) [][]int32 {
+	n := len(ids)
+	full := 1<<n - 1
+
+	// sums[mask] is the total balance of the users in mask, and best[mask] the
+	// most zero-sum sets that the users in mask can be split into.
+	sums := make([]models.Money, full+1)
+	best := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) == 0 {
+				continue
+			}
+			rest := mask &^ (1 << i)
+			sums[mask] = sums[rest] + balances[ids[i]]
+			if best[rest] > best[mask] {
+				best[mask] = best[rest]
+			}
+		}
+		if sums[mask] == 0 {
+			best[mask]++
+		}
+	}
+
+	// Walk back from the full set, removing users one at a time in an order
+	// that achieves best. Every time the remaining users sum to zero a set is
+	// complete.
+	var sets [][]int32
+	var current []int32
+	for mask := full; mask != 0; {
+		bonus := 0
+		if sums[mask] == 0 {
+			bonus = 1
+			if len(current) > 0 {
+				sets = append(sets, current)
+				current = nil
+			}
+		}
+		for i := 0; i < n; i++ {
+			rest := mask &^ (1 << i)
+			if mask&(1<<i) != 0 && best[rest]+bonus == best[mask] {
+				current = append(current, ids[i])
+				mask = rest
+				break
+			}
+		}
+	}
+	if len(current) > 0 {
+		sets = append(sets, current)
+	}
+	return sets
+}
+
+type transfer struct {
+	from, to int32
+	amount   models.Money
+}
+
+// settleSet settles a set of users whose balances sum to zero by repeatedly
+// having the largest debtor pay the largest creditor. Each transfer clears at
+// least one user, so n users need at most n-1 transfers.
+func settleSet(ids []int32, balances map[int32]models.Money) []transfer {
+	remaining := make(map[int32]models.Money, len(ids))
+	for _, id := range ids {
+		remaining[id] = balances[id]
+	}
+
+	var transfers []transfer
+	for {
+		var debtor, creditor int32
+		debtorFound, creditorFound := false, false
+		for _, id := range ids {
+			switch balance := remaining[id]; {
+			case balance < 0 && (!debtorFound || balance < remaining[debtor]):
+				debtor, debtorFound = id, true
+			case balance > 0 && (!creditorFound || balance > remaining[creditor]):
+				creditor, creditorFound = id, true
+			}
+		}
+		if !debtorFound || !creditorFound {
+			return transfers
+		}
+
+		amount := -remaining[debtor]
+		if remaining[creditor] < amount {
+			amount = remaining[creditor]
+		}
+		remaining[debtor] += amount
+		remaining[creditor] -= amount
+		transfers = append(transfers, transfer{from: debtor, to: creditor, amount: amount})
+	}
+}
@@ -0,0 +1,95 @@
+package ledger
+
+import (
+	"splitwise/journal"
+	"splitwise/models"
+	"testing"
+)
+
+func TestLedger_SettlePlan(t *testing.T) {
+	users := map[int32]*models.User{}
+	for id, name := range []string{"A", "B", "C", "D", "E"} {
+		users[int32(id+1)] = &models.User{Id: int32(id + 1), Name: name}
+	}
+	owes := func(debtor, creditor int32, amount models.Money) []journal.Posting {
+		return []journal.Posting{
+			{Group: tripGroup, Account: users[creditor], Counterparty: users[debtor], Amount: amount},
+			{Group: tripGroup, Account: users[debtor], Counterparty: users[creditor], Amount: -amount},
+		}
+	}
+
+	// Balances end up as A -2, B -2, C -3, D +3 and E +4. Greedily paying the
+	// largest creditor needs four transfers, but C paying D and A and B paying
+	// E needs only three.
+	newLedger := func(t *testing.T) *Ledger {
+		l := New()
+		j := journal.New(l)
+		var postings []journal.Posting
+		postings = append(postings, owes(1, 4, 200)...)
+		postings = append(postings, owes(2, 5, 200)...)
+		postings = append(postings, owes(3, 4, 100)...)
+		postings = append(postings, owes(3, 5, 200)...)
+		if _, err := j.Post(journal.ExpenseEntry, 1, postings); err != nil {
+			t.Fatalf("Post() error = %v", err)
+		}
+		return l
+	}
+
+	tests := []struct {
+		name          string
+		keepPairs     bool
+		wantTransfers int
+	}{
+		{name: "Simplified", keepPairs: false, wantTransfers: 3},
+		{name: "Existing Pairs Only", keepPairs: true, wantTransfers: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLedger(t)
+			transfers := l.SettlePlan(tripGroup, tt.keepPairs)
+			if len(transfers) != tt.wantTransfers {
+				t.Errorf("SettlePlan() returned %d transfers, want %d: %v", len(transfers), tt.wantTransfers, transfers)
+			}
+
+			balances := l.Balances(tripGroup)
+			for _, transfer := range transfers {
+				if transfer.Amount <= 0 {
+					t.Errorf("SettlePlan() transfer %v has a non-positive amount", transfer)
+				}
+				if tt.keepPairs && l.Owes(tripGroup, transfer.From.Id, transfer.To.Id) <= 0 {
+					t.Errorf("SettlePlan() transfer %v is not between an existing pair", transfer)
+				}
+				balances[transfer.From.Id] += transfer.Amount
+				balances[transfer.To.Id] -= transfer.Amount
+			}
+			for id, balance := range balances {
+				if balance != 0 {
+					t.Errorf("user %d still has balance %v after the plan", id, balance)
+				}
+			}
+		})
+	}
+}
+
+func TestZeroSumSets(t *testing.T) {
+	balances := map[int32]models.Money{1: -200, 2: -200, 3: -300, 4: 300, 5: 400}
+	sets := zeroSumSets([]int32{1, 2, 3, 4, 5}, balances)
+	if len(sets) != 2 {
+		t.Fatalf("zeroSumSets() = %v, want 2 sets", sets)
+	}
+	seen := 0
+	for _, set := range sets {
+		total := models.Money(0)
+		for _, id := range set {
+			total += balances[id]
+			seen++
+		}
+		if total != 0 {
+			t.Errorf("zeroSumSets() set %v sums to %v, want 0.00", set, total)
+		}
+	}
+	if seen != len(balances) {
+		t.Errorf("zeroSumSets() covered %d users, want %d", seen, len(balances))
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"sort"
+	"splitwise/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every list endpoint returns its items a page at a time, in the order asked
+// for with ?sort=, and takes ?limit= and ?cursor= to page through them.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// page is the response body of every list endpoint.
+type page struct {
+	Items      interface{}
+	NextCursor string `json:",omitempty"` // Pass as ?cursor= to get the next page, left out on the last page
+}
+
+// sortKey is what an item is sorted by. Items are ordered by Number, then
+// Text, then ID, so items that tie on the sorted field still have a fixed
+// order to page through.
+type sortKey struct {
+	Number int64  `json:"n,omitempty"`
+	Text   string `json:"t,omitempty"`
+	ID     int    `json:"id"`
+}
+
+func (k sortKey) less(other sortKey) bool {
+	if k.Number != other.Number {
+		return k.Number < other.Number
+	}
+	if k.Text != other.Text {
+		return k.Text < other.Text
+	}
+	return k.ID < other.ID
+}
+
+// sortFields maps the fields a list can be sorted by to the key of an item.
+// Every list can be sorted by "id", which is the default.
+type sortFields[T any] map[string]func(T) sortKey
+
+// cursor is the last item of a page, encoded into NextCursor. A page starts
+// after it, so inserting or deleting items doesn't skip or repeat any.
+type cursor struct {
+	Sort  string  `json:"s"`
+	After sortKey `json:"a"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	return c, err
+}
+
+// listQuery reads the query parameters of a list request, recording every
+// problem it finds.
+type listQuery struct {
+	c echo.Context
+	v validation
+}
+
+func newListQuery(c echo.Context) *listQuery {
+	return &listQuery{c: c}
+}
+
+// id parses an ID parameter, or returns 0 if it isn't given.
+func (q *listQuery) id(name string) int32 {
+	s := q.c.QueryParam(name)
+	if s == "" {
+		return 0
+	}
+	id, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || id <= 0 {
+		q.v.add(codeInvalid, name, "ID must be a positive integer")
+		return 0
+	}
+	return int32(id)
+}
+
+// time parses an RFC 3339 timestamp or a date such as 2024-01-31, or returns
+// the zero time if it isn't given. The end of a range is returned as the first
+// instant after it, so a date that ends a range includes the whole day.
+func (q *listQuery) time(name string, end bool) time.Time {
+	s := q.c.QueryParam(name)
+	if s == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		if end {
+			t = t.Add(time.Nanosecond)
+		}
+		return t
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		q.v.add(codeInvalid, name, "Expected a date such as 2024-01-31 or an RFC 3339 timestamp")
+		return time.Time{}
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// money parses an amount parameter, and reports whether it was given.
+func (q *listQuery) money(name string) (models.Money, bool) {
+	s := q.c.QueryParam(name)
+	if s == "" {
+		return 0, false
+	}
+	amount, err := models.ParseMoney(s)
+	if err != nil {
+		q.v.add(codeInvalid, name, err.Error())
+		return 0, false
+	}
+	return amount, true
+}
+
+// bool parses a true or false parameter, or returns nil if it isn't given.
+func (q *listQuery) bool(name string) *bool {
+	s := q.c.QueryParam(name)
+	if s == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		q.v.add(codeInvalid, name, fmt.Sprintf("%s must be true or false", name))
+		return nil
+	}
+	return &b
+}
+
+// timeRange is a range of timestamps given by two query parameters. Either end
+// may be left open.
+type timeRange struct {
+	from, to time.Time // from is inclusive and to exclusive
+}
+
+func (q *listQuery) timeRange(from, to string) timeRange {
+	return timeRange{from: q.time(from, false), to: q.time(to, true)}
+}
+
+func (r timeRange) contains(t time.Time) bool {
+	return (r.from.IsZero() || !t.Before(r.from)) && (r.to.IsZero() || t.Before(r.to))
+}
+
+// amountRange is an inclusive range of amounts given by two query parameters.
+// Either end may be left open.
+type amountRange struct {
+	min, max       models.Money
+	hasMin, hasMax bool
+}
+
+func (q *listQuery) amountRange(min, max string) amountRange {
+	var r amountRange
+	r.min, r.hasMin = q.money(min)
+	r.max, r.hasMax = q.money(max)
+	return r
+}
+
+func (r amountRange) contains(amount models.Money) bool {
+	return (!r.hasMin || amount >= r.min) && (!r.hasMax || amount <= r.max)
+}
+
+// paginate sorts items as ?sort= asks, ascending or, with a leading "-",
+// descending, and returns the page that ?cursor= and ?limit= select. It fails
+// with every problem found in the query, including those found while reading
+// filters.
+func paginate[T any](q *listQuery, items []T, fields sortFields[T]) (*page, error) {
+	limit := defaultPageSize
+	if s := q.c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			q.v.add(codeInvalid, "limit", fmt.Sprintf("Limit must be between 1 and %d", maxPageSize))
+		} else {
+			limit = n
+		}
+	}
+
+	sortBy := q.c.QueryParam("sort")
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	descending := strings.HasPrefix(sortBy, "-")
+	key, ok := fields[strings.TrimPrefix(sortBy, "-")]
+	if !ok {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		q.v.add(codeInvalid, "sort", fmt.Sprintf("Can't sort by %q, only by %s", sortBy, strings.Join(names, ", ")))
+	}
+
+	var after *sortKey
+	if s := q.c.QueryParam("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil || c.Sort != sortBy {
+			q.v.add(codeInvalid, "cursor", "Cursor is invalid or was made for a different sort order")
+		} else {
+			after = &c.After
+		}
+	}
+	if err := q.v.err(); err != nil {
+		return nil, err
+	}
+
+	// Work out every key once, then sort the keys along with the items
+	keys := make([]sortKey, len(items))
+	order := make([]int, len(items))
+	for i, item := range items {
+		keys[i] = key(item)
+		order[i] = i
+	}
+	before := func(a, b sortKey) bool {
+		if descending {
+			return b.less(a)
+		}
+		return a.less(b)
+	}
+	sort.Slice(order, func(i, j int) bool { return before(keys[order[i]], keys[order[j]]) })
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(order), func(i int) bool { return before(*after, keys[order[i]]) })
+	}
+	end := start + limit
+	if end > len(order) {
+		end = len(order)
+	}
+
+	selected := make([]T, 0, end-start)
+	for _, i := range order[start:end] {
+		selected = append(selected, items[i])
+	}
+	result := &page{Items: selected}
+	if end < len(order) {
+		result.NextCursor = cursor{Sort: sortBy, After: keys[order[end-1]]}.encode()
+	}
+	return result, nil
+}
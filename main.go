@@ -1,24 +1,34 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"reflect"
+	"sort"
+	"splitwise/audit"
+	"splitwise/auth"
+	"splitwise/blob"
 	"splitwise/group"
+	"splitwise/journal"
+	"splitwise/ledger"
 	"splitwise/models"
-	"strconv"
+	"splitwise/rates"
+	"splitwise/store"
+	"splitwise/store/boltstore"
+	"splitwise/store/mongostore"
 	"strings"
+	"sync"
 )
 
-var (
-	users    []*models.User
-	groups   []*group.Group
-	payments []*models.Payment
-)
+// db holds every user, group, expense, payment, invitation, recurring expense,
+// group activity and the audit log. It is in memory unless DB_FILE or
+// MONGODB_URI is set.
+var db = store.NewMemory()
 
 var (
 	infoLogger  = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
@@ -26,336 +36,362 @@ var (
 	errorLogger = log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
 )
 
-var expensesMap = make(map[int]*models.Expense) // map to hold expenses by ID
-var paymentsMap = make(map[int]*models.Payment) // map to hold payments by ID
-
-func main() {
-	e := echo.New()
-
-	// Routes
-	e.POST("/users", createUser)
-	e.GET("/users/:id", getUser)
-	e.GET("/list", listUsers)
-	e.POST("/groups", createGroup)
-	e.GET("/groups/:name", getGroup)
-	e.POST("/payments", createPayment)
-	e.GET("/payments/:id", getPayment)
-	e.POST("/groups/:name/expenses", createExpense)
-	e.GET("/expenses", listExpenses)
-
-	// Start server
-	infoLogger.Println("Attempting To Start Server...")
-	e.Logger.Fatal(e.Start(":8080"))
+var (
+	debtLedger       = ledger.New()                                                         // pairwise debts between users, per group
+	originalBalances = journal.NewOriginalBalances()                                        // balances in the currencies they were run up in
+	balanceJournal   = journal.New(journal.NewUserBalances(), debtLedger, originalBalances) // source of truth for every balance change
+)
 
-}
+// exchangeRates is the table of exchange rates loaded from RATES_FILE, if it
+// is set. Rates a group enters by hand take precedence over it.
+var exchangeRates *rates.Table
 
-func createUser(c echo.Context) error {
-	name := c.FormValue("name")
-	fmt.Println("Name is ", name)
-	user := models.NewUser(name)
-	users = append(users, user)
-	infoLogger.Println("Created User With Id: ", user.Id)
-	return c.JSON(http.StatusCreated, user)
-}
+// blobs holds the content of files attached to expenses and payments. It is in
+// ATTACHMENTS_DIR if that is set, next to DB_FILE if that is, and otherwise in
+// memory.
+var blobs blob.Store = blob.NewMemory()
 
-func getUser(c echo.Context) error {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		warnLogger.Println("Invalid ID format")
-		return c.JSON(http.StatusBadRequest, "Invalid ID format")
-	}
+// sessions holds every signed in user's session. It signs tokens with a key
+// made when the server starts, so restarting it signs everyone out.
+var sessions *auth.Sessions
 
-	for _, user := range users {
-		if user.Id == int32(id) {
-			infoLogger.Println("Retrieved User With Id: ", user.Id)
-			return c.JSON(http.StatusOK, user)
+func main() {
+	// Attached files must last as long as the records of them
+	attachmentsDir := os.Getenv("ATTACHMENTS_DIR")
+	if path := os.Getenv("DB_FILE"); path != "" {
+		var err error
+		db, err = boltstore.Open(path)
+		if err != nil {
+			errorLogger.Fatalln("Error opening database file:", err)
 		}
+		defer db.Close()
+		infoLogger.Println("Using Database File: ", path)
+		if attachmentsDir == "" {
+			attachmentsDir = path + ".attachments"
+		}
+	} else if uri := os.Getenv("MONGODB_URI"); uri != "" {
+		if attachmentsDir == "" {
+			errorLogger.Fatalln("ATTACHMENTS_DIR must be set to keep attached files when using MONGODB_URI")
+		}
+		database := os.Getenv("MONGODB_DATABASE")
+		if database == "" {
+			database = "splitwise"
+		}
+		var err error
+		db, err = mongostore.Open(uri, database)
+		if err != nil {
+			errorLogger.Fatalln("Error opening MongoDB store:", err)
+		}
+		defer db.Close()
+		infoLogger.Println("Using MongoDB Database: ", database)
 	}
-
-	errorLogger.Println("No Matching User")
-	return c.JSON(http.StatusNotFound, "User not found")
-}
-
-func createGroup(c echo.Context) error {
-	name := c.FormValue("name")
-	members, err := parseUserIDs(c.FormValue("members"))
-	if err != nil {
-		errorLogger.Println(err)
-	}
-	createdGroup := group.NewGroup(name, members)
-	groups = append(groups, createdGroup)
-	infoLogger.Println("Created Group With Name: ", createdGroup.Name)
-	return c.JSON(http.StatusCreated, groups)
-}
-
-func getGroup(c echo.Context) error {
-	name := c.Param("name")
-	for _, eachGroup := range groups {
-		if eachGroup.Name == name {
-			infoLogger.Println("Retrieved Group With Name: ", eachGroup.Name)
-			return c.JSON(http.StatusOK, eachGroup)
-
+	if path := os.Getenv("RATES_FILE"); path != "" {
+		var err error
+		exchangeRates, err = rates.Load(path)
+		if err != nil {
+			errorLogger.Fatalln("Error loading exchange rates:", err)
 		}
+		infoLogger.Println("Using Exchange Rates From: ", path)
 	}
-	errorLogger.Println("No Matching Group")
-	return c.JSON(http.StatusNotFound, "Group not found")
-}
-
-func createPayment(c echo.Context) error {
-	payerID := c.FormValue("payer")
-	payeeID := c.FormValue("payee")
-	amountStr := c.FormValue("amount")
-	mode := models.PaymentMode(c.FormValue("mode"))
-	identifier := c.FormValue("identifier")
-	note := c.FormValue("note")
-	expenseIDs := c.FormValue("expenses")
-
-	// Convert amount from string to float64
-	amount, err := strconv.ParseFloat(amountStr, 64)
-	if err != nil {
-		warnLogger.Println("Invalid Amount Format")
-		return c.JSON(http.StatusBadRequest, "Invalid amount format")
+	if attachmentsDir != "" {
+		dir, err := blob.NewDir(attachmentsDir)
+		if err != nil {
+			errorLogger.Fatalln("Error opening attachments directory:", err)
+		}
+		blobs = dir
+		infoLogger.Println("Using Attachments Directory: ", attachmentsDir)
 	}
-
-	// Convert payer and payee IDs to integers
-	payerIdConv, err := strconv.ParseInt(payerID, 10, 32)
-	if err != nil {
-		errorLogger.Println("Error In Payer ID Conversion Process")
-		return c.JSON(http.StatusBadRequest, "Invalid payer ID format")
+	if err := loadJournal(); err != nil {
+		errorLogger.Fatalln("Error rebuilding balances from the store:", err)
 	}
-
-	payeeIdConv, err := strconv.ParseInt(payeeID, 10, 32)
-	if err != nil {
-		errorLogger.Println("Error In Payee ID Conversion Process")
-		return c.JSON(http.StatusBadRequest, "Invalid payee ID format")
+	if entries, err := db.AuditLog.List(); err != nil {
+		errorLogger.Fatalln("Error reading the audit log:", err)
+	} else if err := audit.Verify(entries); err != nil {
+		warnLogger.Println("Audit Log Failed Verification:", err)
 	}
-
-	payer := findUserByID(int32(payerIdConv))
-	payee := findUserByID(int32(payeeIdConv))
-
-	if payer == nil || payee == nil {
-		warnLogger.Println("Invalid Payer or Payee")
-		return c.JSON(http.StatusBadRequest, "Invalid payer or payee")
+	if list := os.Getenv("ADMIN_EMAILS"); list != "" {
+		admins = parseAdmins(list)
+		infoLogger.Println("Administrators: ", len(admins))
 	}
-
-	// Find expenses associated with the payment
-	expenses := parseExpenseIDs(expenseIDs)
-
-	if len(expenses) == 0 {
-		warnLogger.Println("No valid expenses found")
-		return c.JSON(http.StatusBadRequest, "No valid expenses found")
+	key, err := auth.NewKey()
+	if err != nil {
+		errorLogger.Fatalln("Error making a key to sign tokens with:", err)
 	}
+	sessions = auth.NewSessions(key)
+	go runScheduler(schedulerInterval)
 
-	// Create the payment
-	payment := models.NewPayment(payer, payee, amount, mode, identifier, note, expenses)
-	payments = append(payments, payment)
+	e := newServer()
 
-	// Attempt to settle the payment against the expenses
-	err = payment.SettlePayment()
-	if err != nil {
-		errorLogger.Println("Invalid Settlement:", err)
-		return c.JSON(http.StatusBadRequest, err.Error())
-	}
+	// Start server
+	infoLogger.Println("Attempting To Start Server...")
+	e.Logger.Fatal(e.Start(":8080"))
 
-	infoLogger.Println("Created Payment")
-	return c.JSON(http.StatusCreated, payment)
 }
 
-func getPayment(c echo.Context) error {
-	id := c.Param("id")
-	for _, payment := range payments {
-		if strconv.Itoa(payment.ID) == id {
-			infoLogger.Println("Payment Retrieved With Id: ", payment.ID)
-			return c.JSON(http.StatusOK, payment)
-		}
-	}
-	errorLogger.Println("Payment Not Found")
-	return c.JSON(http.StatusNotFound, "Payment not found")
+// newServer returns the Echo instance with every route registered.
+func newServer() *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = handleError
+	e.Use(readBody)
+
+	// Routes. Signing up and in are the only ones that don't need an access
+	// token. They hash passwords, which is slow on purpose, so they lock
+	// dataMu themselves around the store instead of for the whole request.
+	v1 := e.Group("/v1")
+	v1.POST("/users", createUser)
+	v1.POST("/auth/login", login)
+	v1.POST("/auth/refresh", refreshSession)
+	uploads := v1.Group("", authenticate) // Lock dataMu once the file has arrived
+	uploads.POST("/expenses/:id/attachments", uploadExpenseAttachment)
+	uploads.POST("/payments/:id/attachments", uploadPaymentAttachment)
+	v1 = v1.Group("", lockData, authenticate)
+	v1.POST("/auth/logout", logout)
+	v1.GET("/users/me", getCurrentUser)
+	v1.GET("/users", listUsers)
+	v1.GET("/users/:id", getUser)
+	v1.PUT("/users/:id", updateUser)
+	v1.DELETE("/users/:id", deleteUser)
+	v1.GET("/users/:id/balances", getUserBalances)
+	v1.POST("/groups", createGroup)
+	v1.GET("/groups", listGroups)
+	v1.GET("/groups/:id", getGroup)
+	v1.PUT("/groups/:id", updateGroup)
+	v1.DELETE("/groups/:id", deleteGroup)
+	v1.GET("/groups/:id/members", listMembers)
+	v1.POST("/groups/:id/members", addMember)
+	v1.PUT("/groups/:id/members/:userId", updateMember)
+	v1.DELETE("/groups/:id/members/:userId", removeMember)
+	v1.GET("/groups/:id/members/:userId/removal", checkRemoval)
+	v1.POST("/groups/:id/members/:userId/transfer", transferDebts)
+	v1.POST("/groups/:id/invitations", createInvitation)
+	v1.GET("/groups/:id/invitations", listGroupInvitations)
+	v1.DELETE("/groups/:id/invitations/:invitationId", revokeInvitation)
+	v1.GET("/invitations", listMyInvitations)
+	v1.POST("/invitations/:id/accept", acceptInvitation)
+	v1.POST("/invitations/:id/decline", declineInvitation)
+	v1.GET("/join/:token", previewInvitation)
+	v1.POST("/join/:token", joinWithToken)
+	v1.GET("/groups/:id/expenses", listGroupExpenses)
+	v1.POST("/groups/:id/expenses", createExpense)
+	v1.GET("/groups/:id/balances", getGroupBalances)
+	v1.GET("/groups/:id/settle-plan", getSettlePlan)
+	v1.GET("/groups/:id/rates", getGroupRates)
+	v1.PUT("/groups/:id/rates", updateGroupRates)
+	v1.GET("/groups/:id/categories", getGroupCategories)
+	v1.PUT("/groups/:id/categories", updateGroupCategories)
+	v1.GET("/groups/:id/activity", listGroupActivity)
+	v1.POST("/groups/:id/recurring-expenses", createRecurringExpense)
+	v1.GET("/groups/:id/recurring-expenses", listRecurringExpenses)
+	v1.GET("/recurring-expenses/:id", getRecurringExpense)
+	v1.PUT("/recurring-expenses/:id", updateRecurringExpense)
+	v1.DELETE("/recurring-expenses/:id", deleteRecurringExpense)
+	v1.POST("/payments", createPayment)
+	v1.GET("/payments", listPayments)
+	v1.GET("/payments/:id", getPayment)
+	v1.PUT("/payments/:id", updatePayment)
+	v1.DELETE("/payments/:id", deletePayment)
+	v1.GET("/payments/:id/attachments/:attachmentId", getPaymentAttachment)
+	v1.DELETE("/payments/:id/attachments/:attachmentId", deletePaymentAttachment)
+	v1.GET("/payments/:id/comments", listPaymentComments)
+	v1.POST("/payments/:id/comments", addPaymentComment)
+	v1.PUT("/payments/:id/comments/:commentId", editPaymentComment)
+	v1.DELETE("/payments/:id/comments/:commentId", deletePaymentComment)
+	v1.GET("/expenses", listExpenses)
+	v1.GET("/expenses/:id", getExpense)
+	v1.PUT("/expenses/:id", replaceExpense)
+	v1.PATCH("/expenses/:id", patchExpense)
+	v1.DELETE("/expenses/:id", deleteExpense)
+	v1.GET("/expenses/:id/attachments/:attachmentId", getExpenseAttachment)
+	v1.DELETE("/expenses/:id/attachments/:attachmentId", deleteExpenseAttachment)
+	v1.GET("/expenses/:id/comments", listExpenseComments)
+	v1.POST("/expenses/:id/comments", addExpenseComment)
+	v1.PUT("/expenses/:id/comments/:commentId", editExpenseComment)
+	v1.DELETE("/expenses/:id/comments/:commentId", deleteExpenseComment)
+	v1.GET("/journal", getJournal)
+	v1.GET("/admin/audit", listAuditLog)
+	v1.GET("/admin/audit/verify", verifyAuditLog)
+	return e
 }
 
-// Helper functions
-func parseUserIDs(userIDs string) ([]*models.User, error) {
-	ids := strings.Split(userIDs, ",")
-	var users []*models.User
-	var userAvailabilityErr error
-	for _, idStr := range ids {
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			continue // Skip any IDs that cannot be converted to integers
+// maxBodySize is the largest JSON request body the server reads.
+const maxBodySize = 1 << 20
+
+// readBody reads JSON request bodies in full before anything else runs, so
+// that clients sending them slowly don't hold dataMu while they do. Bodies
+// larger than maxBodySize are turned away. Multipart uploads are left for
+// their handlers to read.
+func readBody(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		if r.ContentLength == 0 || strings.HasPrefix(r.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+			return next(c)
 		}
-
-		user := findUserByID(int32(id))
-
-		if user != nil {
-			users = append(users, user)
-		} else {
-			userAvailabilityErr = errors.New(fmt.Sprintf("User with ID %d not found", id))
+		content, err := io.ReadAll(http.MaxBytesReader(c.Response(), r.Body, maxBodySize))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return newAPIError(http.StatusRequestEntityTooLarge, codeInvalidBody, "", fmt.Sprintf("Request body can be at most %d MiB", maxBodySize>>20))
+		} else if err != nil {
+			return newAPIError(http.StatusBadRequest, codeInvalidBody, "", "Error reading request body")
 		}
+		r.Body = io.NopCloser(bytes.NewReader(content))
+		return next(c)
 	}
-
-	return users, userAvailabilityErr
 }
 
-func parseExpenseIDs(expenseIDs string) []*models.Expense {
-	ids := strings.Split(expenseIDs, ",")
-	var expenses []*models.Expense
-
-	for _, idStr := range ids {
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			continue // Skip any IDs that cannot be converted to integers
-		}
-
-		expense := findExpenseByID(int32(id))
-		if expense != nil {
-			expenses = append(expenses, expense)
+// dataMu guards users, groups, expenses and payments, which handlers and the
+// scheduler change in place: adding an expense to a group, settling an
+// expense, or updating user balances through the journal. The store only
+// guards its own indexes.
+var dataMu sync.RWMutex
+
+// lockData runs requests that only read with dataMu shared, and every other
+// request with it held exclusively, including while the response is written.
+// readBody has read JSON request bodies by then.
+func lockData(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if method := c.Request().Method; method == http.MethodGet || method == http.MethodHead {
+			dataMu.RLock()
+			defer dataMu.RUnlock()
+		} else {
+			dataMu.Lock()
+			defer dataMu.Unlock()
 		}
+		return next(c)
 	}
-
-	return expenses
 }
 
+// The find helpers look things up in db and return nil when they aren't
+// there, logging any other error.
+
 func findUserByID(id int32) *models.User {
-	for _, user := range users {
-		if user.Id == id {
-			return user
-		}
-	}
-	return nil
+	user, err := db.Users.Get(id)
+	logLookupError(err)
+	return user
 }
 
-var expenses []*models.Expense
+func findUserByEmail(email string) *models.User {
+	user, err := db.Users.FindByEmail(email)
+	logLookupError(err)
+	return user
+}
 
 func findExpenseByID(id int32) *models.Expense {
-	for _, expense := range expenses {
-		if expense.ID == int(id) {
-			return expense
-		}
-	}
-	return nil
+	expense, err := db.Expenses.Get(int(id))
+	logLookupError(err)
+	return expense
 }
 
-func listUsers(c echo.Context) error {
-	infoLogger.Println("Listing Users")
-	for _, user := range users {
-		infoLogger.Println("User:", user.Id, user.Name)
-	}
-	return c.JSON(http.StatusOK, users)
+func findGroupByID(id int32) *group.Group {
+	g, err := db.Groups.Get(id)
+	logLookupError(err)
+	return g
 }
 
-func createExpense(c echo.Context) error {
-	fmt.Println("Received Headers: ", c.Request().Header)
-	fmt.Println("Received Form Data: ", c.Request().PostForm)
-	fmt.Println("Received Raw Body: ", c.Request().Body)
-
-	amountStr := c.FormValue("amount")
-	fmt.Println("Received Amount: ", amountStr, " Type: ", reflect.TypeOf(amountStr))
+func findGroupByExpense(expense *models.Expense) *group.Group {
+	g, err := db.Groups.FindByExpense(expense.ID)
+	logLookupError(err)
+	return g
+}
 
-	if amountStr == "" {
-		warnLogger.Println("Amount is missing in the form data")
-		return c.JSON(http.StatusBadRequest, "Amount is missing in the form data")
+func logLookupError(err error) {
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		errorLogger.Println("Error reading from the store:", err)
 	}
+}
 
-	amount, err := strconv.ParseFloat(amountStr, 64)
-	if err != nil {
-		errorLogger.Println("Invalid amount format")
-		return c.JSON(http.StatusBadRequest, "Invalid amount format")
-	}
-	fmt.Println("Received Amount: ", c.FormValue("amount"), " ", reflect.TypeOf(c.FormValue("amount")))
-	groupName := c.Param("name")
-
-	paidByID := c.FormValue("paidBy")
-	splitBetweenIDs := c.FormValue("splitBetween")
-	splitRatesStr := c.FormValue("splitRates")
-
-	// Find the payer by ID
-	paidByIdConv, err := strconv.ParseInt(paidByID, 10, 32)
-	if err != nil {
-		errorLogger.Println(fmt.Sprint("Error in PaidBy ID conversion: ", err))
-		return c.JSON(http.StatusBadRequest, "Invalid paidBy ID format")
+// loadJournal loads the journal saved in the store, which rebuilds every
+// balance, and saves every entry posted from then on. Stores saved before the
+// journal was have none, so their journal is replayed from their expenses,
+// payments and debt transfers instead, and saved.
+func loadJournal() error {
+	if err := balanceJournal.Load(db.Journal); err != nil {
+		return err
 	}
-
-	paidBy := findUserByID(int32(paidByIdConv))
-	if paidBy == nil {
-		errorLogger.Println("PaidBy user not found")
-		return c.JSON(http.StatusNotFound, "PaidBy user not found")
+	if len(balanceJournal.Entries()) > 0 {
+		return nil
 	}
+	return replayJournal()
+}
 
-	// Parse splitBetween user IDs
-	splitBetweenUsers, err := parseUserIDs(splitBetweenIDs)
+// replayJournal rebuilds the journal, and with it every balance, by posting
+// the stored expenses and payments in the order they were made, followed by
+// debt transfers. Unlike a saved journal, it only has the expenses and
+// payments as they are now, without the entries of earlier versions.
+func replayJournal() error {
+	expenses, err := db.Expenses.List()
 	if err != nil {
-		errorLogger.Println(err)
-	}
-	if len(splitBetweenUsers) == 0 {
-		errorLogger.Println("No valid users found in splitBetween")
-		return c.JSON(http.StatusBadRequest, "No valid users found in splitBetween")
+		return err
 	}
-	infoLogger.Println("Split Between Users: ", splitBetweenUsers)
-
-	// Parse split rates
-	splitRates := parseFloat32Array(splitRatesStr)
-	if len(splitRates) == 0 {
-		warnLogger.Println("No valid splits found in splitRates")
-		return c.JSON(http.StatusBadRequest, "No valid users found in splitBetween")
+	payments, err := db.Payments.List()
+	if err != nil {
+		return err
 	}
-	infoLogger.Println("Split Rates: ", splitRates)
-
-	if len(splitRates) != len(splitBetweenUsers) {
-		errorLogger.Println("Split rates count does not match the number of users")
-		return c.JSON(http.StatusBadRequest, "Invalid split rates")
+	sort.SliceStable(expenses, func(i, j int) bool { return expenses[i].Timestamp.Before(expenses[j].Timestamp) })
+	postPayment := func(payment *models.Payment) error {
+		paymentGroup := findGroupByID(payment.GroupID)
+		if paymentGroup == nil {
+			return fmt.Errorf("payment %d has no group", payment.ID)
+		}
+		_, err := balanceJournal.PostPayment(paymentGroup.ID, paymentGroup.Currency, payment)
+		return err
 	}
 
-	// Find the group
-	var group *group.Group
-	for _, g := range groups {
-		if g.Name == groupName {
-			group = g
-			break
+	for _, expense := range expenses {
+		// Post every payment made before the expense
+		for len(payments) > 0 && payments[0].Timestamp.Before(expense.Timestamp) {
+			if err := postPayment(payments[0]); err != nil {
+				return err
+			}
+			payments = payments[1:]
+		}
+		expenseGroup := findGroupByExpense(expense)
+		if expenseGroup == nil {
+			return fmt.Errorf("expense %d has no group", expense.ID)
+		}
+		if _, err := balanceJournal.PostExpense(expenseGroup.ID, expenseGroup.Currency, expense); err != nil {
+			return err
 		}
 	}
-
-	if group == nil {
-		warnLogger.Println("Group not found")
-		return c.JSON(http.StatusNotFound, "Group not found")
+	for _, payment := range payments {
+		if err := postPayment(payment); err != nil {
+			return err
+		}
 	}
 
-	// Create the expense
-	expense := models.NewExpense(amount, paidBy, splitBetweenUsers, splitRates)
-
-	group.AddExpense(expense)
-	expenses = append(expenses, expense)
-	expensesMap[expense.ID] = expense
-
-	// Split the expense to update the balances
-	err = expense.SplitExpense()
+	// Debt transfers record fixed amounts, so they come out the same
+	// whenever they are posted
+	groups, err := db.Groups.List()
 	if err != nil {
-		errorLogger.Println("Error splitting expense in CreateExpense:", err)
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return err
 	}
-
-	infoLogger.Println("Added Expense to Group:", group.Name)
-	return c.JSON(http.StatusCreated, expense)
-}
-
-// Helper function to parse comma-separated float32 values
-func parseFloat32Array(input string) []float32 {
-	strValues := strings.Split(input, ",")
-	var floatValues []float32
-
-	for _, str := range strValues {
-		if value, err := strconv.ParseFloat(str, 32); err == nil {
-			floatValues = append(floatValues, float32(value))
+	for _, g := range groups {
+		for _, transfer := range g.Transfers {
+			if err := postTransfer(g, transfer); err != nil {
+				return fmt.Errorf("group %d: debt transfer: %w", g.ID, err)
+			}
 		}
 	}
+	return nil
+}
 
-	return floatValues
+// journalReport is the response body of getJournal.
+type journalReport struct {
+	Entries      []journal.Entry // Only those in the signed in user's groups
+	TrialBalance models.Money    // Sum of every posting, always zero for a balanced journal
 }
 
-func listExpenses(c echo.Context) error {
-	infoLogger.Println("Listing Expenses")
-	for _, expense := range expenses {
-		infoLogger.Println("Expense ID:", expense.ID, "Amount:", expense.Amount)
+func getJournal(c echo.Context) error {
+	groups, err := memberGroups(currentUser(c))
+	if err != nil {
+		return internalError("Error listing groups", err)
+	}
+	var entries []journal.Entry
+	for _, entry := range balanceJournal.Entries() {
+		// Every posting of an entry is in the same group
+		if len(entry.Postings) > 0 && groups[entry.Postings[0].Group] != nil {
+			entries = append(entries, entry)
+		}
 	}
-	return c.JSON(http.StatusOK, expenses)
+	infoLogger.Println("Retrieved Journal")
+	return c.JSON(http.StatusOK, journalReport{Entries: entries, TrialBalance: balanceJournal.TrialBalance()})
 }
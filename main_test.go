@@ -0,0 +1,2239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"splitwise/audit"
+	"splitwise/auth"
+	"splitwise/blob"
+	"splitwise/group"
+	"splitwise/journal"
+	"splitwise/ledger"
+	"splitwise/models"
+	"splitwise/rates"
+	"splitwise/store"
+	"splitwise/store/boltstore"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	auth.PasswordCost = bcrypt.MinCost
+	infoLogger.SetOutput(io.Discard)
+	warnLogger.SetOutput(io.Discard)
+	errorLogger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// resetState gives the test an empty in-memory store and journal.
+func resetState() {
+	db = store.NewMemory()
+	debtLedger = ledger.New()
+	originalBalances = journal.NewOriginalBalances()
+	balanceJournal = journal.New(journal.NewUserBalances(), debtLedger, originalBalances)
+	balanceJournal.Load(db.Journal) // Listing an in-memory journal can't fail
+	exchangeRates = nil
+	blobs = blob.NewMemory()
+	sessions = auth.NewSessions([]byte("test key"))
+	admins = map[string]bool{}
+}
+
+// body is a JSON request body.
+type body map[string]interface{}
+
+// request sends a request to the server, or to a user signed in to it, and
+// fails the test unless it responds with wantStatus. The response body is
+// decoded into out if it isn't nil.
+func request(t *testing.T, server http.Handler, method, target string, in body, wantStatus int, out interface{}) {
+	t.Helper()
+	var reader io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			t.Fatalf("encoding %v: %v", in, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if in != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if rec.Code != wantStatus {
+		t.Errorf("%s %s = %d %s, want %d", method, target, rec.Code, rec.Body, wantStatus)
+		return
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Errorf("%s %s: decoding %s: %v", method, target, rec.Body, err)
+		}
+	}
+}
+
+// testPassword is the password of every user the tests sign up.
+const testPassword = "correct horse"
+
+// signedIn sends requests to the server as a signed in user.
+type signedIn struct {
+	e     *echo.Echo
+	token string
+}
+
+func (s signedIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Header.Set(echo.HeaderAuthorization, "Bearer "+s.token)
+	s.e.ServeHTTP(w, r)
+}
+
+// signUp signs a new user up and in, and returns them along with a handler
+// that sends requests as them.
+func signUp(t *testing.T, e *echo.Echo, name string) (models.User, http.Handler) {
+	t.Helper()
+	email := strings.ToLower(strings.ReplaceAll(name, " ", ".")) + "@example.com"
+	request(t, e, http.MethodPost, "/v1/users", body{"name": name, "email": email, "password": testPassword}, http.StatusAccepted, nil)
+	var tokens auth.Tokens
+	request(t, e, http.MethodPost, "/v1/auth/login", body{"email": email, "password": testPassword}, http.StatusOK, &tokens)
+	as := signedIn{e: e, token: tokens.AccessToken}
+	var user models.User
+	request(t, as, http.MethodGet, "/v1/users/me", nil, http.StatusOK, &user)
+	return user, as
+}
+
+// TestEndpoints_Concurrent hammers every endpoint from many goroutines at once.
+// Run it with -race to check that handlers and the store are free of races.
+func TestEndpoints_Concurrent(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	const members = 4
+	var memberIDs []int32
+	var as []http.Handler
+	for i := 0; i < members; i++ {
+		user, member := signUp(t, e, fmt.Sprint("Member ", i))
+		memberIDs = append(memberIDs, user.Id)
+		as = append(as, member)
+	}
+	var trip group.Group
+	request(t, as[0], http.MethodPost, "/v1/groups", body{"name": "Trip", "members": memberIDs}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+
+	const workers, iterations = 8, 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				payer, asPayer := memberIDs[(w+i)%members], as[(w+i)%members]
+				sharer, asSharer := memberIDs[(w+i+1)%members], as[(w+i+1)%members]
+
+				user, asUser := signUp(t, e, fmt.Sprintf("User %d-%d", w, i))
+				request(t, asUser, http.MethodGet, fmt.Sprint("/v1/users/", user.Id), nil, http.StatusOK, nil)
+				request(t, asPayer, http.MethodGet, fmt.Sprint("/v1/users/", payer, "/balances"), nil, http.StatusOK, nil)
+				request(t, asPayer, http.MethodGet, "/v1/users", nil, http.StatusOK, nil)
+
+				var created group.Group
+				request(t, asPayer, http.MethodPost, "/v1/groups", body{"name": fmt.Sprintf("Group %d-%d", w, i), "members": memberIDs}, http.StatusCreated, &created)
+				request(t, asPayer, http.MethodGet, fmt.Sprint("/v1/groups/", created.ID), nil, http.StatusOK, nil)
+
+				// An expense that is edited and then settled in part
+				var expense models.Expense
+				request(t, asPayer, http.MethodPost, tripPath+"/expenses", body{
+					"amount": "10.00", "splitBetween": memberIDs, "splitType": "Equal",
+				}, http.StatusCreated, &expense)
+				expensePath := fmt.Sprint("/v1/expenses/", expense.ID)
+				request(t, asSharer, http.MethodGet, expensePath, nil, http.StatusOK, nil)
+				request(t, asPayer, http.MethodPatch, expensePath, body{"amount": "20.00"}, http.StatusOK, nil)
+				request(t, asPayer, http.MethodPut, expensePath, body{
+					"amount": "12.00", "splitBetween": memberIDs, "splitType": "Equal",
+				}, http.StatusOK, nil)
+
+				var payment struct{ ID int }
+				request(t, asSharer, http.MethodPost, "/v1/payments", body{
+					"payee": payer, "amount": "1.00", "expenses": []int{expense.ID},
+				}, http.StatusCreated, &payment)
+				request(t, asPayer, http.MethodGet, fmt.Sprint("/v1/payments/", payment.ID), nil, http.StatusOK, nil)
+
+				// An expense that is deleted again
+				var deleted models.Expense
+				request(t, asPayer, http.MethodPost, tripPath+"/expenses", body{
+					"amount": "3.00", "splitBetween": memberIDs, "splitType": "Equal",
+				}, http.StatusCreated, &deleted)
+				request(t, asPayer, http.MethodDelete, fmt.Sprint("/v1/expenses/", deleted.ID), nil, http.StatusNoContent, nil)
+
+				// A settle up payment without expenses
+				request(t, asPayer, http.MethodPost, "/v1/payments", body{
+					"payee": sharer, "amount": "0.50", "groupId": trip.ID,
+				}, http.StatusCreated, nil)
+
+				request(t, asSharer, http.MethodGet, "/v1/expenses", nil, http.StatusOK, nil)
+				request(t, asSharer, http.MethodGet, tripPath, nil, http.StatusOK, nil)
+				request(t, asSharer, http.MethodGet, tripPath+"/balances", nil, http.StatusOK, nil)
+				request(t, asSharer, http.MethodGet, tripPath+"/settle-plan", nil, http.StatusOK, nil)
+				request(t, asSharer, http.MethodGet, "/v1/journal", nil, http.StatusOK, nil)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if total := balanceJournal.TrialBalance(); total != 0 {
+		t.Errorf("TrialBalance() = %v, want 0.00", total)
+	}
+	total := models.Money(0)
+	for _, balance := range debtLedger.Balances(trip.ID) {
+		total += balance
+	}
+	if total != 0 {
+		t.Errorf("Trip balances sum to %v, want 0.00", total)
+	}
+	expenses, _ := db.Expenses.List()
+	if len(expenses) != workers*iterations {
+		t.Errorf("%d expenses stored, want %d", len(expenses), workers*iterations)
+	}
+	payments, _ := db.Payments.List()
+	if len(payments) != 2*workers*iterations {
+		t.Errorf("%d payments stored, want %d", len(payments), 2*workers*iterations)
+	}
+}
+
+// TestValidationErrors checks that invalid requests are rejected with every
+// problem listed against the field that caused it.
+func TestValidationErrors(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	members := []int32{alice.Id, bob.Id}
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": members}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+	var expense models.Expense
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "10.00", "splitBetween": members, "splitType": "Equal",
+	}, http.StatusCreated, &expense)
+
+	tests := []struct {
+		name       string
+		as         http.Handler
+		method     string
+		target     string
+		in         body
+		wantStatus int
+		want       []fieldError
+	}{
+		{
+			name: "missing sign up details", as: e, method: http.MethodPost, target: "/v1/users", in: body{},
+			wantStatus: http.StatusBadRequest,
+			want: []fieldError{
+				{Code: codeRequired, Field: "name"},
+				{Code: codeRequired, Field: "email"},
+				{Code: codeRequired, Field: "password"},
+			},
+		},
+		{
+			name: "invalid sign up details", as: e, method: http.MethodPost, target: "/v1/users", in: body{"name": "Carol", "email": "Carol <carol@example.com>", "password": "short"},
+			wantStatus: http.StatusBadRequest,
+			want: []fieldError{
+				{Code: codeInvalid, Field: "email"},
+				{Code: codeInvalid, Field: "password"},
+			},
+		},
+		{
+			name: "wrong type", as: e, method: http.MethodPost, target: "/v1/users", in: body{"name": 5},
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeInvalidBody, Field: "name"}},
+		},
+		{
+			name: "unknown member", as: asAlice, method: http.MethodPost, target: "/v1/groups", in: body{"name": "Work", "members": []int32{alice.Id, 99}},
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeNotFound, Field: "members[1]"}},
+		},
+		{
+			name: "every expense problem", as: asAlice, method: http.MethodPost, target: tripPath + "/expenses",
+			in:         body{"splitBetween": []int32{alice.Id, 99}, "splitType": "Thirds"},
+			wantStatus: http.StatusBadRequest,
+			want: []fieldError{
+				{Code: codeInvalid, Field: "splitType"},
+				{Code: codeRequired, Field: "amount"},
+				{Code: codeNotFound, Field: "splitBetween[1]"},
+			},
+		},
+		{
+			name: "invalid split", as: asAlice, method: http.MethodPost, target: tripPath + "/expenses",
+			in:         body{"amount": "10.00", "splitBetween": members, "splitType": "Exact", "splitValues": []string{"4.00", "5.00"}},
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeInvalid, Field: "splitValues"}},
+		},
+		{
+			name: "unknown consumer", as: asAlice, method: http.MethodPost, target: tripPath + "/expenses",
+			in: body{"splitType": "Itemized", "items": []body{
+				{"description": "Steak", "amount": "25.00", "consumers": []int32{alice.Id}},
+				{"description": "Wine", "amount": "9.00", "consumers": []int32{bob.Id, 99}},
+			}},
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeNotFound, Field: "items[1].consumers[1]"}},
+		},
+		{
+			name: "unknown group", as: asAlice, method: http.MethodPost, target: "/v1/groups/99/expenses", in: body{},
+			wantStatus: http.StatusNotFound,
+			want:       []fieldError{{Code: codeNotFound, Field: "id"}},
+		},
+		{
+			name: "invalid id", as: asAlice, method: http.MethodGet, target: "/v1/expenses/first",
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeInvalid, Field: "id"}},
+		},
+		{
+			name: "id out of range", as: asAlice, method: http.MethodGet, target: fmt.Sprint("/v1/expenses/", int64(expense.ID)+1<<32),
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeInvalid, Field: "id"}},
+		},
+		{
+			name: "member id out of range", as: asAlice, method: http.MethodGet, target: fmt.Sprint(tripPath, "/members/", int64(alice.Id)+1<<32, "/removal"),
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeInvalid, Field: "userId"}},
+		},
+		{
+			name: "invalid patch", as: asAlice, method: http.MethodPatch, target: fmt.Sprint("/v1/expenses/", expense.ID), in: body{"amount": "-1.00"},
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeInvalid, Field: "amount"}},
+		},
+		{
+			name: "overpayment", as: asBob, method: http.MethodPost, target: "/v1/payments",
+			in:         body{"payee": alice.Id, "amount": "6.00", "expenses": []int{expense.ID}},
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeInvalid, Field: "expenses"}},
+		},
+		{
+			name: "payment expense id out of range", as: asBob, method: http.MethodPost, target: "/v1/payments",
+			in:         body{"payee": alice.Id, "amount": "1.00", "expenses": []int64{int64(expense.ID) + 1<<32}},
+			wantStatus: http.StatusBadRequest,
+			want:       []fieldError{{Code: codeInvalid, Field: "expenses[0]"}},
+		},
+		{
+			name: "payment without expenses or group", as: asBob, method: http.MethodPost, target: "/v1/payments",
+			in:         body{"payee": bob.Id, "amount": "0", "mode": "Cheque"},
+			wantStatus: http.StatusBadRequest,
+			want: []fieldError{
+				{Code: codeInvalid, Field: "payee"},
+				{Code: codeInvalid, Field: "amount"},
+				{Code: codeInvalid, Field: "mode"},
+				{Code: codeRequired, Field: "groupId"},
+			},
+		},
+		{
+			name: "unknown route", as: e, method: http.MethodGet, target: "/nowhere",
+			wantStatus: http.StatusNotFound,
+			want:       []fieldError{{Code: codeNotFound}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got errorResponse
+			request(t, tt.as, tt.method, tt.target, tt.in, tt.wantStatus, &got)
+			if len(got.Errors) != len(tt.want) {
+				t.Fatalf("errors = %+v, want %+v", got.Errors, tt.want)
+			}
+			for i, want := range tt.want {
+				if got.Errors[i].Code != want.Code || got.Errors[i].Field != want.Field || got.Errors[i].Message == "" {
+					t.Errorf("errors[%d] = %+v, want code %q and field %q with a message", i, got.Errors[i], want.Code, want.Field)
+				}
+			}
+		})
+	}
+}
+
+// TestRequestBodies checks that request bodies are read before taking dataMu,
+// so that a slow client doesn't hold up everyone else, and that large ones are
+// turned away.
+func TestRequestBodies(t *testing.T) {
+	resetState()
+	e := newServer()
+	_, asAlice := signUp(t, e, "Alice")
+
+	slow, send := io.Pipe()
+	req := httptest.NewRequest(http.MethodPost, "/v1/groups", slow)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	sent := make(chan struct{})
+	go func() {
+		asAlice.ServeHTTP(rec, req)
+		close(sent)
+	}()
+	io.WriteString(send, "{") // Returns once the server has started reading
+
+	others := make(chan struct{})
+	go func() {
+		request(t, asAlice, http.MethodGet, "/v1/groups", nil, http.StatusOK, nil)
+		request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip"}, http.StatusCreated, nil)
+		close(others)
+	}()
+	select {
+	case <-others:
+	case <-time.After(5 * time.Second):
+		t.Fatal("requests were held up by a request whose body hadn't arrived")
+	}
+	io.WriteString(send, `"name": "House"}`)
+	send.Close()
+	<-sent
+	if rec.Code != http.StatusCreated {
+		t.Errorf("slow POST /v1/groups = %d %s, want %d", rec.Code, rec.Body, http.StatusCreated)
+	}
+
+	var got errorResponse
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": strings.Repeat("a", maxBodySize)}, http.StatusRequestEntityTooLarge, &got)
+	if len(got.Errors) != 1 || got.Errors[0].Code != codeInvalidBody {
+		t.Errorf("errors = %+v, want one %q", got.Errors, codeInvalidBody)
+	}
+}
+
+// TestInternalError checks that unexpected errors are logged but not sent to
+// the client.
+func TestInternalError(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = handleError
+	e.GET("/store", func(c echo.Context) error {
+		return internalError("Error reading expense", errors.New("bolt: expense 7: page 12 corrupt"))
+	})
+	e.GET("/unhandled", func(c echo.Context) error {
+		return errors.New("mongo: connection to db.internal:27017 refused")
+	})
+
+	for _, target := range []string{"/store", "/unhandled"} {
+		var got errorResponse
+		request(t, e, http.MethodGet, target, nil, http.StatusInternalServerError, &got)
+		if len(got.Errors) != 1 || got.Errors[0].Code != codeInternal || strings.Contains(got.Errors[0].Message, ":") {
+			t.Errorf("GET %s errors = %+v, want one internal error without its details", target, got.Errors)
+		}
+	}
+}
+
+// TestCRUD walks users, groups, members and payments through their whole
+// lifecycle, including the deletes that are refused while something still
+// refers to the resource.
+func TestCRUD(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	var renamed models.User
+	request(t, asCarol, http.MethodPut, fmt.Sprint("/v1/users/", carol.Id), body{"name": "Caroline"}, http.StatusOK, &renamed)
+	if renamed.Name != "Caroline" {
+		t.Errorf("renamed user = %q, want Caroline", renamed.Name)
+	}
+
+	// Group names need not be unique
+	var trip, other group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{alice.Id, bob.Id}}, http.StatusCreated, &trip)
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip"}, http.StatusCreated, &other)
+	if trip.ID == other.ID {
+		t.Fatalf("both groups have ID %d", trip.ID)
+	}
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+	request(t, asAlice, http.MethodPut, tripPath, body{"name": "Road Trip"}, http.StatusOK, nil)
+
+	// Members
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", carol.Id), nil, http.StatusNotFound, nil)
+	request(t, asAlice, http.MethodPost, tripPath+"/members", body{"user": carol.Id}, http.StatusCreated, nil)
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", carol.Id), nil, http.StatusOK, nil)
+	request(t, asAlice, http.MethodPut, fmt.Sprint("/v1/users/", carol.Id), body{"name": "Carrie"}, http.StatusForbidden, nil)
+	request(t, asAlice, http.MethodPost, tripPath+"/members", body{"user": carol.Id}, http.StatusConflict, nil)
+	var members struct{ Items []models.User }
+	request(t, asAlice, http.MethodGet, tripPath+"/members", nil, http.StatusOK, &members)
+	if len(members.Items) != 3 {
+		t.Errorf("group has %d members, want 3", len(members.Items))
+	}
+	request(t, asCarol, http.MethodDelete, fmt.Sprint("/v1/users/", carol.Id), nil, http.StatusConflict, nil)
+	request(t, asAlice, http.MethodDelete, fmt.Sprint(tripPath, "/members/", carol.Id), nil, http.StatusNoContent, nil)
+	request(t, asAlice, http.MethodDelete, fmt.Sprint(tripPath, "/members/", carol.Id), nil, http.StatusNotFound, nil)
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", carol.Id), nil, http.StatusOK, nil) // A former member
+	request(t, asCarol, http.MethodDelete, fmt.Sprint("/v1/users/", carol.Id), nil, http.StatusNoContent, nil)
+	request(t, asCarol, http.MethodGet, "/v1/users/me", nil, http.StatusUnauthorized, nil)
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", carol.Id), nil, http.StatusNotFound, nil)
+	request(t, asAlice, http.MethodGet, tripPath+"/members?former=true", nil, http.StatusOK, &members)
+	if len(members.Items) != 0 {
+		t.Errorf("former members after Carol deleted her account = %+v, want none", members.Items)
+	}
+
+	// An expense and a payment that settles it
+	var expense models.Expense
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "10.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &expense)
+	var expenses struct{ Items []models.Expense }
+	request(t, asAlice, http.MethodGet, tripPath+"/expenses", nil, http.StatusOK, &expenses)
+	if len(expenses.Items) != 1 || expenses.Items[0].ID != expense.ID {
+		t.Errorf("group expenses = %+v, want expense %d", expenses.Items, expense.ID)
+	}
+	request(t, asAlice, http.MethodDelete, fmt.Sprint(tripPath, "/members/", bob.Id), nil, http.StatusConflict, nil)
+
+	// Payments list the IDs of the expenses they settle
+	type paymentResponse struct {
+		ID      int
+		Amount  models.Money
+		Mode    models.PaymentMode
+		Note    string
+		GroupID int32
+	}
+	var payment paymentResponse
+	request(t, asBob, http.MethodPost, "/v1/payments", body{
+		"payee": alice.Id, "amount": "5.00", "expenses": []int{expense.ID},
+	}, http.StatusCreated, &payment)
+	if payment.GroupID != trip.ID {
+		t.Errorf("payment.GroupID = %d, want %d", payment.GroupID, trip.ID)
+	}
+	paymentPath := fmt.Sprint("/v1/payments/", payment.ID)
+	var updated paymentResponse
+	request(t, asBob, http.MethodPut, paymentPath, body{"mode": "UPI", "identifier": "ref-1", "note": "Dinner"}, http.StatusOK, &updated)
+	if updated.Mode != models.UPI || updated.Note != "Dinner" || updated.Amount != payment.Amount {
+		t.Errorf("updated payment = %+v", updated)
+	}
+	request(t, asAlice, http.MethodDelete, tripPath, nil, http.StatusConflict, nil)
+	request(t, asAlice, http.MethodDelete, fmt.Sprint("/v1/expenses/", expense.ID), nil, http.StatusConflict, nil)
+
+	// Deleting the payment makes the expense outstanding again
+	request(t, asBob, http.MethodDelete, paymentPath, nil, http.StatusNoContent, nil)
+	request(t, asAlice, http.MethodGet, paymentPath, nil, http.StatusNotFound, nil)
+	if balance := debtLedger.Balance(trip.ID, bob.Id); balance != -5000 {
+		t.Errorf("Bob's balance after deleting the payment = %v, want -5.00", balance)
+	}
+	request(t, asBob, http.MethodDelete, fmt.Sprint("/v1/expenses/", expense.ID), nil, http.StatusForbidden, nil)
+	request(t, asAlice, http.MethodDelete, fmt.Sprint("/v1/expenses/", expense.ID), nil, http.StatusNoContent, nil)
+	request(t, asAlice, http.MethodDelete, tripPath, nil, http.StatusNoContent, nil)
+	request(t, asAlice, http.MethodGet, tripPath, nil, http.StatusNotFound, nil)
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/groups/", other.ID), nil, http.StatusOK, nil)
+
+	if total := balanceJournal.TrialBalance(); total != 0 {
+		t.Errorf("TrialBalance() = %v, want 0.00", total)
+	}
+}
+
+// TestDeleteExpense_Reopen checks that deleting expenses never leaves a
+// payment naming a missing expense, which would stop the store from opening.
+func TestDeleteExpense_Reopen(t *testing.T) {
+	resetState()
+	path := filepath.Join(t.TempDir(), "splitwise.db")
+	var err error
+	if db, err = boltstore.Open(path); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer func() { db.Close() }()
+	if err := loadJournal(); err != nil {
+		t.Fatalf("loadJournal() error = %v", err)
+	}
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+	var dinner, taxi, museum models.Expense
+	for _, expense := range []*models.Expense{&dinner, &taxi, &museum} {
+		request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+			"amount": "10.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+		}, http.StatusCreated, expense)
+	}
+
+	// A payment that runs out before the taxi doesn't list it, so the taxi
+	// can still be deleted
+	var payment struct {
+		ID       int
+		Expenses []int
+	}
+	request(t, asBob, http.MethodPost, "/v1/payments", body{
+		"payee": alice.Id, "amount": "3.00", "expenses": []int{dinner.ID, taxi.ID},
+	}, http.StatusCreated, &payment)
+	if len(payment.Expenses) != 1 || payment.Expenses[0] != dinner.ID {
+		t.Errorf("payment expenses = %v, want only dinner %d", payment.Expenses, dinner.ID)
+	}
+	request(t, asAlice, http.MethodDelete, fmt.Sprint("/v1/expenses/", dinner.ID), nil, http.StatusConflict, nil)
+	request(t, asAlice, http.MethodDelete, fmt.Sprint("/v1/expenses/", taxi.ID), nil, http.StatusNoContent, nil)
+
+	// Payments saved before unsettled expenses were dropped from them still
+	// keep the expenses they list
+	stored, err := db.Payments.Get(payment.ID)
+	if err != nil {
+		t.Fatalf("Payments.Get() error = %v", err)
+	}
+	stored.Expenses = append(stored.Expenses, findExpenseByID(int32(museum.ID)))
+	if err := db.Payments.Update(stored); err != nil {
+		t.Fatalf("Payments.Update() error = %v", err)
+	}
+	request(t, asAlice, http.MethodDelete, fmt.Sprint("/v1/expenses/", museum.ID), nil, http.StatusConflict, nil)
+
+	// The store opens again with the same balances
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	reopened, err := boltstore.Open(path)
+	if err != nil {
+		t.Fatalf("Open() after deleting expenses error = %v", err)
+	}
+	db = reopened
+	entries := balanceJournal.Entries()
+	debtLedger = ledger.New()
+	balanceJournal = journal.New(debtLedger)
+	if err := loadJournal(); err != nil {
+		t.Fatalf("loadJournal() error = %v", err)
+	}
+	if balance := debtLedger.Balance(trip.ID, bob.Id); balance != -7000 {
+		t.Errorf("Bob's balance after reopening = %v, want -7.00", balance)
+	}
+
+	// The journal is loaded as it was saved, with the taxi and its reversal
+	if got := balanceJournal.Entries(); len(got) != len(entries) || got[len(got)-1].Kind != journal.ReversalEntry || got[len(got)-1].Reference != taxi.ID {
+		t.Errorf("journal after reopening = %+v, want the %d entries posted, ending with the taxi's reversal", got, len(entries))
+	}
+}
+
+// TestSettlePlan checks that the settle plan clears every balance of the
+// group, with or without keeping to existing debts, and that its drafts can be
+// submitted as they are.
+func TestSettlePlan(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	_, asDave := signUp(t, e, "Dave")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id, carol.Id}}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+
+	// Bob owes Alice 10.00 and Carol owes Bob 10.00, so Carol paying Alice
+	// clears both
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{"amount": "20.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal"}, http.StatusCreated, nil)
+	request(t, asBob, http.MethodPost, tripPath+"/expenses", body{"amount": "20.00", "splitBetween": []int32{bob.Id, carol.Id}, "splitType": "Equal"}, http.StatusCreated, nil)
+
+	type user struct{ Id int32 }
+	type plan struct {
+		Group     int32
+		KeepPairs bool
+		Transfers []struct {
+			From, To user
+			Amount   models.Money
+		}
+		Drafts []struct {
+			Payer, Payee user
+			Amount       models.Money
+			Currency     models.Currency
+			GroupID      int32
+			Expenses     []int
+		}
+	}
+	transfers := func(p plan) []string {
+		var got []string
+		for i, transfer := range p.Transfers {
+			draft := p.Drafts[i]
+			if draft.Payer != transfer.From || draft.Payee != transfer.To || draft.Amount != transfer.Amount ||
+				draft.Currency != trip.Currency || draft.GroupID != trip.ID || len(draft.Expenses) != 0 {
+				t.Errorf("draft %+v doesn't make transfer %+v in the trip", draft, transfer)
+			}
+			got = append(got, fmt.Sprint(transfer.From.Id, "->", transfer.To.Id, ":", transfer.Amount))
+		}
+		return got
+	}
+
+	var simplified, pairs plan
+	request(t, asBob, http.MethodGet, tripPath+"/settle-plan", nil, http.StatusOK, &simplified)
+	if simplified.Group != trip.ID || simplified.KeepPairs || len(simplified.Drafts) != len(simplified.Transfers) {
+		t.Errorf("plan = %+v, want one draft per transfer in group %d", simplified, trip.ID)
+	}
+	if got, want := transfers(simplified), []string{fmt.Sprint(carol.Id, "->", alice.Id, ":10.00")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("transfers = %v, want %v", got, want)
+	}
+	request(t, asBob, http.MethodGet, tripPath+"/settle-plan?keepPairs=true", nil, http.StatusOK, &pairs)
+	if got, want := transfers(pairs), []string{fmt.Sprint(bob.Id, "->", alice.Id, ":10.00"), fmt.Sprint(carol.Id, "->", bob.Id, ":10.00")}; !pairs.KeepPairs || !reflect.DeepEqual(got, want) {
+		t.Errorf("transfers keeping pairs = %v, want %v", got, want)
+	}
+	request(t, asBob, http.MethodGet, tripPath+"/settle-plan?keepPairs=maybe", nil, http.StatusBadRequest, nil)
+	request(t, asDave, http.MethodGet, tripPath+"/settle-plan", nil, http.StatusNotFound, nil)
+	request(t, e, http.MethodGet, tripPath+"/settle-plan", nil, http.StatusUnauthorized, nil)
+
+	// Submitting the drafts settles the group up
+	payers := map[int32]http.Handler{alice.Id: asAlice, bob.Id: asBob, carol.Id: asCarol}
+	for _, draft := range pairs.Drafts {
+		request(t, payers[draft.Payer.Id], http.MethodPost, "/v1/payments", body{
+			"payee": draft.Payee.Id, "amount": draft.Amount, "currency": draft.Currency, "groupId": draft.GroupID,
+		}, http.StatusCreated, nil)
+	}
+	var balances groupBalances
+	request(t, asAlice, http.MethodGet, tripPath+"/balances", nil, http.StatusOK, &balances)
+	for id, balance := range balances.Balances {
+		if balance != 0 {
+			t.Errorf("user %d's balance after the plan = %v, want 0.00", id, balance)
+		}
+	}
+	request(t, asAlice, http.MethodGet, tripPath+"/settle-plan", nil, http.StatusOK, &simplified)
+	if len(simplified.Transfers) != 0 || len(simplified.Drafts) != 0 {
+		t.Errorf("plan once settled = %+v, want no transfers", simplified)
+	}
+}
+
+// TestBalances checks the balance endpoints, and that users' positions in
+// groups the signed in user isn't part of stay hidden.
+func TestBalances(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	var trip, house group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	request(t, asCarol, http.MethodPost, "/v1/groups", body{"name": "House", "members": []int32{bob.Id}}, http.StatusCreated, &house)
+	request(t, asAlice, http.MethodPost, fmt.Sprint("/v1/groups/", trip.ID, "/expenses"), body{
+		"amount": "30.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusCreated, nil)
+	request(t, asCarol, http.MethodPost, fmt.Sprint("/v1/groups/", house.ID, "/expenses"), body{
+		"amount": "100.00", "splitBetween": []int32{bob.Id, carol.Id}, "splitType": "Equal",
+	}, http.StatusCreated, nil)
+
+	var balances groupBalances
+	request(t, asBob, http.MethodGet, fmt.Sprint("/v1/groups/", trip.ID, "/balances"), nil, http.StatusOK, &balances)
+	if balances.Group != trip.ID || balances.Balances[alice.Id] != 15000 || balances.Balances[bob.Id] != -15000 {
+		t.Errorf("trip balances = %+v, want Bob owing Alice 15.00", balances)
+	}
+	if len(balances.Debts) != 1 || balances.Debts[0].From.Id != bob.Id || balances.Debts[0].To.Id != alice.Id || balances.Debts[0].Amount != 15000 {
+		t.Errorf("trip debts = %+v, want Bob owing Alice 15.00", balances.Debts)
+	}
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/groups/", house.ID, "/balances"), nil, http.StatusNotFound, nil)
+
+	// Alice only sees Bob's position in the trip, and not his total
+	var bobs struct {
+		User     map[string]interface{}
+		Balances map[int32]models.Money
+		Debts    []ledger.Debt
+	}
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", bob.Id, "/balances"), nil, http.StatusOK, &bobs)
+	if len(bobs.Balances) != 1 || bobs.Balances[trip.ID] != -15000 || len(bobs.Debts) != 1 {
+		t.Errorf("Bob's balances = %+v, want only -15.00 in the trip", bobs)
+	}
+	var user map[string]interface{}
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", bob.Id), nil, http.StatusOK, &user)
+	for _, served := range []map[string]interface{}{bobs.User, user} {
+		if _, ok := served["Balance"]; ok || served["Name"] != "Bob" {
+			t.Errorf("Bob = %v, want him without a balance", served)
+		}
+	}
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", carol.Id, "/balances"), nil, http.StatusNotFound, nil)
+}
+
+// failingLog is a journal.Log that lists the entries of the log it wraps but
+// fails to save new ones.
+type failingLog struct{ journal.Log }
+
+func (failingLog) Append(*journal.Entry) error { return errors.New("disk full") }
+
+// TestExpenseBalances checks that replacing, patching and deleting an
+// expense leave group and user balances as if it had only ever been made as
+// it ends up, and that changes the journal can't save are undone.
+func TestExpenseBalances(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, _ := signUp(t, e, "Carol")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id, carol.Id}}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+
+	// Bob's taxi stays as it is throughout, with Alice owing him 5.00
+	request(t, asBob, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "10.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusCreated, nil)
+	var dinner models.Expense
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "30.00", "splitBetween": []int32{alice.Id, bob.Id, carol.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &dinner)
+	dinnerPath := fmt.Sprint("/v1/expenses/", dinner.ID)
+
+	check := func(when string, want map[int32]models.Money) {
+		t.Helper()
+		var balances groupBalances
+		request(t, asAlice, http.MethodGet, tripPath+"/balances", nil, http.StatusOK, &balances)
+		for _, user := range []models.User{alice, bob, carol} {
+			if got := balances.Balances[user.Id]; got != want[user.Id] {
+				t.Errorf("%s: %s's group balance = %v, want %v", when, user.Name, got, want[user.Id])
+			}
+			var users struct{ Balances map[int32]models.Money }
+			request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", user.Id, "/balances"), nil, http.StatusOK, &users)
+			if got := users.Balances[trip.ID]; got != want[user.Id] {
+				t.Errorf("%s: %s's user balance in the trip = %v, want %v", when, user.Name, got, want[user.Id])
+			}
+		}
+		var report journalReport
+		request(t, asAlice, http.MethodGet, "/v1/journal", nil, http.StatusOK, &report)
+		if report.TrialBalance != 0 {
+			t.Errorf("%s: trial balance = %v, want 0.00", when, report.TrialBalance)
+		}
+	}
+	check("after creating", map[int32]models.Money{alice.Id: 15000, bob.Id: -5000, carol.Id: -10000})
+
+	request(t, asAlice, http.MethodPut, dinnerPath, body{
+		"amount": "60.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusOK, nil)
+	check("after PUT", map[int32]models.Money{alice.Id: 25000, bob.Id: -25000})
+
+	request(t, asAlice, http.MethodPatch, dinnerPath, body{"amount": "40.00"}, http.StatusOK, nil)
+	check("after PATCH", map[int32]models.Money{alice.Id: 15000, bob.Id: -15000})
+
+	// While the journal can't save entries, nothing changes
+	balanceJournal.Load(failingLog{db.Journal})
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "90.00", "splitBetween": []int32{alice.Id, bob.Id, carol.Id}, "splitType": "Equal",
+	}, http.StatusInternalServerError, nil)
+	request(t, asAlice, http.MethodPatch, dinnerPath, body{"amount": "10.00"}, http.StatusInternalServerError, nil)
+	request(t, asAlice, http.MethodDelete, dinnerPath, nil, http.StatusInternalServerError, nil)
+	request(t, asBob, http.MethodPost, "/v1/payments", body{"payee": alice.Id, "amount": "5.00", "expenses": []int{dinner.ID}}, http.StatusInternalServerError, nil)
+	check("after changes the journal couldn't save", map[int32]models.Money{alice.Id: 15000, bob.Id: -15000})
+	var expenses struct{ Items []models.Expense }
+	request(t, asAlice, http.MethodGet, tripPath+"/expenses", nil, http.StatusOK, &expenses)
+	if len(expenses.Items) != 2 {
+		t.Errorf("expenses = %+v, want only the taxi and the dinner", expenses.Items)
+	}
+	var got models.Expense
+	request(t, asAlice, http.MethodGet, dinnerPath, nil, http.StatusOK, &got)
+	if got.Amount != 40000 || got.RemainingAmount != 40000 {
+		t.Errorf("dinner = %v with %v remaining, want 40.00 unsettled", got.Amount, got.RemainingAmount)
+	}
+	balanceJournal.Load(db.Journal)
+
+	request(t, asAlice, http.MethodDelete, dinnerPath, nil, http.StatusNoContent, nil)
+	check("after DELETE", map[int32]models.Money{alice.Id: -5000, bob.Id: 5000})
+}
+
+// TestPaymentSettlement checks that a payment listing expenses settles them
+// oldest first and reports how much went to each, and that a payment that
+// can't be settled in full changes nothing.
+func TestPaymentSettlement(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id, carol.Id}}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+
+	// Bob owes Alice 15.00 for lunch, 20.00 for dinner and 5.00 for the taxi,
+	// and Carol 5.00 for the museum
+	var lunch, dinner, taxi, museum models.Expense
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{"amount": "30.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal"}, http.StatusCreated, &lunch)
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{"amount": "40.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal"}, http.StatusCreated, &dinner)
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{"amount": "10.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal"}, http.StatusCreated, &taxi)
+	request(t, asCarol, http.MethodPost, tripPath+"/expenses", body{"amount": "10.00", "splitBetween": []int32{bob.Id, carol.Id}, "splitType": "Equal"}, http.StatusCreated, &museum)
+
+	check := func(when string, bobBalance models.Money, remaining map[int]models.Money) {
+		t.Helper()
+		var balances groupBalances
+		request(t, asAlice, http.MethodGet, tripPath+"/balances", nil, http.StatusOK, &balances)
+		if got := balances.Balances[bob.Id]; got != bobBalance {
+			t.Errorf("%s: Bob's balance = %v, want %v", when, got, bobBalance)
+		}
+		for id, want := range remaining {
+			var expense struct{ RemainingAmount models.Money }
+			request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/expenses/", id), nil, http.StatusOK, &expense)
+			if expense.RemainingAmount != want {
+				t.Errorf("%s: expense %d has %v remaining, want %v", when, id, expense.RemainingAmount, want)
+			}
+		}
+	}
+	unsettled := map[int]models.Money{lunch.ID: 30000, dinner.ID: 40000, taxi.ID: 10000, museum.ID: 10000}
+	check("before paying", -45000, unsettled)
+
+	// Payments that can't be settled in full are rejected, and say why
+	pay := func(amount string, expenses ...int) body {
+		return body{"payee": alice.Id, "amount": amount, "expenses": expenses}
+	}
+	for _, tt := range []struct {
+		name    string
+		payment body
+		status  int
+		field   string
+	}{
+		{name: "More Than Owed", payment: pay("16.00", lunch.ID), status: http.StatusBadRequest, field: "expenses"},
+		{name: "Paid By Someone Else", payment: pay("5.00", museum.ID), status: http.StatusBadRequest, field: "expenses"},
+		{name: "Listed Twice", payment: pay("5.00", taxi.ID, taxi.ID), status: http.StatusBadRequest, field: "expenses"},
+		{name: "Unknown Expense", payment: pay("5.00", taxi.ID, 999), status: http.StatusBadRequest, field: "expenses[1]"},
+		{name: "Other Currency", payment: body{"payee": alice.Id, "amount": "5.00", "currency": "USD", "expenses": []int{taxi.ID}}, status: http.StatusBadRequest, field: "currency"},
+	} {
+		var got errorResponse
+		request(t, asBob, http.MethodPost, "/v1/payments", tt.payment, tt.status, &got)
+		if len(got.Errors) != 1 || got.Errors[0].Field != tt.field {
+			t.Errorf("%s: errors = %+v, want one about %s", tt.name, got.Errors, tt.field)
+		}
+	}
+	check("after rejected payments", -45000, unsettled)
+
+	// Listed in any order, lunch is settled first, then dinner in part, and
+	// the payment runs out before the taxi, which it doesn't list
+	var payment struct {
+		ID          int
+		Amount      models.Money
+		GroupID     int32
+		Expenses    []int
+		Allocations []models.Allocation
+	}
+	request(t, asBob, http.MethodPost, "/v1/payments", pay("25.00", taxi.ID, dinner.ID, lunch.ID), http.StatusCreated, &payment)
+	wantAllocations := []models.Allocation{{Expense: lunch.ID, Amount: 15000}, {Expense: dinner.ID, Amount: 10000}}
+	if !reflect.DeepEqual(payment.Allocations, wantAllocations) || !reflect.DeepEqual(payment.Expenses, []int{lunch.ID, dinner.ID}) {
+		t.Errorf("payment allocations = %+v over expenses %v, want %+v", payment.Allocations, payment.Expenses, wantAllocations)
+	}
+	if payment.Amount != 25000 || payment.GroupID != trip.ID {
+		t.Errorf("payment = %v in group %d, want 25.00 in %d", payment.Amount, payment.GroupID, trip.ID)
+	}
+	check("after paying", -20000, map[int]models.Money{lunch.ID: 15000, dinner.ID: 30000, taxi.ID: 10000, museum.ID: 10000})
+
+	// Lunch is settled now, so paying it again is more than Bob owes
+	request(t, asBob, http.MethodPost, "/v1/payments", pay("1.00", lunch.ID), http.StatusBadRequest, nil)
+
+	// Deleting the payment unsettles the expenses again
+	request(t, asBob, http.MethodDelete, fmt.Sprint("/v1/payments/", payment.ID), nil, http.StatusNoContent, nil)
+	check("after deleting the payment", -45000, unsettled)
+}
+
+// TestListing checks the filters, sort orders and cursors of the list
+// endpoints.
+func TestListing(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	signUp(t, e, "Dave")
+	var house, trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "House", "members": []int32{alice.Id, bob.Id, carol.Id}}, http.StatusCreated, &house)
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{alice.Id, bob.Id}}, http.StatusCreated, &trip)
+
+	// Expenses i = 0..5 of (i+1)*10.00 on the 1st to 6th of January, paid
+	// alternately by Alice and Bob, the last two on the trip
+	var ids []int
+	for i := 0; i < 6; i++ {
+		payer, target, members := asAlice, fmt.Sprint("/v1/groups/", house.ID, "/expenses"), []int32{alice.Id, bob.Id, carol.Id}
+		if i%2 == 1 {
+			payer = asBob
+		}
+		if i >= 4 {
+			target, members = fmt.Sprint("/v1/groups/", trip.ID, "/expenses"), []int32{alice.Id, bob.Id}
+		}
+		var expense models.Expense
+		request(t, payer, http.MethodPost, target, body{
+			"amount": (i + 1) * 10, "splitBetween": members, "splitType": "Equal",
+		}, http.StatusCreated, &expense)
+		findExpenseByID(int32(expense.ID)).Timestamp = time.Date(2024, 1, i+1, 12, 0, 0, 0, time.UTC)
+		ids = append(ids, expense.ID)
+	}
+	// Bob and Carol settle their shares of the first expense, and Alice settles
+	// up with Bob on the trip
+	var paymentIDs []int
+	for _, each := range []struct {
+		as http.Handler
+		in body
+	}{
+		{asBob, body{"payee": alice.Id, "amount": "3.33", "expenses": []int{ids[0]}}},
+		{asCarol, body{"payee": alice.Id, "amount": "3.33", "expenses": []int{ids[0]}}},
+		{asAlice, body{"payee": bob.Id, "amount": "5.00", "groupId": trip.ID}},
+	} {
+		var payment struct{ ID int }
+		request(t, each.as, http.MethodPost, "/v1/payments", each.in, http.StatusCreated, &payment)
+		paymentIDs = append(paymentIDs, payment.ID)
+	}
+
+	listIDs := func(target string) []int {
+		t.Helper()
+		var got struct {
+			Items      []struct{ ID int }
+			NextCursor string
+		}
+		request(t, asAlice, http.MethodGet, target, nil, http.StatusOK, &got)
+		var gotIDs []int
+		for _, item := range got.Items {
+			gotIDs = append(gotIDs, item.ID)
+		}
+		return gotIDs
+	}
+
+	tests := []struct {
+		target string
+		want   []int
+	}{
+		{"/v1/expenses", ids},
+		{fmt.Sprint("/v1/expenses?group=", trip.ID), ids[4:]},
+		{fmt.Sprint("/v1/groups/", house.ID, "/expenses?payer=", bob.Id), []int{ids[1], ids[3]}},
+		{fmt.Sprint("/v1/expenses?participant=", carol.Id, "&sort=-amount"), []int{ids[3], ids[2], ids[1], ids[0]}},
+		{"/v1/expenses?from=2024-01-02&to=2024-01-03", ids[1:3]},
+		{"/v1/expenses?from=2024-01-05T12:00:00Z", ids[4:]},
+		{"/v1/expenses?to=2024-01-02T12:00:00Z", ids[:2]},
+		{"/v1/expenses?minAmount=20&maxAmount=40.00", ids[1:4]},
+		{"/v1/expenses?settled=true", ids[:1]},
+		{fmt.Sprint("/v1/expenses?settled=false&payer=", alice.Id), []int{ids[2], ids[4]}},
+		{"/v1/expenses?sort=-timestamp&limit=2", []int{ids[5], ids[4]}},
+		{fmt.Sprint("/v1/payments?group=", trip.ID), paymentIDs[2:]},
+		{fmt.Sprint("/v1/payments?participant=", carol.Id), paymentIDs[1:2]},
+		{fmt.Sprint("/v1/groups?member=", carol.Id), []int{int(house.ID)}},
+		{"/v1/groups?sort=-name", []int{int(trip.ID), int(house.ID)}},
+	}
+	for _, tt := range tests {
+		if got := listIDs(tt.target); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET %s = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+
+	// Users sort by name ignoring case, and page through everyone who shares a
+	// group with Alice
+	var pages [][]int32
+	for target := "/v1/users?sort=name&limit=2"; ; {
+		var got struct {
+			Items      []models.User
+			NextCursor string
+		}
+		request(t, asAlice, http.MethodGet, target, nil, http.StatusOK, &got)
+		var pageIDs []int32
+		for _, user := range got.Items {
+			pageIDs = append(pageIDs, user.Id)
+		}
+		pages = append(pages, pageIDs)
+		if got.NextCursor == "" {
+			break
+		}
+		target = "/v1/users?sort=name&limit=2&cursor=" + got.NextCursor
+	}
+	if want := [][]int32{{alice.Id, bob.Id}, {carol.Id}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+
+	// Every problem with the query is reported at once
+	var got errorResponse
+	request(t, asAlice, http.MethodGet, "/v1/expenses?payer=bob&from=yesterday&settled=maybe&sort=name&limit=0&cursor=x", nil, http.StatusBadRequest, &got)
+	var fields []string
+	for _, each := range got.Errors {
+		fields = append(fields, each.Field)
+	}
+	if want := []string{"payer", "from", "settled", "limit", "sort", "cursor"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("error fields = %v, want %v", fields, want)
+	}
+}
+
+// TestAuth signs a user in, refreshes their session and signs them out, and
+// checks that requests without a valid token are turned away.
+func TestAuth(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	var me models.User
+	request(t, asAlice, http.MethodGet, "/v1/users/me", nil, http.StatusOK, &me)
+	if me.Id != alice.Id || me.Email != "alice@example.com" {
+		t.Errorf("GET /v1/users/me = %+v, want Alice", me)
+	}
+
+	var got errorResponse
+	request(t, e, http.MethodGet, "/v1/users/me", nil, http.StatusUnauthorized, &got)
+	if len(got.Errors) != 1 || got.Errors[0].Code != codeUnauthorized {
+		t.Errorf("errors = %+v, want one %q", got.Errors, codeUnauthorized)
+	}
+	request(t, signedIn{e: e, token: "not.a.token"}, http.MethodGet, "/v1/users/me", nil, http.StatusUnauthorized, nil)
+	request(t, e, http.MethodPost, "/v1/auth/login", body{"email": "alice@example.com", "password": "wrong password"}, http.StatusUnauthorized, nil)
+	request(t, e, http.MethodPost, "/v1/auth/login", body{"email": "nobody@example.com", "password": testPassword}, http.StatusUnauthorized, nil)
+
+	// Emails are matched ignoring case
+	var tokens auth.Tokens
+	request(t, e, http.MethodPost, "/v1/auth/login", body{"email": "Alice@Example.com", "password": testPassword}, http.StatusOK, &tokens)
+	if tokens.TokenType != "Bearer" || tokens.ExpiresIn <= 0 {
+		t.Errorf("tokens = %+v, want Bearer tokens that expire", tokens)
+	}
+
+	// An access token can't refresh, and a refresh token only works once
+	request(t, e, http.MethodPost, "/v1/auth/refresh", body{"refreshToken": tokens.AccessToken}, http.StatusUnauthorized, nil)
+	var refreshed auth.Tokens
+	request(t, e, http.MethodPost, "/v1/auth/refresh", body{"refreshToken": tokens.RefreshToken}, http.StatusOK, &refreshed)
+	asRefreshed := signedIn{e: e, token: refreshed.AccessToken}
+	request(t, asRefreshed, http.MethodGet, "/v1/users/me", nil, http.StatusOK, nil)
+
+	// Signing out ends only the session the request was signed with
+	request(t, asRefreshed, http.MethodPost, "/v1/auth/logout", nil, http.StatusNoContent, nil)
+	request(t, asRefreshed, http.MethodGet, "/v1/users/me", nil, http.StatusUnauthorized, nil)
+	request(t, e, http.MethodPost, "/v1/auth/refresh", body{"refreshToken": refreshed.RefreshToken}, http.StatusUnauthorized, nil)
+	request(t, asAlice, http.MethodGet, "/v1/users/me", nil, http.StatusOK, nil)
+
+	// Signing up again with a taken email looks the same as signing up, but
+	// leaves the account as it was
+	request(t, e, http.MethodPost, "/v1/users", body{"name": "Mallory", "email": " ALICE@example.com", "password": "another password"}, http.StatusAccepted, nil)
+	request(t, e, http.MethodPost, "/v1/auth/login", body{"email": "alice@example.com", "password": "another password"}, http.StatusUnauthorized, nil)
+	request(t, asAlice, http.MethodGet, "/v1/users/me", nil, http.StatusOK, &me)
+	if me.Name != "Alice" {
+		t.Errorf("GET /v1/users/me after signing up again = %+v, want Alice unchanged", me)
+	}
+
+	// Users only see the people they share a group with, and can't find
+	// anyone else, even by their email address
+	bob, asBob := signUp(t, e, "Bob")
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", bob.Id), nil, http.StatusNotFound, nil)
+	var users struct{ Items []models.User }
+	for _, target := range []string{"/v1/users", "/v1/users?email=bob@example.com"} {
+		request(t, asAlice, http.MethodGet, target, nil, http.StatusOK, &users)
+		if len(users.Items) != 1 || users.Items[0].Id != alice.Id {
+			t.Errorf("GET %s = %+v, want only Alice", target, users.Items)
+		}
+	}
+
+	// Expenses and payments are made by the signed in user, and other members
+	// can't change them
+	var trip group.Group
+	request(t, asBob, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{alice.Id, bob.Id}}, http.StatusCreated, &trip)
+	var expense models.Expense
+	request(t, asBob, http.MethodPost, fmt.Sprint("/v1/groups/", trip.ID, "/expenses"), body{
+		"amount": "10.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &expense)
+	if expense.PaidBy.Id != bob.Id {
+		t.Errorf("expense paid by %d, want Bob (%d)", expense.PaidBy.Id, bob.Id)
+	}
+	expensePath := fmt.Sprint("/v1/expenses/", expense.ID)
+	got = errorResponse{}
+	request(t, asAlice, http.MethodPatch, expensePath, body{"amount": "1.00"}, http.StatusForbidden, &got)
+	if len(got.Errors) != 1 || got.Errors[0].Code != codeForbidden {
+		t.Errorf("errors = %+v, want one %q", got.Errors, codeForbidden)
+	}
+	request(t, asBob, http.MethodPatch, expensePath, body{"amount": "1.00"}, http.StatusOK, nil)
+
+	// Deleting an account signs it out everywhere
+	dave, asDave := signUp(t, e, "Dave")
+	request(t, asDave, http.MethodDelete, fmt.Sprint("/v1/users/", dave.Id), nil, http.StatusNoContent, nil)
+	request(t, asDave, http.MethodGet, "/v1/users/me", nil, http.StatusUnauthorized, nil)
+}
+
+// TestRoles checks what each role may do in a group, and that the group and
+// everything in it are hidden from users who aren't members.
+func TestRoles(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	dave, asDave := signUp(t, e, "Dave")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	if trip.Roles[alice.Id] != group.Owner || !trip.HasMember(alice.Id) {
+		t.Errorf("group created by Alice = %+v, want her as its owner", trip)
+	}
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+	request(t, asAlice, http.MethodPost, tripPath+"/members", body{"user": carol.Id, "role": "viewer"}, http.StatusCreated, nil)
+	request(t, asAlice, http.MethodPost, tripPath+"/members", body{"user": dave.Id, "role": "boss"}, http.StatusBadRequest, nil)
+
+	var expense models.Expense
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "9.00", "splitBetween": []int32{alice.Id, bob.Id, carol.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &expense)
+	expensePath := fmt.Sprint("/v1/expenses/", expense.ID)
+
+	// Everyone sharing an expense or taking part in a payment must be a member
+	var got errorResponse
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "9.00", "splitBetween": []int32{alice.Id, dave.Id}, "splitType": "Equal",
+	}, http.StatusBadRequest, &got)
+	if len(got.Errors) != 1 || got.Errors[0].Field != "splitBetween[1]" {
+		t.Errorf("errors = %+v, want splitBetween[1] not a member", got.Errors)
+	}
+	request(t, asBob, http.MethodPost, "/v1/payments", body{"payee": dave.Id, "amount": "1.00", "groupId": trip.ID}, http.StatusBadRequest, nil)
+
+	// Viewers only look
+	request(t, asCarol, http.MethodGet, tripPath+"/balances", nil, http.StatusOK, nil)
+	request(t, asCarol, http.MethodGet, expensePath, nil, http.StatusOK, nil)
+	request(t, asCarol, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "1.00", "splitBetween": []int32{alice.Id}, "splitType": "Equal",
+	}, http.StatusForbidden, nil)
+	request(t, asCarol, http.MethodPost, "/v1/payments", body{"payee": alice.Id, "amount": "3.00", "expenses": []int{expense.ID}}, http.StatusForbidden, nil)
+
+	// Members add expenses and payments, but can't manage the group or change
+	// anyone else's expenses
+	request(t, asBob, http.MethodPatch, expensePath, body{"amount": "12.00"}, http.StatusForbidden, nil)
+	request(t, asBob, http.MethodPut, tripPath, body{"name": "Bob's Trip"}, http.StatusForbidden, nil)
+	request(t, asBob, http.MethodPost, tripPath+"/members", body{"user": dave.Id}, http.StatusForbidden, nil)
+
+	// Admins do, but only owners make admins
+	request(t, asAlice, http.MethodPut, fmt.Sprint(tripPath, "/members/", bob.Id), body{"role": "admin"}, http.StatusOK, nil)
+	request(t, asBob, http.MethodPatch, expensePath, body{"amount": "12.00"}, http.StatusOK, nil)
+	request(t, asBob, http.MethodPost, tripPath+"/members", body{"user": dave.Id, "role": "admin"}, http.StatusForbidden, nil)
+	request(t, asBob, http.MethodPut, fmt.Sprint(tripPath, "/members/", carol.Id), body{"role": "owner"}, http.StatusForbidden, nil)
+	request(t, asBob, http.MethodDelete, tripPath, nil, http.StatusForbidden, nil)
+	request(t, asBob, http.MethodDelete, fmt.Sprint(tripPath, "/members/", alice.Id), nil, http.StatusForbidden, nil)
+
+	// A group always has an owner
+	request(t, asAlice, http.MethodPut, fmt.Sprint(tripPath, "/members/", alice.Id), body{"role": "member"}, http.StatusConflict, nil)
+	request(t, asAlice, http.MethodDelete, fmt.Sprint(tripPath, "/members/", alice.Id), nil, http.StatusConflict, nil)
+
+	// The group and everything in it are hidden from everyone else
+	var house group.Group
+	request(t, asDave, http.MethodPost, "/v1/groups", body{"name": "House"}, http.StatusCreated, &house)
+	request(t, asDave, http.MethodGet, tripPath, nil, http.StatusNotFound, nil)
+	request(t, asDave, http.MethodGet, expensePath, nil, http.StatusNotFound, nil)
+	request(t, asDave, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "1.00", "splitBetween": []int32{dave.Id}, "splitType": "Equal",
+	}, http.StatusNotFound, nil)
+	request(t, asDave, http.MethodPost, "/v1/payments", body{"payee": alice.Id, "amount": "1.00", "groupId": trip.ID}, http.StatusBadRequest, nil)
+	var groups struct{ Items []group.Group }
+	request(t, asDave, http.MethodGet, "/v1/groups", nil, http.StatusOK, &groups)
+	if len(groups.Items) != 1 || groups.Items[0].ID != house.ID {
+		t.Errorf("Dave's groups = %+v, want only House", groups.Items)
+	}
+	var expenses struct{ Items []models.Expense }
+	request(t, asDave, http.MethodGet, "/v1/expenses", nil, http.StatusOK, &expenses)
+	if len(expenses.Items) != 0 {
+		t.Errorf("Dave's expenses = %+v, want none", expenses.Items)
+	}
+	var report journalReport
+	request(t, asDave, http.MethodGet, "/v1/journal", nil, http.StatusOK, &report)
+	if len(report.Entries) != 0 {
+		t.Errorf("Dave's journal = %+v, want no entries", report.Entries)
+	}
+
+	// Anyone can leave
+	request(t, asCarol, http.MethodDelete, fmt.Sprint(tripPath, "/members/", carol.Id), nil, http.StatusConflict, nil) // Still shares the expense
+	request(t, asAlice, http.MethodPost, tripPath+"/members", body{"user": dave.Id, "role": "viewer"}, http.StatusCreated, nil)
+	request(t, asDave, http.MethodDelete, fmt.Sprint(tripPath, "/members/", dave.Id), nil, http.StatusNoContent, nil)
+	request(t, asDave, http.MethodGet, tripPath, nil, http.StatusNotFound, nil)
+}
+
+func TestInvitations(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip"}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+
+	var got errorResponse
+	request(t, asAlice, http.MethodPost, tripPath+"/invitations", body{"email": "not an address", "role": "boss", "expiresInDays": 90}, http.StatusBadRequest, &got)
+	if len(got.Errors) != 3 {
+		t.Errorf("errors = %+v, want email, role and expiresInDays", got.Errors)
+	}
+	request(t, asAlice, http.MethodPost, tripPath+"/invitations", body{"email": alice.Email}, http.StatusConflict, nil)
+	request(t, asBob, http.MethodPost, tripPath+"/invitations", body{}, http.StatusNotFound, nil)
+
+	// An invitation addressed to Bob can be accepted by him alone, once
+	var forBob invitationResponse
+	request(t, asAlice, http.MethodPost, tripPath+"/invitations", body{"email": " Bob@Example.com "}, http.StatusCreated, &forBob)
+	if forBob.Email != bob.Email || forBob.Role != group.Member || forBob.Status != group.Pending || forBob.GroupName != "Trip" || forBob.Token == "" {
+		t.Errorf("invitation = %+v, want a pending invitation to Trip for Bob with a token", forBob)
+	}
+	var mine struct{ Items []invitationResponse }
+	request(t, asBob, http.MethodGet, "/v1/invitations", nil, http.StatusOK, &mine)
+	if len(mine.Items) != 1 || mine.Items[0].ID != forBob.ID || mine.Items[0].Token != "" {
+		t.Errorf("Bob's invitations = %+v, want the one to Trip without its token", mine.Items)
+	}
+	acceptPath := fmt.Sprint("/v1/invitations/", forBob.ID, "/accept")
+	request(t, asCarol, http.MethodPost, acceptPath, body{"token": forBob.Token}, http.StatusNotFound, nil)
+	request(t, asCarol, http.MethodPost, forBob.JoinPath, nil, http.StatusForbidden, nil)
+	request(t, asBob, http.MethodPost, acceptPath, body{}, http.StatusBadRequest, nil)
+	request(t, asBob, http.MethodPost, acceptPath, body{"token": "guessed"}, http.StatusForbidden, nil)
+	var joined group.Group
+	request(t, asBob, http.MethodPost, acceptPath, body{"token": forBob.Token}, http.StatusOK, &joined)
+	if !joined.HasMember(bob.Id) || joined.RoleOf(bob.Id) != group.Member {
+		t.Errorf("group after accepting = %+v, want Bob as a member", joined)
+	}
+	request(t, asBob, http.MethodPost, acceptPath, body{"token": forBob.Token}, http.StatusConflict, nil)
+
+	// Only owners invite admins, and members can't invite anyone
+	request(t, asBob, http.MethodPost, tripPath+"/invitations", body{}, http.StatusForbidden, nil)
+	request(t, asAlice, http.MethodPut, fmt.Sprint(tripPath, "/members/", bob.Id), body{"role": "admin"}, http.StatusOK, nil)
+	request(t, asBob, http.MethodPost, tripPath+"/invitations", body{"role": "admin"}, http.StatusForbidden, nil)
+
+	// A join link can be previewed, and used by anyone until it is revoked
+	var link invitationResponse
+	request(t, asBob, http.MethodPost, tripPath+"/invitations", body{"role": "viewer", "expiresInDays": 1}, http.StatusCreated, &link)
+	var preview invitationResponse
+	request(t, asCarol, http.MethodGet, link.JoinPath, nil, http.StatusOK, &preview)
+	if preview.GroupName != "Trip" || preview.Role != group.Viewer || preview.Token != "" {
+		t.Errorf("preview = %+v, want Trip as a viewer without the token", preview)
+	}
+	request(t, asCarol, http.MethodPost, link.JoinPath, nil, http.StatusOK, &joined)
+	if joined.RoleOf(carol.Id) != group.Viewer {
+		t.Errorf("Carol's role after joining = %q, want viewer", joined.RoleOf(carol.Id))
+	}
+	request(t, asCarol, http.MethodPost, link.JoinPath, nil, http.StatusConflict, nil)
+	request(t, asCarol, http.MethodGet, tripPath+"/invitations", nil, http.StatusForbidden, nil)
+	linkPath := fmt.Sprint(tripPath, "/invitations/", link.ID)
+	request(t, asBob, http.MethodDelete, linkPath, nil, http.StatusNoContent, nil)
+	request(t, asBob, http.MethodDelete, linkPath, nil, http.StatusConflict, nil)
+	dave, asDave := signUp(t, e, "Dave")
+	request(t, asDave, http.MethodPost, link.JoinPath, nil, http.StatusConflict, nil)
+	request(t, asDave, http.MethodPost, "/v1/join/unknown", nil, http.StatusNotFound, nil)
+
+	var all struct{ Items []invitationResponse }
+	request(t, asAlice, http.MethodGet, tripPath+"/invitations?status=revoked", nil, http.StatusOK, &all)
+	if len(all.Items) != 1 || all.Items[0].ID != link.ID || all.Items[0].Uses != 1 {
+		t.Errorf("revoked invitations = %+v, want the link, used once", all.Items)
+	}
+
+	// Invitations can be declined
+	var forDave invitationResponse
+	request(t, asAlice, http.MethodPost, tripPath+"/invitations", body{"email": dave.Email}, http.StatusCreated, &forDave)
+	request(t, asDave, http.MethodPost, fmt.Sprint("/v1/invitations/", forDave.ID, "/decline"), nil, http.StatusOK, nil)
+	request(t, asDave, http.MethodPost, forDave.JoinPath, nil, http.StatusConflict, nil)
+	request(t, asDave, http.MethodGet, tripPath, nil, http.StatusNotFound, nil)
+
+	// Emails aren't verified, so whoever signs up with an invited address
+	// first doesn't join without the token that was sent to it
+	var forErin invitationResponse
+	request(t, asAlice, http.MethodPost, tripPath+"/invitations", body{"email": "erin@example.com", "role": "admin"}, http.StatusCreated, &forErin)
+	erin, asErin := signUp(t, e, "Erin")
+	request(t, asErin, http.MethodGet, tripPath, nil, http.StatusNotFound, nil)
+	request(t, asErin, http.MethodPost, fmt.Sprint("/v1/invitations/", forErin.ID, "/accept"), body{"token": "guessed"}, http.StatusForbidden, nil)
+	request(t, asErin, http.MethodGet, tripPath, nil, http.StatusNotFound, nil)
+	request(t, asErin, http.MethodPost, forErin.JoinPath, nil, http.StatusOK, &joined)
+	if joined.RoleOf(erin.Id) != group.Admin {
+		t.Errorf("Erin's role after joining with the token = %q, want admin", joined.RoleOf(erin.Id))
+	}
+
+	// Deleting the group deletes its invitations
+	var house group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "House"}, http.StatusCreated, &house)
+	var toHouse invitationResponse
+	request(t, asAlice, http.MethodPost, fmt.Sprint("/v1/groups/", house.ID, "/invitations"), body{}, http.StatusCreated, &toHouse)
+	request(t, asAlice, http.MethodDelete, fmt.Sprint("/v1/groups/", house.ID), nil, http.StatusNoContent, nil)
+	request(t, asDave, http.MethodGet, toHouse.JoinPath, nil, http.StatusNotFound, nil)
+}
+
+func TestMemberRemoval(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	dave, _ := signUp(t, e, "Dave")
+	erin, asErin := signUp(t, e, "Erin")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id, carol.Id}}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+	request(t, asAlice, http.MethodPost, tripPath+"/members", body{"user": erin.Id, "role": "viewer"}, http.StatusCreated, nil)
+	bobPath, carolPath := fmt.Sprint(tripPath, "/members/", bob.Id), fmt.Sprint(tripPath, "/members/", carol.Id)
+	var expense models.Expense
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "90.00", "splitBetween": []int32{alice.Id, bob.Id, carol.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &expense)
+
+	// Members who owe money can't leave, but are told how to settle up
+	var got errorResponse
+	request(t, asBob, http.MethodDelete, bobPath, nil, http.StatusConflict, &got)
+	if len(got.Errors) != 1 || !strings.Contains(got.Errors[0].Message, "-30.00") {
+		t.Errorf("errors = %+v, want Bob's balance of -30.00", got.Errors)
+	}
+	var check removalCheck
+	request(t, asBob, http.MethodGet, bobPath+"/removal", nil, http.StatusOK, &check)
+	if check.CanRemove || check.Balance != -30000 || len(check.Debts) != 1 || len(check.Drafts) != 1 {
+		t.Fatalf("removal check = %+v, want Bob blocked by his debt of 30.00 with one draft payment", check)
+	}
+	if draft := check.Drafts[0]; draft.Payer.Id != bob.Id || draft.Payee.Id != alice.Id || draft.Amount != 30000 {
+		t.Errorf("draft = %+v, want Bob paying Alice 30.00", draft)
+	}
+
+	// Only admins can hand debts over, so nobody can take over what others
+	// owe a member
+	alicePath := fmt.Sprint(tripPath, "/members/", alice.Id)
+	request(t, asErin, http.MethodPost, alicePath+"/transfer", body{"to": erin.Id}, http.StatusForbidden, nil)
+	request(t, asCarol, http.MethodPost, alicePath+"/transfer", body{"to": carol.Id}, http.StatusForbidden, nil)
+	request(t, asCarol, http.MethodPost, bobPath+"/transfer", body{"to": carol.Id}, http.StatusForbidden, nil)
+	request(t, asAlice, http.MethodPost, bobPath+"/transfer", body{"to": dave.Id}, http.StatusBadRequest, nil)
+	request(t, asAlice, http.MethodPost, bobPath+"/transfer", body{"to": bob.Id}, http.StatusBadRequest, nil)
+	var balances groupBalances
+	request(t, asAlice, http.MethodPost, bobPath+"/transfer", body{"to": carol.Id}, http.StatusOK, &balances)
+	if balances.Balances[bob.Id] != 0 || balances.Balances[carol.Id] != -60000 || balances.Balances[alice.Id] != 60000 {
+		t.Errorf("balances after the transfer = %v, want Carol owing Alice 60.00", balances.Balances)
+	}
+	request(t, asAlice, http.MethodPost, bobPath+"/transfer", body{"to": carol.Id}, http.StatusConflict, nil)
+
+	// Former members stay in the group's history
+	request(t, asBob, http.MethodDelete, bobPath, nil, http.StatusNoContent, nil)
+	request(t, asBob, http.MethodGet, tripPath, nil, http.StatusNotFound, nil)
+	var members struct{ Items []models.User }
+	request(t, asAlice, http.MethodGet, tripPath+"/members?former=true", nil, http.StatusOK, &members)
+	if len(members.Items) != 1 || members.Items[0].Id != bob.Id {
+		t.Errorf("former members = %+v, want Bob", members.Items)
+	}
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/users/", bob.Id), nil, http.StatusOK, nil)
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/expenses/", expense.ID), nil, http.StatusOK, &expense)
+	if !containsUser(expense.SplitBetween, bob.Id) {
+		t.Errorf("expense after Bob left = %+v, want it still shared with him", expense)
+	}
+	request(t, asBob, http.MethodDelete, fmt.Sprint("/v1/users/", bob.Id), nil, http.StatusConflict, nil)
+
+	// Transfers are kept, so balances come out the same when they are rebuilt
+	debtLedger = ledger.New()
+	balanceJournal = journal.New(debtLedger)
+	if err := replayJournal(); err != nil {
+		t.Fatalf("replayJournal() error = %v", err)
+	}
+	if balance := debtLedger.Balance(trip.ID, carol.Id); balance != -60000 {
+		t.Errorf("Carol's balance after replaying = %v, want -60.00", balance)
+	}
+
+	// Settling up with the drafts lets members leave
+	request(t, asAlice, http.MethodGet, carolPath+"/removal", nil, http.StatusOK, &check)
+	if len(check.Drafts) != 1 {
+		t.Fatalf("removal check = %+v, want one draft payment", check)
+	}
+	draft := check.Drafts[0]
+	request(t, asCarol, http.MethodPost, "/v1/payments", body{"payee": draft.Payee.Id, "amount": draft.Amount.String(), "groupId": trip.ID}, http.StatusCreated, nil)
+	request(t, asAlice, http.MethodGet, carolPath+"/removal", nil, http.StatusOK, &check)
+	if !check.CanRemove {
+		t.Errorf("removal check after settling up = %+v, want Carol removable", check)
+	}
+	request(t, asAlice, http.MethodDelete, carolPath, nil, http.StatusNoContent, nil)
+}
+
+func TestCurrencies(t *testing.T) {
+	resetState()
+	e := newServer()
+	exchangeRates = &rates.Table{Base: "USD", Rates: map[models.Currency]models.Rate{"USD": models.OneRate, "INR": 8325000000}}
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	if trip.Currency != models.DefaultCurrency {
+		t.Errorf("group currency = %q, want %q", trip.Currency, models.DefaultCurrency)
+	}
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Bad", "currency": "XYZ"}, http.StatusBadRequest, nil)
+
+	// Expenses in other currencies need a rate, from the file or entered by hand
+	dinner := body{"amount": "30.00", "currency": "eur", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal"}
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", dinner, http.StatusBadRequest, nil)
+	request(t, asBob, http.MethodPut, tripPath+"/rates", body{"rates": body{"EUR": "90"}}, http.StatusForbidden, nil)
+	request(t, asAlice, http.MethodPut, tripPath+"/rates", body{"rates": body{"INR": "1"}}, http.StatusBadRequest, nil)
+	var tripRates groupRates
+	request(t, asAlice, http.MethodPut, tripPath+"/rates", body{"rates": body{"EUR": "90"}}, http.StatusOK, &tripRates)
+	if tripRates.Rates["EUR"] != 9000000000 || tripRates.Loaded["USD"] != 8325000000 {
+		t.Errorf("rates = %+v, want EUR at 90 by hand and USD at 83.25 from the file", tripRates)
+	}
+	var expense models.Expense
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", dinner, http.StatusCreated, &expense)
+	if expense.Currency != "EUR" || expense.Rate != 9000000000 {
+		t.Errorf("expense currency = %s at %s, want EUR at 90", expense.Currency, expense.Rate)
+	}
+	var taxi models.Expense
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{"amount": "10.00", "currency": "USD", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal"}, http.StatusCreated, &taxi)
+
+	// Payments are in the currency of the expenses they settle. They record
+	// today's rate, but take off what the expenses added at the expenses' rates
+	payment := body{"payee": alice.Id, "amount": "5.00", "currency": "USD", "expenses": []int{expense.ID}}
+	request(t, asBob, http.MethodPost, "/v1/payments", payment, http.StatusBadRequest, nil)
+	request(t, asAlice, http.MethodPut, tripPath+"/rates", body{"rates": body{"EUR": "92"}}, http.StatusOK, nil)
+	delete(payment, "currency")
+	var paid struct {
+		Currency models.Currency
+		Rate     models.Rate
+	}
+	request(t, asBob, http.MethodPost, "/v1/payments", payment, http.StatusCreated, &paid)
+	if paid.Currency != "EUR" || paid.Rate != 9200000000 {
+		t.Errorf("payment currency = %s at %s, want EUR at 92", paid.Currency, paid.Rate)
+	}
+
+	// Balances are kept in the group's currency, or shown in the original ones
+	var balances groupBalances
+	request(t, asAlice, http.MethodGet, tripPath+"/balances?original=true", nil, http.StatusOK, &balances)
+	if balances.Currency != "INR" || balances.Balances[bob.Id] != -1316250 {
+		t.Errorf("balances = %v in %s, want Bob owing 1350.00 + 416.25 - 450.00 = 1316.25 INR", balances.Balances, balances.Currency)
+	}
+	if got := balances.Original[bob.Id]; len(got) != 2 || got["EUR"] != -10000 || got["USD"] != -5000 {
+		t.Errorf("Bob's original balances = %v, want -10.00 EUR and -5.00 USD", got)
+	}
+	request(t, asAlice, http.MethodGet, tripPath+"/balances?original=maybe", nil, http.StatusBadRequest, nil)
+
+	// Editing an expense keeps its rate unless its currency changes
+	var edited models.Expense
+	request(t, asAlice, http.MethodPatch, fmt.Sprint("/v1/expenses/", taxi.ID), body{"amount": "20.00"}, http.StatusOK, &edited)
+	if edited.Currency != "USD" || edited.Rate != 8325000000 {
+		t.Errorf("edited expense currency = %s at %s, want USD at 83.25", edited.Currency, edited.Rate)
+	}
+	request(t, asAlice, http.MethodPatch, fmt.Sprint("/v1/expenses/", taxi.ID), body{"currency": "JPY"}, http.StatusBadRequest, nil)
+
+	// The group's currency only changes while it has nothing to convert
+	request(t, asAlice, http.MethodPut, tripPath, body{"name": "Trip", "currency": "USD"}, http.StatusConflict, nil)
+	var house group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "House", "currency": "EUR"}, http.StatusCreated, &house)
+	request(t, asAlice, http.MethodPut, fmt.Sprint("/v1/groups/", house.ID, "/rates"), body{"rates": body{"INR": "0.011"}}, http.StatusOK, nil)
+	request(t, asAlice, http.MethodPut, fmt.Sprint("/v1/groups/", house.ID), body{"name": "House", "currency": "USD"}, http.StatusOK, &house)
+	if house.Currency != "USD" || house.Rates != nil {
+		t.Errorf("house after changing currency = %s with rates %v, want USD without rates", house.Currency, house.Rates)
+	}
+}
+
+// TestMinorUnits checks that amounts have as many decimal places as their
+// currency, and that converted amounts are rounded to the group's currency.
+func TestMinorUnits(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	var tokyo group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Tokyo", "currency": "JPY", "members": []int32{bob.Id}}, http.StatusCreated, &tokyo)
+	tokyoPath := fmt.Sprint("/v1/groups/", tokyo.ID)
+	request(t, asAlice, http.MethodPut, tokyoPath+"/rates", body{"rates": body{"USD": "1000000001"}}, http.StatusBadRequest, nil)
+	request(t, asAlice, http.MethodPut, tokyoPath+"/rates", body{"rates": body{"USD": "150.55", "KWD": "490.123"}}, http.StatusOK, nil)
+
+	// Yen have no decimal places, dinars three
+	split := func(amount, currency string) body {
+		return body{"amount": amount, "currency": currency, "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal"}
+	}
+	request(t, asAlice, http.MethodPost, tokyoPath+"/expenses", split("1500.50", "JPY"), http.StatusBadRequest, nil)
+	request(t, asAlice, http.MethodPost, tokyoPath+"/expenses", split("10.005", "USD"), http.StatusBadRequest, nil)
+	request(t, asAlice, http.MethodPost, tokyoPath+"/expenses", body{"amount": "1501", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Exact", "splitValues": []string{"750.5", "750.5"}}, http.StatusBadRequest, nil)
+	request(t, asAlice, http.MethodPost, tokyoPath+"/expenses", split("1501", "JPY"), http.StatusCreated, nil)
+	var kwd struct{ Amount json.Number }
+	request(t, asAlice, http.MethodPost, tokyoPath+"/expenses", split("1.234", "KWD"), http.StatusCreated, &kwd)
+	if kwd.Amount != "1.234" {
+		t.Errorf("amount = %s, want 1.234", kwd.Amount)
+	}
+
+	// Bob's 0.617 dinars are 302.405891 yen, which round to 302
+	var balances groupBalances
+	request(t, asAlice, http.MethodGet, tokyoPath+"/balances", nil, http.StatusOK, &balances)
+	if balances.Balances[bob.Id] != -1052000 {
+		t.Errorf("Bob's balance = %v, want -750.00 - 302.00 = -1052.00", balances.Balances[bob.Id])
+	}
+
+	request(t, asBob, http.MethodPost, "/v1/payments", body{"payee": alice.Id, "amount": "100.5", "groupId": tokyo.ID}, http.StatusBadRequest, nil)
+	request(t, asBob, http.MethodPost, "/v1/payments", body{"payee": alice.Id, "amount": "100", "groupId": tokyo.ID}, http.StatusCreated, nil)
+	request(t, asAlice, http.MethodGet, tokyoPath+"/balances", nil, http.StatusOK, &balances)
+	if balances.Balances[bob.Id] != -952000 {
+		t.Errorf("Bob's balance after paying 100 = %v, want -952.00", balances.Balances[bob.Id])
+	}
+}
+
+func TestRecurringExpenses(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, _ := signUp(t, e, "Carol")
+	var house group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "House", "members": []int32{bob.Id, carol.Id}}, http.StatusCreated, &house)
+	housePath := fmt.Sprint("/v1/groups/", house.ID)
+
+	request(t, asAlice, http.MethodPost, housePath+"/recurring-expenses", body{"amount": "1200.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal"}, http.StatusBadRequest, nil)
+	request(t, asAlice, http.MethodPost, housePath+"/recurring-expenses", body{"amount": "1200.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal", "rule": "FREQ=HOURLY"}, http.StatusBadRequest, nil)
+
+	// Rent on the 1st of the month, starting next year so that none is due yet
+	start := time.Date(time.Now().Year()+1, time.January, 1, 9, 0, 0, 0, time.UTC)
+	var rent models.RecurringExpense
+	request(t, asAlice, http.MethodPost, housePath+"/recurring-expenses", body{
+		"amount": "1200.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+		"rule": "FREQ=MONTHLY;BYMONTHDAY=1;COUNT=4", "start": start,
+	}, http.StatusCreated, &rent)
+	if rent.Occurrences != 0 || rent.Next == nil || !rent.Next.Equal(start) {
+		t.Fatalf("recurring expense = %+v, want the first rent due %v", rent, start)
+	}
+	rentPath := fmt.Sprint("/v1/recurring-expenses/", rent.ID)
+
+	// Rent missed while the server was down is made with the dates it was
+	// due, and only once however often the scheduler runs
+	now := start.AddDate(0, 2, 3)
+	for i := 0; i < 2; i++ {
+		if err := makeDueExpenses(now); err != nil {
+			t.Fatalf("makeDueExpenses() error = %v", err)
+		}
+	}
+	var expenses struct{ Items []models.Expense }
+	request(t, asBob, http.MethodGet, housePath+"/expenses?sort=timestamp", nil, http.StatusOK, &expenses)
+	if len(expenses.Items) != 3 {
+		t.Fatalf("expenses after catching up = %d, want 3", len(expenses.Items))
+	}
+	for i, expense := range expenses.Items {
+		if want := start.AddDate(0, i, 0); !expense.Timestamp.Equal(want) || expense.Recurring != rent.ID || expense.Amount != 1200000 {
+			t.Errorf("expense %d = %+v, want 1200.00 of rent due %v", i, expense, want)
+		}
+	}
+	if balance := debtLedger.Balance(house.ID, bob.Id); balance != -1800000 {
+		t.Errorf("Bob's balance = %v, want -1800.00", balance)
+	}
+	var made struct{ Items []group.Activity }
+	request(t, asBob, http.MethodGet, housePath+"/activity?subject=expense", nil, http.StatusOK, &made)
+	if len(made.Items) != 3 || made.Items[0].Actor != 0 {
+		t.Errorf("expense activity = %+v, want three made by the server", made.Items)
+	}
+	request(t, asBob, http.MethodGet, rentPath, nil, http.StatusOK, &rent)
+	if rent.Occurrences != 3 || !rent.Next.Equal(start.AddDate(0, 3, 0)) {
+		t.Errorf("recurring expense after catching up = %+v, want three made and the fourth next", rent)
+	}
+
+	// Everyone sharing a recurring expense stays in the group until it changes
+	var cleaning models.RecurringExpense
+	request(t, asAlice, http.MethodPost, housePath+"/recurring-expenses", body{
+		"amount": "40.00", "splitBetween": []int32{alice.Id, carol.Id}, "splitType": "Equal",
+		"rule": "FREQ=WEEKLY;INTERVAL=2", "start": start.AddDate(1, 0, 0),
+	}, http.StatusCreated, &cleaning)
+	var got errorResponse
+	request(t, asAlice, http.MethodDelete, fmt.Sprint(housePath, "/members/", carol.Id), nil, http.StatusConflict, &got)
+	if len(got.Errors) != 1 || !strings.Contains(got.Errors[0].Message, "recurring expense") {
+		t.Errorf("errors = %+v, want Carol blocked by the recurring expense", got.Errors)
+	}
+	var recurring struct{ Items []models.RecurringExpense }
+	request(t, asBob, http.MethodGet, housePath+"/recurring-expenses?sort=-next", nil, http.StatusOK, &recurring)
+	if len(recurring.Items) != 2 || recurring.Items[0].ID != cleaning.ID {
+		t.Errorf("recurring expenses = %+v, want cleaning then rent", recurring.Items)
+	}
+
+	// Only the payer and admins can change a recurring expense. The new
+	// schedule carries on after the rent already made
+	request(t, asBob, http.MethodPut, rentPath, body{"amount": "1300.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal", "rule": "FREQ=MONTHLY"}, http.StatusForbidden, nil)
+	request(t, asAlice, http.MethodPut, rentPath, body{
+		"amount": "1300.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal", "rule": "FREQ=MONTHLY;BYMONTHDAY=15;COUNT=4",
+	}, http.StatusOK, &rent)
+	if want := start.AddDate(0, 2, 14); rent.Occurrences != 3 || rent.Next == nil || !rent.Next.Equal(want) || rent.Template.Amount != 1300000 {
+		t.Errorf("recurring expense after the update = %+v, want 1300.00 due %v", rent, want)
+	}
+
+	// Expenses the journal can't save are taken back out, and stay due
+	request(t, asAlice, http.MethodGet, housePath+"/expenses", nil, http.StatusOK, &expenses)
+	madeBefore := len(expenses.Items)
+	balanceJournal.Load(failingLog{db.Journal})
+	if err := makeDueExpenses(start.AddDate(0, 6, 0)); err != nil {
+		t.Fatalf("makeDueExpenses() error = %v", err)
+	}
+	balanceJournal.Load(db.Journal)
+	request(t, asAlice, http.MethodGet, housePath+"/expenses", nil, http.StatusOK, &expenses)
+	request(t, asBob, http.MethodGet, rentPath, nil, http.StatusOK, &rent)
+	if len(expenses.Items) != madeBefore || rent.Occurrences != 3 || rent.Next == nil {
+		t.Errorf("after failing to post, %d expenses and recurring expense %+v, want %d and the rent still due", len(expenses.Items), rent, madeBefore)
+	}
+
+	if err := makeDueExpenses(start.AddDate(0, 6, 0)); err != nil {
+		t.Fatalf("makeDueExpenses() error = %v", err)
+	}
+	var ended models.RecurringExpense
+	request(t, asBob, http.MethodGet, rentPath, nil, http.StatusOK, &ended)
+	if ended.Occurrences != 4 || ended.Next != nil {
+		t.Errorf("recurring expense after COUNT rents = %+v, want it ended", ended)
+	}
+
+	request(t, asAlice, http.MethodDelete, rentPath, nil, http.StatusNoContent, nil)
+	request(t, asAlice, http.MethodGet, rentPath, nil, http.StatusNotFound, nil)
+	request(t, asAlice, http.MethodGet, housePath+"/expenses", nil, http.StatusOK, &expenses)
+	if len(expenses.Items) != 4 {
+		t.Errorf("expenses after deleting the recurring expense = %d, want the 4 made kept", len(expenses.Items))
+	}
+
+	// A recurring expense that started long ago only makes so many expenses
+	// at once, as the user who added it, and the scheduler makes the rest
+	var gym group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Gym"}, http.StatusCreated, &gym)
+	gymPath := fmt.Sprint("/v1/groups/", gym.ID)
+	var visits models.RecurringExpense
+	request(t, asAlice, http.MethodPost, gymPath+"/recurring-expenses", body{
+		"amount": "5.00", "splitBetween": []int32{alice.Id}, "splitType": "Equal",
+		"rule": "FREQ=DAILY", "start": time.Now().AddDate(-10, 0, 0),
+	}, http.StatusCreated, &visits)
+	if visits.Occurrences != maxOccurrencesPerRun {
+		t.Errorf("recurring expense started long ago = %d made, want %d", visits.Occurrences, maxOccurrencesPerRun)
+	}
+	request(t, asAlice, http.MethodGet, gymPath+"/activity?subject=expense&limit=1", nil, http.StatusOK, &made)
+	if len(made.Items) != 1 || made.Items[0].Actor != alice.Id {
+		t.Errorf("expense activity = %+v, want made by Alice", made.Items)
+	}
+	if err := makeDueExpenses(time.Now()); err != nil {
+		t.Fatalf("makeDueExpenses() error = %v", err)
+	}
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/recurring-expenses/", visits.ID), nil, http.StatusOK, &visits)
+	if visits.Occurrences != 2*maxOccurrencesPerRun {
+		t.Errorf("recurring expense after the scheduler ran = %d made, want %d", visits.Occurrences, 2*maxOccurrencesPerRun)
+	}
+}
+
+func TestCategories(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+	if len(trip.Categories) != len(group.DefaultCategories) {
+		t.Errorf("new group categories = %v, want the defaults", trip.Categories)
+	}
+
+	// Admins set the categories and the rules that assign them
+	rules := body{
+		"categories": []string{"Food", "Transport", "Lodging"},
+		"rules": []body{
+			{"category": "transport", "keywords": []string{"Uber", "taxi"}},
+			{"category": "Lodging", "paidBy": bob.Id},
+		},
+	}
+	request(t, asBob, http.MethodPut, tripPath+"/categories", rules, http.StatusForbidden, nil)
+	var got errorResponse
+	request(t, asAlice, http.MethodPut, tripPath+"/categories", body{
+		"categories": []string{"Food", "food", ""},
+		"rules":      []body{{"category": "Rockets", "keywords": []string{"moon"}}, {"category": "Food"}},
+	}, http.StatusBadRequest, &got)
+	var fields []string
+	for _, err := range got.Errors {
+		fields = append(fields, err.Field)
+	}
+	if want := []string{"categories[1]", "categories[2]", "rules[0].category", "rules[1]"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("error fields = %v, want %v", fields, want)
+	}
+	var categories groupCategories
+	request(t, asAlice, http.MethodPut, tripPath+"/categories", rules, http.StatusOK, &categories)
+	if len(categories.Categories) != 3 || len(categories.Rules) != 2 || categories.Rules[0].Category != "Transport" {
+		t.Errorf("categories = %+v, want three categories and two rules", categories)
+	}
+
+	// Expenses without a category get one from the rules
+	expense := func(as http.Handler, description, category string, tags ...string) models.Expense {
+		t.Helper()
+		var created models.Expense
+		in := body{"description": description, "amount": "10.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal", "tags": tags}
+		if category != "" {
+			in["category"] = category
+		}
+		request(t, as, http.MethodPost, tripPath+"/expenses", in, http.StatusCreated, &created)
+		return created
+	}
+	if taxi := expense(asAlice, "UBER from the airport", "", "Airport", "airport "); taxi.Category != "Transport" || len(taxi.Tags) != 1 || taxi.Tags[0] != "airport" {
+		t.Errorf("taxi = %q tagged %v, want Transport tagged airport", taxi.Category, taxi.Tags)
+	}
+	if hotel := expense(asBob, "Hotel", ""); hotel.Category != "Lodging" {
+		t.Errorf("hotel category = %q, want Lodging from Bob's rule", hotel.Category)
+	}
+	dinner := expense(asBob, "Uber Eats", "food", "airport")
+	if dinner.Category != "Food" {
+		t.Errorf("dinner category = %q, want the Food it was given", dinner.Category)
+	}
+	snacks := expense(asAlice, "Snacks", "")
+	if snacks.Category != "" {
+		t.Errorf("snacks category = %q, want none", snacks.Category)
+	}
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{"amount": "10.00", "splitBetween": []int32{alice.Id}, "splitType": "Equal", "category": "Rockets"}, http.StatusBadRequest, nil)
+
+	// Lists filter by category and tags
+	var expenses struct{ Items []models.Expense }
+	request(t, asBob, http.MethodGet, tripPath+"/expenses?category=TRANSPORT", nil, http.StatusOK, &expenses)
+	if len(expenses.Items) != 1 || expenses.Items[0].Description != "UBER from the airport" {
+		t.Errorf("Transport expenses = %+v, want the taxi", expenses.Items)
+	}
+	request(t, asBob, http.MethodGet, "/v1/expenses?tag=airport", nil, http.StatusOK, &expenses)
+	if len(expenses.Items) != 2 {
+		t.Errorf("expenses tagged airport = %d, want 2", len(expenses.Items))
+	}
+	request(t, asBob, http.MethodGet, "/v1/expenses?tag=airport&category=food", nil, http.StatusOK, &expenses)
+	if len(expenses.Items) != 1 || expenses.Items[0].ID != dinner.ID {
+		t.Errorf("Food expenses tagged airport = %+v, want dinner", expenses.Items)
+	}
+
+	// Patching keeps the labels the body leaves out
+	var patched models.Expense
+	request(t, asBob, http.MethodPatch, fmt.Sprint("/v1/expenses/", dinner.ID), body{"amount": "12.00"}, http.StatusOK, &patched)
+	if patched.Description != "Uber Eats" || patched.Category != "Food" || len(patched.Tags) != 1 {
+		t.Errorf("patched dinner = %+v, want its description, category and tags kept", patched)
+	}
+
+	// Expenses keep a category the group has since removed, but can't be
+	// moved into one
+	rules["categories"] = []string{"Transport", "Lodging"}
+	request(t, asAlice, http.MethodPut, tripPath+"/categories", rules, http.StatusOK, nil)
+	request(t, asBob, http.MethodPatch, fmt.Sprint("/v1/expenses/", dinner.ID), body{"description": "Dinner"}, http.StatusOK, &patched)
+	if patched.Description != "Dinner" || patched.Category != "Food" {
+		t.Errorf("patched dinner = %q in %q, want Dinner still in Food", patched.Description, patched.Category)
+	}
+	request(t, asAlice, http.MethodPatch, fmt.Sprint("/v1/expenses/", snacks.ID), body{"category": "Food"}, http.StatusBadRequest, nil)
+}
+
+// upload sends a file as the "file" field of a multipart form, declared as
+// contentType if it isn't empty, and returns the response.
+func upload(t *testing.T, server http.Handler, target, name, contentType string, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var form bytes.Buffer
+	w := multipart.NewWriter(&form)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, name))
+	if contentType != "" {
+		header.Set(echo.HeaderContentType, contentType)
+	}
+	part, err := w.CreatePart(header)
+	if err != nil {
+		t.Fatalf("creating form: %v", err)
+	}
+	part.Write(content)
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, target, &form)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAttachments(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	var expense models.Expense
+	request(t, asAlice, http.MethodPost, fmt.Sprint("/v1/groups/", trip.ID, "/expenses"), body{
+		"description": "Dinner", "amount": "20.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &expense)
+	expensePath := fmt.Sprint("/v1/expenses/", expense.ID)
+
+	// Only whoever can change the expense attaches files to it, and only
+	// images and PDFs of a limited size
+	receipt := []byte("%PDF-1.4\nreceipt for dinner")
+	if rec := upload(t, asBob, expensePath+"/attachments", "receipt.pdf", "", receipt); rec.Code != http.StatusForbidden {
+		t.Errorf("Bob uploading = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	tests := []struct {
+		name        string
+		fileName    string
+		contentType string
+		content     []byte
+		want        int
+	}{
+		{name: "Text", fileName: "notes.txt", content: []byte("just some notes"), want: http.StatusUnsupportedMediaType},
+		{name: "Mislabelled", fileName: "receipt.png", contentType: "image/png", content: receipt, want: http.StatusBadRequest},
+		{name: "Empty", fileName: "empty.pdf", want: http.StatusBadRequest},
+		{name: "Too Large", fileName: "huge.pdf", content: append([]byte("%PDF-"), make([]byte, maxAttachmentSize)...), want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := upload(t, asAlice, expensePath+"/attachments", tt.fileName, tt.contentType, tt.content); rec.Code != tt.want {
+				t.Errorf("uploading = %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+	request(t, asAlice, http.MethodPost, expensePath+"/attachments", body{"file": "receipt"}, http.StatusUnsupportedMediaType, nil)
+
+	// Files are typed by their content, and uploading one twice keeps one copy
+	rec := upload(t, asAlice, expensePath+"/attachments", `C:\Receipts\dinner.pdf`, "application/pdf", receipt)
+	var attachment models.Attachment
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("uploading = %d %s, want %d", rec.Code, rec.Body, http.StatusCreated)
+	}
+	json.Unmarshal(rec.Body.Bytes(), &attachment)
+	if attachment.Name != "dinner.pdf" || attachment.ContentType != "application/pdf" || attachment.Size != int64(len(receipt)) || attachment.Checksum != blob.Checksum(receipt) || attachment.UploadedBy != alice.Id {
+		t.Errorf("attachment = %+v, want dinner.pdf uploaded by Alice", attachment)
+	}
+	if rec := upload(t, asAlice, expensePath+"/attachments", "again.pdf", "", receipt); rec.Code != http.StatusOK {
+		t.Errorf("uploading again = %d, want %d", rec.Code, http.StatusOK)
+	}
+	request(t, asBob, http.MethodGet, expensePath, nil, http.StatusOK, &expense)
+	if len(expense.Attachments) != 1 || expense.Attachments[0].ID != attachment.ID {
+		t.Errorf("expense attachments = %+v, want just %d", expense.Attachments, attachment.ID)
+	}
+
+	// Members download them
+	attachmentPath := fmt.Sprint(expensePath, "/attachments/", attachment.ID)
+	req := httptest.NewRequest(http.MethodGet, attachmentPath, nil)
+	rec = httptest.NewRecorder()
+	asBob.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != string(receipt) || rec.Header().Get(echo.HeaderContentType) != "application/pdf" {
+		t.Errorf("downloading = %d %q %s, want the receipt", rec.Code, rec.Body, rec.Header().Get(echo.HeaderContentType))
+	}
+	if got := rec.Header().Get(echo.HeaderContentDisposition); got != `attachment; filename=dinner.pdf` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	request(t, asBob, http.MethodGet, fmt.Sprint(expensePath, "/attachments/", attachment.ID+100), nil, http.StatusNotFound, nil)
+
+	// Content lost from the store is missing rather than an error
+	if err := blobs.Delete(attachment.Checksum); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	request(t, asBob, http.MethodGet, attachmentPath, nil, http.StatusNotFound, nil)
+	if err := blobs.Put(attachment.Checksum, receipt); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// An upload that is slow to arrive doesn't hold up other requests
+	slow, send := io.Pipe()
+	form := multipart.NewWriter(send)
+	req = httptest.NewRequest(http.MethodPost, expensePath+"/attachments", slow)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	slowRec := httptest.NewRecorder()
+	sent := make(chan struct{})
+	go func() {
+		asAlice.ServeHTTP(slowRec, req)
+		close(sent)
+	}()
+	part, _ := form.CreateFormFile("file", "tip.pdf") // Returns once the server has started reading
+	others := make(chan struct{})
+	go func() {
+		request(t, asAlice, http.MethodGet, expensePath, nil, http.StatusOK, nil)
+		request(t, asAlice, http.MethodPatch, expensePath, body{"description": "Dinner out"}, http.StatusOK, nil)
+		close(others)
+	}()
+	select {
+	case <-others:
+	case <-time.After(5 * time.Second):
+		t.Fatal("requests were held up by an upload that hadn't arrived")
+	}
+	part.Write([]byte("%PDF-1.4 tip"))
+	form.Close()
+	send.Close()
+	<-sent
+	if slowRec.Code != http.StatusCreated {
+		t.Errorf("slow upload = %d %s, want %d", slowRec.Code, slowRec.Body, http.StatusCreated)
+	}
+
+	// The same file attached to a payment shares its content, which is only
+	// deleted once nothing has it attached
+	type paymentResponse struct {
+		ID          int
+		Attachments []*models.Attachment
+	}
+	var payment paymentResponse
+	request(t, asBob, http.MethodPost, "/v1/payments", body{"payee": alice.Id, "amount": "10.00", "expenses": []int{expense.ID}}, http.StatusCreated, &payment)
+	paymentPath := fmt.Sprint("/v1/payments/", payment.ID)
+	if rec := upload(t, asBob, paymentPath+"/attachments", "proof.pdf", "", receipt); rec.Code != http.StatusCreated {
+		t.Fatalf("uploading proof = %d %s, want %d", rec.Code, rec.Body, http.StatusCreated)
+	}
+	request(t, asBob, http.MethodGet, paymentPath, nil, http.StatusOK, &payment)
+	if len(payment.Attachments) != 1 {
+		t.Fatalf("payment attachments = %+v, want one", payment.Attachments)
+	}
+	request(t, asAlice, http.MethodDelete, attachmentPath, nil, http.StatusNoContent, nil)
+	if _, err := blobs.Get(attachment.Checksum); err != nil {
+		t.Errorf("content after removing it from the expense: %v, want it kept for the payment", err)
+	}
+	request(t, asBob, http.MethodDelete, paymentPath, nil, http.StatusNoContent, nil)
+	if _, err := blobs.Get(attachment.Checksum); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("content after deleting the payment: %v, want %v", err, blob.ErrNotFound)
+	}
+}
+
+func TestComments(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	_, asDave := signUp(t, e, "Dave")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	request(t, asAlice, http.MethodPost, fmt.Sprint("/v1/groups/", trip.ID, "/members"), body{"user": carol.Id, "role": "viewer"}, http.StatusCreated, nil)
+	var expense models.Expense
+	request(t, asBob, http.MethodPost, fmt.Sprint("/v1/groups/", trip.ID, "/expenses"), body{
+		"description": "Jet ski", "amount": "300.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &expense)
+	commentsPath := fmt.Sprint("/v1/expenses/", expense.ID, "/comments")
+
+	// Members comment, viewers and outsiders can't
+	var question, answer models.Comment
+	request(t, asAlice, http.MethodPost, commentsPath, body{"body": "  What was this for?  "}, http.StatusCreated, &question)
+	if question.Author != alice.Id || question.Body != "What was this for?" || question.EditedAt != nil {
+		t.Errorf("comment = %+v, want Alice's question", question)
+	}
+	request(t, asBob, http.MethodPost, commentsPath, body{"body": "The jet ski on Tuesday"}, http.StatusCreated, &answer)
+	request(t, asCarol, http.MethodPost, commentsPath, body{"body": "Hi"}, http.StatusForbidden, nil)
+	request(t, asDave, http.MethodPost, commentsPath, body{"body": "Hi"}, http.StatusNotFound, nil)
+	request(t, asAlice, http.MethodPost, commentsPath, body{"body": " "}, http.StatusBadRequest, nil)
+	request(t, asAlice, http.MethodPost, commentsPath, body{"body": strings.Repeat("a", maxCommentLength+1)}, http.StatusBadRequest, nil)
+
+	var comments struct{ Items []models.Comment }
+	request(t, asCarol, http.MethodGet, commentsPath, nil, http.StatusOK, &comments)
+	if len(comments.Items) != 2 || comments.Items[0].ID != question.ID || comments.Items[1].ID != answer.ID {
+		t.Errorf("comments = %+v, want the question then the answer", comments.Items)
+	}
+	request(t, asCarol, http.MethodGet, fmt.Sprint(commentsPath, "?author=", bob.Id), nil, http.StatusOK, &comments)
+	if len(comments.Items) != 1 || comments.Items[0].ID != answer.ID {
+		t.Errorf("Bob's comments = %+v, want the answer", comments.Items)
+	}
+
+	// Only authors edit their comments, and admins can delete anyone's
+	questionPath := fmt.Sprint(commentsPath, "/", question.ID)
+	answerPath := fmt.Sprint(commentsPath, "/", answer.ID)
+	request(t, asBob, http.MethodPut, questionPath, body{"body": "Changed"}, http.StatusForbidden, nil)
+	var edited models.Comment
+	request(t, asAlice, http.MethodPut, questionPath, body{"body": "What was this for, exactly?"}, http.StatusOK, &edited)
+	if edited.Body != "What was this for, exactly?" || edited.EditedAt == nil {
+		t.Errorf("edited comment = %+v, want the new body and when it was edited", edited)
+	}
+	request(t, asBob, http.MethodDelete, questionPath, nil, http.StatusForbidden, nil)
+	request(t, asAlice, http.MethodDelete, answerPath, nil, http.StatusNoContent, nil)
+	request(t, asAlice, http.MethodDelete, answerPath, nil, http.StatusNotFound, nil)
+
+	// Editing the expense keeps its comments, which also appear in it
+	request(t, asBob, http.MethodPatch, fmt.Sprint("/v1/expenses/", expense.ID), body{"amount": "280.00"}, http.StatusOK, &expense)
+	if len(expense.Comments) != 1 || expense.Comments[0].ID != question.ID {
+		t.Errorf("expense comments = %+v, want the question", expense.Comments)
+	}
+
+	// Payments have threads too
+	var payment struct{ ID int }
+	request(t, asAlice, http.MethodPost, "/v1/payments", body{"payee": bob.Id, "amount": "140.00", "expenses": []int{expense.ID}}, http.StatusCreated, &payment)
+	request(t, asBob, http.MethodPost, fmt.Sprint("/v1/payments/", payment.ID, "/comments"), body{"body": "Thanks!"}, http.StatusCreated, nil)
+	request(t, asAlice, http.MethodGet, fmt.Sprint("/v1/payments/", payment.ID, "/comments"), nil, http.StatusOK, &comments)
+	if len(comments.Items) != 1 || comments.Items[0].Author != bob.Id {
+		t.Errorf("payment comments = %+v, want Bob's thanks", comments.Items)
+	}
+}
+
+func TestActivityFeed(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	carol, asCarol := signUp(t, e, "Carol")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+	request(t, asAlice, http.MethodPost, tripPath+"/members", body{"user": carol.Id}, http.StatusCreated, nil)
+	request(t, asAlice, http.MethodPut, tripPath, body{"name": "Paris Trip"}, http.StatusOK, nil)
+	var expense models.Expense
+	request(t, asBob, http.MethodPost, tripPath+"/expenses", body{
+		"description": "Dinner", "amount": "30.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &expense)
+	expensePath := fmt.Sprint("/v1/expenses/", expense.ID)
+	request(t, asBob, http.MethodPatch, expensePath, body{"amount": "40.00"}, http.StatusOK, nil)
+	request(t, asAlice, http.MethodPost, expensePath+"/comments", body{"body": "Pricey"}, http.StatusCreated, nil)
+	var payment struct{ ID int }
+	request(t, asAlice, http.MethodPost, "/v1/payments", body{"payee": bob.Id, "amount": "20.00", "expenses": []int{expense.ID}}, http.StatusCreated, &payment)
+	request(t, asCarol, http.MethodDelete, fmt.Sprint(tripPath, "/members/", carol.Id), nil, http.StatusNoContent, nil)
+	var lunch models.Expense
+	request(t, asBob, http.MethodPost, tripPath+"/expenses", body{"description": "Lunch", "amount": "10.00", "splitBetween": []int32{bob.Id}, "splitType": "Equal"}, http.StatusCreated, &lunch)
+	request(t, asBob, http.MethodDelete, fmt.Sprint("/v1/expenses/", lunch.ID), nil, http.StatusNoContent, nil)
+
+	// Every change is listed in order with who made it
+	type entry struct {
+		Actor     int32
+		Verb      group.Verb
+		Subject   group.Subject
+		SubjectID int
+	}
+	want := []entry{
+		{alice.Id, group.Created, group.GroupSubject, int(trip.ID)},
+		{alice.Id, group.Joined, group.MemberSubject, int(carol.Id)},
+		{alice.Id, group.Updated, group.GroupSubject, int(trip.ID)},
+		{bob.Id, group.Created, group.ExpenseSubject, expense.ID},
+		{bob.Id, group.Updated, group.ExpenseSubject, expense.ID},
+		{alice.Id, group.Created, group.CommentSubject, 0},
+		{alice.Id, group.Created, group.PaymentSubject, payment.ID},
+		{carol.Id, group.Left, group.MemberSubject, int(carol.Id)},
+		{bob.Id, group.Created, group.ExpenseSubject, lunch.ID},
+		{bob.Id, group.Deleted, group.ExpenseSubject, lunch.ID},
+	}
+	var feed struct{ Items []group.Activity }
+	request(t, asBob, http.MethodGet, tripPath+"/activity", nil, http.StatusOK, &feed)
+	var got []entry
+	for _, a := range feed.Items {
+		if a.Subject == group.CommentSubject {
+			a.SubjectID = 0
+		}
+		got = append(got, entry{a.Actor, a.Verb, a.Subject, a.SubjectID})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("activity = %+v, want %+v", got, want)
+	}
+	if len(feed.Items) > 2 && feed.Items[2].Summary != `Renamed the group from "Trip" to "Paris Trip"` {
+		t.Errorf("rename summary = %q", feed.Items[2].Summary)
+	}
+
+	// The feed filters like other lists, and is hidden from non-members
+	request(t, asBob, http.MethodGet, fmt.Sprint(tripPath, "/activity?subject=expense&actor=", bob.Id), nil, http.StatusOK, &feed)
+	if len(feed.Items) != 4 {
+		t.Errorf("Bob's expense activity = %d entries, want 4", len(feed.Items))
+	}
+	request(t, asBob, http.MethodGet, tripPath+"/activity?sort=-id&limit=1", nil, http.StatusOK, &feed)
+	if len(feed.Items) != 1 || feed.Items[0].Verb != group.Deleted {
+		t.Errorf("latest activity = %+v, want lunch being deleted", feed.Items)
+	}
+	request(t, asCarol, http.MethodGet, tripPath+"/activity", nil, http.StatusNotFound, nil)
+}
+
+func TestAuditLog(t *testing.T) {
+	resetState()
+	admins = parseAdmins(" Admin@Example.com ,")
+	e := newServer()
+
+	admin, asAdmin := signUp(t, e, "Admin")
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, asBob := signUp(t, e, "Bob")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	request(t, asAlice, http.MethodPut, fmt.Sprint("/v1/users/", alice.Id), body{"name": "Alicia"}, http.StatusOK, nil)
+	var expense models.Expense
+	request(t, asAlice, http.MethodPost, fmt.Sprint("/v1/groups/", trip.ID, "/expenses"), body{
+		"amount": "30.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &expense)
+	var payment struct{ ID int }
+	request(t, asBob, http.MethodPost, "/v1/payments", body{"payee": alice.Id, "amount": "10.00", "expenses": []int{expense.ID}}, http.StatusCreated, &payment)
+	request(t, asBob, http.MethodDelete, fmt.Sprint("/v1/payments/", payment.ID), nil, http.StatusNoContent, nil)
+
+	// Every change is logged in order with who made it
+	type change struct {
+		Actor    int32
+		Action   audit.Action
+		Entity   audit.Entity
+		EntityID int
+	}
+	want := []change{
+		{admin.Id, audit.Created, audit.User, int(admin.Id)},
+		{alice.Id, audit.Created, audit.User, int(alice.Id)},
+		{bob.Id, audit.Created, audit.User, int(bob.Id)},
+		{alice.Id, audit.Created, audit.Group, int(trip.ID)},
+		{alice.Id, audit.Updated, audit.User, int(alice.Id)},
+		{alice.Id, audit.Created, audit.Expense, expense.ID},
+		{alice.Id, audit.Updated, audit.Group, int(trip.ID)},
+		{bob.Id, audit.Created, audit.Payment, payment.ID},
+		{bob.Id, audit.Updated, audit.Expense, expense.ID},
+		{bob.Id, audit.Deleted, audit.Payment, payment.ID},
+		{bob.Id, audit.Updated, audit.Expense, expense.ID},
+	}
+	var log struct{ Items []audit.Entry }
+	request(t, asAdmin, http.MethodGet, "/v1/admin/audit", nil, http.StatusOK, &log)
+	var got []change
+	for _, entry := range log.Items {
+		got = append(got, change{entry.Actor, entry.Action, entry.Entity, entry.EntityID})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("audit log = %+v, want %+v", got, want)
+	}
+
+	// Entries hold the saved form before and after the change, without
+	// password hashes
+	rename := log.Items[4]
+	if !strings.Contains(string(rename.Before), `"name":"Alice"`) || !strings.Contains(string(rename.After), `"name":"Alicia"`) {
+		t.Errorf("rename = %s to %s, want Alice to Alicia", rename.Before, rename.After)
+	}
+	if strings.Contains(string(rename.After), "passwordHash") {
+		t.Errorf("rename after = %s, want no password hash", rename.After)
+	}
+	if log.Items[0].Before != nil || log.Items[9].After != nil {
+		t.Errorf("creation before = %s and deletion after = %s, want neither", log.Items[0].Before, log.Items[9].After)
+	}
+	settled := log.Items[8]
+	if !strings.Contains(string(settled.Before), `"remainingAmount":30.00`) || !strings.Contains(string(settled.After), `"remainingAmount":20.00`) {
+		t.Errorf("settled expense = %s to %s, want 30.00 remaining before and 20.00 after", settled.Before, settled.After)
+	}
+
+	// The log filters like other lists, and only administrators can read it
+	request(t, asAdmin, http.MethodGet, fmt.Sprint("/v1/admin/audit?entity=expense&action=updated&actor=", bob.Id), nil, http.StatusOK, &log)
+	if len(log.Items) != 2 {
+		t.Errorf("Bob's expense updates = %d entries, want 2", len(log.Items))
+	}
+	request(t, asAdmin, http.MethodGet, fmt.Sprint("/v1/admin/audit?entityId=", trip.ID, "&entity=group&sort=-id&limit=1"), nil, http.StatusOK, &log)
+	if len(log.Items) != 1 || log.Items[0].Seq != 7 {
+		t.Errorf("latest change to the group = %+v, want entry 7", log.Items)
+	}
+	request(t, asAlice, http.MethodGet, "/v1/admin/audit", nil, http.StatusForbidden, nil)
+	request(t, asAlice, http.MethodGet, "/v1/admin/audit/verify", nil, http.StatusForbidden, nil)
+
+	// The chain verifies until an entry is altered
+	type report struct {
+		Valid    bool
+		Entries  int
+		LastHash string
+		Problem  *audit.ChainError
+	}
+	var verified report
+	request(t, asAdmin, http.MethodGet, "/v1/admin/audit/verify", nil, http.StatusOK, &verified)
+	entries, _ := db.AuditLog.List()
+	if !verified.Valid || verified.Entries != len(want) || verified.LastHash != entries[len(entries)-1].Hash || verified.Problem != nil {
+		t.Errorf("verification = %+v, want %d valid entries", verified, len(want))
+	}
+	entries[4].After = json.RawMessage(`{"id":2,"name":"Mallory"}`)
+	request(t, asAdmin, http.MethodGet, "/v1/admin/audit/verify", nil, http.StatusOK, &verified)
+	if verified.Valid || verified.Problem == nil || verified.Problem.Seq != 5 {
+		t.Errorf("verification after tampering = %+v, want entry 5 reported", verified)
+	}
+}
+
+// failingAuditLog is a store.AuditRepository that lists the entries of the
+// log it wraps but fails to save new ones.
+type failingAuditLog struct{ store.AuditRepository }
+
+func (failingAuditLog) Append(*audit.Entry) error { return errors.New("disk full") }
+
+// TestAuditLog_Failure checks that changes the audit log can't record fail
+// and are undone, and that changes undone because the journal can't post them
+// are logged as undone.
+func TestAuditLog_Failure(t *testing.T) {
+	resetState()
+	e := newServer()
+
+	alice, asAlice := signUp(t, e, "Alice")
+	bob, _ := signUp(t, e, "Bob")
+	var trip group.Group
+	request(t, asAlice, http.MethodPost, "/v1/groups", body{"name": "Trip", "members": []int32{bob.Id}}, http.StatusCreated, &trip)
+	tripPath := fmt.Sprint("/v1/groups/", trip.ID)
+	var dinner models.Expense
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "30.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusCreated, &dinner)
+	commentsPath := fmt.Sprint("/v1/expenses/", dinner.ID, "/comments")
+	before, _ := db.AuditLog.List()
+
+	auditLog := db.AuditLog
+	db.AuditLog = failingAuditLog{auditLog}
+	request(t, asAlice, http.MethodPut, fmt.Sprint("/v1/users/", alice.Id), body{"name": "Alicia"}, http.StatusInternalServerError, nil)
+	request(t, asAlice, http.MethodPut, tripPath, body{"name": "Road Trip"}, http.StatusInternalServerError, nil)
+	request(t, asAlice, http.MethodPut, tripPath+"/rates", body{"rates": body{"EUR": "0.90"}}, http.StatusInternalServerError, nil)
+	request(t, asAlice, http.MethodPost, commentsPath, body{"body": "Pricey"}, http.StatusInternalServerError, nil)
+	db.AuditLog = auditLog
+
+	var me models.User
+	request(t, asAlice, http.MethodGet, "/v1/users/me", nil, http.StatusOK, &me)
+	var got group.Group
+	request(t, asAlice, http.MethodGet, tripPath, nil, http.StatusOK, &got)
+	var comments struct{ Items []models.Comment }
+	request(t, asAlice, http.MethodGet, commentsPath, nil, http.StatusOK, &comments)
+	if me.Name != "Alice" || got.Name != "Trip" || len(got.Rates) != 0 || len(comments.Items) != 0 {
+		t.Errorf("after changes the audit log couldn't record, Alice is %q, the group %q with rates %v and the dinner has %d comments, want none of them changed",
+			me.Name, got.Name, got.Rates, len(comments.Items))
+	}
+	if after, _ := db.AuditLog.List(); len(after) != len(before) {
+		t.Errorf("audit log has %d entries, want still %d", len(after), len(before))
+	}
+
+	// An expense the journal can't post is logged as created and deleted
+	balanceJournal.Load(failingLog{db.Journal})
+	request(t, asAlice, http.MethodPost, tripPath+"/expenses", body{
+		"amount": "10.00", "splitBetween": []int32{alice.Id, bob.Id}, "splitType": "Equal",
+	}, http.StatusInternalServerError, nil)
+	balanceJournal.Load(db.Journal)
+	entries, _ := db.AuditLog.List()
+	var actions []audit.Action
+	for _, entry := range entries[len(before):] {
+		actions = append(actions, entry.Action)
+	}
+	if want := []audit.Action{audit.Created, audit.Updated, audit.Deleted, audit.Updated}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("audit log of the expense that couldn't be posted = %v, want %v", actions, want)
+	}
+	if err := audit.Verify(entries); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
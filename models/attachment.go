@@ -0,0 +1,54 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	attachmentIDCounter int
+	attachmentIDMu      sync.Mutex
+)
+
+func generateAttachmentID() int {
+	attachmentIDMu.Lock()
+	defer attachmentIDMu.Unlock()
+	attachmentIDCounter++
+	return attachmentIDCounter
+}
+
+// ResumeAttachmentIDs makes new attachments number after id, so that those
+// loaded from storage keep their IDs.
+func ResumeAttachmentIDs(id int) {
+	attachmentIDMu.Lock()
+	defer attachmentIDMu.Unlock()
+	if id > attachmentIDCounter {
+		attachmentIDCounter = id
+	}
+}
+
+// Attachment describes a file attached to an expense or payment, such as a
+// photo of a receipt or proof of a bank transfer. The file itself is kept in
+// a blob store under its checksum.
+type Attachment struct {
+	ID          int
+	Name        string // File name it was uploaded with
+	ContentType string
+	Size        int64  // In bytes
+	Checksum    string // Hex encoded SHA-256 of the content
+	UploadedBy  int32  // ID of the user who uploaded it
+	UploadedAt  time.Time
+}
+
+// NewAttachment describes a file the user uploaded just now.
+func NewAttachment(name, contentType string, size int64, checksum string, uploadedBy int32) *Attachment {
+	return &Attachment{
+		ID:          generateAttachmentID(),
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		Checksum:    checksum,
+		UploadedBy:  uploadedBy,
+		UploadedAt:  time.Now(),
+	}
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	commentIDCounter int
+	commentIDMu      sync.Mutex
+)
+
+func generateCommentID() int {
+	commentIDMu.Lock()
+	defer commentIDMu.Unlock()
+	commentIDCounter++
+	return commentIDCounter
+}
+
+// ResumeCommentIDs makes new comments number after id, so that those loaded
+// from storage keep their IDs.
+func ResumeCommentIDs(id int) {
+	commentIDMu.Lock()
+	defer commentIDMu.Unlock()
+	if id > commentIDCounter {
+		commentIDCounter = id
+	}
+}
+
+// Comment is a message in the thread of an expense or payment.
+type Comment struct {
+	ID        int
+	Author    int32 // ID of the user who wrote it
+	Body      string
+	CreatedAt time.Time
+	EditedAt  *time.Time `json:",omitempty"` // When the body was last changed, if it has been
+}
+
+// NewComment returns a comment the user wrote just now.
+func NewComment(author int32, body string) *Comment {
+	return &Comment{ID: generateCommentID(), Author: author, Body: body, CreatedAt: time.Now()}
+}
+
+// Edit replaces the body of the comment.
+func (c *Comment) Edit(body string) {
+	now := time.Now()
+	c.Body = body
+	c.EditedAt = &now
+}
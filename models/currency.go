@@ -0,0 +1,194 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency code such as "INR" or "USD". Amounts in
+// every currency are held as Money, in whole minor units of the currency.
+type Currency string
+
+// DefaultCurrency is the currency of groups that don't name one, and of
+// groups, expenses and payments saved before amounts had a currency.
+const DefaultCurrency Currency = "INR"
+
+// currencies are the ISO 4217 codes of the currencies in circulation.
+var currencies = map[Currency]bool{}
+
+func init() {
+	for _, code := range strings.Fields(`
+		AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND
+		BOB BRL BSD BTN BWP BYN BZD CAD CDF CHF CLP CNY COP CRC CUP CVE CZK DJF
+		DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD GNF GTQ GYD HKD
+		HNL HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW
+		KWD KYD KZT LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR
+		MVR MWK MXN MYR MZN NAD NGN NIO NOK NPR NZD OMR PAB PEN PGK PHP PKR PLN
+		PYG QAR RON RSD RUB RWF SAR SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP STN
+		SVC SYP SZL THB TJS TMT TND TOP TRY TTD TWD TZS UAH UGX USD UYU UZS VES
+		VND VUV WST XAF XCD XOF XPF YER ZAR ZMW ZWL`) {
+		currencies[Currency(code)] = true
+	}
+	for _, code := range strings.Fields(`
+		BIF CLP DJF GNF ISK JPY KMF KRW PYG RWF UGX VND VUV XAF XOF XPF`) {
+		currencyPlaces[Currency(code)] = 0
+	}
+	for _, code := range strings.Fields(`BHD IQD JOD KWD LYD OMR TND`) {
+		currencyPlaces[Currency(code)] = 3
+	}
+}
+
+// currencyPlaces are the ISO 4217 minor units of the currencies that don't
+// have two decimal places.
+var currencyPlaces = map[Currency]int{}
+
+// ParseCurrency returns the currency with the ISO 4217 code s, in either case.
+func ParseCurrency(s string) (Currency, error) {
+	c := Currency(strings.ToUpper(strings.TrimSpace(s)))
+	if !currencies[c] {
+		return "", fmt.Errorf("unknown currency %q", s)
+	}
+	return c, nil
+}
+
+// Places returns the number of decimal places in amounts of the currency, its
+// ISO 4217 minor unit: 0 for JPY, 2 for USD and 3 for KWD. Currencies that
+// aren't known, such as the empty one of amounts saved before amounts had a
+// currency, have two.
+func (c Currency) Places() int {
+	if places, ok := currencyPlaces[c]; ok {
+		return places
+	}
+	return 2
+}
+
+// MinorUnit returns the smallest amount of the currency, such as 0.01 USD.
+func (c Currency) MinorUnit() Money {
+	unit := Money(1)
+	for i := c.Places(); i < moneyPlaces; i++ {
+		unit *= 10
+	}
+	return unit
+}
+
+// ValidateAmount checks that amount is a whole number of the currency's minor
+// units, so that it has no more decimal places than the currency.
+func (c Currency) ValidateAmount(amount Money) error {
+	if amount%c.MinorUnit() != 0 {
+		return fmt.Errorf("%s amounts have at most %d decimal places, got %s", c, c.Places(), amount)
+	}
+	return nil
+}
+
+// Rate is an exchange rate: how much of one currency a unit of another is
+// worth. It is held in fixed point with ratePlaces decimal places, so that
+// converting amounts never involves floating point either.
+type Rate int64
+
+// ratePlaces is the number of decimal places a Rate holds.
+const ratePlaces = 8
+
+// OneRate is the rate between a currency and itself.
+const OneRate Rate = 100_000_000
+
+// maxRate is the largest rate there can be. No currency in circulation is
+// worth anywhere near a billion units of another, and capping rates keeps
+// converted amounts within what Money can hold.
+const maxRate = 1_000_000_000 * OneRate
+
+// ParseRate parses a decimal string such as "83.25" or "0.012" into a Rate.
+// Rates must be greater than zero and at most a billion.
+func ParseRate(s string) (Rate, error) {
+	v, err := parseDecimal(s, ratePlaces)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	if v <= 0 {
+		return 0, fmt.Errorf("invalid rate %q: must be greater than zero", s)
+	}
+	if Rate(v) > maxRate {
+		return 0, fmt.Errorf("invalid rate %q: must be at most %d", s, maxRate/OneRate)
+	}
+	return Rate(v), nil
+}
+
+// String formats the rate with all of its decimal places, e.g. "83.25000000".
+func (r Rate) String() string {
+	return formatDecimal(int64(r), ratePlaces)
+}
+
+// MarshalJSON encodes the rate as a JSON number.
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accepts either a JSON number or a quoted decimal string.
+// Unlike ParseRate, it accepts the zero Rate.
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	v, err := parseDecimal(s, ratePlaces)
+	if err != nil {
+		return fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	*r = Rate(v)
+	return nil
+}
+
+// Convert returns amount converted at the rate into currency, rounded to the
+// nearest minor unit of currency with halves rounded away from zero. The zero
+// Rate leaves amounts as they are, like OneRate, since it is the rate of
+// expenses and payments saved before they had one. Amounts too large for
+// Money once converted are an error.
+func (r Rate) Convert(amount Money, currency Currency) (Money, error) {
+	if r == 0 {
+		return amount, nil
+	}
+	unit := int64(currency.MinorUnit())
+	divisor := new(big.Int).Mul(big.NewInt(int64(OneRate)), big.NewInt(unit))
+	units, err := divideRounded(big.NewInt(int64(amount)), big.NewInt(int64(r)), divisor)
+	if err == nil && (units > math.MaxInt64/unit || units < math.MinInt64/unit) {
+		err = errors.New("value out of range")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("converting %s at %s: %w", amount, r, err)
+	}
+	return Money(units * unit), nil
+}
+
+// Over returns the rate r / d, such as the rate from EUR to INR given the
+// rates from USD to each of them.
+func (r Rate) Over(d Rate) (Rate, error) {
+	if d <= 0 {
+		return 0, errors.New("rates must be greater than zero")
+	}
+	rate, err := divideRounded(big.NewInt(int64(r)), big.NewInt(int64(OneRate)), big.NewInt(int64(d)))
+	if err != nil || Rate(rate) > maxRate {
+		return 0, fmt.Errorf("rate %s / %s is too large to hold", r, d)
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("rate %s / %s is too small to hold", r, d)
+	}
+	return Rate(rate), nil
+}
+
+// divideRounded returns a * b / c rounded to the nearest integer, with halves
+// rounded away from zero. c must be positive. Results that don't fit in an
+// int64 are an error.
+func divideRounded(a, b, c *big.Int) (int64, error) {
+	product := new(big.Int).Mul(a, b)
+	quo, rem := new(big.Int).QuoRem(product, c, new(big.Int))
+	if twice := new(big.Int).Abs(rem); twice.Lsh(twice, 1).Cmp(c) >= 0 {
+		if product.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	if !quo.IsInt64() {
+		return 0, errors.New("value out of range")
+	}
+	return quo.Int64(), nil
+}
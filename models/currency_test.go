@@ -0,0 +1,158 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParseCurrency(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Currency
+		wantErr bool
+	}{
+		{input: "USD", want: "USD"},
+		{input: "eur", want: "EUR"},
+		{input: " inr ", want: "INR"},
+		{input: "BTC", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseCurrency(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCurrency() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseCurrency() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Rate
+		wantErr bool
+	}{
+		{name: "Whole Number", input: "83", want: 8300000000},
+		{name: "Fraction", input: "0.012", want: 1200000},
+		{name: "Eight Decimal Places", input: "0.00000001", want: 1},
+		{name: "Too Many Decimal Places", input: "0.000000001", wantErr: true},
+		{name: "Zero", input: "0", wantErr: true},
+		{name: "Negative", input: "-1.5", wantErr: true},
+		{name: "Largest", input: "1000000000", want: 100000000000000000},
+		{name: "Too Large", input: "1000000000.00000001", wantErr: true},
+		{name: "Out Of Range", input: "100000000000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRate(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRate_JSON(t *testing.T) {
+	data, err := json.Marshal(Rate(8325000000))
+	if err != nil || string(data) != "83.25000000" {
+		t.Fatalf("json.Marshal() = %s, %v, want 83.25000000", data, err)
+	}
+	var got Rate
+	if err := json.Unmarshal([]byte(`"1.08"`), &got); err != nil || got != 108000000 {
+		t.Errorf("json.Unmarshal() = %d, %v, want 108000000", got, err)
+	}
+}
+
+func TestCurrency_MinorUnit(t *testing.T) {
+	tests := []struct {
+		currency Currency
+		places   int
+		unit     Money
+	}{
+		{currency: "USD", places: 2, unit: 10},
+		{currency: "JPY", places: 0, unit: 1000},
+		{currency: "KWD", places: 3, unit: 1},
+		{currency: "", places: 2, unit: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.currency), func(t *testing.T) {
+			if got := tt.currency.Places(); got != tt.places {
+				t.Errorf("Places() = %d, want %d", got, tt.places)
+			}
+			if got := tt.currency.MinorUnit(); got != tt.unit {
+				t.Errorf("MinorUnit() = %d, want %d", got, tt.unit)
+			}
+			if err := tt.currency.ValidateAmount(100 * tt.unit); err != nil {
+				t.Errorf("ValidateAmount(%s) error = %v", 100*tt.unit, err)
+			}
+			if tt.unit > 1 {
+				if err := tt.currency.ValidateAmount(100*tt.unit + 1); err == nil {
+					t.Errorf("ValidateAmount(%s) error = nil, want an error", 100*tt.unit+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRate_Convert(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     Rate
+		amount   Money
+		currency Currency
+		want     Money
+		wantErr  bool
+	}{
+		{name: "One", rate: OneRate, amount: 12340, currency: "INR", want: 12340},
+		{name: "Zero Rate Leaves Amounts Alone", rate: 0, amount: 12340, currency: "INR", want: 12340},
+		{name: "Exact", rate: 8325000000, amount: 15000, currency: "INR", want: 1248750},
+		{name: "Rounds Down", rate: 1234567, amount: 100000, currency: "INR", want: 1230},
+		{name: "Rounds Half Away From Zero", rate: 50000000, amount: 10, currency: "INR", want: 10},
+		{name: "Rounds Negative Half Away From Zero", rate: 50000000, amount: -10, currency: "INR", want: -10},
+		{name: "Rounds To Whole Yen", rate: 150000000, amount: 10010, currency: "JPY", want: 15000},
+		{name: "Rounds To Fils", rate: 30712345, amount: 10000, currency: "KWD", want: 3071},
+		{name: "Too Large", rate: 2 * OneRate, amount: math.MaxInt64 / 2, currency: "INR", wantErr: true},
+		{name: "Too Large Once Rounded", rate: OneRate, amount: math.MaxInt64 - 7, currency: "JPY", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rate.Convert(tt.amount, tt.currency)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rate(%s).Convert(%s, %s) error = %v, wantErr %v", tt.rate, tt.amount, tt.currency, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Rate(%s).Convert(%s, %s) = %s, want %s", tt.rate, tt.amount, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRate_Over(t *testing.T) {
+	// With a dollar buying 83.25 rupees or 0.925 euros, a euro buys 90 rupees
+	got, err := Rate(8325000000).Over(92500000)
+	if err != nil || got != 9000000000 {
+		t.Errorf("Over() = %s, %v, want 90.00000000", got, err)
+	}
+	if _, err := Rate(1).Over(OneRate * 1000); err == nil {
+		t.Error("Over() of a rate too small to hold error = nil, want an error")
+	}
+	if _, err := maxRate.Over(1); err == nil {
+		t.Error("Over() of a rate too large to hold error = nil, want an error")
+	}
+	if _, err := OneRate.Over(0); err == nil {
+		t.Error("Over(0) error = nil, want an error")
+	}
+}
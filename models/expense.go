@@ -2,6 +2,7 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"sync"
 	"time"
@@ -14,39 +15,69 @@ func generateExpenseID() int32 {
 	return expenseIDCounter
 }
 
+// ResumeExpenseIDs makes new expenses number after id, so that expenses loaded
+// from storage keep their IDs.
+func ResumeExpenseIDs(id int32) {
+	expenseIDMuLock.Lock()
+	defer expenseIDMuLock.Unlock()
+	if id > expenseIDCounter {
+		expenseIDCounter = id
+	}
+}
+
 var (
 	expenseIDCounter int32
 	expenseIDMuLock  sync.Mutex
 	expenseMu        sync.Mutex // to ensure thread safety if accessed by multiple goroutines
 )
 
+// ErrExpenseSettled is returned when editing or deleting an expense that has
+// already been partially settled by a payment.
+var ErrExpenseSettled = errors.New("expense has already been partially settled")
+
 // Expense struct represents an expense that needs to be settled.
 type Expense struct {
-	ID              int
-	Amount          float64
-	PaidBy          *User
-	SplitBetween    []*User
-	SplitRate       []float32
-	RemainingAmount float64 // This field will track how much is left to be settled
-	Payments        []*Payment
-	Timestamp       time.Time
+	ID               int
+	Description      string
+	Category         string   `json:",omitempty"` // One of its group's categories
+	Tags             []string `json:",omitempty"` // Free-form labels, lower case
+	Amount           Money
+	Currency         Currency // Currency of Amount and of every other amount of the expense
+	Rate             Rate     // Worth of one unit of Currency in its group's currency when the expense was made
+	PaidBy           *User
+	SplitBetween     []*User
+	SplitType        SplitType   // How SplitRate and SplitAdjustments are interpreted
+	SplitRate        []int64     // Integer split values, one per SplitBetween user
+	SplitAdjustments []Money     // Per user adjustments, only used by the Adjustment split type
+	Items            []*LineItem // Receipt line items, only used by the Itemized split type
+	Charges          []*Charge   // Tax, tip and service charges spread across Items
+	RemainingAmount  Money       // This field will track how much is left to be settled
+	Payments         []*Payment
+	Timestamp        time.Time
+	Rounding         RoundingPolicy // How leftover minor units are assigned when shares don't divide evenly
+	RoundingSeed     int64          // Seed used by the Random rounding policy
+	Recurring        int            `json:",omitempty"` // ID of the recurring expense it was made from, if any
+	Attachments      []*Attachment  `json:",omitempty"` // Receipts and other files attached to it
+	Comments         []*Comment     `json:",omitempty"` // The discussion of the expense, oldest first
 }
 
 // NewExpense creates a new Expense instance with RemainingAmount initialized.
-func NewExpense(amount float64, paidBy *User, splitBetween []*User, splitRate []float32) *Expense {
+func NewExpense(amount Money, paidBy *User, splitBetween []*User, splitRate []int64) *Expense {
 	return &Expense{
 		ID:              int(generateExpenseID()), // You might need to implement generateExpenseID()
 		Amount:          amount,
 		PaidBy:          paidBy,
 		SplitBetween:    splitBetween,
+		SplitType:       SplitShares,
 		SplitRate:       splitRate,
 		RemainingAmount: amount, // Initialize RemainingAmount to the full amount
 		Timestamp:       time.Now(),
+		Rounding:        RoundLargestRemainder,
 	}
 }
 
-func NewEqualExpense(amount float64, paidBy *User, splitBetween []*User) (*Expense, error) {
-	splitRate := make([]float32, len(splitBetween))
+func NewEqualExpense(amount Money, paidBy *User, splitBetween []*User) (*Expense, error) {
+	splitRate := make([]int64, len(splitBetween))
 	if amount <= 0 {
 		return nil, errors.New("amount must be positive")
 	}
@@ -60,7 +91,7 @@ func NewEqualExpense(amount float64, paidBy *User, splitBetween []*User) (*Expen
 		return nil, errors.New("splitRate length must be equal to splitBetween")
 	}
 	for i := range splitRate {
-		splitRate[i] = 1.0 // Default equal rate
+		splitRate[i] = 1 // Default equal rate
 	}
 
 	expenseMu.Lock()
@@ -73,16 +104,181 @@ func NewEqualExpense(amount float64, paidBy *User, splitBetween []*User) (*Expen
 		Amount:          amount,
 		PaidBy:          paidBy,
 		SplitBetween:    splitBetween,
+		SplitType:       SplitEqual,
 		SplitRate:       splitRate,
 		RemainingAmount: amount,
+		Rounding:        RoundLargestRemainder,
 	}, nil
 }
 
+// IsPartiallySettled reports whether any payment has been made against the expense.
+func (e *Expense) IsPartiallySettled() bool {
+	return len(e.Payments) > 0 || e.RemainingAmount != e.Amount
+}
+
+// Update replaces the details of the expense with those of updated, keeping the
+// ID and Timestamp so that groups and payments still refer to it, and keeping
+// its attachments and comments. Only expenses that haven't been partially settled can be
+// updated.
+func (e *Expense) Update(updated *Expense) error {
+	if e.IsPartiallySettled() {
+		return ErrExpenseSettled
+	}
+	id, timestamp, recurring, attachments, comments := e.ID, e.Timestamp, e.Recurring, e.Attachments, e.Comments
+	*e = *updated
+	e.ID = id
+	e.Timestamp = timestamp
+	e.Recurring = recurring
+	e.Attachments = attachments
+	e.Comments = comments
+	e.RemainingAmount = e.Amount
+	e.Payments = nil
+	return nil
+}
+
+// Copy returns a copy of the expense's details that shares no slices or line
+// items with it. Payments, attachments and comments aren't copied, since they
+// belong to e and not the copy.
+func (e *Expense) Copy() *Expense {
+	c := *e
+	c.Tags = append([]string(nil), e.Tags...)
+	c.SplitBetween = append([]*User(nil), e.SplitBetween...)
+	c.SplitRate = append([]int64(nil), e.SplitRate...)
+	c.SplitAdjustments = append([]Money(nil), e.SplitAdjustments...)
+	c.Items = nil
+	for _, item := range e.Items {
+		c.Items = append(c.Items, &LineItem{Description: item.Description, Amount: item.Amount, Consumers: append([]*User(nil), item.Consumers...)})
+	}
+	c.Charges = nil
+	for _, charge := range e.Charges {
+		copied := *charge
+		c.Charges = append(c.Charges, &copied)
+	}
+	c.Payments = nil
+	c.Attachments = nil
+	c.Comments = nil
+	return &c
+}
+
+// Outstanding returns how much of the user's share of the expense they still
+// owe its payer: their share less whatever payments have already been
+// allocated to the expense on their behalf. The payer owes nothing.
+func (e *Expense) Outstanding(user *User) (Money, error) {
+	if e.PaidBy != nil && e.PaidBy.Id == user.Id {
+		return 0, nil
+	}
+	shares, err := e.Shares()
+	if err != nil {
+		return 0, err
+	}
+
+	outstanding := Money(0)
+	shared := false
+	for i, each := range e.SplitBetween {
+		if each.Id == user.Id {
+			outstanding += shares[i]
+			shared = true
+		}
+	}
+	if !shared {
+		return 0, fmt.Errorf("%s does not share the expense", user.Name)
+	}
+
+	for _, payment := range e.Payments {
+		if payment.Payer.Id != user.Id {
+			continue
+		}
+		for _, allocation := range payment.Allocations {
+			if allocation.Expense == e.ID {
+				outstanding -= allocation.Amount
+			}
+		}
+	}
+	return outstanding, nil
+}
+
+// IsSettled reports whether everyone sharing the expense has paid their share
+// to its payer. RemainingAmount alone can't tell, since it still includes the
+// payer's own share.
+func (e *Expense) IsSettled() bool {
+	for _, user := range e.SplitBetween {
+		outstanding, err := e.Outstanding(user)
+		if err != nil || outstanding > 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func PrintExpenseInfo(e Expense) string {
-	return "ID: " + strconv.Itoa(e.ID) + " Amount: " + strconv.FormatFloat(e.Amount, 'f', -1, 64) + "Paid By: " + e.PaidBy.Name + " " + strconv.FormatFloat(e.PaidBy.Balance, 'f', -1, 64) + " Remaining Amount: " + strconv.FormatFloat(e.RemainingAmount, 'f', -1, 64) + "\n"
+	return "ID: " + strconv.Itoa(e.ID) + " Amount: " + e.Amount.String() + "Paid By: " + e.PaidBy.Name + " " + e.PaidBy.Balance.String() + " Remaining Amount: " + e.RemainingAmount.String() + "\n"
 
 }
 
+// ParseSplitRates parses decimal split weights such as "1", "0.5" or "2.25"
+// into exact integer weights. The weights are scaled to whole numbers and then
+// reduced by their common divisor, so "0.5,1.5" becomes [1 3].
+func ParseSplitRates(values []string) ([]int64, error) {
+	rates := make([]int64, len(values))
+	var divisor int64
+	for i, value := range values {
+		rate, err := parseDecimal(value, 4)
+		if err != nil {
+			return nil, fmt.Errorf("invalid split rate %q: %w", value, err)
+		}
+		if rate < 0 {
+			return nil, fmt.Errorf("invalid split rate %q: rates cannot be negative", value)
+		}
+		rates[i] = rate
+		divisor = gcd(divisor, rate)
+	}
+	if divisor > 1 {
+		for i := range rates {
+			rates[i] /= divisor
+		}
+	}
+	return rates, nil
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Shares returns the exact amount each SplitBetween user owes for this expense,
+// in the same order as SplitBetween. Leftover minor units are assigned by the
+// expense's Rounding policy, so the shares always add up to Amount.
+func (e *Expense) Shares() ([]Money, error) {
+	if len(e.SplitRate) != len(e.SplitBetween) {
+		return nil, errors.New("splitRate length must be equal to splitBetween")
+	}
+	if e.splitType() != SplitAdjustment {
+		return e.allocate(e.Amount, e.SplitRate, e.SplitBetween)
+	}
+
+	// Adjustments are charged first and the rest is divided by SplitRate
+	if len(e.SplitAdjustments) != len(e.SplitBetween) {
+		return nil, errors.New("splitAdjustments length must be equal to splitBetween")
+	}
+	remaining := e.Amount
+	for _, adjustment := range e.SplitAdjustments {
+		remaining -= adjustment
+	}
+	shares, err := e.allocate(remaining, e.SplitRate, e.SplitBetween)
+	if err != nil {
+		return nil, err
+	}
+	for i := range shares {
+		shares[i] += e.SplitAdjustments[i]
+	}
+	return shares, nil
+}
+
+// SplitExpense applies the expense directly to the balances of the users who
+// share it. The server posts expenses to the journal instead, which keeps
+// balances as a projection; SplitExpense is for callers without a journal.
 func (e *Expense) SplitExpense() error {
 	// Handle the case where PaidBy is nil; no operation should be performed
 	if e.PaidBy == nil {
@@ -93,10 +289,10 @@ func (e *Expense) SplitExpense() error {
 		return nil // No change since amount is 0
 	}
 
-	totalSplitRate := 0.0
+	totalSplitRate := int64(0)
 	// Calculate the total split rate
 	for _, rate := range e.SplitRate {
-		totalSplitRate += float64(rate)
+		totalSplitRate += rate
 	}
 
 	// Check for division by zero
@@ -104,33 +300,29 @@ func (e *Expense) SplitExpense() error {
 		return nil // No rates set, nothing to split
 	}
 
-	// Create a map to keep track of balances for each user
-	userBalances := make(map[int32]float64)
-	for _, user := range e.SplitBetween {
-		userBalances[user.Id] = 0
-	}
-	if e.PaidBy != nil {
-		userBalances[e.PaidBy.Id] = e.PaidBy.Balance
+	shares, err := e.Shares()
+	if err != nil {
+		return err
 	}
 
-	// Deduct the shares from all users including the PaidBy user
+	// Work out the net change per user ID so that a payer who also appears in
+	// SplitBetween is only updated once. Shares add up to Amount exactly, so the
+	// changes always sum to zero.
+	deltas := make(map[int32]Money)
 	for i, user := range e.SplitBetween {
-		splitAmount := (float64(e.SplitRate[i]) / totalSplitRate) * e.Amount
-		userBalances[user.Id] -= splitAmount
-	}
-
-	// Update the balance of the PaidBy user by adding the total amount
-	if e.PaidBy != nil {
-		userBalances[e.PaidBy.Id] += e.Amount
+		deltas[user.Id] -= shares[i]
 	}
+	deltas[e.PaidBy.Id] += e.Amount
 
 	// Update the balances in the users
+	balances := map[int32]Money{e.PaidBy.Id: e.PaidBy.Balance + deltas[e.PaidBy.Id]}
 	for _, user := range e.SplitBetween {
-		user.Balance = userBalances[user.Id]
-	}
-	if e.PaidBy != nil {
-		e.PaidBy.Balance = userBalances[e.PaidBy.Id]
+		if _, ok := balances[user.Id]; !ok {
+			balances[user.Id] = user.Balance + deltas[user.Id]
+		}
+		user.Balance = balances[user.Id]
 	}
+	e.PaidBy.Balance = balances[e.PaidBy.Id]
 
 	return nil
 }
@@ -4,14 +4,15 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 )
 
 func TestExpense_SplitExpense(t *testing.T) {
 	type fields struct {
-		Amount       float64
+		Amount       Money
 		PaidBy       *User
 		SplitBetween []*User
-		SplitRate    []float32
+		SplitRate    []int64
 	}
 
 	tests := []struct {
@@ -20,129 +21,129 @@ func TestExpense_SplitExpense(t *testing.T) {
 		want   []struct {
 			Id      int32
 			Name    string
-			Balance float64
+			Balance Money
 		} // Expected balances of users after splitting
 	}{
 		{
 			name: "Split between A, B, C with A paying",
 			fields: fields{
-				Amount:       300,
+				Amount:       3000,
 				PaidBy:       &User{Id: 1, Name: "A", Balance: 0},                                                                     // User A
 				SplitBetween: []*User{{Id: 1, Name: "A", Balance: 0}, {Id: 2, Name: "B", Balance: 0}, {Id: 3, Name: "C", Balance: 0}}, // Users A, B, C
-				SplitRate:    []float32{1, 1, 1},
+				SplitRate:    []int64{1, 1, 1},
 			},
 			want: []struct {
 				Id      int32
 				Name    string
-				Balance float64
+				Balance Money
 			}{
-				{Id: 1, Name: "A", Balance: 200}, // A pays 300, split equally between A, B, C
-				{Id: 2, Name: "B", Balance: -100},
-				{Id: 3, Name: "C", Balance: -100},
+				{Id: 1, Name: "A", Balance: 2000}, // A pays 300, split equally between A, B, C
+				{Id: 2, Name: "B", Balance: -1000},
+				{Id: 3, Name: "C", Balance: -1000},
 			},
 		},
 		{
 			name: "Valid Equal Split",
 			fields: fields{
-				Amount:       100,
+				Amount:       1000,
 				PaidBy:       &User{Id: 1, Name: "A", Balance: 0},                                     // User A
 				SplitBetween: []*User{{Id: 1, Name: "A", Balance: 0}, {Id: 2, Name: "B", Balance: 0}}, // Users A, B
-				SplitRate:    []float32{1, 1},
+				SplitRate:    []int64{1, 1},
 			},
 			want: []struct {
 				Id      int32
 				Name    string
-				Balance float64
+				Balance Money
 			}{
-				{Id: 1, Name: "A", Balance: 50}, // Split equally
-				{Id: 2, Name: "B", Balance: -50},
+				{Id: 1, Name: "A", Balance: 500}, // Split equally
+				{Id: 2, Name: "B", Balance: -500},
 			},
 		},
 		{
 			name: "Valid Unequal Split",
 			fields: fields{
-				Amount:       150,
+				Amount:       1500,
 				PaidBy:       &User{Id: 1, Name: "A", Balance: 0},                                     // User A
 				SplitBetween: []*User{{Id: 2, Name: "B", Balance: 0}, {Id: 3, Name: "C", Balance: 0}}, // Users B, C
-				SplitRate:    []float32{1, 2},
+				SplitRate:    []int64{1, 2},
 			},
 			want: []struct {
 				Id      int32
 				Name    string
-				Balance float64
+				Balance Money
 			}{
-				{Id: 2, Name: "B", Balance: -50},
-				{Id: 3, Name: "C", Balance: -100},
-				{Id: 1, Name: "A", Balance: 150}, // Split unequally
+				{Id: 2, Name: "B", Balance: -500},
+				{Id: 3, Name: "C", Balance: -1000},
+				{Id: 1, Name: "A", Balance: 1500}, // Split unequally
 			},
 		},
 		{
 			name: "Amount Zero",
 			fields: fields{
 				Amount:       0,
-				PaidBy:       &User{Id: 1, Name: "A", Balance: 100},                                     // User A
-				SplitBetween: []*User{{Id: 2, Name: "B", Balance: 50}, {Id: 3, Name: "C", Balance: 50}}, // Users B, C
-				SplitRate:    []float32{1, 1},
+				PaidBy:       &User{Id: 1, Name: "A", Balance: 1000},                                      // User A
+				SplitBetween: []*User{{Id: 2, Name: "B", Balance: 500}, {Id: 3, Name: "C", Balance: 500}}, // Users B, C
+				SplitRate:    []int64{1, 1},
 			},
 			want: []struct {
 				Id      int32
 				Name    string
-				Balance float64
+				Balance Money
 			}{
-				{Id: 1, Name: "A", Balance: 100}, // No change since amount is 0
-				{Id: 2, Name: "B", Balance: 50},
-				{Id: 3, Name: "C", Balance: 50},
+				{Id: 1, Name: "A", Balance: 1000}, // No change since amount is 0
+				{Id: 2, Name: "B", Balance: 500},
+				{Id: 3, Name: "C", Balance: 500},
 			},
 		},
 		{
 			name: "Nil PaidBy User",
 			fields: fields{
-				Amount:       100,
-				PaidBy:       nil,                                                                       // No payer
-				SplitBetween: []*User{{Id: 2, Name: "B", Balance: 50}, {Id: 3, Name: "C", Balance: 50}}, // Users B, C
-				SplitRate:    []float32{1, 1},
+				Amount:       1000,
+				PaidBy:       nil,                                                                         // No payer
+				SplitBetween: []*User{{Id: 2, Name: "B", Balance: 500}, {Id: 3, Name: "C", Balance: 500}}, // Users B, C
+				SplitRate:    []int64{1, 1},
 			},
 			want: []struct {
 				Id      int32
 				Name    string
-				Balance float64
+				Balance Money
 			}{
-				{Id: 2, Name: "B", Balance: 50}, // No change since PaidBy is nil
-				{Id: 3, Name: "C", Balance: 50},
+				{Id: 2, Name: "B", Balance: 500}, // No change since PaidBy is nil
+				{Id: 3, Name: "C", Balance: 500},
 			},
 		},
 		{
 			name: "Empty SplitBetween",
 			fields: fields{
-				Amount:       100,
-				PaidBy:       &User{Id: 1, Name: "A", Balance: 100}, // User A
-				SplitBetween: []*User{},                             // No users to split
-				SplitRate:    []float32{},
+				Amount:       1000,
+				PaidBy:       &User{Id: 1, Name: "A", Balance: 1000}, // User A
+				SplitBetween: []*User{},                              // No users to split
+				SplitRate:    []int64{},
 			},
 			want: []struct {
 				Id      int32
 				Name    string
-				Balance float64
+				Balance Money
 			}{
-				{Id: 1, Name: "A", Balance: 100}, // No change since no users to split between
+				{Id: 1, Name: "A", Balance: 1000}, // No change since no users to split between
 			},
 		},
 		{
 			name: "Different Split Rates",
 			fields: fields{
-				Amount:       300,
+				Amount:       3000,
 				PaidBy:       &User{Id: 1, Name: "A", Balance: 0},                                     // User A
 				SplitBetween: []*User{{Id: 2, Name: "B", Balance: 0}, {Id: 3, Name: "C", Balance: 0}}, // Users B, C
-				SplitRate:    []float32{1, 2},                                                         // B's share is half of C's
+				SplitRate:    []int64{1, 2},                                                           // B's share is half of C's
 			},
 			want: []struct {
 				Id      int32
 				Name    string
-				Balance float64
+				Balance Money
 			}{
-				{Id: 2, Name: "B", Balance: -100},
-				{Id: 3, Name: "C", Balance: -200},
-				{Id: 1, Name: "A", Balance: 300}, // B pays 100, C pays 200, A receives 300
+				{Id: 2, Name: "B", Balance: -1000},
+				{Id: 3, Name: "C", Balance: -2000},
+				{Id: 1, Name: "A", Balance: 3000}, // B pays 100, C pays 200, A receives 300
 				// A should only be present once with the final balance
 			},
 		},
@@ -162,27 +163,27 @@ func TestExpense_SplitExpense(t *testing.T) {
 			got := []struct {
 				Id      int32
 				Name    string
-				Balance float64
+				Balance Money
 			}{}
 
 			userMap := make(map[int32]struct {
 				Id      int32
 				Name    string
-				Balance float64
+				Balance Money
 			})
 
 			if e.PaidBy != nil {
 				userMap[e.PaidBy.Id] = struct {
 					Id      int32
 					Name    string
-					Balance float64
+					Balance Money
 				}{Id: e.PaidBy.Id, Name: e.PaidBy.Name, Balance: e.PaidBy.Balance}
 			}
 			for _, user := range e.SplitBetween {
 				userMap[user.Id] = struct {
 					Id      int32
 					Name    string
-					Balance float64
+					Balance Money
 				}{Id: user.Id, Name: user.Name, Balance: user.Balance}
 			}
 
@@ -204,3 +205,106 @@ func TestExpense_SplitExpense(t *testing.T) {
 		})
 	}
 }
+
+func TestExpense_SplitExpense_BalancesSumToZero(t *testing.T) {
+	a := &User{Id: 1, Name: "A"}
+	b := &User{Id: 2, Name: "B"}
+	c := &User{Id: 3, Name: "C"}
+	members := []*User{a, b, c}
+
+	for i := 0; i < 50; i++ {
+		e := &Expense{
+			Amount:       Money(100000 + 10*i),
+			PaidBy:       members[i%3],
+			SplitBetween: members,
+			SplitRate:    []int64{1, int64(i%4 + 1), 3},
+		}
+		if err := e.SplitExpense(); err != nil {
+			t.Fatalf("SplitExpense() error = %v", err)
+		}
+	}
+
+	if total := a.Balance + b.Balance + c.Balance; total != 0 {
+		t.Errorf("balances sum to %s, want 0.00", total)
+	}
+}
+
+func TestExpense_Update(t *testing.T) {
+	a := &User{Id: 1, Name: "A"}
+	b := &User{Id: 2, Name: "B"}
+	timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		payments []*Payment
+		wantErr  bool
+	}{
+		{name: "Unsettled", payments: nil},
+		{name: "Partially Settled", payments: []*Payment{{ID: 1, Payer: b, Payee: a, Amount: 5000}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt := &Attachment{ID: 1, Name: "receipt.pdf"}
+			comment := &Comment{ID: 1, Author: a.Id, Body: "Why so much?"}
+			e := &Expense{ID: 7, Amount: 10000, PaidBy: a, SplitBetween: []*User{a, b}, SplitRate: []int64{1, 1}, RemainingAmount: 10000, Payments: tt.payments, Timestamp: timestamp, Attachments: []*Attachment{receipt}, Comments: []*Comment{comment}}
+			updated := &Expense{ID: 8, Amount: 30000, PaidBy: b, SplitBetween: []*User{a, b}, SplitType: SplitExact, SplitRate: []int64{10000, 20000}, Timestamp: time.Now()}
+
+			err := e.Update(updated)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if e.Amount != 10000 || e.PaidBy != a {
+					t.Errorf("Update() changed a settled expense: %v", e)
+				}
+				return
+			}
+			if e.ID != 7 || !e.Timestamp.Equal(timestamp) {
+				t.Errorf("Update() changed ID or Timestamp to %d, %v", e.ID, e.Timestamp)
+			}
+			if len(e.Attachments) != 1 || e.Attachments[0] != receipt {
+				t.Errorf("Update() changed Attachments to %v, want the receipt kept", e.Attachments)
+			}
+			if len(e.Comments) != 1 || e.Comments[0] != comment {
+				t.Errorf("Update() changed Comments to %v, want the comment kept", e.Comments)
+			}
+			if e.Amount != 30000 || e.RemainingAmount != 30000 || e.PaidBy != b || e.SplitType != SplitExact {
+				t.Errorf("Update() = %v, want the updated details", e)
+			}
+			if got := e.SplitValues(); !reflect.DeepEqual(got, []string{"10.00", "20.00"}) {
+				t.Errorf("SplitValues() = %v, want [10.00 20.00]", got)
+			}
+		})
+	}
+}
+
+func TestExpense_IsSettled(t *testing.T) {
+	a := &User{Id: 1, Name: "A"}
+	b := &User{Id: 2, Name: "B"}
+	c := &User{Id: 3, Name: "C"}
+
+	tests := []struct {
+		name        string
+		allocations map[*User]Money // Paid towards the expense by each user
+		want        bool
+	}{
+		{name: "Unsettled", want: false},
+		{name: "Partially Settled", allocations: map[*User]Money{b: 4000, c: 1000}, want: false},
+		{name: "One Share Settled", allocations: map[*User]Money{b: 4000}, want: false},
+		{name: "Settled", allocations: map[*User]Money{b: 4000, c: 4000}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Expense{ID: 1, Amount: 12000, PaidBy: a, SplitBetween: []*User{a, b, c}, SplitRate: []int64{1, 1, 1}, RemainingAmount: 12000}
+			for user, amount := range tt.allocations {
+				payment := &Payment{Payer: user, Payee: a, Amount: amount, Expenses: []*Expense{e}}
+				payment.ApplySettlement([]Allocation{{Expense: e.ID, Amount: amount}})
+			}
+			if got := e.IsSettled(); got != tt.want {
+				t.Errorf("IsSettled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact monetary amount held in integer thousandths of a unit of
+// its currency, the smallest ISO 4217 minor unit there is (the fils of KWD).
+// Amounts in other currencies are whole multiples of their own minor unit, see
+// Currency.MinorUnit. Floating point values are never used for amounts, so
+// splitting and settling cannot drift by fractions of a unit.
+type Money int64
+
+// moneyPlaces is the number of decimal places Money holds.
+const moneyPlaces = 3
+
+// MaxMoney is the largest amount ParseMoney accepts, a trillion units. It
+// leaves room for sums of thousands of amounts to fit in Money.
+const MaxMoney Money = 1_000_000_000_000 * 1000
+
+// ParseMoney parses a decimal string such as "12", "12.5" or "-0.05" into Money.
+// More than three decimal places is rejected rather than silently rounded, as
+// are amounts larger than MaxMoney either way.
+func ParseMoney(s string) (Money, error) {
+	v, err := parseDecimal(s, moneyPlaces)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	if Money(v) > MaxMoney || Money(v) < -MaxMoney {
+		return 0, fmt.Errorf("invalid amount %q: must be at most %s either way", s, MaxMoney)
+	}
+	return Money(v), nil
+}
+
+// String formats the amount with two decimal places, or three if it has a
+// third, e.g. "12.50" or "1.234".
+func (m Money) String() string {
+	return strings.TrimSuffix(formatDecimal(int64(m), moneyPlaces), "0")
+}
+
+// MarshalJSON encodes Money as a JSON number formatted like String.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts either a JSON number or a quoted decimal string.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Allocate divides m, an amount in currency, across the given relative weights
+// using the largest remainder method, so the shares always add up to exactly
+// m. Every share is a whole number of the currency's minor units. Ties are
+// broken by position, which keeps the result deterministic.
+func (m Money) Allocate(currency Currency, weights []int64) ([]Money, error) {
+	return allocate(m, currency, weights, largestRemainder)
+}
+
+// allocateFloor returns the truncated proportional shares of m along with the
+// remainder of each division, which callers use to place the leftover units.
+func (m Money) allocateFloor(weights []int64) ([]Money, []int64, error) {
+	if len(weights) == 0 {
+		return nil, nil, errors.New("no weights to allocate across")
+	}
+	total := new(big.Int)
+	for _, w := range weights {
+		if w < 0 {
+			return nil, nil, errors.New("weights cannot be negative")
+		}
+		total.Add(total, big.NewInt(w))
+	}
+	if total.Sign() == 0 {
+		return nil, nil, errors.New("weights must not all be zero")
+	}
+
+	shares := make([]Money, len(weights))
+	remainders := make([]int64, len(weights))
+	amount := big.NewInt(int64(m))
+	for i, w := range weights {
+		product := new(big.Int).Mul(amount, big.NewInt(w))
+		quo, rem := new(big.Int).QuoRem(product, total, new(big.Int))
+		shares[i] = Money(quo.Int64())
+		remainders[i] = rem.Int64()
+	}
+	return shares, remainders, nil
+}
+
+// parseDecimal parses s as a fixed point number with at most places digits
+// after the decimal point and returns it scaled by 10^places.
+func parseDecimal(s string, places int) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty value")
+	}
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" && frac == "" || hasPoint && frac == "" {
+		return 0, errors.New("malformed number")
+	}
+	if len(frac) > places {
+		return 0, fmt.Errorf("more than %d decimal places", places)
+	}
+	frac += strings.Repeat("0", places-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return 0, errors.New("malformed number")
+		}
+	}
+
+	v, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, errors.New("value out of range")
+	}
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+// formatDecimal is the inverse of parseDecimal.
+func formatDecimal(v int64, places int) string {
+	sign := ""
+	u := uint64(v)
+	if v < 0 {
+		sign = "-"
+		u = uint64(-v)
+	}
+	digits := strconv.FormatUint(u, 10)
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+	point := len(digits) - places
+	if places == 0 {
+		return sign + digits
+	}
+	return sign + digits[:point] + "." + digits[point:]
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Money
+		wantErr bool
+	}{
+		{name: "Whole Number", input: "100", want: 100000},
+		{name: "One Decimal Place", input: "12.5", want: 12500},
+		{name: "Two Decimal Places", input: "0.05", want: 50},
+		{name: "Three Decimal Places", input: "1.234", want: 1234},
+		{name: "Negative", input: "-3.10", want: -3100},
+		{name: "Leading Point", input: ".75", want: 750},
+		{name: "Surrounding Spaces", input: " 7.00 ", want: 7000},
+		{name: "Too Many Decimal Places", input: "0.0001", wantErr: true},
+		{name: "Largest", input: "1000000000000", want: MaxMoney},
+		{name: "Most Negative", input: "-1000000000000", want: -MaxMoney},
+		{name: "Too Large", input: "1000000000000.001", wantErr: true},
+		{name: "Too Negative", input: "-1000000000000.001", wantErr: true},
+		{name: "Largest Int64", input: "9223372036854775.807", wantErr: true},
+		{name: "Beyond Int64", input: "9223372036854775.808", wantErr: true},
+		{name: "Empty", input: "", wantErr: true},
+		{name: "Not A Number", input: "abc", wantErr: true},
+		{name: "Trailing Point", input: "5.", wantErr: true},
+		{name: "Exponent", input: "1e3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMoney() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoney_String(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{money: 0, want: "0.00"},
+		{money: 50, want: "0.05"},
+		{money: 12500, want: "12.50"},
+		{money: -3100, want: "-3.10"},
+		{money: -50, want: "-0.05"},
+		{money: 1234, want: "1.234"},
+		{money: -5, want: "-0.005"},
+		{money: 1500000, want: "1500.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.money.String(); got != tt.want {
+				t.Errorf("Money.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	encoded, err := json.Marshal(struct{ Amount Money }{Amount: 33330})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `{"Amount":33.33}` {
+		t.Errorf("json.Marshal() = %s", encoded)
+	}
+
+	var decoded struct{ A, B Money }
+	if err := json.Unmarshal([]byte(`{"A":33.33,"B":"0.10"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.A != 33330 || decoded.B != 100 {
+		t.Errorf("json.Unmarshal() = %+v", decoded)
+	}
+}
+
+func TestMoney_Allocate(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Money
+		currency Currency
+		weights  []int64
+		want     []Money
+		wantErr  bool
+	}{
+		{name: "Even Split", amount: 300000, weights: []int64{1, 1, 1}, want: []Money{100000, 100000, 100000}},
+		{name: "Uneven Three Way Split", amount: 100000, weights: []int64{1, 1, 1}, want: []Money{33340, 33330, 33330}},
+		{name: "Weighted Split", amount: 1000, weights: []int64{1, 2}, want: []Money{330, 670}},
+		{name: "Zero Weight Gets Nothing", amount: 1010, weights: []int64{0, 1, 1}, want: []Money{0, 510, 500}},
+		{name: "Negative Amount", amount: -1000, weights: []int64{1, 1, 1}, want: []Money{-340, -330, -330}},
+		{name: "Whole Yen", amount: 100000, currency: "JPY", weights: []int64{1, 1, 1}, want: []Money{34000, 33000, 33000}},
+		{name: "Fils", amount: 1000, currency: "KWD", weights: []int64{1, 1, 1}, want: []Money{334, 333, 333}},
+		{name: "Fraction Of A Minor Unit", amount: 1005, currency: "USD", weights: []int64{1, 1}, wantErr: true},
+		{name: "No Weights", amount: 1000, weights: nil, wantErr: true},
+		{name: "All Zero Weights", amount: 1000, weights: []int64{0, 0}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.amount.Allocate(tt.currency, tt.weights)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Allocate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allocate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSplitRates(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []string
+		want    []int64
+		wantErr bool
+	}{
+		{name: "Whole Numbers", input: []string{"1", "1"}, want: []int64{1, 1}},
+		{name: "Decimals", input: []string{"0.5", "1.5"}, want: []int64{1, 3}},
+		{name: "Common Divisor", input: []string{"2", "4"}, want: []int64{1, 2}},
+		{name: "Invalid Entry", input: []string{"1", "x"}, wantErr: true},
+		{name: "Negative Entry", input: []string{"1", "-1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSplitRates(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSplitRates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSplitRates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
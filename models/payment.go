@@ -1,8 +1,10 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
@@ -22,15 +24,35 @@ var (
 
 // Payment struct represents a payment made to settle expenses.
 type Payment struct {
-	ID         int
-	Payer      *User
-	Payee      *User
-	Amount     float64
-	Mode       PaymentMode
-	Timestamp  time.Time
-	Identifier string
-	Note       string
-	Expenses   []*Expense
+	ID          int
+	Payer       *User
+	Payee       *User
+	Amount      Money
+	Currency    Currency // Currency of Amount, which is that of the Expenses it settles
+	Rate        Rate     // Worth of one unit of Currency in its group's currency when the payment was made; Allocations are converted at their Expenses' rates instead
+	Mode        PaymentMode
+	Timestamp   time.Time
+	Identifier  string
+	Note        string
+	Expenses    []*Expense
+	GroupID     int32         // ID of the group whose debts the payment settles
+	Allocations []Allocation  // How much of the payment went to each of Expenses
+	Attachments []*Attachment `json:",omitempty"` // Proof of the payment, such as a screenshot of the transfer
+	Comments    []*Comment    `json:",omitempty"` // The discussion of the payment, oldest first
+}
+
+// MarshalJSON encodes the payment with its Expenses given by ID. Expenses link
+// back to their payments, so encoding them in full would never terminate.
+func (p Payment) MarshalJSON() ([]byte, error) {
+	type payment Payment
+	expenseIDs := make([]int, len(p.Expenses))
+	for i, expense := range p.Expenses {
+		expenseIDs[i] = expense.ID
+	}
+	return json.Marshal(struct {
+		payment
+		Expenses []int
+	}{payment: payment(p), Expenses: expenseIDs})
 }
 
 // generatePaymentID generates a unique ID for the payment.
@@ -41,8 +63,18 @@ func generatePaymentID() int32 {
 	return paymentIDCounter
 }
 
+// ResumePaymentIDs makes NewPayment number new payments after id, so that
+// payments loaded from storage keep their IDs.
+func ResumePaymentIDs(id int32) {
+	muLock.Lock()
+	defer muLock.Unlock()
+	if id > paymentIDCounter {
+		paymentIDCounter = id
+	}
+}
+
 // NewPayment creates a new Payment instance.
-func NewPayment(payer *User, payee *User, amount float64, mode PaymentMode, identifier string, note string, expenses []*Expense) *Payment {
+func NewPayment(payer *User, payee *User, amount Money, mode PaymentMode, identifier string, note string, expenses []*Expense) *Payment {
 	return &Payment{
 		ID:         int(generatePaymentID()),
 		Payer:      payer,
@@ -56,65 +88,154 @@ func NewPayment(payer *User, payee *User, amount float64, mode PaymentMode, iden
 	}
 }
 
-// SettlePayment settles the expenses based on the payment made.
-func (p *Payment) SettlePayment() error {
+// Allocation is the part of a payment that went towards settling one expense.
+type Allocation struct {
+	Expense int // ID of the expense
+	Amount  Money
+}
+
+// SettlePayment allocates the payment across its Expenses and records the
+// allocations on the payment and each expense. It is all or nothing: if the
+// payment can't be allocated in full, nothing is changed. Balances aren't
+// changed either; the caller posts the payment to the journal.
+//
+// See PlanSettlement for how the payment is allocated.
+func (p *Payment) SettlePayment() ([]Allocation, error) {
+	allocations, err := p.PlanSettlement()
+	if err != nil {
+		return nil, err
+	}
+	p.ApplySettlement(allocations)
+	return allocations, nil
+}
+
+// PlanSettlement works out how the payment is allocated across its Expenses
+// without changing anything.
+//
+// A payment only settles what its payer owes its payee, so every expense must
+// have been paid by the payee and shared by the payer, in the payment's
+// currency. Expenses are settled
+// oldest first, by Timestamp and then by ID, whatever order they are listed
+// in. Each expense receives the payer's outstanding share of it, meaning their
+// share less whatever earlier payments already allocated to it, until the
+// payment runs out, so the last expense settled may only be settled in part.
+// Expenses the payer has already settled, or that the payment runs out before,
+// receive nothing and get no allocation. Paying more than the payer's
+// outstanding shares of all the expenses is an error.
+func (p *Payment) PlanSettlement() ([]Allocation, error) {
 	if p.Amount <= 0 {
-		return errors.New("payment amount must be greater than zero")
+		return nil, errors.New("payment amount must be greater than zero")
+	}
+	if p.Payer == nil || p.Payee == nil {
+		return nil, errors.New("payer and payee cannot be nil")
+	}
+	if len(p.Expenses) == 0 {
+		return nil, errors.New("payment has no expenses to settle")
 	}
 
-	remainingAmount := p.Amount
+	expenses := append([]*Expense(nil), p.Expenses...)
+	sort.SliceStable(expenses, func(i, j int) bool {
+		if !expenses[i].Timestamp.Equal(expenses[j].Timestamp) {
+			return expenses[i].Timestamp.Before(expenses[j].Timestamp)
+		}
+		return expenses[i].ID < expenses[j].ID
+	})
 
-	for _, expense := range p.Expenses {
-		if expense.RemainingAmount <= 0 {
-			continue // Skip already settled expenses
+	remainingAmount := p.Amount
+	allocations := make([]Allocation, 0, len(expenses))
+	seen := make(map[int]bool, len(expenses))
+	for _, expense := range expenses {
+		if seen[expense.ID] {
+			return nil, fmt.Errorf("expense %d is listed more than once", expense.ID)
+		}
+		seen[expense.ID] = true
+		if expense.PaidBy == nil || expense.PaidBy.Id != p.Payee.Id {
+			return nil, fmt.Errorf("expense %d was not paid by %s", expense.ID, p.Payee.Name)
+		}
+		if expense.Currency != p.Currency {
+			return nil, fmt.Errorf("expense %d is in %s, not %s", expense.ID, expense.Currency, p.Currency)
 		}
 
-		// Calculate the payer's share of the expense
-		payerShare := 0.0
-		for i, user := range expense.SplitBetween {
-			if user.Id == p.Payer.Id {
-				// Share is calculated proportionally
-				payerShare = float64(expense.SplitRate[i]) * expense.Amount
-				break
-			}
+		outstanding, err := expense.Outstanding(p.Payer)
+		if err != nil {
+			return nil, fmt.Errorf("expense %d: %w", expense.ID, err)
+		}
+		amount := outstanding
+		if amount < 0 {
+			amount = 0 // A negative share is owed by the payer, not to them
+		}
+		if remainingAmount < amount {
+			amount = remainingAmount
 		}
+		if amount == 0 {
+			continue
+		}
+		remainingAmount -= amount
+		allocations = append(allocations, Allocation{Expense: expense.ID, Amount: amount})
+	}
 
-		// Settle the payer's share
-		if payerShare > 0 {
-			// adjusting for payer's share of the expense
-			if remainingAmount >= payerShare {
-				remainingAmount -= payerShare
-				expense.RemainingAmount -= payerShare
-				p.Payee.Balance -= payerShare
-				p.Payer.Balance += payerShare
-			} else {
-				expense.RemainingAmount -= remainingAmount
-				p.Payee.Balance -= remainingAmount
-				p.Payer.Balance += remainingAmount
-				remainingAmount = 0
+	if remainingAmount > 0 {
+		return nil, fmt.Errorf("payment exceeds what %s owes for these expenses by %s", p.Payer.Name, remainingAmount)
+	}
+	return allocations, nil
+}
+
+// ApplySettlement records allocations returned by PlanSettlement on the payment
+// and its expenses. Expenses that receive nothing are dropped from the
+// payment, so that it only lists the expenses it settles.
+func (p *Payment) ApplySettlement(allocations []Allocation) {
+	p.Allocations = allocations
+	var settled []*Expense
+	for _, allocation := range allocations {
+		if allocation.Amount == 0 {
+			continue
+		}
+		for _, expense := range p.Expenses {
+			if expense.ID == allocation.Expense {
+				expense.RemainingAmount -= allocation.Amount
+				expense.Payments = append(expense.Payments, p)
+				settled = append(settled, expense)
 				break
 			}
-			expense.Payments = append(expense.Payments, p)
 		}
 	}
+	p.Expenses = settled
+}
 
-	if remainingAmount > 0 {
-		return errors.New("partial payment made, some expenses are still unsettled")
+// RevertSettlement undoes ApplySettlement, for when the settled payment could
+// not be saved or is deleted.
+func (p *Payment) RevertSettlement() {
+	for _, allocation := range p.Allocations {
+		if allocation.Amount == 0 {
+			continue
+		}
+		for _, expense := range p.Expenses {
+			if expense.ID != allocation.Expense {
+				continue
+			}
+			expense.RemainingAmount += allocation.Amount
+			for i, each := range expense.Payments {
+				if each == p {
+					expense.Payments = append(expense.Payments[:i], expense.Payments[i+1:]...)
+					break
+				}
+			}
+			break
+		}
 	}
-
-	return nil
+	p.Allocations = nil
 }
 
 // printPaymentInfo returns a formatted string containing all the fields of a Payment structure.
 func printPaymentInfo(payment *Payment) string {
 	expenseInfo := ""
 	for _, expense := range payment.Expenses {
-		expenseInfo += fmt.Sprintf("Expense ID: %d, Amount: %.2f, Paid By: %s, Remaining Amount: %.2f\n",
+		expenseInfo += fmt.Sprintf("Expense ID: %d, Amount: %s, Paid By: %s, Remaining Amount: %s\n",
 			expense.ID, expense.Amount, expense.PaidBy.Name, expense.RemainingAmount)
 	}
 
 	return fmt.Sprintf(
-		"Payment Info:\nID: %d\nPayer: %s\nPayee: %s\nAmount: %.2f\nMode: %s\nTimestamp: %s\nIdentifier: %s\nNote: %s\nExpenses:\n%s",
+		"Payment Info:\nID: %d\nPayer: %s\nPayee: %s\nAmount: %s\nMode: %s\nTimestamp: %s\nIdentifier: %s\nNote: %s\nExpenses:\n%s",
 		payment.ID,
 		payment.Payer.Name,
 		payment.Payee.Name,
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -9,7 +10,7 @@ func TestNewPayment(t *testing.T) {
 	type args struct {
 		payer      *User
 		payee      *User
-		amount     float64
+		amount     Money
 		mode       PaymentMode
 		identifier string
 		note       string
@@ -23,23 +24,23 @@ func TestNewPayment(t *testing.T) {
 		{
 			name: "Simple Payment",
 			args: args{
-				payer:      &User{Name: "User A", Balance: 200, Id: 1},
-				payee:      &User{Name: "User B", Balance: 100, Id: 2},
-				amount:     100, // Correct amount to settle both expenses
+				payer:      &User{Name: "User A", Balance: 2000, Id: 1},
+				payee:      &User{Name: "User B", Balance: 1000, Id: 2},
+				amount:     1000, // Correct amount to settle both expenses
 				mode:       UPI,
 				identifier: "DUMMYTXN1",
 				note:       "Lorem Ipsum",
-				expenses:   []*Expense{{Amount: 100, PaidBy: &User{Name: "User A", Balance: 200, Id: 1}, SplitBetween: []*User{{Name: "User A", Balance: 200, Id: 1}, {Name: "User B", Balance: 100, Id: 2}}, SplitRate: []float32{1.0}}},
+				expenses:   []*Expense{{Amount: 1000, PaidBy: &User{Name: "User A", Balance: 2000, Id: 1}, SplitBetween: []*User{{Name: "User A", Balance: 2000, Id: 1}, {Name: "User B", Balance: 1000, Id: 2}}, SplitRate: []int64{1}}},
 			},
 			want: &Payment{
-				Payer:      &User{Name: "User A", Balance: 200, Id: 1},
-				Payee:      &User{Name: "User B", Balance: 100, Id: 2},
-				Amount:     100,
+				Payer:      &User{Name: "User A", Balance: 2000, Id: 1},
+				Payee:      &User{Name: "User B", Balance: 1000, Id: 2},
+				Amount:     1000,
 				Mode:       UPI,
 				Timestamp:  time.Now(), // Timestamp field
 				Identifier: "DUMMYTXN1",
 				Note:       "Lorem Ipsum",
-				Expenses:   []*Expense{{Amount: 100, PaidBy: &User{Name: "User A", Balance: 200, Id: 1}, SplitBetween: []*User{{Name: "User A", Balance: 200, Id: 1}, {Name: "User B", Balance: 100, Id: 2}}, SplitRate: []float32{1.0}}},
+				Expenses:   []*Expense{{Amount: 1000, PaidBy: &User{Name: "User A", Balance: 2000, Id: 1}, SplitBetween: []*User{{Name: "User A", Balance: 2000, Id: 1}, {Name: "User B", Balance: 1000, Id: 2}}, SplitRate: []int64{1}}},
 			},
 		},
 	}
@@ -80,117 +81,160 @@ func TestNewPayment(t *testing.T) {
 func TestPayment_SettlePayment(t *testing.T) {
 	payer := &User{Name: "User A", Balance: 0, Id: 1}
 	payee := &User{Name: "User B", Balance: 0, Id: 2}
-	expense1 := &Expense{
-		Amount:          100,
-		PaidBy:          payer,
-		SplitBetween:    []*User{payer, payee},
-		SplitRate:       []float32{1.0, 1.0},
-		RemainingAmount: 100, // Amount remaining to be settled
-	}
-	expense2 := &Expense{
-		Amount:          100,
-		PaidBy:          payer,
-		SplitBetween:    []*User{payer, payee},
-		SplitRate:       []float32{1.0, 1.0},
-		RemainingAmount: 100, // Amount remaining to be settled
-	}
-	expense3 := &Expense{
-		Amount:          200,
-		PaidBy:          payee,
-		SplitBetween:    []*User{payer, payee},
-		SplitRate:       []float32{1.0, 1.0},
-		RemainingAmount: 200, // Amount remaining to be settled
-	}
+	other := &User{Name: "User C", Balance: 0, Id: 3}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 
-	type fields struct {
-		Payer      *User
-		Payee      *User
-		Amount     float64
-		Mode       PaymentMode
-		Timestamp  time.Time
-		Identifier string
-		Note       string
-		Expenses   []*Expense
+	// newExpenses returns fresh expenses for every test, oldest first. The payer
+	// owes the payee 50 for the first and 100 for the second.
+	newExpenses := func() []*Expense {
+		return []*Expense{
+			{ID: 1, Amount: 1000, PaidBy: payee, SplitBetween: []*User{payer, payee}, SplitRate: []int64{1, 1}, RemainingAmount: 1000, Timestamp: start},
+			{ID: 2, Amount: 3000, PaidBy: payee, SplitBetween: []*User{payer, payee}, SplitRate: []int64{1, 2}, RemainingAmount: 3000, Timestamp: start.Add(time.Hour)},
+			{ID: 3, Amount: 2000, PaidBy: payer, SplitBetween: []*User{payer, payee}, SplitRate: []int64{1, 1}, RemainingAmount: 2000, Timestamp: start},
+			{ID: 4, Amount: 2000, PaidBy: payee, SplitBetween: []*User{payee, other}, SplitRate: []int64{1, 1}, RemainingAmount: 2000, Timestamp: start},
+		}
 	}
 
 	tests := []struct {
-		name   string
-		fields fields
-		// Expected state of the Payment object
-		wantErr bool
+		name     string
+		amount   Money
+		expenses []int // Indexes into newExpenses, in the order listed on the payment
+		earlier  Money // Amount already allocated to the first expense by an earlier payment
+		want     []Allocation
+		wantErr  bool
 	}{
 		{
-			name: "Settle Payment Success",
-			fields: fields{
-				Payer:    payer,
-				Payee:    payee,
-				Amount:   200,
-				Mode:     Cash,
-				Expenses: []*Expense{expense1, expense2},
-			},
-			wantErr: false,
+			name:     "Settles Every Expense",
+			amount:   1500,
+			expenses: []int{0, 1},
+			want:     []Allocation{{Expense: 1, Amount: 500}, {Expense: 2, Amount: 1000}},
 		},
 		{
-			name: "Settle Payment Success #2",
-			fields: fields{
-				Payer:    payer,
-				Payee:    payee,
-				Amount:   150,
-				Mode:     Cash,
-				Expenses: []*Expense{expense1, expense3},
-			},
-			wantErr: false,
+			name:     "Settles Oldest First",
+			amount:   1200,
+			expenses: []int{1, 0},
+			want:     []Allocation{{Expense: 1, Amount: 500}, {Expense: 2, Amount: 700}},
 		},
 		{
-			name: "Excess Payment",
-			fields: fields{
-				Payer:    payer,
-				Payee:    payee,
-				Amount:   10000,
-				Mode:     Cash,
-				Expenses: []*Expense{expense1, expense3},
-			},
-			wantErr: true,
+			name:     "Partial Payment",
+			amount:   300,
+			expenses: []int{0, 1},
+			want:     []Allocation{{Expense: 1, Amount: 300}},
 		},
 		{
-			name: "Negative Amount Payment ",
-			fields: fields{
-				Payer:    payer,
-				Payee:    payee,
-				Amount:   -10000,
-				Mode:     Cash,
-				Expenses: []*Expense{expense1, expense2},
-			},
-			wantErr: true,
+			name:     "Earlier Payments Are Taken Into Account",
+			amount:   1200,
+			expenses: []int{0, 1},
+			earlier:  200,
+			want:     []Allocation{{Expense: 1, Amount: 300}, {Expense: 2, Amount: 900}},
 		},
 		{
-			name: "Partial Payment Error",
-			fields: fields{
-				Payer:    payer,
-				Payee:    payee,
-				Amount:   50,
-				Mode:     Cash,
-				Expenses: []*Expense{expense1, expense2},
-			},
-			wantErr: true,
+			name:     "Settled Expenses Are Dropped",
+			amount:   1000,
+			expenses: []int{0, 1},
+			earlier:  500,
+			want:     []Allocation{{Expense: 2, Amount: 1000}},
+		},
+		{
+			name:     "Excess Payment",
+			amount:   100000,
+			expenses: []int{0, 1},
+			wantErr:  true,
+		},
+		{
+			name:     "Negative Amount Payment ",
+			amount:   -100000,
+			expenses: []int{0, 1},
+			wantErr:  true,
+		},
+		{
+			name:     "Expense Not Paid By Payee",
+			amount:   500,
+			expenses: []int{0, 2},
+			wantErr:  true,
+		},
+		{
+			name:     "Expense Not Shared By Payer",
+			amount:   500,
+			expenses: []int{0, 3},
+			wantErr:  true,
+		},
+		{
+			name:     "Expense Listed Twice",
+			amount:   500,
+			expenses: []int{0, 0},
+			wantErr:  true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			all := newExpenses()
+			var expenses []*Expense
+			for _, i := range tt.expenses {
+				expenses = append(expenses, all[i])
+			}
+			if tt.earlier > 0 {
+				earlier := &Payment{ID: 1, Payer: payer, Payee: payee, Amount: tt.earlier, Expenses: all[:1]}
+				if _, err := earlier.SettlePayment(); err != nil {
+					t.Fatalf("SettlePayment() of earlier payment error = %v", err)
+				}
+			}
+			remaining := make([]Money, len(all))
+			for i, expense := range all {
+				remaining[i] = expense.RemainingAmount
+			}
+
 			payment := &Payment{
-				Payer:      tt.fields.Payer,
-				Payee:      tt.fields.Payee,
-				Amount:     tt.fields.Amount,
-				Mode:       tt.fields.Mode,
-				Identifier: tt.fields.Identifier,
-				Note:       tt.fields.Note,
-				Expenses:   tt.fields.Expenses,
+				ID:       2,
+				Payer:    payer,
+				Payee:    payee,
+				Amount:   tt.amount,
+				Mode:     Cash,
+				Expenses: expenses,
+			}
+			got, err := payment.SettlePayment()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SettlePayment() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if err := payment.SettlePayment(); (err != nil) != tt.wantErr {
-				t.Errorf("SettlePayment() error = %v, wantErr %v", err, tt.wantErr)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SettlePayment() = %v, want %v", got, tt.want)
 			}
 
+			if !tt.wantErr && len(payment.Expenses) != len(tt.want) {
+				t.Errorf("payment Expenses = %d expenses, want only the %d it settles", len(payment.Expenses), len(tt.want))
+			}
+
+			for i, expense := range all {
+				// A failed payment changes nothing
+				wantRemaining := remaining[i]
+				for _, allocation := range tt.want {
+					if allocation.Expense == expense.ID {
+						wantRemaining -= allocation.Amount
+					}
+				}
+				if expense.RemainingAmount != wantRemaining {
+					t.Errorf("expense %d RemainingAmount = %v, want %v", expense.ID, expense.RemainingAmount, wantRemaining)
+				}
+			}
 		})
 	}
 }
+
+func TestPayment_RevertSettlement(t *testing.T) {
+	payer := &User{Name: "User A", Id: 1}
+	payee := &User{Name: "User B", Id: 2}
+	expense := &Expense{ID: 1, Amount: 1000, PaidBy: payee, SplitBetween: []*User{payer, payee}, SplitRate: []int64{1, 1}, RemainingAmount: 1000}
+	payment := &Payment{ID: 1, Payer: payer, Payee: payee, Amount: 300, Expenses: []*Expense{expense}}
+
+	if _, err := payment.SettlePayment(); err != nil {
+		t.Fatalf("SettlePayment() error = %v", err)
+	}
+	payment.RevertSettlement()
+	if expense.RemainingAmount != 1000 || len(expense.Payments) != 0 || payment.Allocations != nil {
+		t.Errorf("after RevertSettlement() RemainingAmount = %v, Payments = %v, Allocations = %v, want 1.00 and none", expense.RemainingAmount, expense.Payments, payment.Allocations)
+	}
+	if outstanding, _ := expense.Outstanding(payer); outstanding != 500 {
+		t.Errorf("Outstanding() after RevertSettlement() = %v, want 0.50", outstanding)
+	}
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LineItem is a single item on an itemized receipt, shared equally between the
+// users who consumed it.
+type LineItem struct {
+	Description string
+	Amount      Money
+	Consumers   []*User
+}
+
+// ChargeKind identifies an extra charge on a receipt.
+type ChargeKind string
+
+const (
+	Tax           ChargeKind = "Tax"
+	Tip           ChargeKind = "Tip"
+	ServiceCharge ChargeKind = "ServiceCharge"
+)
+
+// Charge is an amount added on top of a receipt's line items. Charges are
+// spread across the items in proportion to each item's amount.
+type Charge struct {
+	Kind   ChargeKind
+	Amount Money
+}
+
+// NewItemizedExpense creates an expense in currency from receipt line items and
+// charges. The expense Amount is the sum of the items and charges, and
+// SplitBetween and SplitRate are derived from who consumed which item.
+func NewItemizedExpense(currency Currency, paidBy *User, items []*LineItem, charges []*Charge) (*Expense, error) {
+	expense := NewExpense(0, paidBy, nil, nil)
+	expense.Currency = currency
+	expense.SplitType = SplitItemized
+	expense.Items = items
+	expense.Charges = charges
+	if err := expense.deriveItemizedSplit(); err != nil {
+		return nil, err
+	}
+	expense.RemainingAmount = expense.Amount
+	if err := expense.ValidateSplit(); err != nil {
+		return nil, err
+	}
+	return expense, nil
+}
+
+// deriveItemizedSplit works out each consumer's share of the receipt. Charges
+// are first spread across the items by item amount, then each item's total is
+// divided equally between its consumers using the expense's rounding policy.
+// The per user totals are stored in SplitRate as amounts in minor units, in
+// the order users first appear on the receipt.
+func (e *Expense) deriveItemizedSplit() error {
+	if len(e.Items) == 0 {
+		return errors.New("itemized expenses need at least one item")
+	}
+
+	itemAmounts := make([]int64, len(e.Items))
+	subtotal := Money(0)
+	for i, item := range e.Items {
+		if item.Amount < 0 {
+			return fmt.Errorf("item %q has a negative amount", item.Description)
+		}
+		if len(item.Consumers) == 0 {
+			return fmt.Errorf("item %q has no consumers", item.Description)
+		}
+		if err := e.Currency.ValidateAmount(item.Amount); err != nil {
+			return fmt.Errorf("item %q: %w", item.Description, err)
+		}
+		itemAmounts[i] = int64(item.Amount)
+		subtotal += item.Amount
+	}
+	if subtotal == 0 {
+		return errors.New("itemized expenses need a non-zero subtotal")
+	}
+
+	extras := Money(0)
+	for _, charge := range e.Charges {
+		switch charge.Kind {
+		case Tax, Tip, ServiceCharge:
+		default:
+			return fmt.Errorf("unknown charge kind %q", charge.Kind)
+		}
+		if charge.Amount < 0 {
+			return fmt.Errorf("%s cannot be negative", charge.Kind)
+		}
+		if err := e.Currency.ValidateAmount(charge.Amount); err != nil {
+			return fmt.Errorf("%s: %w", charge.Kind, err)
+		}
+		extras += charge.Amount
+	}
+	itemExtras, err := extras.Allocate(e.Currency, itemAmounts)
+	if err != nil {
+		return err
+	}
+
+	var users []*User
+	totals := make(map[int32]int64)
+	for i, item := range e.Items {
+		shares, err := e.allocate(item.Amount+itemExtras[i], equalRates(len(item.Consumers)), item.Consumers)
+		if err != nil {
+			return err
+		}
+		for j, consumer := range item.Consumers {
+			if _, seen := totals[consumer.Id]; !seen {
+				users = append(users, consumer)
+			}
+			totals[consumer.Id] += int64(shares[j])
+		}
+	}
+
+	e.Amount = subtotal + extras
+	e.SplitBetween = users
+	e.SplitRate = make([]int64, len(users))
+	for i, user := range users {
+		e.SplitRate[i] = totals[user.Id]
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewItemizedExpense(t *testing.T) {
+	alice := &User{Id: 1, Name: "Alice"}
+	bob := &User{Id: 2, Name: "Bob"}
+	carol := &User{Id: 3, Name: "Carol"}
+
+	tests := []struct {
+		name       string
+		items      []*LineItem
+		charges    []*Charge
+		wantAmount Money
+		wantUsers  []*User
+		wantShares []Money
+		wantErr    bool
+	}{
+		{
+			name: "Items Without Charges",
+			items: []*LineItem{
+				{Description: "Steak", Amount: 30000, Consumers: []*User{alice}},
+				{Description: "Pizza", Amount: 20000, Consumers: []*User{bob, carol}},
+			},
+			wantAmount: 50000,
+			wantUsers:  []*User{alice, bob, carol},
+			wantShares: []Money{30000, 10000, 10000},
+		},
+		{
+			name: "Tax And Tip Spread By Item Amount",
+			items: []*LineItem{
+				{Description: "Steak", Amount: 30000, Consumers: []*User{alice}},
+				{Description: "Pizza", Amount: 20000, Consumers: []*User{bob, carol}},
+			},
+			charges:    []*Charge{{Kind: Tax, Amount: 5000}, {Kind: Tip, Amount: 10000}},
+			wantAmount: 65000,
+			wantUsers:  []*User{alice, bob, carol},
+			wantShares: []Money{39000, 13000, 13000},
+		},
+		{
+			name: "Uneven Charges Still Add Up",
+			items: []*LineItem{
+				{Description: "Soup", Amount: 10000, Consumers: []*User{alice, bob, carol}},
+				{Description: "Bread", Amount: 5000, Consumers: []*User{bob}},
+			},
+			charges:    []*Charge{{Kind: ServiceCharge, Amount: 1000}},
+			wantAmount: 16000,
+			wantUsers:  []*User{alice, bob, carol},
+			wantShares: []Money{3560, 8890, 3550},
+		},
+		{
+			name:    "No Items",
+			wantErr: true,
+		},
+		{
+			name:    "Item Without Consumers",
+			items:   []*LineItem{{Description: "Water", Amount: 1000}},
+			wantErr: true,
+		},
+		{
+			name:    "Item With A Fraction Of A Cent",
+			items:   []*LineItem{{Description: "Water", Amount: 1005, Consumers: []*User{alice}}},
+			wantErr: true,
+		},
+		{
+			name:    "Charge With A Fraction Of A Cent",
+			items:   []*LineItem{{Description: "Water", Amount: 1000, Consumers: []*User{alice}}},
+			charges: []*Charge{{Kind: Tip, Amount: 5}},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown Charge",
+			items:   []*LineItem{{Description: "Water", Amount: 1000, Consumers: []*User{alice}}},
+			charges: []*Charge{{Kind: "Corkage", Amount: 1000}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewItemizedExpense(DefaultCurrency, alice, tt.items, tt.charges)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewItemizedExpense() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Amount != tt.wantAmount || got.RemainingAmount != tt.wantAmount {
+				t.Errorf("NewItemizedExpense() Amount = %v, RemainingAmount = %v, want %v", got.Amount, got.RemainingAmount, tt.wantAmount)
+			}
+			if !reflect.DeepEqual(got.SplitBetween, tt.wantUsers) {
+				t.Errorf("NewItemizedExpense() SplitBetween = %v, want %v", got.SplitBetween, tt.wantUsers)
+			}
+			shares, err := got.Shares()
+			if err != nil {
+				t.Fatalf("Shares() error = %v", err)
+			}
+			if !reflect.DeepEqual(shares, tt.wantShares) {
+				t.Errorf("Shares() = %v, want %v", shares, tt.wantShares)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	recurringIDCounter int
+	recurringIDMu      sync.Mutex
+)
+
+func generateRecurringID() int {
+	recurringIDMu.Lock()
+	defer recurringIDMu.Unlock()
+	recurringIDCounter++
+	return recurringIDCounter
+}
+
+// ResumeRecurringIDs makes new recurring expenses number after id, so that
+// those loaded from storage keep their IDs.
+func ResumeRecurringIDs(id int) {
+	recurringIDMu.Lock()
+	defer recurringIDMu.Unlock()
+	if id > recurringIDCounter {
+		recurringIDCounter = id
+	}
+}
+
+// RecurringExpense makes a copy of an expense every time its schedule comes
+// round, such as the rent on the 1st of every month.
+type RecurringExpense struct {
+	ID          int
+	GroupID     int32    // ID of the group the expenses are added to
+	Template    *Expense // What each expense is a copy of, but for its ID, Timestamp and Rate
+	Schedule    Schedule
+	Rule        string     // Schedule as a recurrence rule
+	Occurrences int        // How many expenses have been made so far
+	Last        *time.Time `json:",omitempty"` // When the last expense made was due
+	Next        *time.Time `json:",omitempty"` // When the next expense is due, or nil once the schedule has ended
+}
+
+// NewRecurringExpense creates a recurring expense that adds copies of template
+// to the group on the schedule.
+func NewRecurringExpense(groupID int32, template *Expense, schedule Schedule) *RecurringExpense {
+	r := &RecurringExpense{ID: generateRecurringID(), GroupID: groupID}
+	r.Reschedule(template, schedule)
+	return r
+}
+
+// Reschedule replaces the template and schedule. Expenses already made are
+// kept, and count towards the schedule's Count; the next one is due at the
+// first occurrence of the new schedule after the last one made.
+func (r *RecurringExpense) Reschedule(template *Expense, schedule Schedule) {
+	r.Template, r.Schedule, r.Rule = template, schedule, schedule.Rule()
+	next := schedule.First()
+	if r.Last != nil {
+		next = schedule.After(*r.Last)
+	}
+	r.Next = &next
+	if schedule.Ended(next, r.Occurrences) {
+		r.Next = nil
+	}
+}
+
+// Due reports whether an expense is due to be made at or before now.
+func (r *RecurringExpense) Due(now time.Time) bool {
+	return r.Next != nil && !r.Next.After(now)
+}
+
+// Occurrence returns the expense due at Next, converted to the group's
+// currency at rate. It doesn't change r; call Advance once it is saved.
+func (r *RecurringExpense) Occurrence(rate Rate) *Expense {
+	e := r.Template.Copy()
+	e.ID = int(generateExpenseID())
+	e.Timestamp = *r.Next
+	e.Rate = rate
+	e.RemainingAmount = e.Amount
+	e.Recurring = r.ID
+	return e
+}
+
+// Advance moves on to the occurrence after Next and returns how r was before,
+// to restore if saving the expense made for Next fails.
+func (r *RecurringExpense) Advance() RecurringExpense {
+	previous := *r
+	last := *r.Next
+	next := r.Schedule.After(last)
+	r.Occurrences++
+	r.Last, r.Next = &last, &next
+	if r.Schedule.Ended(next, r.Occurrences) {
+		r.Next = nil
+	}
+	return previous
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurringExpense(t *testing.T) {
+	alice := &User{Id: 1, Name: "Alice"}
+	bob := &User{Id: 2, Name: "Bob"}
+	rent := &Expense{Amount: 1000000, Currency: "EUR", PaidBy: alice, SplitBetween: []*User{alice, bob}, SplitType: SplitShares, SplitRate: []int64{1, 1}}
+	start := time.Date(2026, time.January, 1, 9, 0, 0, 0, time.UTC)
+	schedule, err := ParseSchedule("FREQ=MONTHLY;COUNT=2", start)
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+
+	r := NewRecurringExpense(1, rent, schedule)
+	if r.Rule != "FREQ=MONTHLY;COUNT=2" || r.Due(start.Add(-time.Second)) || !r.Due(start) {
+		t.Fatalf("NewRecurringExpense() = %+v, want it due from %v", r, start)
+	}
+
+	first := r.Occurrence(9000000000)
+	if first.Timestamp != start || first.Rate != 9000000000 || first.RemainingAmount != 1000000 || first.Recurring != r.ID || first.ID == 0 {
+		t.Errorf("Occurrence() = %+v, want a copy of the rent due %v at the given rate", first, start)
+	}
+	first.SplitRate[0] = 3
+	if rent.SplitRate[0] != 1 {
+		t.Error("changing an occurrence changed the template")
+	}
+
+	previous := r.Advance()
+	if r.Occurrences != 1 || !r.Last.Equal(start) || !r.Next.Equal(start.AddDate(0, 1, 0)) {
+		t.Errorf("after Advance() = %+v, want the second occurrence next", r)
+	}
+	*r = previous
+	if r.Occurrences != 0 || r.Last != nil || !r.Next.Equal(start) {
+		t.Errorf("after restoring = %+v, want it as it was", r)
+	}
+
+	r.Advance()
+	r.Advance()
+	if r.Next != nil || r.Due(start.AddDate(1, 0, 0)) {
+		t.Errorf("after COUNT occurrences = %+v, want the schedule ended", r)
+	}
+
+	// Rescheduling carries on after the last expense made, on the 1st of
+	// February, and counts it
+	weekly, _ := ParseSchedule("FREQ=WEEKLY;COUNT=3", start)
+	r.Reschedule(rent, weekly)
+	if want := time.Date(2026, time.February, 5, 9, 0, 0, 0, time.UTC); r.Next == nil || !r.Next.Equal(want) {
+		t.Errorf("Next after rescheduling = %v, want the Thursday after the last one, %v", r.Next, want)
+	}
+	r.Advance()
+	if r.Next != nil {
+		t.Errorf("Next after the third occurrence = %v, want the schedule ended", r.Next)
+	}
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// RoundingPolicy decides who receives the leftover minor units when an amount
+// cannot be divided exactly, e.g. splitting 100.00 three ways. Whatever the
+// policy, the resulting shares always add up to exactly the amount split.
+type RoundingPolicy string
+
+const (
+	// RoundLargestRemainder gives the leftover units to the shares that lost the
+	// most to rounding down. This is the default policy.
+	RoundLargestRemainder RoundingPolicy = "LargestRemainder"
+	// RoundPayerAbsorbs rounds every other share down and lets the payer cover
+	// the difference. It falls back to RoundLargestRemainder when the payer is
+	// not one of the users sharing the amount.
+	RoundPayerAbsorbs RoundingPolicy = "PayerAbsorbs"
+	// RoundRobin hands out leftover units in ascending user ID order, starting
+	// from a position that rotates with the expense ID so that no single user
+	// always pays the extra unit.
+	RoundRobin RoundingPolicy = "RoundRobin"
+	// RoundRandom hands out leftover units in a random order derived from the
+	// expense's RoundingSeed, so the result can be reproduced later.
+	RoundRandom RoundingPolicy = "Random"
+)
+
+// ParseRoundingPolicy validates a rounding policy name. An empty name selects
+// the default RoundLargestRemainder policy.
+func ParseRoundingPolicy(name string) (RoundingPolicy, error) {
+	switch policy := RoundingPolicy(name); policy {
+	case "":
+		return RoundLargestRemainder, nil
+	case RoundLargestRemainder, RoundPayerAbsorbs, RoundRobin, RoundRandom:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown rounding policy %q", name)
+	}
+}
+
+// SetRounding changes the expense's rounding policy. The seed is only kept for
+// the Random policy. Itemized splits are derived using the policy, so they are
+// recalculated, and the split is validated again.
+func (e *Expense) SetRounding(policy RoundingPolicy, seed int64) error {
+	e.Rounding = policy
+	e.RoundingSeed = 0
+	if policy == RoundRandom {
+		e.RoundingSeed = seed
+	}
+	if e.splitType() == SplitItemized {
+		if err := e.deriveItemizedSplit(); err != nil {
+			return err
+		}
+	}
+	return e.ValidateSplit()
+}
+
+// distributor orders the eligible shares (those with a non-zero weight) by who
+// should receive the next leftover unit. The order is cycled if it is shorter
+// than the number of leftover units.
+type distributor func(eligible []int, remainders []int64) []int
+
+// allocate splits amount, an amount in currency, across weights in whole minor
+// units of the currency, rounding every share towards zero and then handing
+// out the leftover units in the order chosen by next.
+func allocate(amount Money, currency Currency, weights []int64, next distributor) ([]Money, error) {
+	if err := currency.ValidateAmount(amount); err != nil {
+		return nil, err
+	}
+	unit := currency.MinorUnit()
+	sign := Money(1)
+	if amount < 0 {
+		sign, amount = -1, -amount
+	}
+
+	// Shares are worked out in minor units and scaled back at the end
+	amount /= unit
+	shares, remainders, err := amount.allocateFloor(weights)
+	if err != nil {
+		return nil, err
+	}
+
+	leftover := amount
+	var eligible []int
+	for i, share := range shares {
+		leftover -= share
+		if weights[i] > 0 {
+			eligible = append(eligible, i)
+		}
+	}
+	if leftover > 0 {
+		order := next(eligible, remainders)
+		for i := 0; leftover > 0; i++ {
+			shares[order[i%len(order)]]++
+			leftover--
+		}
+	}
+
+	for i := range shares {
+		shares[i] *= sign * unit
+	}
+	return shares, nil
+}
+
+func largestRemainder(eligible []int, remainders []int64) []int {
+	order := append([]int(nil), eligible...)
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	return order
+}
+
+// allocate splits amount between users according to the expense's rounding
+// policy. users and weights must be the same length.
+func (e *Expense) allocate(amount Money, weights []int64, users []*User) ([]Money, error) {
+	if len(weights) != len(users) {
+		return nil, fmt.Errorf("got %d weights for %d users", len(weights), len(users))
+	}
+
+	policy, err := ParseRoundingPolicy(string(e.Rounding))
+	if err != nil {
+		return nil, err
+	}
+
+	next := largestRemainder
+	switch policy {
+	case RoundPayerAbsorbs:
+		next = func(eligible []int, remainders []int64) []int {
+			for _, i := range eligible {
+				if e.PaidBy != nil && users[i].Id == e.PaidBy.Id {
+					return []int{i}
+				}
+			}
+			return largestRemainder(eligible, remainders)
+		}
+	case RoundRobin:
+		next = func(eligible []int, _ []int64) []int {
+			order := append([]int(nil), eligible...)
+			sort.SliceStable(order, func(a, b int) bool {
+				return users[order[a]].Id < users[order[b]].Id
+			})
+			start := e.ID % len(order)
+			return append(order[start:], order[:start]...)
+		}
+	case RoundRandom:
+		next = func(eligible []int, _ []int64) []int {
+			rng := rand.New(rand.NewSource(e.RoundingSeed))
+			order := make([]int, len(eligible))
+			for i, j := range rng.Perm(len(eligible)) {
+				order[i] = eligible[j]
+			}
+			return order
+		}
+	}
+
+	return allocate(amount, e.Currency, weights, next)
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpense_Shares_RoundingPolicies(t *testing.T) {
+	a := &User{Id: 1, Name: "A"}
+	b := &User{Id: 2, Name: "B"}
+	c := &User{Id: 3, Name: "C"}
+	d := &User{Id: 4, Name: "D"}
+
+	tests := []struct {
+		name         string
+		id           int
+		paidBy       *User
+		splitBetween []*User
+		rounding     RoundingPolicy
+		want         []Money
+	}{
+		{name: "Default Is Largest Remainder", paidBy: a, splitBetween: []*User{a, b, c}, want: []Money{33340, 33330, 33330}},
+		{name: "Largest Remainder", paidBy: a, splitBetween: []*User{a, b, c}, rounding: RoundLargestRemainder, want: []Money{33340, 33330, 33330}},
+		{name: "Payer Absorbs", paidBy: c, splitBetween: []*User{a, b, c}, rounding: RoundPayerAbsorbs, want: []Money{33330, 33330, 33340}},
+		{name: "Payer Absorbs Without Payer Sharing", paidBy: c, splitBetween: []*User{b, a, d}, rounding: RoundPayerAbsorbs, want: []Money{33340, 33330, 33330}},
+		{name: "Round Robin Starts At Lowest ID", id: 0, paidBy: a, splitBetween: []*User{c, b, a}, rounding: RoundRobin, want: []Money{33330, 33330, 33340}},
+		{name: "Round Robin Rotates With Expense ID", id: 1, paidBy: a, splitBetween: []*User{c, b, a}, rounding: RoundRobin, want: []Money{33330, 33340, 33330}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weights := make([]int64, len(tt.splitBetween))
+			for i := range weights {
+				weights[i] = 1
+			}
+			e := &Expense{
+				ID:           tt.id,
+				Amount:       100000,
+				PaidBy:       tt.paidBy,
+				SplitBetween: tt.splitBetween,
+				SplitRate:    weights,
+				Rounding:     tt.rounding,
+			}
+
+			got, err := e.Shares()
+			if err != nil {
+				t.Fatalf("Shares() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Shares() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpense_Shares_RandomIsReproducible(t *testing.T) {
+	users := []*User{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}, {Id: 5}, {Id: 6}, {Id: 7}}
+	weights := []int64{1, 1, 1, 1, 1, 1, 1}
+
+	for seed := int64(0); seed < 20; seed++ {
+		e := &Expense{Amount: 10000, PaidBy: users[0], SplitBetween: users, SplitRate: weights, Rounding: RoundRandom, RoundingSeed: seed}
+		first, err := e.Shares()
+		if err != nil {
+			t.Fatalf("Shares() error = %v", err)
+		}
+		second, _ := e.Shares()
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("seed %d: Shares() = %v then %v", seed, first, second)
+		}
+
+		total := Money(0)
+		for _, share := range first {
+			total += share
+		}
+		if total != e.Amount {
+			t.Errorf("seed %d: shares sum to %s, want %s", seed, total, e.Amount)
+		}
+	}
+}
+
+func TestParseRoundingPolicy(t *testing.T) {
+	if got, err := ParseRoundingPolicy(""); err != nil || got != RoundLargestRemainder {
+		t.Errorf("ParseRoundingPolicy(\"\") = %v, %v", got, err)
+	}
+	if got, err := ParseRoundingPolicy("RoundRobin"); err != nil || got != RoundRobin {
+		t.Errorf("ParseRoundingPolicy(RoundRobin) = %v, %v", got, err)
+	}
+	if _, err := ParseRoundingPolicy("Banker"); err == nil {
+		t.Error("ParseRoundingPolicy(Banker) expected an error")
+	}
+}
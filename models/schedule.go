@@ -0,0 +1,258 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is how often a schedule repeats, before its interval is applied.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// Schedule is when a recurring expense recurs. It is the part of an iCalendar
+// recurrence rule (RFC 5545) that recurring expenses need, written the same
+// way, e.g. "FREQ=MONTHLY;BYMONTHDAY=1" for the 1st of every month or
+// "FREQ=WEEKLY;INTERVAL=2;COUNT=10" for every other week, ten times.
+//
+// Occurrences are at Start's time of day in Start's location. Days past the
+// end of a shorter month fall on its last day, so the 31st of every month is
+// the last day of every month, and the 29th of February is the 28th in other
+// years.
+type Schedule struct {
+	Frequency  Frequency
+	Interval   int            // Every Interval days, weeks, months or years
+	ByWeekday  []time.Weekday `json:",omitempty"` // Days of the week of weekly schedules; Start's unless given
+	ByMonthDay int            `json:",omitempty"` // Day of the month of monthly schedules, from the end if negative; Start's unless given
+	Start      time.Time      // The first occurrence, unless it isn't on one of the days above
+	Count      int            `json:",omitempty"` // How many times it recurs, or 0 for no limit
+	Until      *time.Time     `json:",omitempty"` // The last time it may recur at, or nil for no limit
+}
+
+// weekdays are the days of the week as written in recurrence rules.
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// untilLayouts are the forms an UNTIL date can be written in.
+var untilLayouts = []string{"20060102T150405Z", "20060102"}
+
+// ParseSchedule parses a recurrence rule such as "FREQ=MONTHLY;BYMONTHDAY=1"
+// into a Schedule starting at start. It understands FREQ, INTERVAL, BYDAY,
+// BYMONTHDAY, COUNT and UNTIL. An UNTIL date without a time includes that
+// whole day.
+func ParseSchedule(rule string, start time.Time) (Schedule, error) {
+	s := Schedule{Interval: 1, Start: start}
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:"), ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return Schedule{}, fmt.Errorf("malformed rule part %q", part)
+		}
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			s.Frequency = Frequency(strings.ToUpper(value))
+		case "INTERVAL":
+			s.Interval, err = strconv.Atoi(value)
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					return Schedule{}, fmt.Errorf("unknown day %q", day)
+				}
+				s.ByWeekday = append(s.ByWeekday, weekday)
+			}
+		case "BYMONTHDAY":
+			s.ByMonthDay, err = strconv.Atoi(value)
+		case "COUNT":
+			s.Count, err = strconv.Atoi(value)
+			if err == nil && s.Count <= 0 {
+				err = errors.New("must be greater than zero")
+			}
+		case "UNTIL":
+			err = s.parseUntil(value)
+		default:
+			return Schedule{}, fmt.Errorf("unsupported rule part %q", name)
+		}
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid %s %q: %w", strings.ToUpper(name), value, err)
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return Schedule{}, err
+	}
+	return s, nil
+}
+
+// parseUntil sets Until from an UNTIL value.
+func (s *Schedule) parseUntil(value string) error {
+	for _, layout := range untilLayouts {
+		until, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if layout == "20060102" {
+			// The whole day in the schedule's own location
+			until = time.Date(until.Year(), until.Month(), until.Day(), 23, 59, 59, 0, s.Start.Location())
+		}
+		s.Until = &until
+		return nil
+	}
+	return errors.New("must be a date such as 20261231 or 20261231T235959Z")
+}
+
+// Validate checks that the schedule can be followed.
+func (s Schedule) Validate() error {
+	switch s.Frequency {
+	case Daily, Weekly, Monthly, Yearly:
+	case "":
+		return errors.New("FREQ is required")
+	default:
+		return fmt.Errorf("unknown frequency %q", s.Frequency)
+	}
+	if s.Interval <= 0 {
+		return errors.New("INTERVAL must be greater than zero")
+	}
+	if len(s.ByWeekday) > 0 && s.Frequency != Weekly {
+		return errors.New("BYDAY is only supported by weekly schedules")
+	}
+	if s.ByMonthDay != 0 && s.Frequency != Monthly {
+		return errors.New("BYMONTHDAY is only supported by monthly schedules")
+	}
+	if s.ByMonthDay < -31 || s.ByMonthDay > 31 {
+		return errors.New("BYMONTHDAY must be between -31 and 31")
+	}
+	if s.Count > 0 && s.Until != nil {
+		return errors.New("COUNT and UNTIL cannot both be given")
+	}
+	if s.Start.IsZero() {
+		return errors.New("schedules need a start")
+	}
+	return nil
+}
+
+// Rule returns the schedule as a recurrence rule, the inverse of
+// ParseSchedule.
+func (s Schedule) Rule() string {
+	parts := []string{"FREQ=" + string(s.Frequency)}
+	if s.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(s.Interval))
+	}
+	if len(s.ByWeekday) > 0 {
+		var days []string
+		for _, weekday := range s.ByWeekday {
+			days = append(days, strings.ToUpper(weekday.String()[:2]))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if s.ByMonthDay != 0 {
+		parts = append(parts, "BYMONTHDAY="+strconv.Itoa(s.ByMonthDay))
+	}
+	if s.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(s.Count))
+	}
+	if s.Until != nil {
+		parts = append(parts, "UNTIL="+s.Until.UTC().Format(untilLayouts[0]))
+	}
+	return strings.Join(parts, ";")
+}
+
+// After returns the first occurrence later than t, ignoring Count and Until,
+// which depend on how many occurrences there have been.
+func (s Schedule) After(t time.Time) time.Time {
+	// Skip straight to the period before the one t is in, since schedules
+	// can go on for years
+	period := 0
+	if t.After(s.Start) {
+		switch days := int(t.Sub(s.Start).Hours() / 24); s.Frequency {
+		case Daily:
+			period = days / s.Interval
+		case Weekly:
+			period = days / 7 / s.Interval
+		case Monthly:
+			period = days / 31 / s.Interval
+		case Yearly:
+			period = days / 366 / s.Interval
+		}
+		if period > 0 {
+			period--
+		}
+	}
+	for ; ; period++ {
+		for _, occurrence := range s.period(period) {
+			if !occurrence.Before(s.Start) && occurrence.After(t) {
+				return occurrence
+			}
+		}
+	}
+}
+
+// First returns the first occurrence, ignoring Count and Until.
+func (s Schedule) First() time.Time {
+	return s.After(s.Start.Add(-time.Nanosecond))
+}
+
+// Ended reports whether an occurrence at next, after count earlier ones, is
+// past the end of the schedule.
+func (s Schedule) Ended(next time.Time, count int) bool {
+	return s.Count > 0 && count >= s.Count || s.Until != nil && next.After(*s.Until)
+}
+
+// period returns the occurrences in the nth day, week, month or year of the
+// schedule, in order, counting Start's as the 0th.
+func (s Schedule) period(n int) []time.Time {
+	start := s.Start
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+	}
+	switch s.Frequency {
+	case Daily:
+		return []time.Time{at(start.Year(), start.Month(), start.Day()+n*s.Interval)}
+	case Weekly:
+		// Weeks start on Monday, as they do in recurrence rules by default
+		monday := start.Day() - (int(start.Weekday())+6)%7 + 7*n*s.Interval
+		days := s.ByWeekday
+		if len(days) == 0 {
+			days = []time.Weekday{start.Weekday()}
+		}
+		var occurrences []time.Time
+		for _, weekday := range days {
+			occurrences = append(occurrences, at(start.Year(), start.Month(), monday+(int(weekday)+6)%7))
+		}
+		sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].Before(occurrences[j]) })
+		return occurrences
+	case Monthly:
+		first := at(start.Year(), start.Month()+time.Month(n*s.Interval), 1)
+		last := daysIn(first.Year(), first.Month())
+		day := s.ByMonthDay
+		switch {
+		case day == 0:
+			day = start.Day()
+		case day < 0:
+			day += last + 1
+		}
+		return []time.Time{at(first.Year(), first.Month(), clamp(day, 1, last))}
+	default:
+		year := start.Year() + n*s.Interval
+		return []time.Time{at(year, start.Month(), clamp(start.Day(), 1, daysIn(year, start.Month())))}
+	}
+}
+
+// daysIn returns the number of days in the month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func clamp(v, low, high int) int {
+	return min(max(v, low), high)
+}
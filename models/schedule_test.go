@@ -0,0 +1,137 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	start := time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		rule    string
+		want    string // The rule it round trips to
+		wantErr bool
+	}{
+		{name: "Monthly", rule: "FREQ=MONTHLY;BYMONTHDAY=1", want: "FREQ=MONTHLY;BYMONTHDAY=1"},
+		{name: "Every Two Weeks", rule: "freq=weekly;interval=2;byday=mo,th", want: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH"},
+		{name: "Prefixed", rule: "RRULE:FREQ=DAILY;COUNT=3", want: "FREQ=DAILY;COUNT=3"},
+		{name: "Until Date", rule: "FREQ=YEARLY;UNTIL=20301231", want: "FREQ=YEARLY;UNTIL=20301231T235959Z"},
+		{name: "Missing Frequency", rule: "INTERVAL=2", wantErr: true},
+		{name: "Unknown Frequency", rule: "FREQ=HOURLY", wantErr: true},
+		{name: "Zero Interval", rule: "FREQ=DAILY;INTERVAL=0", wantErr: true},
+		{name: "Unknown Day", rule: "FREQ=WEEKLY;BYDAY=XX", wantErr: true},
+		{name: "Month Day Out Of Range", rule: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: true},
+		{name: "Month Day Of Weekly Schedule", rule: "FREQ=WEEKLY;BYMONTHDAY=1", wantErr: true},
+		{name: "Count And Until", rule: "FREQ=DAILY;COUNT=2;UNTIL=20301231", wantErr: true},
+		{name: "Unsupported Part", rule: "FREQ=DAILY;BYHOUR=9", wantErr: true},
+		{name: "Malformed", rule: "FREQ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSchedule(tt.rule, start)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Rule() != tt.want {
+				t.Errorf("ParseSchedule().Rule() = %q, want %q", got.Rule(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedule_Occurrences(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		want  []time.Time
+	}{
+		{
+			name:  "Daily",
+			rule:  "FREQ=DAILY;INTERVAL=3",
+			start: date(2026, time.February, 27),
+			want:  []time.Time{date(2026, time.February, 27), date(2026, time.March, 2), date(2026, time.March, 5)},
+		},
+		{
+			name:  "Every Two Weeks On Start's Day",
+			rule:  "FREQ=WEEKLY;INTERVAL=2",
+			start: date(2026, time.October, 16), // A Friday
+			want:  []time.Time{date(2026, time.October, 16), date(2026, time.October, 30), date(2026, time.November, 13)},
+		},
+		{
+			name:  "Weekly On Several Days",
+			rule:  "FREQ=WEEKLY;BYDAY=FR,MO",
+			start: date(2026, time.October, 14), // A Wednesday
+			want:  []time.Time{date(2026, time.October, 16), date(2026, time.October, 19), date(2026, time.October, 23)},
+		},
+		{
+			name:  "Monthly On The 1st",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=1",
+			start: date(2026, time.October, 17),
+			want:  []time.Time{date(2026, time.November, 1), date(2026, time.December, 1), date(2027, time.January, 1)},
+		},
+		{
+			name:  "Monthly On The 31st",
+			rule:  "FREQ=MONTHLY",
+			start: date(2026, time.January, 31),
+			want:  []time.Time{date(2026, time.January, 31), date(2026, time.February, 28), date(2026, time.March, 31)},
+		},
+		{
+			name:  "Last Day Of Every Other Month",
+			rule:  "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=-1",
+			start: date(2026, time.April, 1),
+			want:  []time.Time{date(2026, time.April, 30), date(2026, time.June, 30), date(2026, time.August, 31)},
+		},
+		{
+			name:  "Yearly On A Leap Day",
+			rule:  "FREQ=YEARLY",
+			start: date(2028, time.February, 29),
+			want:  []time.Time{date(2028, time.February, 29), date(2029, time.February, 28), date(2030, time.February, 28)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.rule, tt.start)
+			if err != nil {
+				t.Fatalf("ParseSchedule() error = %v", err)
+			}
+			got := s.First()
+			for i, want := range tt.want {
+				if !got.Equal(want) {
+					t.Fatalf("occurrence %d = %v, want %v", i, got, want)
+				}
+				got = s.After(got)
+			}
+		})
+	}
+}
+
+func TestSchedule_After_LongAfterStart(t *testing.T) {
+	s, err := ParseSchedule("FREQ=DAILY", time.Date(2000, time.January, 1, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+	after := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	if got, want := s.After(after), time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("After(%v) = %v, want %v", after, got, want)
+	}
+}
+
+func TestSchedule_Ended(t *testing.T) {
+	start := time.Date(2026, time.January, 1, 9, 0, 0, 0, time.UTC)
+	counted, _ := ParseSchedule("FREQ=DAILY;COUNT=2", start)
+	until, _ := ParseSchedule("FREQ=DAILY;UNTIL=20260102", start)
+
+	if counted.Ended(start, 1) || !counted.Ended(start, 2) {
+		t.Error("COUNT=2 schedule should end after two occurrences")
+	}
+	if until.Ended(start.AddDate(0, 0, 1), 5) || !until.Ended(start.AddDate(0, 0, 2), 0) {
+		t.Error("UNTIL=20260102 schedule should include the whole of the 2nd and nothing after")
+	}
+}
@@ -0,0 +1,239 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// SplitType describes how an expense is divided between its SplitBetween users
+// and how the values in SplitRate and SplitAdjustments are interpreted.
+type SplitType string
+
+const (
+	// SplitEqual divides the amount equally. SplitRate holds a 1 per user.
+	SplitEqual SplitType = "Equal"
+	// SplitExact assigns each user a fixed amount. SplitRate holds each user's
+	// amount in minor units and must add up to the expense Amount.
+	SplitExact SplitType = "Exact"
+	// SplitPercentage assigns each user a percentage of the amount. SplitRate
+	// holds hundredths of a percent (3333 is 33.33%) and must add up to 100%.
+	SplitPercentage SplitType = "Percentage"
+	// SplitShares divides the amount by relative weights held in SplitRate.
+	// This is the default for expenses created without a split type.
+	SplitShares SplitType = "Shares"
+	// SplitAdjustment charges each user the amount in SplitAdjustments and
+	// divides whatever is left equally. SplitRate holds a 1 per user.
+	SplitAdjustment SplitType = "Adjustment"
+	// SplitItemized derives each user's share from receipt line items and
+	// charges. SplitRate holds each user's derived amount in minor units.
+	SplitItemized SplitType = "Itemized"
+)
+
+// percentTotal is 100% expressed in hundredths of a percent.
+const percentTotal = 100 * 100
+
+// ParseSplitType validates a split type name. An empty name selects SplitShares,
+// which matches how expenses were split before split types existed.
+func ParseSplitType(name string) (SplitType, error) {
+	switch splitType := SplitType(name); splitType {
+	case "":
+		return SplitShares, nil
+	case SplitEqual, SplitExact, SplitPercentage, SplitShares, SplitAdjustment, SplitItemized:
+		return splitType, nil
+	default:
+		return "", fmt.Errorf("unknown split type %q", name)
+	}
+}
+
+// NewSplitExpense creates an expense of amount in currency, split according to
+// splitType. The values are the user supplied split values in SplitBetween
+// order: amounts for Exact and Adjustment, percentages for Percentage and
+// weights for Shares. Equal takes no values. The split is validated before the
+// expense is returned.
+func NewSplitExpense(amount Money, currency Currency, paidBy *User, splitBetween []*User, splitType SplitType, values []string) (*Expense, error) {
+	splitRate, adjustments, err := parseSplitValues(splitType, len(splitBetween), values)
+	if err != nil {
+		return nil, err
+	}
+
+	expense := NewExpense(amount, paidBy, splitBetween, splitRate)
+	expense.Currency = currency
+	expense.SplitType = splitType
+	expense.SplitAdjustments = adjustments
+	if err := expense.ValidateSplit(); err != nil {
+		return nil, err
+	}
+	return expense, nil
+}
+
+func parseSplitValues(splitType SplitType, users int, values []string) ([]int64, []Money, error) {
+	if splitType == SplitItemized {
+		return nil, nil, errors.New("itemized expenses are created from line items")
+	}
+	if splitType != SplitEqual && len(values) != users {
+		return nil, nil, fmt.Errorf("expected %d split values, got %d", users, len(values))
+	}
+
+	switch splitType {
+	case SplitEqual:
+		return equalRates(users), nil, nil
+	case SplitShares:
+		rates, err := ParseSplitRates(values)
+		return rates, nil, err
+	case SplitExact:
+		rates := make([]int64, users)
+		for i, value := range values {
+			amount, err := ParseMoney(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			rates[i] = int64(amount)
+		}
+		return rates, nil, nil
+	case SplitPercentage:
+		rates := make([]int64, users)
+		for i, value := range values {
+			percent, err := parseDecimal(value, 2)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid percentage %q: %w", value, err)
+			}
+			rates[i] = percent
+		}
+		return rates, nil, nil
+	case SplitAdjustment:
+		adjustments := make([]Money, users)
+		for i, value := range values {
+			amount, err := ParseMoney(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			adjustments[i] = amount
+		}
+		return equalRates(users), adjustments, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown split type %q", splitType)
+	}
+}
+
+// SplitValues returns the expense's split values in SplitBetween order, in the
+// form NewSplitExpense accepts them, so that an existing split can be shown and
+// edited. Equal and Itemized splits have no split values.
+func (e *Expense) SplitValues() []string {
+	var values []string
+	switch e.splitType() {
+	case SplitShares:
+		for _, rate := range e.SplitRate {
+			values = append(values, strconv.FormatInt(rate, 10))
+		}
+	case SplitExact:
+		for _, rate := range e.SplitRate {
+			values = append(values, Money(rate).String())
+		}
+	case SplitPercentage:
+		for _, rate := range e.SplitRate {
+			values = append(values, formatDecimal(rate, 2))
+		}
+	case SplitAdjustment:
+		for _, adjustment := range e.SplitAdjustments {
+			values = append(values, adjustment.String())
+		}
+	}
+	return values
+}
+
+func equalRates(users int) []int64 {
+	rates := make([]int64, users)
+	for i := range rates {
+		rates[i] = 1
+	}
+	return rates
+}
+
+// ValidateSplit checks that the split values are consistent with the split
+// type, e.g. that percentages add up to 100 and exact amounts add up to Amount.
+func (e *Expense) ValidateSplit() error {
+	if len(e.SplitBetween) == 0 {
+		return errors.New("splitBetween cannot be empty")
+	}
+	if len(e.SplitRate) != len(e.SplitBetween) {
+		return errors.New("splitRate length must be equal to splitBetween")
+	}
+
+	if err := e.Currency.ValidateAmount(e.Amount); err != nil {
+		return err
+	}
+
+	var total int64
+	for _, rate := range e.SplitRate {
+		if rate < 0 {
+			return errors.New("split values cannot be negative")
+		}
+		if rate > math.MaxInt64-total {
+			return errors.New("split values add up to more than can be held")
+		}
+		total += rate
+	}
+
+	switch e.splitType() {
+	case SplitEqual:
+		for _, rate := range e.SplitRate {
+			if rate != 1 {
+				return errors.New("equal splits must weight every user the same")
+			}
+		}
+	case SplitShares:
+		if total == 0 {
+			return errors.New("at least one share must be greater than zero")
+		}
+	case SplitExact:
+		for _, rate := range e.SplitRate {
+			if err := e.Currency.ValidateAmount(Money(rate)); err != nil {
+				return err
+			}
+		}
+		if Money(total) != e.Amount {
+			return fmt.Errorf("exact amounts add up to %s, expected %s", Money(total), e.Amount)
+		}
+	case SplitItemized:
+		if len(e.Items) == 0 {
+			return errors.New("itemized expenses need at least one item")
+		}
+		if Money(total) != e.Amount {
+			return fmt.Errorf("item shares add up to %s, expected %s", Money(total), e.Amount)
+		}
+	case SplitPercentage:
+		if total != percentTotal {
+			return fmt.Errorf("percentages add up to %s, expected 100", formatDecimal(total, 2))
+		}
+	case SplitAdjustment:
+		if len(e.SplitAdjustments) != len(e.SplitBetween) {
+			return errors.New("splitAdjustments length must be equal to splitBetween")
+		}
+		for _, adjustment := range e.SplitAdjustments {
+			if err := e.Currency.ValidateAmount(adjustment); err != nil {
+				return err
+			}
+		}
+		shares, err := e.Shares()
+		if err != nil {
+			return err
+		}
+		for i, share := range shares {
+			if share < 0 {
+				return fmt.Errorf("adjustments leave user %d with a negative share", e.SplitBetween[i].Id)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown split type %q", e.SplitType)
+	}
+	return nil
+}
+
+func (e *Expense) splitType() SplitType {
+	if e.SplitType == "" {
+		return SplitShares
+	}
+	return e.SplitType
+}
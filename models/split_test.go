@@ -0,0 +1,80 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewSplitExpense(t *testing.T) {
+	a := &User{Id: 1, Name: "A"}
+	b := &User{Id: 2, Name: "B"}
+	c := &User{Id: 3, Name: "C"}
+	users := []*User{a, b, c}
+
+	tests := []struct {
+		name       string
+		amount     Money
+		currency   Currency
+		splitType  SplitType
+		values     []string
+		wantShares []Money
+		wantErr    bool
+	}{
+		{name: "Equal", amount: 100000, splitType: SplitEqual, wantShares: []Money{33340, 33330, 33330}},
+		{name: "Exact", amount: 100000, splitType: SplitExact, values: []string{"50", "25.50", "24.50"}, wantShares: []Money{50000, 25500, 24500}},
+		{name: "Exact Not Adding Up", amount: 100000, splitType: SplitExact, values: []string{"50", "25", "24"}, wantErr: true},
+		{name: "Percentage", amount: 200000, splitType: SplitPercentage, values: []string{"50", "30", "20"}, wantShares: []Money{100000, 60000, 40000}},
+		{name: "Fractional Percentage", amount: 100000, splitType: SplitPercentage, values: []string{"33.33", "33.33", "33.34"}, wantShares: []Money{33330, 33330, 33340}},
+		{name: "Percentage Not Adding Up", amount: 100000, splitType: SplitPercentage, values: []string{"50", "30", "30"}, wantErr: true},
+		{name: "Shares", amount: 120000, splitType: SplitShares, values: []string{"1", "2", "3"}, wantShares: []Money{20000, 40000, 60000}},
+		{name: "Shares Overflowing", amount: 120000, splitType: SplitShares, values: []string{"922337203685477", "922337203685477", "0.0001"}, wantErr: true},
+		{name: "Largest Shares", amount: 120000, splitType: SplitShares, values: []string{"922337203685475", "0.0001", "0.0001"}, wantShares: []Money{120000, 0, 0}},
+		{name: "Exact Too Large", amount: 100000, splitType: SplitExact, values: []string{"1000000000001", "0", "0"}, wantErr: true},
+		{name: "All Zero Shares", amount: 120000, splitType: SplitShares, values: []string{"0", "0", "0"}, wantErr: true},
+		{name: "Adjustment", amount: 100000, splitType: SplitAdjustment, values: []string{"10", "0", "-10"}, wantShares: []Money{43340, 33330, 23330}},
+		{name: "Adjustment Leaving Negative Share", amount: 30000, splitType: SplitAdjustment, values: []string{"0", "0", "-20"}, wantErr: true},
+		{name: "Too Few Values", amount: 100000, splitType: SplitExact, values: []string{"100"}, wantErr: true},
+		{name: "Invalid Value", amount: 100000, splitType: SplitPercentage, values: []string{"50", "fifty", "0"}, wantErr: true},
+		{name: "Whole Yen", amount: 100000, currency: "JPY", splitType: SplitEqual, wantShares: []Money{34000, 33000, 33000}},
+		{name: "Yen With Decimals", amount: 100500, currency: "JPY", splitType: SplitEqual, wantErr: true},
+		{name: "Cents In Dollars", amount: 100005, currency: "USD", splitType: SplitEqual, wantErr: true},
+		{name: "Fils", amount: 1000, currency: "KWD", splitType: SplitEqual, wantShares: []Money{334, 333, 333}},
+		{name: "Exact Fils", amount: 1000, currency: "KWD", splitType: SplitExact, values: []string{"0.5", "0.25", "0.25"}, wantShares: []Money{500, 250, 250}},
+		{name: "Exact Yen With Decimals", amount: 100000, currency: "JPY", splitType: SplitExact, values: []string{"50.5", "25", "24.5"}, wantErr: true},
+		{name: "Adjustment Yen With Decimals", amount: 100000, currency: "JPY", splitType: SplitAdjustment, values: []string{"0.5", "0", "-0.5"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewSplitExpense(tt.amount, tt.currency, a, users, tt.splitType, tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSplitExpense() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.SplitType != tt.splitType {
+				t.Errorf("NewSplitExpense() SplitType = %v, want %v", got.SplitType, tt.splitType)
+			}
+			shares, err := got.Shares()
+			if err != nil {
+				t.Fatalf("Shares() error = %v", err)
+			}
+			if !reflect.DeepEqual(shares, tt.wantShares) {
+				t.Errorf("Shares() = %v, want %v", shares, tt.wantShares)
+			}
+		})
+	}
+}
+
+func TestParseSplitType(t *testing.T) {
+	if got, err := ParseSplitType(""); err != nil || got != SplitShares {
+		t.Errorf("ParseSplitType(\"\") = %v, %v", got, err)
+	}
+	if got, err := ParseSplitType("Percentage"); err != nil || got != SplitPercentage {
+		t.Errorf("ParseSplitType(Percentage) = %v, %v", got, err)
+	}
+	if _, err := ParseSplitType("Halves"); err == nil {
+		t.Error("ParseSplitType(Halves) expected an error")
+	}
+}
@@ -8,9 +8,11 @@ var (
 )
 
 type User struct {
-	Name    string
-	Balance float64
-	Id      int32
+	Name         string
+	Balance      Money `json:"-"` // Sum over every group, so never served to other users
+	Id           int32
+	Email        string `json:",omitempty"` // Used to sign in, empty for users who can't
+	PasswordHash []byte `json:"-"`          // bcrypt hash of the user's password
 }
 
 func NewUser(name string) *User {
@@ -20,7 +22,17 @@ func NewUser(name string) *User {
 	mu.Unlock()
 	return &User{
 		Name:    name,
-		Balance: 0.0,
+		Balance: 0,
 		Id:      id,
 	}
 }
+
+// ResumeUserIDs makes NewUser number new users after id, so that users loaded
+// from storage keep their IDs.
+func ResumeUserIDs(id int32) {
+	mu.Lock()
+	defer mu.Unlock()
+	if id > userIDCounter {
+		userIDCounter = id
+	}
+}
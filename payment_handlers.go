@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/journal"
+	"splitwise/models"
+	"splitwise/store"
+)
+
+func createPayment(c echo.Context) error {
+	req := createPaymentRequest{payer: currentUser(c)}
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	// Find the group of the expenses associated with the payment. A payment
+	// without expenses settles up the payer's balance in the given group instead.
+	// Groups the payer isn't a member of are reported as missing.
+	var paymentGroup *group.Group
+	var missing error
+	if len(req.expenses) > 0 {
+		// Payments settle debts inside a single group
+		paymentGroup = findGroupByExpense(req.expenses[0])
+		for i, expense := range req.expenses[1:] {
+			if findGroupByExpense(expense) != paymentGroup {
+				return invalid(fmt.Sprintf("expenses[%d]", i+1), "All expenses in a payment must belong to the same group")
+			}
+		}
+		if paymentGroup == nil {
+			return internalError("Error finding payment group", fmt.Errorf("expense %d has no group", req.expenses[0].ID))
+		}
+		missing = newAPIError(http.StatusBadRequest, codeNotFound, "expenses[0]", fmt.Sprintf("Expense %d not found", req.expenses[0].ID))
+		if req.Group != 0 && paymentGroup.ID != req.Group {
+			return invalid("groupId", "Expenses do not belong to the payment group")
+		}
+	} else {
+		missing = newAPIError(http.StatusBadRequest, codeNotFound, "groupId", fmt.Sprintf("Group %d not found", req.Group))
+		paymentGroup = findGroupByID(req.Group)
+		if paymentGroup == nil {
+			return missing
+		}
+	}
+	if err := authorize(c, paymentGroup, addEntries, missing); err != nil {
+		return err
+	}
+	if !paymentGroup.HasMember(req.payee.Id) {
+		return invalid("payee", fmt.Sprintf("User %d is not a member of group %d", req.payee.Id, paymentGroup.ID))
+	}
+
+	// Payments are in the currency of the expenses they settle
+	currency := paymentGroup.Currency
+	if len(req.expenses) > 0 {
+		currency = req.expenses[0].Currency
+		if req.currency != "" && req.currency != currency {
+			return invalid("currency", fmt.Sprintf("Payments must be in %s, the currency of the expenses they settle", currency))
+		}
+	} else if req.currency != "" {
+		currency = req.currency
+	}
+	var v validation
+	rate := validateRate(&v, currency, paymentGroup)
+	if err := currency.ValidateAmount(req.Amount); err != nil {
+		v.add(codeInvalid, "amount", err.Error())
+	}
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	// Create the payment
+	payment := models.NewPayment(req.payer, req.payee, req.Amount, req.Mode, req.Identifier, req.Note, req.expenses)
+	payment.GroupID = paymentGroup.ID
+	payment.Currency, payment.Rate = currency, rate
+
+	// Work out how the payment settles the expenses before anything changes,
+	// so that an invalid payment leaves both the expenses and the journal as
+	// they were
+	var allocations []models.Allocation
+	if len(req.expenses) > 0 {
+		var err error
+		allocations, err = payment.PlanSettlement()
+		if err != nil {
+			return invalid("expenses", err.Error())
+		}
+	}
+
+	// Save the payment together with the expenses it settles. Listed expenses
+	// the payment runs out before are dropped from it, since it settles nothing
+	// on them.
+	actor := currentUser(c).Id
+	states, err := expenseStates(payment.Expenses)
+	if err != nil {
+		return auditStateError(err)
+	}
+	payment.ApplySettlement(allocations)
+	if err := db.Transaction(func(tx *store.Store) error {
+		if err := tx.Payments.Add(payment); err != nil {
+			return err
+		}
+		if err := updateExpenses(tx, payment.Expenses); err != nil {
+			return err
+		}
+		changes := append([]auditChange{{audit.Created, payment, nil}}, expenseChanges(payment.Expenses, states)...)
+		return recordAudit(tx, actor, changes...)
+	}); err != nil {
+		payment.RevertSettlement()
+		return internalError("Error storing payment", err)
+	}
+
+	// A payment that can't be posted is taken back out of the store, and the
+	// expenses it settled are saved as they were
+	if _, err := balanceJournal.PostPayment(paymentGroup.ID, paymentGroup.Currency, payment); err != nil {
+		if err := removePayment(actor, payment); err != nil {
+			errorLogger.Println("Error removing payment", payment.ID, "that couldn't be posted:", err)
+		}
+		return internalError("Error posting payment to journal", err)
+	}
+
+	recordActivity(paymentGroup.ID, req.payer.Id, group.Created, group.PaymentSubject, payment.ID, "Made a "+describePayment(payment))
+	infoLogger.Println("Created Payment")
+	return c.JSON(http.StatusCreated, payment)
+}
+
+func getPayment(c echo.Context) error {
+	payment, _, err := paymentFromParam(c)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Payment Retrieved With Id: ", payment.ID)
+	return c.JSON(http.StatusOK, payment)
+}
+
+// listPayments handles GET /v1/payments, which lists the payments in the
+// signed in user's groups.
+func listPayments(c echo.Context) error {
+	q := newListQuery(c)
+	filter := newPaymentFilter(q)
+	groups, err := memberGroups(currentUser(c))
+	if err != nil {
+		return internalError("Error listing groups", err)
+	}
+	payments, err := db.Payments.List()
+	if err != nil {
+		return internalError("Error listing payments", err)
+	}
+	var matching []*models.Payment
+	for _, payment := range payments {
+		if groups[payment.GroupID] != nil && filter.matches(payment) {
+			matching = append(matching, payment)
+		}
+	}
+	result, err := paginate(q, matching, paymentSorts)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Listing Payments")
+	return c.JSON(http.StatusOK, result)
+}
+
+// paymentFilter selects payments by the query parameters of a list request.
+type paymentFilter struct {
+	group       int32
+	payer       int32
+	payee       int32
+	participant int32 // Only payments this user made or received
+	timestamp   timeRange
+	amount      amountRange
+}
+
+func newPaymentFilter(q *listQuery) paymentFilter {
+	return paymentFilter{
+		group:       q.id("group"),
+		payer:       q.id("payer"),
+		payee:       q.id("payee"),
+		participant: q.id("participant"),
+		timestamp:   q.timeRange("from", "to"),
+		amount:      q.amountRange("minAmount", "maxAmount"),
+	}
+}
+
+func (f paymentFilter) matches(payment *models.Payment) bool {
+	if f.group != 0 && payment.GroupID != f.group {
+		return false
+	}
+	if f.payer != 0 && payment.Payer.Id != f.payer {
+		return false
+	}
+	if f.payee != 0 && payment.Payee.Id != f.payee {
+		return false
+	}
+	if f.participant != 0 && payment.Payer.Id != f.participant && payment.Payee.Id != f.participant {
+		return false
+	}
+	return f.timestamp.contains(payment.Timestamp) && f.amount.contains(payment.Amount)
+}
+
+// paymentSorts are the orders payments can be listed in.
+var paymentSorts = sortFields[*models.Payment]{
+	"id":        func(p *models.Payment) sortKey { return sortKey{ID: p.ID} },
+	"timestamp": func(p *models.Payment) sortKey { return sortKey{Number: p.Timestamp.UnixNano(), ID: p.ID} },
+	"amount":    func(p *models.Payment) sortKey { return sortKey{Number: int64(p.Amount), ID: p.ID} },
+}
+
+// updatePayment handles PUT /v1/payments/:id, which replaces the payment's
+// mode, identifier and note.
+func updatePayment(c echo.Context) error {
+	payment, g, err := paymentFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := authorizeChange(c, g, payment.Payer, nil); err != nil {
+		return err
+	}
+	var req updatePaymentRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	state, err := auditState(payment)
+	if err != nil {
+		return auditStateError(err)
+	}
+	previous := *payment
+	payment.Mode, payment.Identifier, payment.Note = req.Mode, req.Identifier, req.Note
+	if err := savePayment(currentUser(c).Id, payment, state); err != nil {
+		payment.Mode, payment.Identifier, payment.Note = previous.Mode, previous.Identifier, previous.Note
+		return internalError("Error storing payment", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Updated, group.PaymentSubject, payment.ID, "Changed the details of the "+describePayment(payment))
+	infoLogger.Println("Updated Payment With Id: ", payment.ID)
+	return c.JSON(http.StatusOK, payment)
+}
+
+// deletePayment handles DELETE /v1/payments/:id. The expenses the payment
+// settled become outstanding again, and its effect on balances is reversed in
+// the journal.
+func deletePayment(c echo.Context) error {
+	payment, g, err := paymentFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := authorizeChange(c, g, payment.Payer, nil); err != nil {
+		return err
+	}
+
+	// The payment's effect on balances is reversed first, so that it is only
+	// deleted once the reversal is saved. If it can't be deleted, it is posted
+	// again.
+	if _, err := balanceJournal.Reverse(journal.PaymentEntry, payment.ID); err != nil {
+		return internalError("Error reversing payment in the journal", err)
+	}
+
+	if err := removePayment(currentUser(c).Id, payment); err != nil {
+		if _, err := balanceJournal.PostPayment(g.ID, g.Currency, payment); err != nil {
+			errorLogger.Println("Error posting payment", payment.ID, "back to the journal:", err)
+		}
+		return internalError("Error deleting payment", err)
+	}
+	releaseBlobs(payment.Attachments)
+	recordActivity(g.ID, currentUser(c).Id, group.Deleted, group.PaymentSubject, payment.ID, "Deleted the "+describePayment(payment))
+
+	infoLogger.Println("Deleted Payment With Id: ", payment.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// updateExpenses saves expenses changed by settling or unsettling a payment.
+func updateExpenses(tx *store.Store, expenses []*models.Expense) error {
+	for _, expense := range expenses {
+		if err := tx.Expenses.Update(expense); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// savePayment stores the changes the user with the ID actor made to the
+// payment, together with their entry in the audit log. state is the payment's
+// auditState before them.
+func savePayment(actor int32, payment *models.Payment, state json.RawMessage) error {
+	return db.Transaction(func(tx *store.Store) error {
+		if err := tx.Payments.Update(payment); err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditChange{audit.Updated, payment, state})
+	})
+}
+
+// removePayment deletes the payment and unsettles the expenses it settled, in
+// memory and in the store, recording both in the audit log. The expenses are
+// left settled if that can't be saved.
+func removePayment(actor int32, payment *models.Payment) error {
+	state, err := auditState(payment)
+	if err != nil {
+		return err
+	}
+	states, err := expenseStates(payment.Expenses)
+	if err != nil {
+		return err
+	}
+	allocations := payment.Allocations
+	payment.RevertSettlement()
+	if err := db.Transaction(func(tx *store.Store) error {
+		if err := tx.Payments.Delete(payment.ID); err != nil {
+			return err
+		}
+		if err := updateExpenses(tx, payment.Expenses); err != nil {
+			return err
+		}
+		changes := append([]auditChange{{audit.Deleted, payment, state}}, expenseChanges(payment.Expenses, states)...)
+		return recordAudit(tx, actor, changes...)
+	}); err != nil {
+		payment.ApplySettlement(allocations)
+		return err
+	}
+	return nil
+}
+
+// expenseStates returns the auditState of each of the expenses by ID, before
+// a payment settles or unsettles them.
+func expenseStates(expenses []*models.Expense) (map[int]json.RawMessage, error) {
+	states := make(map[int]json.RawMessage, len(expenses))
+	for _, expense := range expenses {
+		state, err := auditState(expense)
+		if err != nil {
+			return nil, err
+		}
+		states[expense.ID] = state
+	}
+	return states, nil
+}
+
+// expenseChanges returns the changes a payment made to the expenses it
+// settles or unsettles for the audit log, given their expenseStates from
+// before.
+func expenseChanges(expenses []*models.Expense, before map[int]json.RawMessage) []auditChange {
+	changes := make([]auditChange, len(expenses))
+	for i, expense := range expenses {
+		changes[i] = auditChange{audit.Updated, expense, before[expense.ID]}
+	}
+	return changes
+}
+
+// paymentFromParam finds the payment named by the :id path parameter and the
+// group it is in, which the signed in user must be able to see.
+func paymentFromParam(c echo.Context) (*models.Payment, *group.Group, error) {
+	id, err := idParam(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	missing := notFound("id", fmt.Sprintf("Payment %d not found", id))
+	payment, err := db.Payments.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil, missing
+	} else if err != nil {
+		return nil, nil, internalError("Error reading payment", err)
+	}
+	g := findGroupByID(payment.GroupID)
+	if g == nil {
+		return nil, nil, internalError("Error finding payment group", fmt.Errorf("payment %d has no group", payment.ID))
+	}
+	if err := authorize(c, g, viewGroup, missing); err != nil {
+		return nil, nil, err
+	}
+	return payment, g, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"splitwise/group"
+	"splitwise/models"
+)
+
+// action is something a member does in a group, described so that it reads
+// well in an error message.
+type action string
+
+const (
+	viewGroup        action = "see the group"
+	addEntries       action = "add expenses or payments"
+	changeOwnEntries action = "change your own expenses or payments"
+	changeAnyEntries action = "change other members' expenses or payments"
+	addComments      action = "comment on expenses and payments"
+	moderateComments action = "delete other members' comments"
+	renameGroup      action = "rename the group"
+	manageRates      action = "change the group's currency or exchange rates"
+	manageCategories action = "change the group's categories or the rules that assign them"
+	manageMembers    action = "add, remove or change the role of members, or transfer their debts"
+	manageAdmins     action = "grant or revoke the admin or owner role"
+	removeGroup      action = "delete the group"
+)
+
+// policy is the least role each action needs. Every handler that reads or
+// changes something in a group checks it through authorize.
+var policy = map[action]group.Role{
+	viewGroup:        group.Viewer,
+	addEntries:       group.Member,
+	changeOwnEntries: group.Member,
+	changeAnyEntries: group.Admin,
+	addComments:      group.Member,
+	moderateComments: group.Admin,
+	renameGroup:      group.Admin,
+	manageRates:      group.Admin,
+	manageCategories: group.Admin,
+	manageMembers:    group.Admin,
+	manageAdmins:     group.Owner,
+	removeGroup:      group.Owner,
+}
+
+// authorize returns an error unless the signed in user's role in the group
+// allows the action. Users who aren't members can't tell the group exists, so
+// they get hidden, the error for whatever the request named being missing, if
+// it isn't nil.
+func authorize(c echo.Context, g *group.Group, a action, hidden error) error {
+	role := g.RoleOf(currentUser(c).Id)
+	switch {
+	case role == "" && hidden != nil:
+		return hidden
+	case role == "":
+		return forbidden(fmt.Sprintf("Only members of group %d can %s", g.ID, a))
+	case !role.AtLeast(policy[a]):
+		return forbidden(fmt.Sprintf("As a %s of group %d you can't %s", role, g.ID, a))
+	}
+	return nil
+}
+
+// authorizeChange returns an error unless the signed in user may change an
+// expense or payment paid by payer in the group.
+func authorizeChange(c echo.Context, g *group.Group, payer *models.User, hidden error) error {
+	if payer.Id == currentUser(c).Id {
+		return authorize(c, g, changeOwnEntries, hidden)
+	}
+	return authorize(c, g, changeAnyEntries, hidden)
+}
+
+// memberGroups returns the groups the user is a member of, by ID.
+func memberGroups(user *models.User) (map[int32]*group.Group, error) {
+	groups, err := db.Groups.List()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int32]*group.Group)
+	for _, g := range groups {
+		if g.HasMember(user.Id) {
+			result[g.ID] = g
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/group"
+	"splitwise/models"
+)
+
+// exchangeRate returns what one unit of currency is worth in the group's
+// currency, and whether it is known. Rates the group entered by hand take
+// precedence over those loaded from RATES_FILE.
+func exchangeRate(g *group.Group, currency models.Currency) (models.Rate, bool) {
+	if currency == g.Currency {
+		return models.OneRate, true
+	}
+	if rate, ok := g.Rates[currency]; ok {
+		return rate, true
+	}
+	return exchangeRates.Rate(currency, g.Currency)
+}
+
+// groupRates is the response body of getGroupRates and updateGroupRates. Each
+// rate is what one unit of a currency is worth in the group's currency.
+type groupRates struct {
+	Group    int32 // ID of the group
+	Currency models.Currency
+	Rates    map[models.Currency]models.Rate // Entered by hand
+	Loaded   map[models.Currency]models.Rate // From RATES_FILE, for currencies without a rate entered by hand
+}
+
+// newGroupRates returns every exchange rate the group's expenses and payments
+// can be converted at.
+func newGroupRates(g *group.Group) groupRates {
+	result := groupRates{Group: g.ID, Currency: g.Currency, Rates: make(map[models.Currency]models.Rate), Loaded: exchangeRates.RatesTo(g.Currency)}
+	for currency, rate := range g.Rates {
+		result.Rates[currency] = rate
+		delete(result.Loaded, currency)
+	}
+	return result
+}
+
+// getGroupRates handles GET /v1/groups/:id/rates.
+func getGroupRates(c echo.Context) error {
+	g, err := groupFromParam(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Retrieved Exchange Rates For Group: ", g.ID)
+	return c.JSON(http.StatusOK, newGroupRates(g))
+}
+
+// updateGroupRates handles PUT /v1/groups/:id/rates, which replaces the rates
+// entered by hand. Expenses and payments already made keep the rates they
+// were made at.
+func updateGroupRates(c echo.Context) error {
+	g, err := groupFromParam(c, manageRates)
+	if err != nil {
+		return err
+	}
+	req := ratesRequest{group: g}
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	state, err := auditState(g)
+	if err != nil {
+		return auditStateError(err)
+	}
+	rates := g.Rates
+	g.Rates = req.rates
+	if len(g.Rates) == 0 {
+		g.Rates = nil
+	}
+	if err := saveGroup(currentUser(c).Id, g, state); err != nil {
+		g.Rates = rates
+		return internalError("Error storing group", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Updated, group.GroupSubject, int(g.ID), "Changed the exchange rates")
+	infoLogger.Println("Updated Exchange Rates For Group: ", g.ID)
+	return c.JSON(http.StatusOK, newGroupRates(g))
+}
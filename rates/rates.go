@@ -0,0 +1,101 @@
+// Package rates holds a table of exchange rates loaded from a file, such as
+// one downloaded from an exchange rate service every day.
+package rates
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"splitwise/models"
+)
+
+// Table is a set of exchange rates against a single base currency, in the
+// format of the JSON file it is loaded from:
+//
+//	{"base":"USD","rates":{"EUR":0.92,"INR":83.25}}
+//
+// which says that one US dollar buys 0.92 euros or 83.25 rupees.
+type Table struct {
+	Base  models.Currency
+	Rates map[models.Currency]models.Rate // How much of each currency one unit of Base buys
+}
+
+// Load reads a table from the JSON file at path. Codes that aren't ISO 4217
+// currencies, such as "BTC", are skipped.
+func Load(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// parse reads a table from its JSON form.
+func parse(data []byte) (*Table, error) {
+	var file struct {
+		Base  string                 `json:"base"`
+		Rates map[string]json.Number `json:"rates"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	base, err := models.ParseCurrency(file.Base)
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+	t := &Table{Base: base, Rates: map[models.Currency]models.Rate{base: models.OneRate}}
+	for code, value := range file.Rates {
+		currency, err := models.ParseCurrency(code)
+		if err != nil {
+			continue
+		}
+		rate, err := models.ParseRate(value.String())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", code, err)
+		}
+		if currency != base {
+			t.Rates[currency] = rate
+		}
+	}
+	return t, nil
+}
+
+// Rate returns how much of currency to one unit of currency from is worth, and
+// whether the table has rates for both. A nil table has no rates.
+func (t *Table) Rate(from, to models.Currency) (models.Rate, bool) {
+	if from == to {
+		return models.OneRate, true
+	}
+	if t == nil {
+		return 0, false
+	}
+	fromRate, ok := t.Rates[from]
+	if !ok {
+		return 0, false
+	}
+	toRate, ok := t.Rates[to]
+	if !ok {
+		return 0, false
+	}
+	rate, err := toRate.Over(fromRate)
+	return rate, err == nil
+}
+
+// RatesTo returns how much of currency to one unit of every other currency in
+// the table is worth.
+func (t *Table) RatesTo(to models.Currency) map[models.Currency]models.Rate {
+	result := make(map[models.Currency]models.Rate)
+	if t == nil {
+		return result
+	}
+	for from := range t.Rates {
+		if rate, ok := t.Rate(from, to); ok && from != to {
+			result[from] = rate
+		}
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+package rates
+
+import (
+	"os"
+	"path/filepath"
+	"splitwise/models"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	data := `{"base":"usd","rates":{"INR":83.25,"EUR":0.925,"BTC":0.0000158,"USD":1}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	table, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if table.Base != "USD" || len(table.Rates) != 3 {
+		t.Errorf("Load() = %+v, want USD, INR and EUR rates against USD", table)
+	}
+
+	tests := []struct {
+		name     string
+		from, to models.Currency
+		want     models.Rate
+		wantOK   bool
+	}{
+		{name: "From Base", from: "USD", to: "INR", want: 8325000000, wantOK: true},
+		{name: "To Base", from: "INR", to: "USD", want: 1201201, wantOK: true},
+		{name: "Cross Rate", from: "EUR", to: "INR", want: 9000000000, wantOK: true},
+		{name: "Same Currency", from: "JPY", to: "JPY", want: models.OneRate, wantOK: true},
+		{name: "Missing", from: "JPY", to: "INR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := table.Rate(tt.from, tt.to)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("Rate(%s, %s) = %s, %v, want %s, %v", tt.from, tt.to, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	if got := table.RatesTo("INR"); len(got) != 2 || got["EUR"] != 9000000000 {
+		t.Errorf("RatesTo(INR) = %v, want USD and EUR", got)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "Not JSON", data: `base: USD`},
+		{name: "Unknown Base", data: `{"base":"XYZ","rates":{}}`},
+		{name: "Negative Rate", data: `{"base":"USD","rates":{"INR":-83.25}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rates.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(path); err == nil {
+				t.Error("Load() error = nil, want an error")
+			}
+		})
+	}
+
+	var missing *Table
+	if _, ok := missing.Rate("USD", "INR"); ok {
+		t.Error("Rate() of a nil table = ok, want no rate")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"math"
+	"net/http"
+	"splitwise/group"
+	"splitwise/models"
+	"time"
+)
+
+// createRecurringExpense handles POST /v1/groups/:id/recurring-expenses, which
+// adds an expense to the group every time its schedule comes round. Expenses
+// already due, because the schedule starts in the past, are made straight
+// away.
+func createRecurringExpense(c echo.Context) error {
+	g, err := groupFromParam(c, addEntries)
+	if err != nil {
+		return err
+	}
+	req := recurringExpenseRequest{expenseRequest: expenseRequest{paidBy: currentUser(c), group: g}, start: time.Now()}
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	recurring := models.NewRecurringExpense(g.ID, req.expense, req.schedule)
+	if err := db.RecurringExpenses.Add(recurring); err != nil {
+		return internalError("Error storing recurring expense", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Created, group.RecurringExpenseSubject, recurring.ID, fmt.Sprintf("Added %s recurring %s", describeExpense(recurring.Template), recurring.Rule))
+	if err := makeDue(recurring, time.Now(), currentUser(c).Id); err != nil {
+		return internalError("Error making due expenses", err)
+	}
+
+	infoLogger.Println("Added Recurring Expense to Group:", g.Name)
+	return c.JSON(http.StatusCreated, recurring)
+}
+
+// listRecurringExpenses handles GET /v1/groups/:id/recurring-expenses.
+func listRecurringExpenses(c echo.Context) error {
+	g, err := groupFromParam(c, viewGroup)
+	if err != nil {
+		return err
+	}
+	q := newListQuery(c)
+	all, err := db.RecurringExpenses.List()
+	if err != nil {
+		return internalError("Error listing recurring expenses", err)
+	}
+	var recurring []*models.RecurringExpense
+	for _, r := range all {
+		if r.GroupID == g.ID {
+			recurring = append(recurring, r)
+		}
+	}
+	result, err := paginate(q, recurring, recurringSorts)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Listing Recurring Expenses For Group: ", g.ID)
+	return c.JSON(http.StatusOK, result)
+}
+
+// recurringSorts are the orders recurring expenses can be listed in. Those
+// whose schedule has ended come last when sorted by when they are next due.
+var recurringSorts = sortFields[*models.RecurringExpense]{
+	"id": func(r *models.RecurringExpense) sortKey { return sortKey{ID: r.ID} },
+	"next": func(r *models.RecurringExpense) sortKey {
+		if r.Next == nil {
+			return sortKey{Number: math.MaxInt64, ID: r.ID}
+		}
+		return sortKey{Number: r.Next.UnixNano(), ID: r.ID}
+	},
+}
+
+func getRecurringExpense(c echo.Context) error {
+	recurring, _, err := recurringFromParam(c)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Retrieved Recurring Expense With Id: ", recurring.ID)
+	return c.JSON(http.StatusOK, recurring)
+}
+
+// updateRecurringExpense handles PUT /v1/recurring-expenses/:id, which
+// replaces the expense and schedule. Expenses already made are kept and count
+// towards the new schedule's COUNT; the next one is due at the first
+// occurrence of the new schedule after the last one made.
+func updateRecurringExpense(c echo.Context) error {
+	recurring, g, err := recurringFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := authorizeChange(c, g, recurring.Template.PaidBy, nil); err != nil {
+		return err
+	}
+	req := recurringExpenseRequest{expenseRequest: expenseRequest{paidBy: recurring.Template.PaidBy, group: g}, start: recurring.Schedule.Start}
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	previous := *recurring
+	recurring.Reschedule(req.expense, req.schedule)
+	if err := db.RecurringExpenses.Update(recurring); err != nil {
+		*recurring = previous
+		return internalError("Error storing recurring expense", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Updated, group.RecurringExpenseSubject, recurring.ID, fmt.Sprintf("Changed recurring expense %d to %s recurring %s", recurring.ID, describeExpense(recurring.Template), recurring.Rule))
+	if err := makeDue(recurring, time.Now(), currentUser(c).Id); err != nil {
+		return internalError("Error making due expenses", err)
+	}
+
+	infoLogger.Println("Updated Recurring Expense With Id: ", recurring.ID)
+	return c.JSON(http.StatusOK, recurring)
+}
+
+// deleteRecurringExpense handles DELETE /v1/recurring-expenses/:id, which stops
+// the expense recurring. Expenses already made are kept.
+func deleteRecurringExpense(c echo.Context) error {
+	recurring, g, err := recurringFromParam(c)
+	if err != nil {
+		return err
+	}
+	if err := authorizeChange(c, g, recurring.Template.PaidBy, nil); err != nil {
+		return err
+	}
+	if err := db.RecurringExpenses.Delete(recurring.ID); err != nil {
+		return internalError("Error deleting recurring expense", err)
+	}
+	recordActivity(g.ID, currentUser(c).Id, group.Deleted, group.RecurringExpenseSubject, recurring.ID, fmt.Sprintf("Stopped %s recurring", describeExpense(recurring.Template)))
+	infoLogger.Println("Deleted Recurring Expense With Id: ", recurring.ID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// recurringFromParam finds the recurring expense named by the :id path
+// parameter and the group it adds expenses to, which the signed in user must
+// be able to see.
+func recurringFromParam(c echo.Context) (*models.RecurringExpense, *group.Group, error) {
+	id, err := idParam(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	missing := notFound("id", fmt.Sprintf("Recurring expense %d not found", id))
+	recurring, err := db.RecurringExpenses.Get(id)
+	logLookupError(err)
+	if recurring == nil {
+		return nil, nil, missing
+	}
+	g := findGroupByID(recurring.GroupID)
+	if g == nil {
+		return nil, nil, internalError("Error finding recurring expense group", fmt.Errorf("recurring expense %d has no group", recurring.ID))
+	}
+	if err := authorize(c, g, viewGroup, missing); err != nil {
+		return nil, nil, err
+	}
+	return recurring, g, nil
+}
+
+// recurringExpenseRequest is the body of POST
+// /v1/groups/:id/recurring-expenses and PUT /v1/recurring-expenses/:id. It
+// describes the expense as an expenseRequest does, along with a recurrence
+// rule and, optionally, the RFC 3339 time the schedule starts at, e.g.
+//
+//	{"amount":"1200.00","splitBetween":[1,2],"rule":"FREQ=MONTHLY;BYMONTHDAY=1","start":"2026-11-01T09:00:00Z"}
+//
+// Schedules start when they are created unless start is given, and keep
+// their start when they are updated.
+type recurringExpenseRequest struct {
+	expenseRequest
+	Rule  string     `json:"rule"`
+	Start *time.Time `json:"start"`
+
+	start    time.Time // Used unless Start is given
+	schedule models.Schedule
+}
+
+func (r *recurringExpenseRequest) validate(v *validation) {
+	r.expenseRequest.validate(v)
+	if r.Start != nil {
+		r.start = *r.Start
+	}
+	if r.Rule == "" {
+		v.add(codeRequired, "rule", "Recurrence rule is required")
+		return
+	}
+	schedule, err := models.ParseSchedule(r.Rule, r.start)
+	if err != nil {
+		v.add(codeInvalid, "rule", err.Error())
+	}
+	r.schedule = schedule
+}
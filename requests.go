@@ -0,0 +1,582 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"math"
+	"net/http"
+	"net/mail"
+	"splitwise/auth"
+	"splitwise/group"
+	"splitwise/models"
+	"strconv"
+	"strings"
+)
+
+// validatable is implemented by request bodies, which check themselves once
+// bound and record every problem they find.
+type validatable interface {
+	validate(v *validation)
+}
+
+// bindRequest decodes the JSON request body into req and validates it. Fields
+// the body leaves out keep the values req already has.
+func bindRequest(c echo.Context, req validatable) error {
+	r := c.Request()
+	if r.ContentLength != 0 && !strings.HasPrefix(r.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return newAPIError(http.StatusUnsupportedMediaType, codeInvalidBody, "", "Request body must be JSON")
+	}
+	if err := (&echo.DefaultBinder{}).BindBody(c, req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return newAPIError(http.StatusBadRequest, codeInvalidBody, typeErr.Field, fmt.Sprintf("Expected %s, got %s", typeErr.Type, typeErr.Value))
+		}
+		if internal := errors.Unwrap(err); internal != nil {
+			err = internal
+		}
+		return newAPIError(http.StatusBadRequest, codeInvalidBody, "", err.Error())
+	}
+
+	var v validation
+	req.validate(&v)
+	return v.err()
+}
+
+// validation collects the problems found while validating a request.
+type validation struct {
+	errors []fieldError
+}
+
+func (v *validation) add(code, field, message string) {
+	v.errors = append(v.errors, fieldError{Code: code, Field: field, Message: message})
+}
+
+// check records a problem with the field unless ok.
+func (v *validation) check(ok bool, field, message string) {
+	if !ok {
+		v.add(codeInvalid, field, message)
+	}
+}
+
+// err returns an error responding with every problem found, or nil.
+func (v *validation) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &apiError{Status: http.StatusBadRequest, Errors: v.errors}
+}
+
+// user looks up the user the field refers to.
+func (v *validation) user(field string, id int32) *models.User {
+	if id == 0 {
+		v.add(codeRequired, field, "User ID is required")
+		return nil
+	}
+	user := findUserByID(id)
+	if user == nil {
+		v.add(codeNotFound, field, fmt.Sprintf("User %d not found", id))
+	}
+	return user
+}
+
+// users looks up every user the field lists.
+func (v *validation) users(field string, ids []int32) []*models.User {
+	users := make([]*models.User, len(ids))
+	for i, id := range ids {
+		users[i] = v.user(field+"["+strconv.Itoa(i)+"]", id)
+	}
+	return users
+}
+
+// members looks up every user the field lists, who must all be members of the
+// group.
+func (v *validation) members(field string, ids []int32, g *group.Group) []*models.User {
+	users := v.users(field, ids)
+	for i, user := range users {
+		if user != nil && !g.HasMember(user.Id) {
+			v.add(codeInvalid, field+"["+strconv.Itoa(i)+"]", fmt.Sprintf("User %d is not a member of group %d", user.Id, g.ID))
+		}
+	}
+	return users
+}
+
+// idParam parses the :id path parameter.
+func idParam(c echo.Context) (int, error) {
+	return intParam(c, "id")
+}
+
+// id32Param parses the named path parameter as the ID of a user, group or
+// expense, which are 32-bit.
+func id32Param(c echo.Context, name string) (int32, error) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 32)
+	if err != nil {
+		return 0, invalid(name, "ID must be a 32-bit integer")
+	}
+	return int32(id), nil
+}
+
+// intParam parses the named path parameter as an ID.
+func intParam(c echo.Context, name string) (int, error) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil {
+		return 0, invalid(name, "ID must be an integer")
+	}
+	return id, nil
+}
+
+// createUserRequest is the body of POST /v1/users, which signs a new user up.
+type createUserRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (r *createUserRequest) validate(v *validation) {
+	if r.Name == "" {
+		v.add(codeRequired, "name", "Name is required")
+	}
+	r.Email = normalizeEmail(r.Email)
+	if r.Email == "" {
+		v.add(codeRequired, "email", "Email is required")
+	} else {
+		validateEmail(v, r.Email)
+	}
+	if r.Password == "" {
+		v.add(codeRequired, "password", "Password is required")
+	} else {
+		v.check(len(r.Password) >= auth.MinPasswordLength, "password", auth.ErrPasswordTooShort.Error())
+	}
+}
+
+// validateEmail checks that a normalized email is a plain address.
+func validateEmail(v *validation, email string) {
+	address, err := mail.ParseAddress(email)
+	v.check(err == nil && address.Address == email, "email", "Email must be an address such as name@example.com")
+}
+
+// normalizeEmail returns the form email addresses are stored and looked up
+// in.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginRequest is the body of POST /v1/auth/login.
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (r *loginRequest) validate(v *validation) {
+	if r.Email == "" {
+		v.add(codeRequired, "email", "Email is required")
+	}
+	if r.Password == "" {
+		v.add(codeRequired, "password", "Password is required")
+	}
+}
+
+// refreshRequest is the body of POST /v1/auth/refresh.
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (r *refreshRequest) validate(v *validation) {
+	if r.RefreshToken == "" {
+		v.add(codeRequired, "refreshToken", "Refresh token is required")
+	}
+}
+
+// userRequest is the body of PUT /v1/users/:id.
+type userRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *userRequest) validate(v *validation) {
+	if r.Name == "" {
+		v.add(codeRequired, "name", "Name is required")
+	}
+}
+
+// createGroupRequest is the body of POST /v1/groups. The currency is
+// optional.
+type createGroupRequest struct {
+	Name     string  `json:"name"`
+	Members  []int32 `json:"members"`
+	Currency string  `json:"currency"`
+
+	members  []*models.User
+	currency models.Currency // The default unless Currency is given
+}
+
+func (r *createGroupRequest) validate(v *validation) {
+	if r.Name == "" {
+		v.add(codeRequired, "name", "Name is required")
+	}
+	r.members = v.users("members", r.Members)
+	r.currency = models.DefaultCurrency
+	if r.Currency != "" {
+		r.currency = validateCurrency(v, r.Currency)
+	}
+}
+
+// updateGroupRequest is the body of PUT /v1/groups/:id. Members are changed
+// through /v1/groups/:id/members instead. Leaving the currency out keeps it
+// as it is.
+type updateGroupRequest struct {
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+
+	currency models.Currency
+}
+
+func (r *updateGroupRequest) validate(v *validation) {
+	if r.Name == "" {
+		v.add(codeRequired, "name", "Name is required")
+	}
+	if r.Currency != "" {
+		r.currency = validateCurrency(v, r.Currency)
+	}
+}
+
+// ratesRequest is the body of PUT /v1/groups/:id/rates, which gives what one
+// unit of each currency is worth in the group's currency, e.g.
+//
+//	{"rates":{"USD":"83.25","EUR":"90.10"}}
+type ratesRequest struct {
+	Rates map[string]json.Number `json:"rates"`
+
+	group *group.Group
+	rates map[models.Currency]models.Rate
+}
+
+func (r *ratesRequest) validate(v *validation) {
+	r.rates = make(map[models.Currency]models.Rate, len(r.Rates))
+	for code, value := range r.Rates {
+		field := "rates." + code
+		currency, err := models.ParseCurrency(code)
+		if err != nil {
+			v.add(codeInvalid, field, err.Error())
+			continue
+		}
+		if currency == r.group.Currency {
+			v.add(codeInvalid, field, fmt.Sprintf("%s is the group's own currency", currency))
+			continue
+		}
+		rate, err := models.ParseRate(value.String())
+		if err != nil {
+			v.add(codeInvalid, field, err.Error())
+			continue
+		}
+		r.rates[currency] = rate
+	}
+}
+
+// categoriesRequest is the body of PUT /v1/groups/:id/categories, which
+// replaces the group's categories and the rules that assign them, e.g.
+//
+//	{"categories":["Food","Transport"],"rules":[{"category":"Transport","keywords":["uber","taxi"]},{"category":"Food","paidBy":2}]}
+//
+// Rules are tried in order, and each needs keywords, a payer or both.
+type categoriesRequest struct {
+	Categories []string              `json:"categories"`
+	Rules      []categoryRuleRequest `json:"rules"`
+
+	group      *group.Group
+	categories []string
+	rules      []group.CategoryRule
+}
+
+// categoryRuleRequest is a rule in a categoriesRequest.
+type categoryRuleRequest struct {
+	Category string   `json:"category"`
+	Keywords []string `json:"keywords"`
+	PaidBy   int32    `json:"paidBy"`
+}
+
+func (r *categoriesRequest) validate(v *validation) {
+	if r.Categories == nil {
+		v.add(codeRequired, "categories", "Categories are required, though the list may be empty")
+	}
+	r.categories = []string{}
+	names := make(map[string]string)
+	for i, name := range r.Categories {
+		field := fmt.Sprintf("categories[%d]", i)
+		name = strings.TrimSpace(name)
+		if !validateLabel(v, field, name) {
+			continue
+		}
+		if _, ok := names[strings.ToLower(name)]; ok {
+			v.add(codeInvalid, field, fmt.Sprintf("Category %q is listed more than once", name))
+			continue
+		}
+		names[strings.ToLower(name)] = name
+		r.categories = append(r.categories, name)
+	}
+
+	for i, rule := range r.Rules {
+		field := fmt.Sprintf("rules[%d]", i)
+		category, ok := names[strings.ToLower(strings.TrimSpace(rule.Category))]
+		if !ok {
+			v.add(codeInvalid, field+".category", fmt.Sprintf("Category %q is not one of the categories", rule.Category))
+		}
+		var keywords []string
+		for j, keyword := range rule.Keywords {
+			keyword = strings.TrimSpace(keyword)
+			if keyword == "" {
+				v.add(codeInvalid, fmt.Sprintf("%s.keywords[%d]", field, j), "Keywords can't be blank")
+				continue
+			}
+			keywords = append(keywords, keyword)
+		}
+		if rule.PaidBy != 0 && !r.group.HasMember(rule.PaidBy) {
+			v.add(codeInvalid, field+".paidBy", fmt.Sprintf("User %d is not a member of group %d", rule.PaidBy, r.group.ID))
+		}
+		if len(rule.Keywords) == 0 && rule.PaidBy == 0 {
+			v.add(codeRequired, field, "Rules need keywords, a payer or both")
+		}
+		r.rules = append(r.rules, group.CategoryRule{Category: category, Keywords: keywords, PaidBy: rule.PaidBy})
+	}
+}
+
+// maxLabelLength is the longest a category or tag may be, maxTags the most
+// tags an expense may have, and maxDescriptionLength the longest its
+// description may be.
+const (
+	maxLabelLength       = 40
+	maxTags              = 20
+	maxDescriptionLength = 500
+)
+
+// validateLabel checks a category or tag, which must not be blank or too
+// long, and reports whether it is valid.
+func validateLabel(v *validation, field, label string) bool {
+	switch {
+	case label == "":
+		v.add(codeInvalid, field, "Can't be blank")
+	case len([]rune(label)) > maxLabelLength:
+		v.add(codeInvalid, field, fmt.Sprintf("Must be at most %d characters", maxLabelLength))
+	default:
+		return true
+	}
+	return false
+}
+
+// validateTags checks the tags in the tags field and returns them trimmed, in
+// lower case and without repeats.
+func validateTags(v *validation, tags []string) []string {
+	if len(tags) > maxTags {
+		v.add(codeInvalid, "tags", fmt.Sprintf("Expenses can have at most %d tags", maxTags))
+	}
+	var result []string
+	seen := make(map[string]bool)
+	for i, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !validateLabel(v, fmt.Sprintf("tags[%d]", i), tag) || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+// validateCurrency checks the currency code in the currency field.
+func validateCurrency(v *validation, code string) models.Currency {
+	currency, err := models.ParseCurrency(code)
+	if err != nil {
+		v.add(codeInvalid, "currency", err.Error())
+	}
+	return currency
+}
+
+// validateRate checks that an amount in currency can be converted to the
+// group's currency, and returns what one unit of it is worth there.
+func validateRate(v *validation, currency models.Currency, g *group.Group) models.Rate {
+	rate, ok := exchangeRate(g, currency)
+	if !ok {
+		v.add(codeInvalid, "currency", fmt.Sprintf("No exchange rate from %s to %s; group admins can add one to the group's rates", currency, g.Currency))
+	}
+	return rate
+}
+
+// memberRequest is the body of POST /v1/groups/:id/members. The role is
+// optional.
+type memberRequest struct {
+	User int32  `json:"user"`
+	Role string `json:"role"`
+
+	user *models.User
+	role group.Role // The default unless Role is given
+}
+
+func (r *memberRequest) validate(v *validation) {
+	r.user = v.user("user", r.User)
+	if r.Role != "" {
+		r.role = validateRole(v, r.Role)
+	}
+}
+
+// roleRequest is the body of PUT /v1/groups/:id/members/:userId.
+type roleRequest struct {
+	Role string `json:"role"`
+
+	role group.Role
+}
+
+func (r *roleRequest) validate(v *validation) {
+	if r.Role == "" {
+		v.add(codeRequired, "role", "Role is required")
+		return
+	}
+	r.role = validateRole(v, r.Role)
+}
+
+// transferRequest is the body of POST
+// /v1/groups/:id/members/:userId/transfer.
+type transferRequest struct {
+	To int32 `json:"to"`
+
+	from  *models.User // The member whose debts are transferred
+	group *group.Group
+	to    *models.User
+}
+
+func (r *transferRequest) validate(v *validation) {
+	r.to = v.user("to", r.To)
+	if r.to == nil {
+		return
+	}
+	if !r.group.HasMember(r.to.Id) {
+		v.add(codeInvalid, "to", fmt.Sprintf("User %d is not a member of group %d", r.to.Id, r.group.ID))
+	}
+	v.check(r.to != r.from, "to", "Debts must be transferred to another member")
+}
+
+// validateRole parses the role a request names.
+func validateRole(v *validation, name string) group.Role {
+	role, err := group.ParseRole(name)
+	if err != nil {
+		v.add(codeInvalid, "role", err.Error())
+	}
+	return role
+}
+
+// createInvitationRequest is the body of POST /v1/groups/:id/invitations.
+// Leaving the email out makes a join link.
+type createInvitationRequest struct {
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	ExpiresInDays int    `json:"expiresInDays"`
+
+	role group.Role // The default unless Role is given
+}
+
+func (r *createInvitationRequest) validate(v *validation) {
+	r.Email = normalizeEmail(r.Email)
+	if r.Email != "" {
+		validateEmail(v, r.Email)
+	}
+	if r.Role != "" {
+		r.role = validateRole(v, r.Role)
+	}
+	v.check(r.ExpiresInDays >= 1 && r.ExpiresInDays <= maxInvitationDays, "expiresInDays", fmt.Sprintf("Invitations must expire after 1 to %d days", maxInvitationDays))
+}
+
+// acceptInvitationRequest is the body of POST /v1/invitations/:id/accept. The
+// token proves that the user got the invitation, since their email address
+// isn't verified.
+type acceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *acceptInvitationRequest) validate(v *validation) {
+	if r.Token == "" {
+		v.add(codeRequired, "token", "Token is required")
+	}
+}
+
+// createPaymentRequest is the body of POST /v1/payments, made by the signed in
+// user. A payment either settles the listed expenses, which must all be in one
+// group, or settles up the payer's balance in the group with the given ID.
+// Its currency is that of the expenses, or else the group's, unless given.
+type createPaymentRequest struct {
+	Payee      int32              `json:"payee"`
+	Amount     models.Money       `json:"amount"`
+	Currency   string             `json:"currency"`
+	Mode       models.PaymentMode `json:"mode"`
+	Identifier string             `json:"identifier"`
+	Note       string             `json:"note"`
+	Expenses   []int              `json:"expenses"`
+	Group      int32              `json:"groupId"`
+
+	payer    *models.User // The signed in user
+	payee    *models.User
+	currency models.Currency // Empty unless Currency is given
+	expenses []*models.Expense
+}
+
+func (r *createPaymentRequest) validate(v *validation) {
+	r.payee = v.user("payee", r.Payee)
+	v.check(r.payee == nil || r.payee != r.payer, "payee", "Payee must be someone other than the payer")
+	v.check(r.Amount > 0, "amount", "Amount must be greater than zero")
+	validateMode(v, r.Mode)
+	if r.Currency != "" {
+		r.currency = validateCurrency(v, r.Currency)
+	}
+
+	for i, id := range r.Expenses {
+		field := fmt.Sprintf("expenses[%d]", i)
+		var expense *models.Expense
+		if id < math.MinInt32 || id > math.MaxInt32 {
+			v.add(codeInvalid, field, "ID must be a 32-bit integer")
+		} else if expense = findExpenseByID(int32(id)); expense == nil {
+			v.add(codeNotFound, field, fmt.Sprintf("Expense %d not found", id))
+		}
+		r.expenses = append(r.expenses, expense)
+	}
+	if len(r.Expenses) == 0 && r.Group == 0 {
+		v.add(codeRequired, "groupId", "Either expenses or a group is required")
+	}
+}
+
+// updatePaymentRequest is the body of PUT /v1/payments/:id. Only the details
+// that don't affect balances can change; to change anything else, delete the
+// payment and create it again.
+type updatePaymentRequest struct {
+	Mode       models.PaymentMode `json:"mode"`
+	Identifier string             `json:"identifier"`
+	Note       string             `json:"note"`
+}
+
+func (r *updatePaymentRequest) validate(v *validation) {
+	validateMode(v, r.Mode)
+}
+
+// validateMode checks that mode is empty or a known payment mode.
+func validateMode(v *validation, mode models.PaymentMode) {
+	switch mode {
+	case "", models.Cash, models.BankTransfer, models.UPI:
+	default:
+		v.add(codeInvalid, "mode", fmt.Sprintf("Unknown payment mode %q", mode))
+	}
+}
+
+// maxCommentLength is the longest a comment may be.
+const maxCommentLength = 2000
+
+// commentRequest is the body of the endpoints that write and edit comments.
+type commentRequest struct {
+	Body string `json:"body"`
+}
+
+func (r *commentRequest) validate(v *validation) {
+	r.Body = strings.TrimSpace(r.Body)
+	switch {
+	case r.Body == "":
+		v.add(codeRequired, "body", "Body is required")
+	case len([]rune(r.Body)) > maxCommentLength:
+		v.add(codeInvalid, "body", fmt.Sprintf("Must be at most %d characters", maxCommentLength))
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/models"
+	"splitwise/store"
+	"time"
+)
+
+// schedulerInterval is how often the scheduler looks for recurring expenses
+// that are due.
+const schedulerInterval = time.Minute
+
+// maxOccurrencesPerRun is the most expenses makeDue makes for a recurring
+// expense at once, so that one starting years ago doesn't make them all in a
+// single request. The scheduler makes the rest on its later runs.
+const maxOccurrencesPerRun = 31
+
+// runScheduler makes the expenses recurring expenses are due, straight away
+// and then every interval, for as long as the server runs. Expenses missed
+// while the server was down are made when it starts, each with the time it
+// was due.
+func runScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		dataMu.Lock()
+		err := makeDueExpenses(time.Now())
+		dataMu.Unlock()
+		if err != nil {
+			errorLogger.Println("Error making recurring expenses:", err)
+		}
+		<-ticker.C
+	}
+}
+
+// makeDueExpenses makes every expense that recurring expenses are due by now.
+// A recurring expense that fails is logged and tried again next time, without
+// holding up the others. The caller must hold dataMu.
+func makeDueExpenses(now time.Time) error {
+	recurring, err := db.RecurringExpenses.List()
+	if err != nil {
+		return err
+	}
+	for _, r := range recurring {
+		if err := makeDue(r, now, 0); err != nil {
+			errorLogger.Println("Error making recurring expense", r.ID, "due:", err)
+		}
+	}
+	return nil
+}
+
+// makeDue adds a copy of the recurring expense's template to its group for
+// every occurrence due by now, up to maxOccurrencesPerRun, converted at the
+// rate of the day it is made. Each expense is saved together with the
+// recurring expense moving on past it, so none is made twice however often
+// this runs or the server restarts. Occurrences that can't be made yet,
+// because someone sharing the expense has left the group or there is no
+// exchange rate for its currency, stay due until they can. The expenses are
+// recorded as made by actor, which is 0 for the scheduler. The caller must
+// hold dataMu.
+func makeDue(r *models.RecurringExpense, now time.Time, actor int32) error {
+	for made := 0; made < maxOccurrencesPerRun && r.Due(now); made++ {
+		g := findGroupByID(r.GroupID)
+		if g == nil {
+			return fmt.Errorf("group %d: %w", r.GroupID, store.ErrNotFound)
+		}
+		if reason := occurrenceBlocker(r, g); reason != "" {
+			warnLogger.Println("Recurring Expense", r.ID, "Is Due But Can't Be Made:", reason)
+			return nil
+		}
+		rate, _ := exchangeRate(g, r.Template.Currency)
+		expense := r.Occurrence(rate)
+
+		state, err := auditState(g)
+		if err != nil {
+			return err
+		}
+		previous := r.Advance()
+		g.AddExpense(expense)
+		if err := db.Transaction(func(tx *store.Store) error {
+			if err := tx.Expenses.Add(expense); err != nil {
+				return err
+			}
+			if err := tx.Groups.Update(g); err != nil {
+				return err
+			}
+			if err := tx.RecurringExpenses.Update(r); err != nil {
+				return err
+			}
+			return recordAudit(tx, actor, auditChange{audit.Created, expense, nil}, auditChange{audit.Updated, g, state})
+		}); err != nil {
+			g.RemoveExpense(expense.ID)
+			*r = previous
+			return err
+		}
+		// An expense that can't be posted is taken back out of the store, and
+		// the occurrence stays due
+		if _, err := balanceJournal.PostExpense(g.ID, g.Currency, expense); err != nil {
+			if err := unmake(r, previous, expense, g, actor); err != nil {
+				errorLogger.Println("Error removing expense", expense.ID, "that couldn't be posted:", err)
+			}
+			return fmt.Errorf("posting expense %d to the journal: %w", expense.ID, err)
+		}
+		recordActivity(g.ID, actor, group.Created, group.ExpenseSubject, expense.ID, fmt.Sprintf("Added %s from recurring expense %d", describeExpense(expense), r.ID))
+		infoLogger.Println("Made Expense", expense.ID, "From Recurring Expense", r.ID)
+	}
+	return nil
+}
+
+// unmake takes an expense makeDue made out of its group and the store again,
+// recording that in the audit log, and moves the recurring expense back to
+// previous, so that the occurrence is due again.
+func unmake(r *models.RecurringExpense, previous models.RecurringExpense, expense *models.Expense, g *group.Group, actor int32) error {
+	expenseState, err := auditState(expense)
+	if err != nil {
+		return err
+	}
+	groupState, err := auditState(g)
+	if err != nil {
+		return err
+	}
+	g.RemoveExpense(expense.ID)
+	*r = previous
+	return db.Transaction(func(tx *store.Store) error {
+		if err := tx.Expenses.Delete(expense.ID); err != nil {
+			return err
+		}
+		if err := tx.Groups.Update(g); err != nil {
+			return err
+		}
+		if err := tx.RecurringExpenses.Update(r); err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, auditChange{audit.Deleted, expense, expenseState}, auditChange{audit.Updated, g, groupState})
+	})
+}
+
+// occurrenceBlocker says why the recurring expense's next expense can't be
+// made in the group, or returns "" if it can.
+func occurrenceBlocker(r *models.RecurringExpense, g *group.Group) string {
+	for _, user := range recurringUsers(r) {
+		if !g.HasMember(user.Id) {
+			return fmt.Sprintf("user %d is no longer a member of group %d", user.Id, g.ID)
+		}
+	}
+	if _, ok := exchangeRate(g, r.Template.Currency); !ok {
+		return fmt.Sprintf("no exchange rate from %s to %s", r.Template.Currency, g.Currency)
+	}
+	return ""
+}
+
+// recurringUsers returns everyone who pays or shares the recurring expense.
+func recurringUsers(r *models.RecurringExpense) []*models.User {
+	return append([]*models.User{r.Template.PaidBy}, r.Template.SplitBetween...)
+}
@@ -0,0 +1,245 @@
+// Package boltstore saves users, groups, expenses, payments, invitations,
+// recurring expenses, group activity, the audit log and the balance journal in
+// a single local bbolt file, for deployments that can't run a database server.
+package boltstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"splitwise/store"
+	"time"
+)
+
+var (
+	usersBucket    = []byte("users")
+	groupsBucket   = []byte("groups")
+	expensesBucket = []byte("expenses")
+	paymentsBucket = []byte("payments")
+
+	invitationsBucket       = []byte("invitations")
+	recurringExpensesBucket = []byte("recurringExpenses")
+	activitiesBucket        = []byte("activities")
+	auditLogBucket          = []byte("auditLog")
+	journalBucket           = []byte("journal")
+)
+
+// Backend is a store.Backend keeping one bucket per kind of record, with every
+// record encoded as JSON.
+type Backend struct {
+	db *bolt.DB
+	tx *bolt.Tx // Set while writing inside Batch
+}
+
+// Open opens the file at path, creating it if needed, and returns a store
+// backed by it.
+func Open(path string) (*store.Store, error) {
+	backend, err := OpenBackend(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := store.Open(backend)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenBackend opens the file at path, creating it if needed, and migrates it
+// to the latest schema version.
+func OpenBackend(path string) (*Backend, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Backend{db: db}, nil
+}
+
+// Load reads every record in the file.
+func (b *Backend) Load() (*store.Snapshot, error) {
+	snapshot := &store.Snapshot{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if err := loadAll(tx, usersBucket, func() interface{} {
+			snapshot.Users = append(snapshot.Users, store.UserRecord{})
+			return &snapshot.Users[len(snapshot.Users)-1]
+		}); err != nil {
+			return err
+		}
+		if err := loadAll(tx, groupsBucket, func() interface{} {
+			snapshot.Groups = append(snapshot.Groups, store.GroupRecord{})
+			return &snapshot.Groups[len(snapshot.Groups)-1]
+		}); err != nil {
+			return err
+		}
+		if err := loadAll(tx, expensesBucket, func() interface{} {
+			snapshot.Expenses = append(snapshot.Expenses, store.ExpenseRecord{})
+			return &snapshot.Expenses[len(snapshot.Expenses)-1]
+		}); err != nil {
+			return err
+		}
+		if err := loadAll(tx, paymentsBucket, func() interface{} {
+			snapshot.Payments = append(snapshot.Payments, store.PaymentRecord{})
+			return &snapshot.Payments[len(snapshot.Payments)-1]
+		}); err != nil {
+			return err
+		}
+		if err := loadAll(tx, invitationsBucket, func() interface{} {
+			snapshot.Invitations = append(snapshot.Invitations, store.InvitationRecord{})
+			return &snapshot.Invitations[len(snapshot.Invitations)-1]
+		}); err != nil {
+			return err
+		}
+		if err := loadAll(tx, recurringExpensesBucket, func() interface{} {
+			snapshot.RecurringExpenses = append(snapshot.RecurringExpenses, store.RecurringExpenseRecord{})
+			return &snapshot.RecurringExpenses[len(snapshot.RecurringExpenses)-1]
+		}); err != nil {
+			return err
+		}
+		if err := loadAll(tx, activitiesBucket, func() interface{} {
+			snapshot.Activities = append(snapshot.Activities, store.ActivityRecord{})
+			return &snapshot.Activities[len(snapshot.Activities)-1]
+		}); err != nil {
+			return err
+		}
+		if err := loadAll(tx, auditLogBucket, func() interface{} {
+			snapshot.AuditLog = append(snapshot.AuditLog, store.AuditRecord{})
+			return &snapshot.AuditLog[len(snapshot.AuditLog)-1]
+		}); err != nil {
+			return err
+		}
+		return loadAll(tx, journalBucket, func() interface{} {
+			snapshot.Journal = append(snapshot.Journal, store.JournalRecord{})
+			return &snapshot.Journal[len(snapshot.Journal)-1]
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// loadAll decodes every record in the bucket into the value next returns.
+func loadAll(tx *bolt.Tx, bucket []byte, next func() interface{}) error {
+	return tx.Bucket(bucket).ForEach(func(_, value []byte) error {
+		return json.Unmarshal(value, next())
+	})
+}
+
+// update runs fn in the current batch, or in a transaction of its own.
+func (b *Backend) update(fn func(tx *bolt.Tx) error) error {
+	if b.tx != nil {
+		return fn(b.tx)
+	}
+	return b.db.Update(fn)
+}
+
+func (b *Backend) put(bucket, key []byte, record interface{}) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, value)
+	})
+}
+
+func (b *Backend) delete(bucket, key []byte) error {
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key)
+	})
+}
+
+// idKey encodes an ID big-endian so that records are kept in ID order.
+func idKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func (b *Backend) PutUser(record store.UserRecord) error {
+	return b.put(usersBucket, idKey(int(record.ID)), record)
+}
+
+func (b *Backend) DeleteUser(id int32) error {
+	return b.delete(usersBucket, idKey(int(id)))
+}
+
+func (b *Backend) PutGroup(record store.GroupRecord) error {
+	return b.put(groupsBucket, idKey(int(record.ID)), record)
+}
+
+func (b *Backend) DeleteGroup(id int32) error {
+	return b.delete(groupsBucket, idKey(int(id)))
+}
+
+func (b *Backend) PutExpense(record store.ExpenseRecord) error {
+	return b.put(expensesBucket, idKey(record.ID), record)
+}
+
+func (b *Backend) DeleteExpense(id int) error {
+	return b.delete(expensesBucket, idKey(id))
+}
+
+func (b *Backend) PutPayment(record store.PaymentRecord) error {
+	return b.put(paymentsBucket, idKey(record.ID), record)
+}
+
+func (b *Backend) DeletePayment(id int) error {
+	return b.delete(paymentsBucket, idKey(id))
+}
+
+func (b *Backend) PutInvitation(record store.InvitationRecord) error {
+	return b.put(invitationsBucket, idKey(record.ID), record)
+}
+
+func (b *Backend) DeleteInvitation(id int) error {
+	return b.delete(invitationsBucket, idKey(id))
+}
+
+func (b *Backend) PutRecurringExpense(record store.RecurringExpenseRecord) error {
+	return b.put(recurringExpensesBucket, idKey(record.ID), record)
+}
+
+func (b *Backend) DeleteRecurringExpense(id int) error {
+	return b.delete(recurringExpensesBucket, idKey(id))
+}
+
+func (b *Backend) PutActivity(record store.ActivityRecord) error {
+	return b.put(activitiesBucket, idKey(record.ID), record)
+}
+
+func (b *Backend) DeleteActivity(id int) error {
+	return b.delete(activitiesBucket, idKey(id))
+}
+
+func (b *Backend) PutAuditEntry(record store.AuditRecord) error {
+	return b.put(auditLogBucket, idKey(record.Seq), record)
+}
+
+func (b *Backend) PutJournalEntry(record store.JournalRecord) error {
+	return b.put(journalBucket, idKey(record.ID), record)
+}
+
+// Batch writes everything fn writes in a single transaction.
+func (b *Backend) Batch(fn func(tx store.Backend) error) error {
+	if b.tx != nil {
+		return errors.New("batches cannot be nested")
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(&Backend{db: b.db, tx: tx})
+	})
+}
+
+// Close closes the file.
+func (b *Backend) Close() error {
+	if b.tx != nil {
+		return errors.New("cannot close a batch")
+	}
+	return b.db.Close()
+}
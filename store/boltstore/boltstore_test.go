@@ -0,0 +1,192 @@
+package boltstore
+
+import (
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"splitwise/group"
+	"splitwise/models"
+	"splitwise/store"
+	"splitwise/store/storetest"
+	"testing"
+)
+
+func openTestStore(t *testing.T, path string) *store.Store {
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBoltStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Store {
+		return openTestStore(t, filepath.Join(t.TempDir(), "splitwise.db"))
+	})
+}
+
+func TestBoltStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "splitwise.db")
+	storetest.RunPersistence(t, func(t *testing.T) *store.Store {
+		s, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		return s
+	})
+}
+
+func TestBoltStore_Transaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "splitwise.db")
+	s := openTestStore(t, path)
+	alice := &models.User{Id: 1, Name: "Alice"}
+	if err := s.Users.Add(alice); err != nil {
+		t.Fatalf("Users.Add() error = %v", err)
+	}
+	trip := group.NewGroup("Trip", []*models.User{alice})
+	if err := s.Groups.Add(trip); err != nil {
+		t.Fatalf("Groups.Add() error = %v", err)
+	}
+
+	// A transaction that fails part way saves nothing
+	errFailed := errors.New("failed")
+	expense := &models.Expense{ID: 1, Amount: 1000, PaidBy: alice, SplitBetween: []*models.User{alice}, SplitRate: []int64{1}}
+	err := s.Transaction(func(tx *store.Store) error {
+		if err := tx.Expenses.Add(expense); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Transaction() error = %v, want %v", err, errFailed)
+	}
+	if _, err := s.Expenses.Get(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expenses.Get(1) after a failed transaction error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	// A transaction that succeeds saves everything
+	err = s.Transaction(func(tx *store.Store) error {
+		if err := tx.Expenses.Add(expense); err != nil {
+			return err
+		}
+		trip.AddExpense(expense)
+		return tx.Groups.Update(trip)
+	})
+	if err != nil {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+	if got, err := s.Expenses.Get(1); err != nil || got != expense {
+		t.Errorf("Expenses.Get(1) after the transaction = %v, %v, want %v", got, err, expense)
+	}
+	s.Close()
+
+	s = openTestStore(t, path)
+	if got, err := s.Groups.FindByExpense(1); err != nil || got.Name != "Trip" {
+		t.Errorf("Groups.FindByExpense(1) after reopening = %v, %v, want Trip", got, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "splitwise.db")
+	backend, err := OpenBackend(path)
+	if err != nil {
+		t.Fatalf("OpenBackend() error = %v", err)
+	}
+	var version int
+	backend.db.View(func(tx *bolt.Tx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	if version != latestVersion() {
+		t.Errorf("schema version of a new file = %d, want %d", version, latestVersion())
+	}
+
+	// Files written by a newer version are refused rather than misread
+	backend.db.Update(func(tx *bolt.Tx) error {
+		return setSchemaVersion(tx, latestVersion()+1)
+	})
+	backend.Close()
+	if _, err := OpenBackend(path); err == nil {
+		t.Errorf("OpenBackend() of a newer schema version succeeded, want an error")
+	}
+}
+
+// TestMigrate_GroupIDs upgrades a file from before groups had IDs.
+func TestMigrate_GroupIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "splitwise.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error = %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := migrations[0].up(tx); err != nil {
+			return err
+		}
+		tx.Bucket(usersBucket).Put(idKey(1), []byte(`{"id":1,"name":"Alice"}`))
+		tx.Bucket(usersBucket).Put(idKey(2), []byte(`{"id":2,"name":"Bob"}`))
+		tx.Bucket(groupsBucket).Put([]byte("Trip"), []byte(`{"name":"Trip","members":[1,2],"expenses":[]}`))
+		tx.Bucket(groupsBucket).Put([]byte("House"), []byte(`{"name":"House","members":[1],"expenses":[]}`))
+		tx.Bucket(paymentsBucket).Put(idKey(1), []byte(`{"id":1,"payer":2,"payee":1,"amount":5.00,"mode":"Cash","expenses":[],"group":"Trip"}`))
+		return setSchemaVersion(tx, 1)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("writing a version 1 file: %v", err)
+	}
+
+	s := openTestStore(t, path)
+	house, err := s.Groups.Get(1)
+	if err != nil || house.Name != "House" || len(house.Members) != 1 {
+		t.Errorf("Groups.Get(1) = %+v, %v, want House", house, err)
+	}
+	trip, err := s.Groups.Get(2)
+	if err != nil || trip.Name != "Trip" || len(trip.Members) != 2 {
+		t.Fatalf("Groups.Get(2) = %+v, %v, want Trip", trip, err)
+	}
+	if owners := trip.Owners(); len(owners) != 2 {
+		t.Errorf("Trip owners = %v, want every member of a group saved before roles", owners)
+	}
+	if payment, err := s.Payments.Get(1); err != nil || payment.GroupID != 2 || payment.Amount != 5000 {
+		t.Errorf("Payments.Get(1) = %+v, %v, want 5.00 in group 2", payment, err)
+	}
+}
+
+// TestMigrate_Thousandths upgrades a file from when amounts were held in
+// hundredths of a unit.
+func TestMigrate_Thousandths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "splitwise.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error = %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, m := range migrations[:7] {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+		}
+		tx.Bucket(usersBucket).Put(idKey(1), []byte(`{"id":1,"name":"Alice"}`))
+		tx.Bucket(usersBucket).Put(idKey(2), []byte(`{"id":2,"name":"Bob"}`))
+		tx.Bucket(groupsBucket).Put(idKey(1), []byte(`{"id":1,"name":"Trip","currency":"INR","members":[1,2],"expenses":[1,2]}`))
+		tx.Bucket(expensesBucket).Put(idKey(1), []byte(`{"id":1,"amount":30.00,"currency":"INR","paidBy":1,"splitBetween":[1,2],"splitType":"Exact","splitRate":[1000,2000],"remainingAmount":30.00}`))
+		tx.Bucket(expensesBucket).Put(idKey(2), []byte(`{"id":2,"amount":30.00,"currency":"INR","paidBy":1,"splitBetween":[1,2],"splitType":"Shares","splitRate":[1,2],"remainingAmount":30.00}`))
+		tx.Bucket(recurringExpensesBucket).Put(idKey(1), []byte(`{"id":1,"groupId":1,"rule":"FREQ=MONTHLY","start":"2026-01-01T09:00:00Z","template":{"id":3,"amount":30.00,"currency":"INR","paidBy":1,"splitBetween":[1,2],"splitType":"Exact","splitRate":[1000,2000],"remainingAmount":30.00}}`))
+		return setSchemaVersion(tx, 7)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("writing a version 7 file: %v", err)
+	}
+
+	s := openTestStore(t, path)
+	if exact, err := s.Expenses.Get(1); err != nil || exact.Amount != 30000 || exact.SplitRate[0] != 10000 || exact.SplitRate[1] != 20000 {
+		t.Errorf("Expenses.Get(1) = %+v, %v, want 30.00 split 10.00 and 20.00", exact, err)
+	}
+	if shares, err := s.Expenses.Get(2); err != nil || shares.SplitRate[0] != 1 || shares.SplitRate[1] != 2 {
+		t.Errorf("Expenses.Get(2) = %+v, %v, want the shares left alone", shares, err)
+	}
+	if rent, err := s.RecurringExpenses.Get(1); err != nil || rent.Template.SplitRate[0] != 10000 || rent.Template.SplitRate[1] != 20000 {
+		t.Errorf("RecurringExpenses.Get(1) = %+v, %v, want the template split 10.00 and 20.00", rent, err)
+	}
+}
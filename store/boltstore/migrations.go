@@ -0,0 +1,267 @@
+package boltstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"strconv"
+)
+
+var (
+	metaBucket       = []byte("meta")
+	schemaVersionKey = []byte("schemaVersion")
+)
+
+// migration upgrades the file from the previous schema version to version.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *bolt.Tx) error
+}
+
+// migrations are run in order on files older than their version. Never edit a
+// migration once released; add a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create buckets for users, groups, expenses and payments",
+		up: func(tx *bolt.Tx) error {
+			for _, bucket := range [][]byte{usersBucket, groupsBucket, expensesBucket, paymentsBucket} {
+				if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     2,
+		description: "number groups and refer to them from payments by ID",
+		up: func(tx *bolt.Tx) error {
+			// Groups were keyed by name, so they are numbered in name order
+			ids := make(map[string]int)
+			var groups []map[string]json.RawMessage
+			if err := readJSON(tx.Bucket(groupsBucket), func(key []byte, record map[string]json.RawMessage) {
+				groups = append(groups, record)
+				ids[string(key)] = len(groups)
+			}); err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket(groupsBucket); err != nil {
+				return err
+			}
+			bucket, err := tx.CreateBucket(groupsBucket)
+			if err != nil {
+				return err
+			}
+			for i, record := range groups {
+				record["id"] = json.RawMessage(strconv.Itoa(i + 1))
+				if err := putJSON(bucket, idKey(i+1), record); err != nil {
+					return err
+				}
+			}
+
+			payments := make(map[string]map[string]json.RawMessage)
+			if err := readJSON(tx.Bucket(paymentsBucket), func(key []byte, record map[string]json.RawMessage) {
+				var name string
+				json.Unmarshal(record["group"], &name)
+				delete(record, "group")
+				record["groupId"] = json.RawMessage(strconv.Itoa(ids[name]))
+				payments[string(key)] = record
+			}); err != nil {
+				return err
+			}
+			for key, record := range payments {
+				if err := putJSON(tx.Bucket(paymentsBucket), []byte(key), record); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     3,
+		description: "create a bucket for invitations",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(invitationsBucket)
+			return err
+		},
+	},
+	{
+		version:     4,
+		description: "create a bucket for recurring expenses",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(recurringExpensesBucket)
+			return err
+		},
+	},
+	{
+		version:     5,
+		description: "create a bucket for group activity",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(activitiesBucket)
+			return err
+		},
+	},
+	{
+		version:     6,
+		description: "create a bucket for the audit log",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(auditLogBucket)
+			return err
+		},
+	},
+	{
+		version:     7,
+		description: "create a bucket for the balance journal",
+		up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(journalBucket)
+			return err
+		},
+	},
+	{
+		version:     8,
+		description: "hold the split amounts of exact and itemized expenses in thousandths",
+		up: func(tx *bolt.Tx) error {
+			// Amounts are saved as decimals, so only the split amounts of
+			// exact and itemized expenses, saved in minor units, change
+			if err := updateJSON(tx.Bucket(expensesBucket), scaleSplitRate); err != nil {
+				return err
+			}
+			return updateJSON(tx.Bucket(recurringExpensesBucket), func(record map[string]json.RawMessage) error {
+				var template map[string]json.RawMessage
+				if err := json.Unmarshal(record["template"], &template); err != nil {
+					return err
+				}
+				if err := scaleSplitRate(template); err != nil {
+					return err
+				}
+				value, err := json.Marshal(template)
+				record["template"] = value
+				return err
+			})
+		},
+	},
+}
+
+// scaleSplitRate multiplies the split values of an exact or itemized expense
+// record by ten, from hundredths to thousandths of a unit.
+func scaleSplitRate(record map[string]json.RawMessage) error {
+	var splitType string
+	json.Unmarshal(record["splitType"], &splitType)
+	if splitType != "Exact" && splitType != "Itemized" {
+		return nil
+	}
+	var splitRate []int64
+	if err := json.Unmarshal(record["splitRate"], &splitRate); err != nil {
+		return err
+	}
+	for i := range splitRate {
+		splitRate[i] *= 10
+	}
+	value, err := json.Marshal(splitRate)
+	record["splitRate"] = value
+	return err
+}
+
+// readJSON decodes the fields of every record in the bucket, in key order,
+// without using the record types of the current schema.
+func readJSON(bucket *bolt.Bucket, fn func(key []byte, record map[string]json.RawMessage)) error {
+	return bucket.ForEach(func(key, value []byte) error {
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(value, &record); err != nil {
+			return err
+		}
+		fn(key, record)
+		return nil
+	})
+}
+
+// updateJSON passes the fields of every record in the bucket to fn and stores
+// the records again as fn leaves them.
+func updateJSON(bucket *bolt.Bucket, fn func(record map[string]json.RawMessage) error) error {
+	records := make(map[string]map[string]json.RawMessage)
+	if err := readJSON(bucket, func(key []byte, record map[string]json.RawMessage) {
+		records[string(key)] = record
+	}); err != nil {
+		return err
+	}
+	for key, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+		if err := putJSON(bucket, []byte(key), record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putJSON encodes the record as JSON and stores it under key.
+func putJSON(bucket *bolt.Bucket, key []byte, record map[string]json.RawMessage) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+// latestVersion is the schema version this code reads and writes.
+func latestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// schemaVersion returns the file's schema version, 0 for a new file.
+func schemaVersion(tx *bolt.Tx) int {
+	meta := tx.Bucket(metaBucket)
+	if meta == nil {
+		return 0
+	}
+	value := meta.Get(schemaVersionKey)
+	if value == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(value))
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(version))
+	return meta.Put(schemaVersionKey, value)
+}
+
+// migrate runs every migration the file hasn't had yet, each in its own
+// transaction together with the version bump, so a failed migration leaves the
+// file at the last version that completed.
+func migrate(db *bolt.DB) error {
+	var current int
+	if err := db.View(func(tx *bolt.Tx) error {
+		current = schemaVersion(tx)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if current > latestVersion() {
+		return fmt.Errorf("schema version %d is newer than the latest supported version %d", current, latestVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return setSchemaVersion(tx, m.version)
+		}); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
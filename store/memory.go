@@ -0,0 +1,575 @@
+package store
+
+import (
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/journal"
+	"splitwise/models"
+	"sync"
+)
+
+// NewMemory creates a Store that keeps everything in memory. Lookups by ID
+// take constant time, lists are in the order things were added, and every
+// repository is safe for concurrent use. Nothing survives a restart.
+func NewMemory() *Store {
+	return &Store{
+		Users:       &memoryUsers{byID: make(map[int32]*models.User), byEmail: make(map[string]int32), emails: make(map[int32]string)},
+		Groups:      &memoryGroups{byID: make(map[int32]*group.Group), byExpense: make(map[int]int32), expenses: make(map[int32][]int)},
+		Expenses:    &memoryExpenses{byID: make(map[int]*models.Expense)},
+		Payments:    &memoryPayments{byID: make(map[int]*models.Payment)},
+		Invitations: &memoryInvitations{byID: make(map[int]*group.Invitation), byToken: make(map[string]int)},
+
+		RecurringExpenses: &memoryRecurringExpenses{byID: make(map[int]*models.RecurringExpense)},
+		Activities:        &memoryActivities{byID: make(map[int]*group.Activity)},
+		AuditLog:          &memoryAuditLog{},
+		Journal:           &memoryJournal{},
+		Close:             func() error { return nil },
+	}
+}
+
+// remove returns keys without key.
+func remove[K comparable](keys []K, key K) []K {
+	for i, each := range keys {
+		if each == key {
+			return append(keys[:i], keys[i+1:]...)
+		}
+	}
+	return keys
+}
+
+type memoryUsers struct {
+	mu    sync.RWMutex
+	byID  map[int32]*models.User
+	order []int32
+	// byEmail maps email addresses to user IDs, and emails holds the address
+	// each user had when they were last saved.
+	byEmail map[string]int32
+	emails  map[int32]string
+}
+
+// emailTaken reports whether another user has the user's email address. The
+// caller must hold r.mu.
+func (r *memoryUsers) emailTaken(user *models.User) bool {
+	id, ok := r.byEmail[user.Email]
+	return user.Email != "" && ok && id != user.Id
+}
+
+// index points the user's email address at them, or clears the address they
+// were saved with if user is nil. The caller must hold r.mu.
+func (r *memoryUsers) index(id int32, user *models.User) {
+	if email, ok := r.emails[id]; ok {
+		delete(r.byEmail, email)
+		delete(r.emails, id)
+	}
+	if user != nil && user.Email != "" {
+		r.byEmail[user.Email] = id
+		r.emails[id] = user.Email
+	}
+}
+
+func (r *memoryUsers) Add(user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[user.Id]; ok || r.emailTaken(user) {
+		return ErrExists
+	}
+	r.byID[user.Id] = user
+	r.order = append(r.order, user.Id)
+	r.index(user.Id, user)
+	return nil
+}
+
+func (r *memoryUsers) Get(id int32) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if user, ok := r.byID[id]; ok {
+		return user, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUsers) FindByEmail(email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if id, ok := r.byEmail[email]; ok {
+		return r.byID[id], nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUsers) List() ([]*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	users := make([]*models.User, len(r.order))
+	for i, id := range r.order {
+		users[i] = r.byID[id]
+	}
+	return users, nil
+}
+
+func (r *memoryUsers) Update(user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[user.Id]; !ok {
+		return ErrNotFound
+	}
+	if r.emailTaken(user) {
+		return ErrExists
+	}
+	r.byID[user.Id] = user
+	r.index(user.Id, user)
+	return nil
+}
+
+func (r *memoryUsers) Delete(id int32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.byID, id)
+	r.order = remove(r.order, id)
+	r.index(id, nil)
+	return nil
+}
+
+type memoryGroups struct {
+	mu    sync.RWMutex
+	byID  map[int32]*group.Group
+	order []int32
+	// byExpense maps expense IDs to the ID of their group, and expenses holds
+	// the expense IDs each group had when it was last saved.
+	byExpense map[int]int32
+	expenses  map[int32][]int
+}
+
+// index points the group's expenses at it, or at nothing if g is nil. The
+// caller must hold r.mu.
+func (r *memoryGroups) index(id int32, g *group.Group) {
+	for _, expenseID := range r.expenses[id] {
+		delete(r.byExpense, expenseID)
+	}
+	delete(r.expenses, id)
+	if g == nil {
+		return
+	}
+	ids := make([]int, len(g.Expenses))
+	for i, expense := range g.Expenses {
+		ids[i] = expense.ID
+		r.byExpense[expense.ID] = id
+	}
+	r.expenses[id] = ids
+}
+
+func (r *memoryGroups) Add(g *group.Group) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[g.ID]; ok {
+		return ErrExists
+	}
+	r.byID[g.ID] = g
+	r.order = append(r.order, g.ID)
+	r.index(g.ID, g)
+	return nil
+}
+
+func (r *memoryGroups) Get(id int32) (*group.Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if g, ok := r.byID[id]; ok {
+		return g, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryGroups) FindByExpense(expenseID int) (*group.Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if id, ok := r.byExpense[expenseID]; ok {
+		return r.byID[id], nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryGroups) List() ([]*group.Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	groups := make([]*group.Group, len(r.order))
+	for i, id := range r.order {
+		groups[i] = r.byID[id]
+	}
+	return groups, nil
+}
+
+func (r *memoryGroups) Update(g *group.Group) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[g.ID]; !ok {
+		return ErrNotFound
+	}
+	r.byID[g.ID] = g
+	r.index(g.ID, g)
+	return nil
+}
+
+func (r *memoryGroups) Delete(id int32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.byID, id)
+	r.order = remove(r.order, id)
+	r.index(id, nil)
+	return nil
+}
+
+type memoryExpenses struct {
+	mu    sync.RWMutex
+	byID  map[int]*models.Expense
+	order []int
+}
+
+func (r *memoryExpenses) Add(expense *models.Expense) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[expense.ID]; ok {
+		return ErrExists
+	}
+	r.byID[expense.ID] = expense
+	r.order = append(r.order, expense.ID)
+	return nil
+}
+
+func (r *memoryExpenses) Get(id int) (*models.Expense, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if expense, ok := r.byID[id]; ok {
+		return expense, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryExpenses) List() ([]*models.Expense, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	expenses := make([]*models.Expense, len(r.order))
+	for i, id := range r.order {
+		expenses[i] = r.byID[id]
+	}
+	return expenses, nil
+}
+
+func (r *memoryExpenses) Update(expense *models.Expense) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[expense.ID]; !ok {
+		return ErrNotFound
+	}
+	r.byID[expense.ID] = expense
+	return nil
+}
+
+func (r *memoryExpenses) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.byID, id)
+	r.order = remove(r.order, id)
+	return nil
+}
+
+type memoryPayments struct {
+	mu    sync.RWMutex
+	byID  map[int]*models.Payment
+	order []int
+}
+
+func (r *memoryPayments) Add(payment *models.Payment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[payment.ID]; ok {
+		return ErrExists
+	}
+	r.byID[payment.ID] = payment
+	r.order = append(r.order, payment.ID)
+	return nil
+}
+
+func (r *memoryPayments) Get(id int) (*models.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if payment, ok := r.byID[id]; ok {
+		return payment, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryPayments) List() ([]*models.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	payments := make([]*models.Payment, len(r.order))
+	for i, id := range r.order {
+		payments[i] = r.byID[id]
+	}
+	return payments, nil
+}
+
+func (r *memoryPayments) Update(payment *models.Payment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[payment.ID]; !ok {
+		return ErrNotFound
+	}
+	r.byID[payment.ID] = payment
+	return nil
+}
+
+func (r *memoryPayments) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.byID, id)
+	r.order = remove(r.order, id)
+	return nil
+}
+
+type memoryInvitations struct {
+	mu    sync.RWMutex
+	byID  map[int]*group.Invitation
+	order []int
+	// byToken maps token hashes to invitation IDs. An invitation's token
+	// never changes.
+	byToken map[string]int
+}
+
+func (r *memoryInvitations) Add(invitation *group.Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[invitation.ID]; ok {
+		return ErrExists
+	}
+	r.byID[invitation.ID] = invitation
+	r.order = append(r.order, invitation.ID)
+	r.byToken[invitation.TokenHash] = invitation.ID
+	return nil
+}
+
+func (r *memoryInvitations) Get(id int) (*group.Invitation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if invitation, ok := r.byID[id]; ok {
+		return invitation, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryInvitations) FindByToken(tokenHash string) (*group.Invitation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if id, ok := r.byToken[tokenHash]; ok {
+		return r.byID[id], nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryInvitations) List() ([]*group.Invitation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	invitations := make([]*group.Invitation, len(r.order))
+	for i, id := range r.order {
+		invitations[i] = r.byID[id]
+	}
+	return invitations, nil
+}
+
+func (r *memoryInvitations) Update(invitation *group.Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[invitation.ID]; !ok {
+		return ErrNotFound
+	}
+	r.byID[invitation.ID] = invitation
+	return nil
+}
+
+func (r *memoryInvitations) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	invitation, ok := r.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(r.byID, id)
+	delete(r.byToken, invitation.TokenHash)
+	r.order = remove(r.order, id)
+	return nil
+}
+
+type memoryRecurringExpenses struct {
+	mu    sync.RWMutex
+	byID  map[int]*models.RecurringExpense
+	order []int
+}
+
+func (r *memoryRecurringExpenses) Add(recurring *models.RecurringExpense) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[recurring.ID]; ok {
+		return ErrExists
+	}
+	r.byID[recurring.ID] = recurring
+	r.order = append(r.order, recurring.ID)
+	return nil
+}
+
+func (r *memoryRecurringExpenses) Get(id int) (*models.RecurringExpense, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if recurring, ok := r.byID[id]; ok {
+		return recurring, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryRecurringExpenses) List() ([]*models.RecurringExpense, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	recurring := make([]*models.RecurringExpense, len(r.order))
+	for i, id := range r.order {
+		recurring[i] = r.byID[id]
+	}
+	return recurring, nil
+}
+
+func (r *memoryRecurringExpenses) Update(recurring *models.RecurringExpense) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[recurring.ID]; !ok {
+		return ErrNotFound
+	}
+	r.byID[recurring.ID] = recurring
+	return nil
+}
+
+func (r *memoryRecurringExpenses) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.byID, id)
+	r.order = remove(r.order, id)
+	return nil
+}
+
+type memoryActivities struct {
+	mu    sync.RWMutex
+	byID  map[int]*group.Activity
+	order []int
+}
+
+func (r *memoryActivities) Add(activity *group.Activity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[activity.ID]; ok {
+		return ErrExists
+	}
+	r.byID[activity.ID] = activity
+	r.order = append(r.order, activity.ID)
+	return nil
+}
+
+func (r *memoryActivities) Get(id int) (*group.Activity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if activity, ok := r.byID[id]; ok {
+		return activity, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryActivities) List() ([]*group.Activity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	activities := make([]*group.Activity, len(r.order))
+	for i, id := range r.order {
+		activities[i] = r.byID[id]
+	}
+	return activities, nil
+}
+
+func (r *memoryActivities) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.byID, id)
+	r.order = remove(r.order, id)
+	return nil
+}
+
+type memoryAuditLog struct {
+	mu      sync.RWMutex
+	entries []*audit.Entry
+}
+
+func (r *memoryAuditLog) Append(e *audit.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := len(r.entries); n > 0 && e.Seq <= r.entries[n-1].Seq {
+		return ErrExists
+	}
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func (r *memoryAuditLog) Last() (*audit.Entry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return r.entries[len(r.entries)-1], nil
+}
+
+func (r *memoryAuditLog) List() ([]*audit.Entry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*audit.Entry(nil), r.entries...), nil
+}
+
+type memoryJournal struct {
+	mu      sync.RWMutex
+	entries []*journal.Entry
+}
+
+func (r *memoryJournal) Append(e *journal.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := len(r.entries); n > 0 && e.ID <= r.entries[n-1].ID {
+		return ErrExists
+	}
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func (r *memoryJournal) Last() (*journal.Entry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return r.entries[len(r.entries)-1], nil
+}
+
+func (r *memoryJournal) List() ([]*journal.Entry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*journal.Entry(nil), r.entries...), nil
+}
@@ -0,0 +1,70 @@
+package store_test
+
+import (
+	"fmt"
+	"splitwise/group"
+	"splitwise/models"
+	"splitwise/store"
+	"splitwise/store/storetest"
+	"sync"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Store { return store.NewMemory() })
+}
+
+// TestMemory_Concurrent uses every repository from many goroutines at once.
+// Run it with -race.
+func TestMemory_Concurrent(t *testing.T) {
+	s := store.NewMemory()
+	const workers, iterations = 8, 50
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := w*iterations + i + 1
+				user := &models.User{Id: int32(id), Name: fmt.Sprint("User ", id)}
+				expense := &models.Expense{ID: id, PaidBy: user}
+				payment := &models.Payment{ID: id, Payer: user, Payee: user}
+				g := group.NewGroup(fmt.Sprint("Group ", id), []*models.User{user})
+				g.AddExpense(expense)
+
+				if err := s.Users.Add(user); err != nil {
+					t.Errorf("Users.Add() error = %v", err)
+				}
+				if err := s.Expenses.Add(expense); err != nil {
+					t.Errorf("Expenses.Add() error = %v", err)
+				}
+				if err := s.Payments.Add(payment); err != nil {
+					t.Errorf("Payments.Add() error = %v", err)
+				}
+				if err := s.Groups.Add(g); err != nil {
+					t.Errorf("Groups.Add() error = %v", err)
+				}
+				if got, err := s.Groups.FindByExpense(id); err != nil || got != g {
+					t.Errorf("Groups.FindByExpense(%d) = %v, %v, want %v", id, got, err, g)
+				}
+				s.Users.List()
+				s.Groups.List()
+				s.Expenses.List()
+				s.Payments.List()
+				if i%2 == 0 {
+					if err := s.Expenses.Delete(id); err != nil {
+						t.Errorf("Expenses.Delete() error = %v", err)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	users, _ := s.Users.List()
+	expenses, _ := s.Expenses.List()
+	if len(users) != workers*iterations || len(expenses) != workers*iterations/2 {
+		t.Errorf("stored %d users and %d expenses, want %d and %d", len(users), len(expenses), workers*iterations, workers*iterations/2)
+	}
+}
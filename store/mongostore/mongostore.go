@@ -0,0 +1,347 @@
+// Package mongostore saves users, groups, expenses, payments, invitations,
+// recurring expenses, group activity, the audit log and the balance journal in
+// MongoDB.
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"splitwise/store"
+	"time"
+)
+
+// timeout bounds every call to the database.
+const timeout = 10 * time.Second
+
+// Backend is a store.Backend keeping one collection per kind of record in a
+// MongoDB database.
+type Backend struct {
+	client   *mongo.Client
+	users    *mongo.Collection
+	groups   *mongo.Collection
+	expenses *mongo.Collection
+	payments *mongo.Collection
+
+	invitations       *mongo.Collection
+	recurringExpenses *mongo.Collection
+	activities        *mongo.Collection
+	auditLog          *mongo.Collection
+	journal           *mongo.Collection
+	meta              *mongo.Collection // What the database has been upgraded to
+}
+
+// Open connects to the MongoDB server at uri and returns a store backed by the
+// named database.
+func Open(uri, database string) (*store.Store, error) {
+	backend, err := Connect(uri, database)
+	if err != nil {
+		return nil, err
+	}
+	s, err := store.Open(backend)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Connect connects to the MongoDB server at uri and checks that it responds.
+func Connect(uri, database string) (*Backend, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	db := client.Database(database)
+	b := &Backend{
+		client:   client,
+		users:    db.Collection("users"),
+		groups:   db.Collection("groups"),
+		expenses: db.Collection("expenses"),
+		payments: db.Collection("payments"),
+
+		invitations:       db.Collection("invitations"),
+		recurringExpenses: db.Collection("recurringExpenses"),
+		activities:        db.Collection("activities"),
+		auditLog:          db.Collection("auditLog"),
+		journal:           db.Collection("journal"),
+		meta:              db.Collection("meta"),
+	}
+	if err := b.numberGroups(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	if err := b.scaleAmounts(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return b, nil
+}
+
+// numberGroups upgrades groups saved by name, from before groups had IDs. They
+// are numbered in name order after the highest ID in use, and the payments
+// that name them are pointed at the new IDs.
+func (b *Backend) numberGroups(ctx context.Context) error {
+	var named []bson.M
+	cursor, err := b.groups.Find(ctx, bson.M{"_id": bson.M{"$type": "string"}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &named); err != nil || len(named) == 0 {
+		return err
+	}
+
+	var last store.GroupRecord
+	err = b.groups.FindOne(ctx, bson.M{"_id": bson.M{"$type": "number"}}, options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&last)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	id := last.ID
+	for _, doc := range named {
+		name, _ := doc["_id"].(string)
+		id++
+		doc["_id"], doc["name"] = id, name
+		if _, err := b.groups.InsertOne(ctx, doc); err != nil {
+			return err
+		}
+		if _, err := b.groups.DeleteOne(ctx, bson.M{"_id": name}); err != nil {
+			return err
+		}
+		if _, err := b.payments.UpdateMany(ctx, bson.M{"group": name}, bson.M{
+			"$set":   bson.M{"groupId": id},
+			"$unset": bson.M{"group": ""},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// amountPlaces is the number of decimal places of the amounts in the database,
+// which were saved in hundredths of a unit until amounts were held in
+// thousandths.
+const amountPlaces = 3
+
+// scaleAmounts upgrades amounts saved in hundredths of a unit to thousandths,
+// along with the split amounts of exact and itemized expenses. Documents are
+// flagged as they are scaled, so an upgrade that is cut short carries on where
+// it stopped, and the database is marked once every document is.
+func (b *Backend) scaleAmounts(ctx context.Context) error {
+	err := b.meta.FindOne(ctx, bson.M{"_id": "amountPlaces", "places": amountPlaces}).Err()
+	if err == nil || !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	times10 := func(path string) bson.M {
+		return bson.M{"$multiply": bson.A{path, 10}}
+	}
+	each := func(input, in interface{}) bson.M {
+		return bson.M{"$map": bson.M{"input": input, "in": in}}
+	}
+	withAmount := func(fields ...string) bson.M {
+		scaled := bson.M{}
+		for _, field := range fields {
+			scaled[field] = times10("$$this." + field)
+		}
+		return bson.M{"$mergeObjects": bson.A{"$$this", scaled}}
+	}
+	expense := func(prefix string) bson.M {
+		return bson.M{
+			prefix + "amount":           times10("$" + prefix + "amount"),
+			prefix + "remainingAmount":  times10("$" + prefix + "remainingAmount"),
+			prefix + "splitAdjustments": each("$"+prefix+"splitAdjustments", times10("$$this")),
+			prefix + "items":            each("$"+prefix+"items", withAmount("amount")),
+			prefix + "charges":          each("$"+prefix+"charges", withAmount("amount")),
+			prefix + "splitRate": bson.M{"$cond": bson.A{
+				bson.M{"$in": bson.A{"$" + prefix + "splitType", bson.A{"Exact", "Itemized"}}},
+				each("$"+prefix+"splitRate", times10("$$this")),
+				"$" + prefix + "splitRate",
+			}},
+		}
+	}
+	upgrades := []struct {
+		collection *mongo.Collection
+		set        bson.M
+	}{
+		{b.expenses, expense("")},
+		{b.recurringExpenses, expense("template.")},
+		{b.payments, bson.M{
+			"amount":      times10("$amount"),
+			"allocations": each("$allocations", withAmount("amount")),
+		}},
+		{b.groups, bson.M{
+			"transfers": each("$transfers", bson.M{"$mergeObjects": bson.A{"$$this", bson.M{
+				"amounts": bson.M{"$arrayToObject": each(bson.M{"$objectToArray": "$$this.amounts"}, bson.M{
+					"k": "$$this.k", "v": times10("$$this.v"),
+				})},
+			}}}),
+		}},
+		{b.journal, bson.M{
+			"postings": each("$postings", withAmount("amount", "original")),
+		}},
+	}
+	for _, upgrade := range upgrades {
+		upgrade.set["scaled"] = true
+		if _, err := upgrade.collection.UpdateMany(ctx, bson.M{"scaled": bson.M{"$exists": false}}, mongo.Pipeline{{{Key: "$set", Value: upgrade.set}}}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := b.meta.ReplaceOne(ctx, bson.M{"_id": "amountPlaces"}, bson.M{"_id": "amountPlaces", "places": amountPlaces}, options.Replace().SetUpsert(true)); err != nil {
+		return err
+	}
+	for _, upgrade := range upgrades {
+		if _, err := upgrade.collection.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"scaled": ""}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads every record in the database.
+func (b *Backend) Load() (*store.Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	snapshot := &store.Snapshot{}
+	if err := findAll(ctx, b.users, &snapshot.Users); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, b.groups, &snapshot.Groups); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, b.expenses, &snapshot.Expenses); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, b.payments, &snapshot.Payments); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, b.invitations, &snapshot.Invitations); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, b.recurringExpenses, &snapshot.RecurringExpenses); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, b.activities, &snapshot.Activities); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, b.auditLog, &snapshot.AuditLog); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, b.journal, &snapshot.Journal); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func findAll(ctx context.Context, collection *mongo.Collection, records interface{}) error {
+	cursor, err := collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, records)
+}
+
+// put inserts the record or replaces the one with the same ID.
+func put(collection *mongo.Collection, id interface{}, record interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": id}, record, options.Replace().SetUpsert(true))
+	return err
+}
+
+// deleteOne deletes the record with the ID, if there is one.
+func deleteOne(collection *mongo.Collection, id interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (b *Backend) PutUser(record store.UserRecord) error {
+	return put(b.users, record.ID, record)
+}
+
+func (b *Backend) DeleteUser(id int32) error {
+	return deleteOne(b.users, id)
+}
+
+func (b *Backend) PutGroup(record store.GroupRecord) error {
+	return put(b.groups, record.ID, record)
+}
+
+func (b *Backend) DeleteGroup(id int32) error {
+	return deleteOne(b.groups, id)
+}
+
+func (b *Backend) PutExpense(record store.ExpenseRecord) error {
+	return put(b.expenses, record.ID, record)
+}
+
+func (b *Backend) DeleteExpense(id int) error {
+	return deleteOne(b.expenses, id)
+}
+
+func (b *Backend) PutPayment(record store.PaymentRecord) error {
+	return put(b.payments, record.ID, record)
+}
+
+func (b *Backend) DeletePayment(id int) error {
+	return deleteOne(b.payments, id)
+}
+
+func (b *Backend) PutInvitation(record store.InvitationRecord) error {
+	return put(b.invitations, record.ID, record)
+}
+
+func (b *Backend) DeleteInvitation(id int) error {
+	return deleteOne(b.invitations, id)
+}
+
+func (b *Backend) PutRecurringExpense(record store.RecurringExpenseRecord) error {
+	return put(b.recurringExpenses, record.ID, record)
+}
+
+func (b *Backend) DeleteRecurringExpense(id int) error {
+	return deleteOne(b.recurringExpenses, id)
+}
+
+func (b *Backend) PutActivity(record store.ActivityRecord) error {
+	return put(b.activities, record.ID, record)
+}
+
+func (b *Backend) DeleteActivity(id int) error {
+	return deleteOne(b.activities, id)
+}
+
+func (b *Backend) PutAuditEntry(record store.AuditRecord) error {
+	return put(b.auditLog, record.Seq, record)
+}
+
+func (b *Backend) PutJournalEntry(record store.JournalRecord) error {
+	return put(b.journal, record.ID, record)
+}
+
+// Drop deletes the database with everything in it.
+func (b *Backend) Drop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return b.users.Database().Drop(ctx)
+}
+
+// Close disconnects from the server.
+func (b *Backend) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return b.client.Disconnect(ctx)
+}
@@ -0,0 +1,86 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"os"
+	"splitwise/store"
+	"splitwise/store/storetest"
+	"testing"
+	"time"
+)
+
+// testBackend connects to the MongoDB server given by SPLITWISE_TEST_MONGODB_URI,
+// such as a local mongod, using a fresh database that is dropped after the
+// test. Without a server the test is skipped.
+func testBackend(t *testing.T, database string) func(t *testing.T) *store.Store {
+	uri := os.Getenv("SPLITWISE_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("SPLITWISE_TEST_MONGODB_URI is not set")
+	}
+	t.Cleanup(func() {
+		if backend, err := Connect(uri, database); err == nil {
+			backend.Drop()
+			backend.Close()
+		}
+	})
+
+	return func(t *testing.T) *store.Store {
+		s, err := Open(uri, database)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+}
+
+func TestMongoStore(t *testing.T) {
+	databases := 0
+	prefix := fmt.Sprintf("splitwise_test_%d", time.Now().UnixNano())
+	open := func(t *testing.T) *store.Store {
+		databases++
+		return testBackend(t, fmt.Sprintf("%s_%d", prefix, databases))(t)
+	}
+	storetest.Run(t, open)
+}
+
+func TestMongoStore_Persistence(t *testing.T) {
+	open := testBackend(t, fmt.Sprintf("splitwise_test_%d", time.Now().UnixNano()))
+	storetest.RunPersistence(t, open)
+}
+
+// TestScaleAmounts upgrades a database from when amounts were saved in
+// hundredths of a unit.
+func TestScaleAmounts(t *testing.T) {
+	database := fmt.Sprintf("splitwise_test_%d", time.Now().UnixNano())
+	open := testBackend(t, database)
+	b, err := Connect(os.Getenv("SPLITWISE_TEST_MONGODB_URI"), database)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer b.Close()
+
+	ctx := context.Background()
+	b.meta.DeleteMany(ctx, bson.M{})
+	b.users.InsertMany(ctx, []interface{}{bson.M{"_id": int32(1), "name": "Alice"}, bson.M{"_id": int32(2), "name": "Bob"}})
+	b.groups.InsertOne(ctx, bson.M{"_id": int32(1), "name": "Trip", "currency": "INR", "members": bson.A{int32(1), int32(2)}, "expenses": bson.A{1}})
+	b.expenses.InsertOne(ctx, bson.M{"_id": 1, "amount": int64(3000), "currency": "INR", "paidBy": int32(1), "splitBetween": bson.A{int32(1), int32(2)},
+		"splitType": "Exact", "splitRate": bson.A{int64(1000), int64(2000)}, "remainingAmount": int64(3000)})
+	b.payments.InsertOne(ctx, bson.M{"_id": 1, "payer": int32(2), "payee": int32(1), "amount": int64(500), "currency": "INR", "groupId": int32(1)})
+	for i := 0; i < 2; i++ {
+		// Upgrading again changes nothing
+		if err := b.scaleAmounts(ctx); err != nil {
+			t.Fatalf("scaleAmounts() error = %v", err)
+		}
+	}
+
+	s := open(t)
+	if expense, err := s.Expenses.Get(1); err != nil || expense.Amount != 30000 || expense.RemainingAmount != 30000 || expense.SplitRate[0] != 10000 || expense.SplitRate[1] != 20000 {
+		t.Errorf("Expenses.Get(1) = %+v, %v, want 30.00 split 10.00 and 20.00", expense, err)
+	}
+	if payment, err := s.Payments.Get(1); err != nil || payment.Amount != 5000 {
+		t.Errorf("Payments.Get(1) = %+v, %v, want 5.00", payment, err)
+	}
+}
@@ -0,0 +1,367 @@
+package store
+
+import (
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/journal"
+	"splitwise/models"
+)
+
+// Backend saves records somewhere that outlives the process, such as a
+// database. Put methods insert the record or replace the one with the same ID.
+type Backend interface {
+	Load() (*Snapshot, error)
+	PutUser(record UserRecord) error
+	DeleteUser(id int32) error
+	PutGroup(record GroupRecord) error
+	DeleteGroup(id int32) error
+	PutExpense(record ExpenseRecord) error
+	DeleteExpense(id int) error
+	PutPayment(record PaymentRecord) error
+	DeletePayment(id int) error
+	PutInvitation(record InvitationRecord) error
+	DeleteInvitation(id int) error
+	PutRecurringExpense(record RecurringExpenseRecord) error
+	DeleteRecurringExpense(id int) error
+	PutActivity(record ActivityRecord) error
+	DeleteActivity(id int) error
+	PutAuditEntry(record AuditRecord) error
+	PutJournalEntry(record JournalRecord) error
+	Close() error
+}
+
+// Batcher is implemented by backends that can save several records at once.
+// Batch calls fn with a Backend whose writes are all saved when fn returns
+// nil, and none of them when it returns an error or the process crashes.
+type Batcher interface {
+	Batch(fn func(tx Backend) error) error
+}
+
+// Open loads everything the backend holds and returns a Store that serves
+// reads from memory and writes every change through to the backend. A change
+// the backend fails to save is not made in memory either.
+func Open(backend Backend) (*Store, error) {
+	snapshot, err := backend.Load()
+	if err != nil {
+		return nil, err
+	}
+	memory, err := snapshot.Restore()
+	if err != nil {
+		return nil, err
+	}
+
+	s := writeThrough(memory, backend, nil)
+	s.Close = backend.Close
+	s.transaction = func(fn func(tx *Store) error) error {
+		batcher, ok := backend.(Batcher)
+		if !ok {
+			return fn(s)
+		}
+
+		// Changes are only made in memory once the backend has saved them all
+		var pending []func() error
+		if err := batcher.Batch(func(tx Backend) error {
+			pending = nil
+			return fn(writeThrough(memory, tx, &pending))
+		}); err != nil {
+			return err
+		}
+		for _, change := range pending {
+			if err := change(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s, nil
+}
+
+// writeThrough returns a Store that saves every change to the backend before
+// making it in memory. If pending is not nil, changes to memory are queued on
+// it instead.
+func writeThrough(memory *Store, backend Backend, pending *[]func() error) *Store {
+	w := &persistent{backend: backend, pending: pending}
+	return &Store{
+		Users:       persistentUsers{memory.Users, w},
+		Groups:      persistentGroups{memory.Groups, w},
+		Expenses:    persistentExpenses{memory.Expenses, w},
+		Payments:    persistentPayments{memory.Payments, w},
+		Invitations: persistentInvitations{memory.Invitations, w},
+
+		RecurringExpenses: persistentRecurringExpenses{memory.RecurringExpenses, w},
+		Activities:        persistentActivities{memory.Activities, w},
+		AuditLog:          persistentAuditLog{memory.AuditLog, w},
+		Journal:           persistentJournal{memory.Journal, w},
+		Close:             backend.Close,
+	}
+}
+
+type persistent struct {
+	backend Backend
+	pending *[]func() error
+}
+
+// save writes to the backend and then, or once the transaction commits,
+// changes memory.
+func (p *persistent) save(write func() error, change func() error) error {
+	if err := write(); err != nil {
+		return err
+	}
+	if p.pending != nil {
+		*p.pending = append(*p.pending, change)
+		return nil
+	}
+	return change()
+}
+
+type persistentUsers struct {
+	UserRepository
+	p *persistent
+}
+
+// emailTaken reports whether another user has the user's email address.
+func (r persistentUsers) emailTaken(user *models.User) bool {
+	existing, err := r.FindByEmail(user.Email)
+	return user.Email != "" && err == nil && existing.Id != user.Id
+}
+
+func (r persistentUsers) Add(user *models.User) error {
+	if _, err := r.Get(user.Id); err == nil || r.emailTaken(user) {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutUser(NewUserRecord(user)) },
+		func() error { return r.UserRepository.Add(user) })
+}
+
+func (r persistentUsers) Update(user *models.User) error {
+	if _, err := r.Get(user.Id); err != nil {
+		return err
+	}
+	if r.emailTaken(user) {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutUser(NewUserRecord(user)) },
+		func() error { return r.UserRepository.Update(user) })
+}
+
+func (r persistentUsers) Delete(id int32) error {
+	if _, err := r.Get(id); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.DeleteUser(id) },
+		func() error { return r.UserRepository.Delete(id) })
+}
+
+type persistentGroups struct {
+	GroupRepository
+	p *persistent
+}
+
+func (r persistentGroups) Add(g *group.Group) error {
+	if _, err := r.Get(g.ID); err == nil {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutGroup(NewGroupRecord(g)) },
+		func() error { return r.GroupRepository.Add(g) })
+}
+
+func (r persistentGroups) Update(g *group.Group) error {
+	if _, err := r.Get(g.ID); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutGroup(NewGroupRecord(g)) },
+		func() error { return r.GroupRepository.Update(g) })
+}
+
+func (r persistentGroups) Delete(id int32) error {
+	if _, err := r.Get(id); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.DeleteGroup(id) },
+		func() error { return r.GroupRepository.Delete(id) })
+}
+
+type persistentExpenses struct {
+	ExpenseRepository
+	p *persistent
+}
+
+func (r persistentExpenses) Add(expense *models.Expense) error {
+	if _, err := r.Get(expense.ID); err == nil {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutExpense(NewExpenseRecord(expense)) },
+		func() error { return r.ExpenseRepository.Add(expense) })
+}
+
+func (r persistentExpenses) Update(expense *models.Expense) error {
+	if _, err := r.Get(expense.ID); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutExpense(NewExpenseRecord(expense)) },
+		func() error { return r.ExpenseRepository.Update(expense) })
+}
+
+func (r persistentExpenses) Delete(id int) error {
+	if _, err := r.Get(id); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.DeleteExpense(id) },
+		func() error { return r.ExpenseRepository.Delete(id) })
+}
+
+type persistentPayments struct {
+	PaymentRepository
+	p *persistent
+}
+
+func (r persistentPayments) Add(payment *models.Payment) error {
+	if _, err := r.Get(payment.ID); err == nil {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutPayment(NewPaymentRecord(payment)) },
+		func() error { return r.PaymentRepository.Add(payment) })
+}
+
+func (r persistentPayments) Update(payment *models.Payment) error {
+	if _, err := r.Get(payment.ID); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutPayment(NewPaymentRecord(payment)) },
+		func() error { return r.PaymentRepository.Update(payment) })
+}
+
+func (r persistentPayments) Delete(id int) error {
+	if _, err := r.Get(id); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.DeletePayment(id) },
+		func() error { return r.PaymentRepository.Delete(id) })
+}
+
+type persistentInvitations struct {
+	InvitationRepository
+	p *persistent
+}
+
+func (r persistentInvitations) Add(invitation *group.Invitation) error {
+	if _, err := r.Get(invitation.ID); err == nil {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutInvitation(NewInvitationRecord(invitation)) },
+		func() error { return r.InvitationRepository.Add(invitation) })
+}
+
+func (r persistentInvitations) Update(invitation *group.Invitation) error {
+	if _, err := r.Get(invitation.ID); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutInvitation(NewInvitationRecord(invitation)) },
+		func() error { return r.InvitationRepository.Update(invitation) })
+}
+
+func (r persistentInvitations) Delete(id int) error {
+	if _, err := r.Get(id); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.DeleteInvitation(id) },
+		func() error { return r.InvitationRepository.Delete(id) })
+}
+
+type persistentRecurringExpenses struct {
+	RecurringExpenseRepository
+	p *persistent
+}
+
+func (r persistentRecurringExpenses) Add(recurring *models.RecurringExpense) error {
+	if _, err := r.Get(recurring.ID); err == nil {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutRecurringExpense(NewRecurringExpenseRecord(recurring)) },
+		func() error { return r.RecurringExpenseRepository.Add(recurring) })
+}
+
+func (r persistentRecurringExpenses) Update(recurring *models.RecurringExpense) error {
+	if _, err := r.Get(recurring.ID); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutRecurringExpense(NewRecurringExpenseRecord(recurring)) },
+		func() error { return r.RecurringExpenseRepository.Update(recurring) })
+}
+
+func (r persistentRecurringExpenses) Delete(id int) error {
+	if _, err := r.Get(id); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.DeleteRecurringExpense(id) },
+		func() error { return r.RecurringExpenseRepository.Delete(id) })
+}
+
+type persistentActivities struct {
+	ActivityRepository
+	p *persistent
+}
+
+func (r persistentActivities) Add(activity *group.Activity) error {
+	if _, err := r.Get(activity.ID); err == nil {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutActivity(NewActivityRecord(activity)) },
+		func() error { return r.ActivityRepository.Add(activity) })
+}
+
+func (r persistentActivities) Delete(id int) error {
+	if _, err := r.Get(id); err != nil {
+		return err
+	}
+	return r.p.save(
+		func() error { return r.p.backend.DeleteActivity(id) },
+		func() error { return r.ActivityRepository.Delete(id) })
+}
+
+type persistentAuditLog struct {
+	AuditRepository
+	p *persistent
+}
+
+func (r persistentAuditLog) Append(e *audit.Entry) error {
+	if last, err := r.Last(); err == nil && e.Seq <= last.Seq {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutAuditEntry(NewAuditRecord(e)) },
+		func() error { return r.AuditRepository.Append(e) })
+}
+
+type persistentJournal struct {
+	JournalRepository
+	p *persistent
+}
+
+func (r persistentJournal) Append(e *journal.Entry) error {
+	if last, err := r.Last(); err == nil && e.ID <= last.ID {
+		return ErrExists
+	}
+	return r.p.save(
+		func() error { return r.p.backend.PutJournalEntry(NewJournalRecord(e)) },
+		func() error { return r.JournalRepository.Append(e) })
+}
@@ -0,0 +1,234 @@
+package store_test
+
+import (
+	"errors"
+	"splitwise/models"
+	"splitwise/store"
+	"splitwise/store/storetest"
+	"testing"
+)
+
+// mapBackend is a store.Backend that keeps records in maps, standing in for a
+// database.
+type mapBackend struct {
+	users    map[int32]store.UserRecord
+	groups   map[int32]store.GroupRecord
+	expenses map[int]store.ExpenseRecord
+	payments map[int]store.PaymentRecord
+	invites  map[int]store.InvitationRecord
+	repeats  map[int]store.RecurringExpenseRecord
+	activity map[int]store.ActivityRecord
+	audit    map[int]store.AuditRecord
+	journal  map[int]store.JournalRecord
+	fail     bool // Makes every write fail
+}
+
+func newMapBackend() *mapBackend {
+	return &mapBackend{
+		users:    make(map[int32]store.UserRecord),
+		groups:   make(map[int32]store.GroupRecord),
+		expenses: make(map[int]store.ExpenseRecord),
+		payments: make(map[int]store.PaymentRecord),
+		invites:  make(map[int]store.InvitationRecord),
+		repeats:  make(map[int]store.RecurringExpenseRecord),
+		activity: make(map[int]store.ActivityRecord),
+		audit:    make(map[int]store.AuditRecord),
+		journal:  make(map[int]store.JournalRecord),
+	}
+}
+
+var errWrite = errors.New("write failed")
+
+func (b *mapBackend) Load() (*store.Snapshot, error) {
+	snapshot := &store.Snapshot{}
+	for _, record := range b.users {
+		snapshot.Users = append(snapshot.Users, record)
+	}
+	for _, record := range b.groups {
+		snapshot.Groups = append(snapshot.Groups, record)
+	}
+	for _, record := range b.expenses {
+		snapshot.Expenses = append(snapshot.Expenses, record)
+	}
+	for _, record := range b.payments {
+		snapshot.Payments = append(snapshot.Payments, record)
+	}
+	for _, record := range b.invites {
+		snapshot.Invitations = append(snapshot.Invitations, record)
+	}
+	for _, record := range b.repeats {
+		snapshot.RecurringExpenses = append(snapshot.RecurringExpenses, record)
+	}
+	for _, record := range b.activity {
+		snapshot.Activities = append(snapshot.Activities, record)
+	}
+	for _, record := range b.audit {
+		snapshot.AuditLog = append(snapshot.AuditLog, record)
+	}
+	for _, record := range b.journal {
+		snapshot.Journal = append(snapshot.Journal, record)
+	}
+	return snapshot, nil
+}
+
+func (b *mapBackend) PutUser(record store.UserRecord) error {
+	if b.fail {
+		return errWrite
+	}
+	b.users[record.ID] = record
+	return nil
+}
+
+func (b *mapBackend) DeleteUser(id int32) error {
+	if b.fail {
+		return errWrite
+	}
+	delete(b.users, id)
+	return nil
+}
+
+func (b *mapBackend) PutGroup(record store.GroupRecord) error {
+	if b.fail {
+		return errWrite
+	}
+	b.groups[record.ID] = record
+	return nil
+}
+
+func (b *mapBackend) DeleteGroup(id int32) error {
+	if b.fail {
+		return errWrite
+	}
+	delete(b.groups, id)
+	return nil
+}
+
+func (b *mapBackend) PutExpense(record store.ExpenseRecord) error {
+	if b.fail {
+		return errWrite
+	}
+	b.expenses[record.ID] = record
+	return nil
+}
+
+func (b *mapBackend) DeleteExpense(id int) error {
+	if b.fail {
+		return errWrite
+	}
+	delete(b.expenses, id)
+	return nil
+}
+
+func (b *mapBackend) PutPayment(record store.PaymentRecord) error {
+	if b.fail {
+		return errWrite
+	}
+	b.payments[record.ID] = record
+	return nil
+}
+
+func (b *mapBackend) DeletePayment(id int) error {
+	if b.fail {
+		return errWrite
+	}
+	delete(b.payments, id)
+	return nil
+}
+
+func (b *mapBackend) PutInvitation(record store.InvitationRecord) error {
+	if b.fail {
+		return errWrite
+	}
+	b.invites[record.ID] = record
+	return nil
+}
+
+func (b *mapBackend) DeleteInvitation(id int) error {
+	if b.fail {
+		return errWrite
+	}
+	delete(b.invites, id)
+	return nil
+}
+
+func (b *mapBackend) PutRecurringExpense(record store.RecurringExpenseRecord) error {
+	if b.fail {
+		return errWrite
+	}
+	b.repeats[record.ID] = record
+	return nil
+}
+
+func (b *mapBackend) DeleteRecurringExpense(id int) error {
+	if b.fail {
+		return errWrite
+	}
+	delete(b.repeats, id)
+	return nil
+}
+
+func (b *mapBackend) PutActivity(record store.ActivityRecord) error {
+	if b.fail {
+		return errWrite
+	}
+	b.activity[record.ID] = record
+	return nil
+}
+
+func (b *mapBackend) DeleteActivity(id int) error {
+	if b.fail {
+		return errWrite
+	}
+	delete(b.activity, id)
+	return nil
+}
+
+func (b *mapBackend) PutAuditEntry(record store.AuditRecord) error {
+	if b.fail {
+		return errWrite
+	}
+	b.audit[record.Seq] = record
+	return nil
+}
+
+func (b *mapBackend) PutJournalEntry(record store.JournalRecord) error {
+	if b.fail {
+		return errWrite
+	}
+	b.journal[record.ID] = record
+	return nil
+}
+
+func (b *mapBackend) Close() error {
+	return nil
+}
+
+func openMapBackend(t *testing.T, backend *mapBackend) *store.Store {
+	s, err := store.Open(backend)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return s
+}
+
+func TestPersistent(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Store { return openMapBackend(t, newMapBackend()) })
+}
+
+func TestPersistent_Reopen(t *testing.T) {
+	backend := newMapBackend()
+	storetest.RunPersistence(t, func(t *testing.T) *store.Store { return openMapBackend(t, backend) })
+}
+
+func TestPersistent_FailedWrite(t *testing.T) {
+	backend := newMapBackend()
+	s := openMapBackend(t, backend)
+	backend.fail = true
+
+	if err := s.Users.Add(&models.User{Id: 1, Name: "Alice"}); !errors.Is(err, errWrite) {
+		t.Fatalf("Users.Add() error = %v, want %v", err, errWrite)
+	}
+	if _, err := s.Users.Get(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Users.Get(1) after a failed write error = %v, want %v", err, store.ErrNotFound)
+	}
+}
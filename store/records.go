@@ -0,0 +1,711 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/journal"
+	"splitwise/models"
+	"time"
+)
+
+// Records are how persistent backends save users, groups, expenses and
+// payments. They refer to each other by ID instead of by pointer, and a
+// Snapshot of them is linked back into objects when a backend is opened.
+
+// UserRecord is the saved form of a models.User. Balances are not saved, since
+// they are rebuilt from expenses and payments.
+type UserRecord struct {
+	ID           int32  `bson:"_id" json:"id"`
+	Name         string `bson:"name" json:"name"`
+	Email        string `bson:"email,omitempty" json:"email,omitempty"`
+	PasswordHash []byte `bson:"passwordHash,omitempty" json:"passwordHash,omitempty"`
+}
+
+// GroupRecord is the saved form of a group.Group.
+type GroupRecord struct {
+	ID            int32                           `bson:"_id" json:"id"`
+	Name          string                          `bson:"name" json:"name"`
+	Currency      models.Currency                 `bson:"currency" json:"currency"` // Missing from groups saved before amounts had currencies
+	Rates         map[models.Currency]models.Rate `bson:"rates,omitempty" json:"rates,omitempty"`
+	Members       []int32                         `bson:"members" json:"members"`
+	FormerMembers []int32                         `bson:"formerMembers,omitempty" json:"formerMembers,omitempty"`
+	Roles         map[int32]group.Role            `bson:"roles,omitempty" json:"roles,omitempty"` // Missing from groups saved before members had roles
+	Expenses      []int                           `bson:"expenses" json:"expenses"`
+	Transfers     []DebtTransferRecord            `bson:"transfers,omitempty" json:"transfers,omitempty"`
+	Categories    []string                        `bson:"categories" json:"categories"` // Missing from groups saved before groups had categories
+	CategoryRules []group.CategoryRule            `bson:"categoryRules,omitempty" json:"categoryRules,omitempty"`
+}
+
+// DebtTransferRecord is the saved form of a group.DebtTransfer.
+type DebtTransferRecord struct {
+	From      int32                  `bson:"from" json:"from"`
+	To        int32                  `bson:"to" json:"to"`
+	Amounts   map[int32]models.Money `bson:"amounts" json:"amounts"`
+	Timestamp time.Time              `bson:"timestamp" json:"timestamp"`
+}
+
+// LineItemRecord is the saved form of a models.LineItem.
+type LineItemRecord struct {
+	Description string       `bson:"description" json:"description"`
+	Amount      models.Money `bson:"amount" json:"amount"`
+	Consumers   []int32      `bson:"consumers" json:"consumers"`
+}
+
+// ExpenseRecord is the saved form of a models.Expense.
+type ExpenseRecord struct {
+	ID               int                   `bson:"_id" json:"id"`
+	Description      string                `bson:"description,omitempty" json:"description,omitempty"`
+	Category         string                `bson:"category,omitempty" json:"category,omitempty"`
+	Tags             []string              `bson:"tags,omitempty" json:"tags,omitempty"`
+	Amount           models.Money          `bson:"amount" json:"amount"`
+	Currency         models.Currency       `bson:"currency" json:"currency"`
+	Rate             models.Rate           `bson:"rate" json:"rate"`
+	PaidBy           int32                 `bson:"paidBy" json:"paidBy"`
+	SplitBetween     []int32               `bson:"splitBetween" json:"splitBetween"`
+	SplitType        models.SplitType      `bson:"splitType" json:"splitType"`
+	SplitRate        []int64               `bson:"splitRate" json:"splitRate"`
+	SplitAdjustments []models.Money        `bson:"splitAdjustments" json:"splitAdjustments"`
+	Items            []LineItemRecord      `bson:"items" json:"items"`
+	Charges          []*models.Charge      `bson:"charges" json:"charges"`
+	RemainingAmount  models.Money          `bson:"remainingAmount" json:"remainingAmount"`
+	Payments         []int                 `bson:"payments" json:"payments"`
+	Timestamp        time.Time             `bson:"timestamp" json:"timestamp"`
+	Rounding         models.RoundingPolicy `bson:"rounding" json:"rounding"`
+	RoundingSeed     int64                 `bson:"roundingSeed" json:"roundingSeed"`
+	Recurring        int                   `bson:"recurring,omitempty" json:"recurring,omitempty"`
+	Attachments      []*models.Attachment  `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	Comments         []*models.Comment     `bson:"comments,omitempty" json:"comments,omitempty"`
+}
+
+// PaymentRecord is the saved form of a models.Payment.
+type PaymentRecord struct {
+	ID          int                  `bson:"_id" json:"id"`
+	Payer       int32                `bson:"payer" json:"payer"`
+	Payee       int32                `bson:"payee" json:"payee"`
+	Amount      models.Money         `bson:"amount" json:"amount"`
+	Currency    models.Currency      `bson:"currency" json:"currency"`
+	Rate        models.Rate          `bson:"rate" json:"rate"`
+	Mode        models.PaymentMode   `bson:"mode" json:"mode"`
+	Timestamp   time.Time            `bson:"timestamp" json:"timestamp"`
+	Identifier  string               `bson:"identifier" json:"identifier"`
+	Note        string               `bson:"note" json:"note"`
+	Expenses    []int                `bson:"expenses" json:"expenses"`
+	GroupID     int32                `bson:"groupId" json:"groupId"`
+	Allocations []models.Allocation  `bson:"allocations" json:"allocations"`
+	Attachments []*models.Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	Comments    []*models.Comment    `bson:"comments,omitempty" json:"comments,omitempty"`
+}
+
+// InvitationRecord is the saved form of a group.Invitation.
+type InvitationRecord struct {
+	ID        int                    `bson:"_id" json:"id"`
+	GroupID   int32                  `bson:"groupId" json:"groupId"`
+	Email     string                 `bson:"email,omitempty" json:"email,omitempty"`
+	Role      group.Role             `bson:"role" json:"role"`
+	InvitedBy int32                  `bson:"invitedBy" json:"invitedBy"`
+	CreatedAt time.Time              `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time              `bson:"expiresAt" json:"expiresAt"`
+	Status    group.InvitationStatus `bson:"status" json:"status"`
+	Uses      int                    `bson:"uses" json:"uses"`
+	TokenHash string                 `bson:"tokenHash" json:"tokenHash"`
+}
+
+// RecurringExpenseRecord is the saved form of a models.RecurringExpense. Its
+// schedule is saved as a recurrence rule and the time it starts at.
+type RecurringExpenseRecord struct {
+	ID          int           `bson:"_id" json:"id"`
+	GroupID     int32         `bson:"groupId" json:"groupId"`
+	Template    ExpenseRecord `bson:"template" json:"template"`
+	Rule        string        `bson:"rule" json:"rule"`
+	Start       time.Time     `bson:"start" json:"start"`
+	Occurrences int           `bson:"occurrences" json:"occurrences"`
+	Last        *time.Time    `bson:"last,omitempty" json:"last,omitempty"`
+	Next        *time.Time    `bson:"next,omitempty" json:"next,omitempty"`
+}
+
+// ActivityRecord is the saved form of a group.Activity.
+type ActivityRecord struct {
+	ID        int           `bson:"_id" json:"id"`
+	GroupID   int32         `bson:"groupId" json:"groupId"`
+	Actor     int32         `bson:"actor,omitempty" json:"actor,omitempty"`
+	Verb      group.Verb    `bson:"verb" json:"verb"`
+	Subject   group.Subject `bson:"subject" json:"subject"`
+	SubjectID int           `bson:"subjectId" json:"subjectId"`
+	Summary   string        `bson:"summary" json:"summary"`
+	Timestamp time.Time     `bson:"timestamp" json:"timestamp"`
+}
+
+// AuditRecord is the saved form of an audit.Entry, with the values kept as
+// JSON text so that they read back byte for byte and still hash the same.
+type AuditRecord struct {
+	Seq       int          `bson:"_id" json:"seq"`
+	Timestamp time.Time    `bson:"timestamp" json:"timestamp"`
+	Actor     int32        `bson:"actor,omitempty" json:"actor,omitempty"`
+	Action    audit.Action `bson:"action" json:"action"`
+	Entity    audit.Entity `bson:"entity" json:"entity"`
+	EntityID  int          `bson:"entityId" json:"entityId"`
+	Before    string       `bson:"before,omitempty" json:"before,omitempty"`
+	After     string       `bson:"after,omitempty" json:"after,omitempty"`
+	PrevHash  string       `bson:"prevHash,omitempty" json:"prevHash,omitempty"`
+	Hash      string       `bson:"hash" json:"hash"`
+}
+
+// PostingRecord is the saved form of a journal.Posting.
+type PostingRecord struct {
+	Group        int32           `bson:"group" json:"group"`
+	Account      int32           `bson:"account" json:"account"`
+	Counterparty int32           `bson:"counterparty" json:"counterparty"`
+	Amount       models.Money    `bson:"amount" json:"amount"`
+	Currency     models.Currency `bson:"currency" json:"currency"`
+	Original     models.Money    `bson:"original" json:"original"`
+}
+
+// JournalRecord is the saved form of a journal.Entry.
+type JournalRecord struct {
+	ID        int               `bson:"_id" json:"id"`
+	Kind      journal.EntryKind `bson:"kind" json:"kind"`
+	Reference int               `bson:"reference" json:"reference"`
+	Reverses  int               `bson:"reverses,omitempty" json:"reverses,omitempty"`
+	Timestamp time.Time         `bson:"timestamp" json:"timestamp"`
+	Postings  []PostingRecord   `bson:"postings" json:"postings"`
+}
+
+// Snapshot is every record a backend holds.
+type Snapshot struct {
+	Users       []UserRecord
+	Groups      []GroupRecord
+	Expenses    []ExpenseRecord
+	Payments    []PaymentRecord
+	Invitations []InvitationRecord
+
+	RecurringExpenses []RecurringExpenseRecord
+	Activities        []ActivityRecord
+	AuditLog          []AuditRecord
+	Journal           []JournalRecord
+}
+
+func userIDs(users []*models.User) []int32 {
+	ids := make([]int32, len(users))
+	for i, user := range users {
+		ids[i] = user.Id
+	}
+	return ids
+}
+
+func expenseIDs(expenses []*models.Expense) []int {
+	ids := make([]int, len(expenses))
+	for i, expense := range expenses {
+		ids[i] = expense.ID
+	}
+	return ids
+}
+
+// NewUserRecord returns the saved form of the user.
+func NewUserRecord(user *models.User) UserRecord {
+	return UserRecord{ID: user.Id, Name: user.Name, Email: user.Email, PasswordHash: user.PasswordHash}
+}
+
+// NewGroupRecord returns the saved form of the group.
+func NewGroupRecord(g *group.Group) GroupRecord {
+	roles := make(map[int32]group.Role, len(g.Members))
+	for _, member := range g.Members {
+		roles[member.Id] = g.RoleOf(member.Id)
+	}
+	var transfers []DebtTransferRecord
+	for _, t := range g.Transfers {
+		transfers = append(transfers, DebtTransferRecord{From: t.From, To: t.To, Amounts: t.Amounts, Timestamp: t.Timestamp})
+	}
+	categories := g.Categories
+	if categories == nil {
+		categories = []string{}
+	}
+	record := GroupRecord{ID: g.ID, Name: g.Name, Currency: g.Currency, Rates: g.Rates, Members: userIDs(g.Members), Roles: roles, Expenses: expenseIDs(g.Expenses), Transfers: transfers, Categories: categories, CategoryRules: g.CategoryRules}
+	if len(g.FormerMembers) > 0 {
+		record.FormerMembers = userIDs(g.FormerMembers)
+	}
+	return record
+}
+
+// NewExpenseRecord returns the saved form of the expense.
+func NewExpenseRecord(e *models.Expense) ExpenseRecord {
+	items := make([]LineItemRecord, len(e.Items))
+	for i, item := range e.Items {
+		items[i] = LineItemRecord{Description: item.Description, Amount: item.Amount, Consumers: userIDs(item.Consumers)}
+	}
+	payments := make([]int, len(e.Payments))
+	for i, payment := range e.Payments {
+		payments[i] = payment.ID
+	}
+	record := ExpenseRecord{
+		ID:               e.ID,
+		Description:      e.Description,
+		Category:         e.Category,
+		Tags:             e.Tags,
+		Amount:           e.Amount,
+		Currency:         e.Currency,
+		Rate:             e.Rate,
+		SplitBetween:     userIDs(e.SplitBetween),
+		SplitType:        e.SplitType,
+		SplitRate:        e.SplitRate,
+		SplitAdjustments: e.SplitAdjustments,
+		Items:            items,
+		Charges:          e.Charges,
+		RemainingAmount:  e.RemainingAmount,
+		Payments:         payments,
+		Timestamp:        e.Timestamp,
+		Rounding:         e.Rounding,
+		RoundingSeed:     e.RoundingSeed,
+		Recurring:        e.Recurring,
+		Attachments:      e.Attachments,
+		Comments:         e.Comments,
+	}
+	if e.PaidBy != nil {
+		record.PaidBy = e.PaidBy.Id
+	}
+	return record
+}
+
+// NewPaymentRecord returns the saved form of the payment.
+func NewPaymentRecord(p *models.Payment) PaymentRecord {
+	record := PaymentRecord{
+		ID:          p.ID,
+		Amount:      p.Amount,
+		Currency:    p.Currency,
+		Rate:        p.Rate,
+		Mode:        p.Mode,
+		Timestamp:   p.Timestamp,
+		Identifier:  p.Identifier,
+		Note:        p.Note,
+		Expenses:    expenseIDs(p.Expenses),
+		GroupID:     p.GroupID,
+		Allocations: p.Allocations,
+		Attachments: p.Attachments,
+		Comments:    p.Comments,
+	}
+	if p.Payer != nil {
+		record.Payer = p.Payer.Id
+	}
+	if p.Payee != nil {
+		record.Payee = p.Payee.Id
+	}
+	return record
+}
+
+// NewInvitationRecord returns the saved form of the invitation.
+func NewInvitationRecord(i *group.Invitation) InvitationRecord {
+	return InvitationRecord{
+		ID:        i.ID,
+		GroupID:   i.GroupID,
+		Email:     i.Email,
+		Role:      i.Role,
+		InvitedBy: i.InvitedBy,
+		CreatedAt: i.CreatedAt,
+		ExpiresAt: i.ExpiresAt,
+		Status:    i.Status,
+		Uses:      i.Uses,
+		TokenHash: i.TokenHash,
+	}
+}
+
+// NewRecurringExpenseRecord returns the saved form of the recurring expense.
+func NewRecurringExpenseRecord(r *models.RecurringExpense) RecurringExpenseRecord {
+	return RecurringExpenseRecord{
+		ID:          r.ID,
+		GroupID:     r.GroupID,
+		Template:    NewExpenseRecord(r.Template),
+		Rule:        r.Rule,
+		Start:       r.Schedule.Start,
+		Occurrences: r.Occurrences,
+		Last:        r.Last,
+		Next:        r.Next,
+	}
+}
+
+// NewActivityRecord returns the saved form of the activity.
+func NewActivityRecord(a *group.Activity) ActivityRecord {
+	return ActivityRecord{
+		ID:        a.ID,
+		GroupID:   a.GroupID,
+		Actor:     a.Actor,
+		Verb:      a.Verb,
+		Subject:   a.Subject,
+		SubjectID: a.SubjectID,
+		Summary:   a.Summary,
+		Timestamp: a.Timestamp,
+	}
+}
+
+// NewAuditRecord returns the saved form of the audit log entry.
+func NewAuditRecord(e *audit.Entry) AuditRecord {
+	return AuditRecord{
+		Seq:       e.Seq,
+		Timestamp: e.Timestamp,
+		Actor:     e.Actor,
+		Action:    e.Action,
+		Entity:    e.Entity,
+		EntityID:  e.EntityID,
+		Before:    string(e.Before),
+		After:     string(e.After),
+		PrevHash:  e.PrevHash,
+		Hash:      e.Hash,
+	}
+}
+
+// NewJournalRecord returns the saved form of the journal entry.
+func NewJournalRecord(e *journal.Entry) JournalRecord {
+	postings := make([]PostingRecord, len(e.Postings))
+	for i, posting := range e.Postings {
+		postings[i] = PostingRecord{
+			Group:        posting.Group,
+			Account:      posting.Account.Id,
+			Counterparty: posting.Counterparty.Id,
+			Amount:       posting.Amount,
+			Currency:     posting.Currency,
+			Original:     posting.Original,
+		}
+	}
+	return JournalRecord{ID: e.ID, Kind: e.Kind, Reference: e.Reference, Reverses: e.Reverses, Timestamp: e.Timestamp, Postings: postings}
+}
+
+// rawJSON returns the JSON text as a json.RawMessage, nil if it is empty.
+func rawJSON(text string) json.RawMessage {
+	if text == "" {
+		return nil
+	}
+	return json.RawMessage(text)
+}
+
+// Restore links the records back into objects and returns them in an
+// in-memory store. Everything is ordered by ID, and new users, groups,
+// expenses, payments, invitations, recurring expenses and activities are
+// numbered after the highest stored ID, or for users, the highest in the
+// journal.
+func (s *Snapshot) Restore() (*Store, error) {
+	restored := NewMemory()
+
+	sort.Slice(s.Users, func(i, j int) bool { return s.Users[i].ID < s.Users[j].ID })
+	users := make(map[int32]*models.User, len(s.Users))
+	for _, record := range s.Users {
+		user := &models.User{Id: record.ID, Name: record.Name, Email: record.Email, PasswordHash: record.PasswordHash}
+		users[user.Id] = user
+		if err := restored.Users.Add(user); err != nil {
+			return nil, fmt.Errorf("user %d: %w", record.ID, err)
+		}
+		models.ResumeUserIDs(user.Id)
+	}
+	findUsers := func(ids []int32) ([]*models.User, error) {
+		found := make([]*models.User, len(ids))
+		for i, id := range ids {
+			if found[i] = users[id]; found[i] == nil {
+				return nil, fmt.Errorf("user %d: %w", id, ErrNotFound)
+			}
+		}
+		return found, nil
+	}
+
+	sort.Slice(s.Expenses, func(i, j int) bool { return s.Expenses[i].ID < s.Expenses[j].ID })
+	expenses := make(map[int]*models.Expense, len(s.Expenses))
+	for _, record := range s.Expenses {
+		expense, err := restoreExpense(record, users, findUsers)
+		if err != nil {
+			return nil, fmt.Errorf("expense %d: %w", record.ID, err)
+		}
+		expenses[expense.ID] = expense
+		if err := restored.Expenses.Add(expense); err != nil {
+			return nil, fmt.Errorf("expense %d: %w", record.ID, err)
+		}
+		models.ResumeExpenseIDs(int32(expense.ID))
+		resumeAttachmentIDs(expense.Attachments)
+		resumeCommentIDs(expense.Comments)
+	}
+	findExpenses := func(ids []int) ([]*models.Expense, error) {
+		found := make([]*models.Expense, len(ids))
+		for i, id := range ids {
+			if found[i] = expenses[id]; found[i] == nil {
+				return nil, fmt.Errorf("expense %d: %w", id, ErrNotFound)
+			}
+		}
+		return found, nil
+	}
+
+	sort.Slice(s.Payments, func(i, j int) bool { return s.Payments[i].ID < s.Payments[j].ID })
+	payments := make(map[int]*models.Payment, len(s.Payments))
+	for _, record := range s.Payments {
+		paymentExpenses, err := findExpenses(record.Expenses)
+		if err != nil {
+			return nil, fmt.Errorf("payment %d: %w", record.ID, err)
+		}
+		payment := &models.Payment{
+			ID:          record.ID,
+			Payer:       users[record.Payer],
+			Payee:       users[record.Payee],
+			Amount:      record.Amount,
+			Currency:    restoreCurrency(record.Currency),
+			Rate:        restoreRate(record.Rate),
+			Mode:        record.Mode,
+			Timestamp:   record.Timestamp,
+			Identifier:  record.Identifier,
+			Note:        record.Note,
+			Expenses:    paymentExpenses,
+			GroupID:     record.GroupID,
+			Allocations: record.Allocations,
+			Attachments: record.Attachments,
+			Comments:    record.Comments,
+		}
+		if payment.Payer == nil || payment.Payee == nil {
+			return nil, fmt.Errorf("payment %d: payer or payee %w", record.ID, ErrNotFound)
+		}
+		payments[payment.ID] = payment
+		if err := restored.Payments.Add(payment); err != nil {
+			return nil, fmt.Errorf("payment %d: %w", record.ID, err)
+		}
+		models.ResumePaymentIDs(int32(payment.ID))
+		resumeAttachmentIDs(payment.Attachments)
+		resumeCommentIDs(payment.Comments)
+	}
+
+	// Expenses link back to the payments that settle them
+	for _, record := range s.Expenses {
+		for _, id := range record.Payments {
+			payment := payments[id]
+			if payment == nil {
+				return nil, fmt.Errorf("expense %d: payment %d: %w", record.ID, id, ErrNotFound)
+			}
+			expenses[record.ID].Payments = append(expenses[record.ID].Payments, payment)
+		}
+	}
+
+	sort.Slice(s.Groups, func(i, j int) bool { return s.Groups[i].ID < s.Groups[j].ID })
+	for _, record := range s.Groups {
+		members, err := findUsers(record.Members)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %w", record.ID, err)
+		}
+		formerMembers, err := findUsers(record.FormerMembers)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: former member: %w", record.ID, err)
+		}
+		groupExpenses, err := findExpenses(record.Expenses)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %w", record.ID, err)
+		}
+		g := &group.Group{ID: record.ID, Name: record.Name, Currency: restoreCurrency(record.Currency), Rates: record.Rates, Members: members, Expenses: groupExpenses, Categories: record.Categories, CategoryRules: record.CategoryRules}
+		if record.Categories == nil {
+			// Groups saved before groups had categories start with the
+			// default ones, like new groups do
+			g.Categories = append([]string(nil), group.DefaultCategories...)
+		}
+		if len(formerMembers) > 0 {
+			g.FormerMembers = formerMembers
+		}
+		for _, t := range record.Transfers {
+			g.Transfers = append(g.Transfers, group.DebtTransfer{From: t.From, To: t.To, Amounts: t.Amounts, Timestamp: t.Timestamp})
+		}
+		for _, member := range members {
+			// Every member of a group saved before members had roles could
+			// manage it, so they keep doing so as its owners
+			role, ok := record.Roles[member.Id]
+			if record.Roles == nil {
+				role, ok = group.Owner, true
+			}
+			if ok {
+				g.SetRole(member.Id, role)
+			}
+		}
+		if err := restored.Groups.Add(g); err != nil {
+			return nil, fmt.Errorf("group %d: %w", record.ID, err)
+		}
+		group.ResumeGroupIDs(g.ID)
+	}
+
+	sort.Slice(s.Invitations, func(i, j int) bool { return s.Invitations[i].ID < s.Invitations[j].ID })
+	for _, record := range s.Invitations {
+		invitation := &group.Invitation{
+			ID:        record.ID,
+			GroupID:   record.GroupID,
+			Email:     record.Email,
+			Role:      record.Role,
+			InvitedBy: record.InvitedBy,
+			CreatedAt: record.CreatedAt,
+			ExpiresAt: record.ExpiresAt,
+			Status:    record.Status,
+			Uses:      record.Uses,
+			TokenHash: record.TokenHash,
+		}
+		if err := restored.Invitations.Add(invitation); err != nil {
+			return nil, fmt.Errorf("invitation %d: %w", record.ID, err)
+		}
+		group.ResumeInvitationIDs(invitation.ID)
+	}
+
+	sort.Slice(s.RecurringExpenses, func(i, j int) bool { return s.RecurringExpenses[i].ID < s.RecurringExpenses[j].ID })
+	for _, record := range s.RecurringExpenses {
+		template, err := restoreExpense(record.Template, users, findUsers)
+		if err != nil {
+			return nil, fmt.Errorf("recurring expense %d: %w", record.ID, err)
+		}
+		schedule, err := models.ParseSchedule(record.Rule, record.Start)
+		if err != nil {
+			return nil, fmt.Errorf("recurring expense %d: %w", record.ID, err)
+		}
+		recurring := &models.RecurringExpense{
+			ID:          record.ID,
+			GroupID:     record.GroupID,
+			Template:    template,
+			Schedule:    schedule,
+			Rule:        record.Rule,
+			Occurrences: record.Occurrences,
+			Last:        record.Last,
+			Next:        record.Next,
+		}
+		if err := restored.RecurringExpenses.Add(recurring); err != nil {
+			return nil, fmt.Errorf("recurring expense %d: %w", record.ID, err)
+		}
+		models.ResumeRecurringIDs(recurring.ID)
+	}
+
+	sort.Slice(s.Activities, func(i, j int) bool { return s.Activities[i].ID < s.Activities[j].ID })
+	for _, record := range s.Activities {
+		activity := &group.Activity{
+			ID:        record.ID,
+			GroupID:   record.GroupID,
+			Actor:     record.Actor,
+			Verb:      record.Verb,
+			Subject:   record.Subject,
+			SubjectID: record.SubjectID,
+			Summary:   record.Summary,
+			Timestamp: record.Timestamp,
+		}
+		if err := restored.Activities.Add(activity); err != nil {
+			return nil, fmt.Errorf("activity %d: %w", record.ID, err)
+		}
+		group.ResumeActivityIDs(activity.ID)
+	}
+
+	// The audit log is restored as it was saved, even if it has been tampered
+	// with, so that audit.Verify can report where
+	sort.Slice(s.AuditLog, func(i, j int) bool { return s.AuditLog[i].Seq < s.AuditLog[j].Seq })
+	for _, record := range s.AuditLog {
+		entry := &audit.Entry{
+			Seq:       record.Seq,
+			Timestamp: record.Timestamp,
+			Actor:     record.Actor,
+			Action:    record.Action,
+			Entity:    record.Entity,
+			EntityID:  record.EntityID,
+			Before:    rawJSON(record.Before),
+			After:     rawJSON(record.After),
+			PrevHash:  record.PrevHash,
+			Hash:      record.Hash,
+		}
+		if err := restored.AuditLog.Append(entry); err != nil {
+			return nil, fmt.Errorf("audit log entry %d: %w", record.Seq, err)
+		}
+	}
+
+	// Journal entries outlive the users in them, who can be deleted once
+	// their expenses and payments are, so those users are stood in for by
+	// users with only their ID
+	sort.Slice(s.Journal, func(i, j int) bool { return s.Journal[i].ID < s.Journal[j].ID })
+	journalUser := func(id int32) *models.User {
+		if users[id] == nil {
+			users[id] = &models.User{Id: id}
+			models.ResumeUserIDs(id)
+		}
+		return users[id]
+	}
+	for _, record := range s.Journal {
+		entry := &journal.Entry{ID: record.ID, Kind: record.Kind, Reference: record.Reference, Reverses: record.Reverses, Timestamp: record.Timestamp}
+		for _, posting := range record.Postings {
+			entry.Postings = append(entry.Postings, journal.Posting{
+				Group:        posting.Group,
+				Account:      journalUser(posting.Account),
+				Counterparty: journalUser(posting.Counterparty),
+				Amount:       posting.Amount,
+				Currency:     posting.Currency,
+				Original:     posting.Original,
+			})
+		}
+		if err := restored.Journal.Append(entry); err != nil {
+			return nil, fmt.Errorf("journal entry %d: %w", record.ID, err)
+		}
+	}
+	return restored, nil
+}
+
+func restoreExpense(record ExpenseRecord, users map[int32]*models.User, findUsers func([]int32) ([]*models.User, error)) (*models.Expense, error) {
+	paidBy := users[record.PaidBy]
+	if paidBy == nil {
+		return nil, fmt.Errorf("paidBy user %d: %w", record.PaidBy, ErrNotFound)
+	}
+	splitBetween, err := findUsers(record.SplitBetween)
+	if err != nil {
+		return nil, err
+	}
+	var items []*models.LineItem
+	for _, item := range record.Items {
+		consumers, err := findUsers(item.Consumers)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &models.LineItem{Description: item.Description, Amount: item.Amount, Consumers: consumers})
+	}
+	return &models.Expense{
+		ID:               record.ID,
+		Description:      record.Description,
+		Category:         record.Category,
+		Tags:             record.Tags,
+		Amount:           record.Amount,
+		Currency:         restoreCurrency(record.Currency),
+		Rate:             restoreRate(record.Rate),
+		PaidBy:           paidBy,
+		SplitBetween:     splitBetween,
+		SplitType:        record.SplitType,
+		SplitRate:        record.SplitRate,
+		SplitAdjustments: record.SplitAdjustments,
+		Items:            items,
+		Charges:          record.Charges,
+		RemainingAmount:  record.RemainingAmount,
+		Timestamp:        record.Timestamp,
+		Rounding:         record.Rounding,
+		RoundingSeed:     record.RoundingSeed,
+		Recurring:        record.Recurring,
+		Attachments:      record.Attachments,
+		Comments:         record.Comments,
+	}, nil
+}
+
+// resumeAttachmentIDs makes new attachments number after those loaded.
+func resumeAttachmentIDs(attachments []*models.Attachment) {
+	for _, attachment := range attachments {
+		models.ResumeAttachmentIDs(attachment.ID)
+	}
+}
+
+// resumeCommentIDs makes new comments number after those loaded.
+func resumeCommentIDs(comments []*models.Comment) {
+	for _, comment := range comments {
+		models.ResumeCommentIDs(comment.ID)
+	}
+}
+
+// restoreCurrency returns the currency of a record, which is the default
+// currency for records saved before amounts had currencies.
+func restoreCurrency(currency models.Currency) models.Currency {
+	if currency == "" {
+		return models.DefaultCurrency
+	}
+	return currency
+}
+
+// restoreRate returns the exchange rate of a record, which is one for records
+// saved before amounts had currencies, since they were all in the same one.
+func restoreRate(rate models.Rate) models.Rate {
+	if rate == 0 {
+		return models.OneRate
+	}
+	return rate
+}
@@ -0,0 +1,146 @@
+// Package store defines the repositories the server keeps users, groups,
+// expenses, payments, invitations, recurring expenses, group activity, the
+// audit log and the balance journal in, together with an in-memory
+// implementation.
+//
+// Repositories hand out the same pointers they were given, so that an expense
+// and its group, or a payment and the expenses it settles, share objects just
+// as they do in memory. Changing an object does not save it; call Update on
+// its repository afterwards so that persistent implementations write it out.
+package store
+
+import (
+	"errors"
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/journal"
+	"splitwise/models"
+)
+
+var (
+	// ErrNotFound is returned when looking up something that isn't stored.
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when adding something whose ID is taken, or saving
+	// a user with another user's email address.
+	ErrExists = errors.New("already exists")
+)
+
+// UserRepository stores users by ID. No two users can have the same email
+// address, though users without one are allowed.
+type UserRepository interface {
+	Add(user *models.User) error
+	Get(id int32) (*models.User, error)
+	// FindByEmail returns the user with the email address.
+	FindByEmail(email string) (*models.User, error)
+	List() ([]*models.User, error)
+	Update(user *models.User) error
+	Delete(id int32) error
+}
+
+// GroupRepository stores groups by ID.
+type GroupRepository interface {
+	Add(g *group.Group) error
+	Get(id int32) (*group.Group, error)
+	// FindByExpense returns the group the expense belongs to.
+	FindByExpense(expenseID int) (*group.Group, error)
+	List() ([]*group.Group, error)
+	Update(g *group.Group) error
+	Delete(id int32) error
+}
+
+// ExpenseRepository stores expenses by ID.
+type ExpenseRepository interface {
+	Add(expense *models.Expense) error
+	Get(id int) (*models.Expense, error)
+	List() ([]*models.Expense, error)
+	Update(expense *models.Expense) error
+	Delete(id int) error
+}
+
+// PaymentRepository stores payments by ID.
+type PaymentRepository interface {
+	Add(payment *models.Payment) error
+	Get(id int) (*models.Payment, error)
+	List() ([]*models.Payment, error)
+	Update(payment *models.Payment) error
+	Delete(id int) error
+}
+
+// InvitationRepository stores invitations to join groups by ID.
+type InvitationRepository interface {
+	Add(invitation *group.Invitation) error
+	Get(id int) (*group.Invitation, error)
+	// FindByToken returns the invitation whose token has the hash.
+	FindByToken(tokenHash string) (*group.Invitation, error)
+	List() ([]*group.Invitation, error)
+	Update(invitation *group.Invitation) error
+	Delete(id int) error
+}
+
+// RecurringExpenseRepository stores recurring expenses by ID.
+type RecurringExpenseRepository interface {
+	Add(r *models.RecurringExpense) error
+	Get(id int) (*models.RecurringExpense, error)
+	List() ([]*models.RecurringExpense, error)
+	Update(r *models.RecurringExpense) error
+	Delete(id int) error
+}
+
+// ActivityRepository stores the activity of groups by ID. Activities are only
+// ever added, and deleted along with their group.
+type ActivityRepository interface {
+	Add(a *group.Activity) error
+	Get(id int) (*group.Activity, error)
+	List() ([]*group.Activity, error)
+	Delete(id int) error
+}
+
+// AuditRepository stores the audit log in sequence order. Entries are only
+// ever appended, and Append returns ErrExists for one that isn't numbered
+// after the last.
+type AuditRepository interface {
+	Append(e *audit.Entry) error
+	// Last returns the latest entry, or ErrNotFound if the log is empty.
+	Last() (*audit.Entry, error)
+	List() ([]*audit.Entry, error)
+}
+
+// JournalRepository stores the balance journal in the order entries were
+// posted. Entries are only ever appended, and Append returns ErrExists for one
+// that isn't numbered after the last. It is a journal.Log.
+type JournalRepository interface {
+	Append(e *journal.Entry) error
+	// Last returns the latest entry, or ErrNotFound if the journal is empty.
+	Last() (*journal.Entry, error)
+	List() ([]*journal.Entry, error)
+}
+
+// Store groups the repositories of one backend.
+type Store struct {
+	Users       UserRepository
+	Groups      GroupRepository
+	Expenses    ExpenseRepository
+	Payments    PaymentRepository
+	Invitations InvitationRepository
+
+	RecurringExpenses RecurringExpenseRepository
+	Activities        ActivityRepository
+	AuditLog          AuditRepository
+	Journal           JournalRepository
+	// Close releases the backend's resources, if it has any.
+	Close func() error
+
+	transaction func(fn func(tx *Store) error) error
+}
+
+// Transaction calls fn with a Store whose changes are saved together: if fn
+// returns an error, or the process crashes before it returns, none of them
+// are. Inside fn, reads don't see changes made by fn. Only backends that
+// implement Batcher support this; with other backends, and in memory, fn's
+// changes are made one by one as usual.
+func (s *Store) Transaction(fn func(tx *Store) error) error {
+	if s.transaction == nil {
+		return fn(s)
+	}
+	return s.transaction(fn)
+}
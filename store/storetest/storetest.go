@@ -0,0 +1,641 @@
+// Package storetest checks that a store.Store implementation behaves like the
+// in-memory one, so every backend can be tested with the same suite.
+package storetest
+
+import (
+	"encoding/json"
+	"errors"
+	"splitwise/audit"
+	"splitwise/group"
+	"splitwise/journal"
+	"splitwise/models"
+	"splitwise/store"
+	"testing"
+	"time"
+)
+
+// Run tests the repositories of stores returned by open. Every call to open
+// must return an empty store.
+func Run(t *testing.T, open func(t *testing.T) *store.Store) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, open(t)) })
+	t.Run("Groups", func(t *testing.T) { testGroups(t, open(t)) })
+	t.Run("Expenses", func(t *testing.T) { testExpenses(t, open(t)) })
+	t.Run("Payments", func(t *testing.T) { testPayments(t, open(t)) })
+	t.Run("Invitations", func(t *testing.T) { testInvitations(t, open(t)) })
+	t.Run("RecurringExpenses", func(t *testing.T) { testRecurringExpenses(t, open(t)) })
+	t.Run("Activities", func(t *testing.T) { testActivities(t, open(t)) })
+	t.Run("AuditLog", func(t *testing.T) { testAuditLog(t, open(t)) })
+	t.Run("Journal", func(t *testing.T) { testJournal(t, open(t)) })
+}
+
+func testUsers(t *testing.T, s *store.Store) {
+	alice := &models.User{Id: 1, Name: "Alice", Email: "alice@example.com"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	for _, user := range []*models.User{alice, bob} {
+		if err := s.Users.Add(user); err != nil {
+			t.Fatalf("Users.Add() error = %v", err)
+		}
+	}
+	if err := s.Users.Add(&models.User{Id: 1, Name: "Other"}); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Users.Add() of a taken ID error = %v, want %v", err, store.ErrExists)
+	}
+
+	if err := s.Users.Add(&models.User{Id: 3, Name: "Other", Email: "alice@example.com"}); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Users.Add() of a taken email error = %v, want %v", err, store.ErrExists)
+	}
+
+	if got, err := s.Users.Get(2); err != nil || got != bob {
+		t.Errorf("Users.Get(2) = %v, %v, want %v", got, err, bob)
+	}
+	if _, err := s.Users.Get(3); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Users.Get(3) error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	if got, err := s.Users.FindByEmail("alice@example.com"); err != nil || got != alice {
+		t.Errorf("Users.FindByEmail() = %v, %v, want %v", got, err, alice)
+	}
+	if _, err := s.Users.FindByEmail(""); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Users.FindByEmail() of no email error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	bob.Name, bob.Email = "Robert", "alice@example.com"
+	if err := s.Users.Update(bob); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Users.Update() to a taken email error = %v, want %v", err, store.ErrExists)
+	}
+	bob.Email = "robert@example.com"
+	if err := s.Users.Update(bob); err != nil {
+		t.Fatalf("Users.Update() error = %v", err)
+	}
+	if got, err := s.Users.FindByEmail("robert@example.com"); err != nil || got != bob {
+		t.Errorf("Users.FindByEmail() after Update() = %v, %v, want %v", got, err, bob)
+	}
+	users, err := s.Users.List()
+	if err != nil {
+		t.Fatalf("Users.List() error = %v", err)
+	}
+	if len(users) != 2 || users[0] != alice || users[1].Name != "Robert" {
+		t.Errorf("Users.List() = %v, want Alice and Robert", users)
+	}
+
+	if err := s.Users.Delete(1); err != nil {
+		t.Fatalf("Users.Delete() error = %v", err)
+	}
+	if _, err := s.Users.FindByEmail("alice@example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Users.FindByEmail() after Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if _, err := s.Users.Get(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Users.Get(1) after Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if err := s.Users.Delete(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("second Users.Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if users, err := s.Users.List(); err != nil || len(users) != 1 || users[0] != bob {
+		t.Errorf("Users.List() after Delete() = %v, %v, want Robert", users, err)
+	}
+}
+
+func testGroups(t *testing.T, s *store.Store) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	if err := s.Users.Add(alice); err != nil {
+		t.Fatalf("Users.Add() error = %v", err)
+	}
+	expense := &models.Expense{ID: 1, Amount: 1000, PaidBy: alice, SplitBetween: []*models.User{alice}, SplitType: models.SplitShares, SplitRate: []int64{1}, RemainingAmount: 1000}
+	if err := s.Expenses.Add(expense); err != nil {
+		t.Fatalf("Expenses.Add() error = %v", err)
+	}
+
+	trip := group.NewGroup("Trip", []*models.User{alice})
+	if err := s.Groups.Add(trip); err != nil {
+		t.Fatalf("Groups.Add() error = %v", err)
+	}
+	if err := s.Groups.Add(&group.Group{ID: trip.ID, Name: "Other"}); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Groups.Add() of a taken ID error = %v, want %v", err, store.ErrExists)
+	}
+	otherTrip := group.NewGroup("Trip", nil)
+	if err := s.Groups.Add(otherTrip); err != nil {
+		t.Errorf("Groups.Add() of a taken name error = %v, want nil", err)
+	}
+	if _, err := s.Groups.FindByExpense(expense.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Groups.FindByExpense() before adding the expense error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	trip.AddExpense(expense)
+	if err := s.Groups.Update(trip); err != nil {
+		t.Fatalf("Groups.Update() error = %v", err)
+	}
+	if got, err := s.Groups.FindByExpense(expense.ID); err != nil || got != trip {
+		t.Errorf("Groups.FindByExpense() = %v, %v, want %v", got, err, trip)
+	}
+	if got, err := s.Groups.Get(trip.ID); err != nil || got != trip {
+		t.Errorf("Groups.Get(%d) = %v, %v, want %v", trip.ID, got, err, trip)
+	}
+	if _, err := s.Groups.Get(-1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Groups.Get(-1) error = %v, want %v", err, store.ErrNotFound)
+	}
+	if groups, err := s.Groups.List(); err != nil || len(groups) != 2 || groups[0] != trip {
+		t.Errorf("Groups.List() = %v, %v, want both groups", groups, err)
+	}
+
+	if err := s.Groups.Delete(trip.ID); err != nil {
+		t.Fatalf("Groups.Delete() error = %v", err)
+	}
+	if _, err := s.Groups.Get(trip.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Groups.Get(%d) after Delete() error = %v, want %v", trip.ID, err, store.ErrNotFound)
+	}
+	if _, err := s.Groups.FindByExpense(expense.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Groups.FindByExpense() after Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if groups, err := s.Groups.List(); err != nil || len(groups) != 1 || groups[0] != otherTrip {
+		t.Errorf("Groups.List() after Delete() = %v, %v, want the other group", groups, err)
+	}
+}
+
+func testExpenses(t *testing.T, s *store.Store) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	for _, user := range []*models.User{alice, bob} {
+		if err := s.Users.Add(user); err != nil {
+			t.Fatalf("Users.Add() error = %v", err)
+		}
+	}
+
+	expense := &models.Expense{ID: 1, Amount: 1000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitType: models.SplitShares, SplitRate: []int64{1, 1}, RemainingAmount: 1000}
+	if err := s.Expenses.Add(expense); err != nil {
+		t.Fatalf("Expenses.Add() error = %v", err)
+	}
+	if err := s.Expenses.Add(expense); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expenses.Add() of a taken ID error = %v, want %v", err, store.ErrExists)
+	}
+
+	expense.Amount, expense.RemainingAmount = 2000, 2000
+	if err := s.Expenses.Update(expense); err != nil {
+		t.Fatalf("Expenses.Update() error = %v", err)
+	}
+	if got, err := s.Expenses.Get(1); err != nil || got.Amount != 2000 {
+		t.Errorf("Expenses.Get(1) = %v, %v, want an amount of 20.00", got, err)
+	}
+
+	if err := s.Expenses.Delete(1); err != nil {
+		t.Fatalf("Expenses.Delete() error = %v", err)
+	}
+	if _, err := s.Expenses.Get(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expenses.Get(1) after Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if err := s.Expenses.Delete(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("second Expenses.Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if expenses, err := s.Expenses.List(); err != nil || len(expenses) != 0 {
+		t.Errorf("Expenses.List() = %v, %v, want none", expenses, err)
+	}
+}
+
+func testPayments(t *testing.T, s *store.Store) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	for _, user := range []*models.User{alice, bob} {
+		if err := s.Users.Add(user); err != nil {
+			t.Fatalf("Users.Add() error = %v", err)
+		}
+	}
+
+	payment := &models.Payment{ID: 1, Payer: bob, Payee: alice, Amount: 500, Mode: models.Cash, GroupID: 1}
+	if err := s.Payments.Add(payment); err != nil {
+		t.Fatalf("Payments.Add() error = %v", err)
+	}
+	if err := s.Payments.Add(payment); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Payments.Add() of a taken ID error = %v, want %v", err, store.ErrExists)
+	}
+
+	payment.Note = "Dinner"
+	if err := s.Payments.Update(payment); err != nil {
+		t.Fatalf("Payments.Update() error = %v", err)
+	}
+	if got, err := s.Payments.Get(1); err != nil || got.Note != "Dinner" {
+		t.Errorf("Payments.Get(1) = %v, %v, want the note Dinner", got, err)
+	}
+	if _, err := s.Payments.Get(2); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Payments.Get(2) error = %v, want %v", err, store.ErrNotFound)
+	}
+	if payments, err := s.Payments.List(); err != nil || len(payments) != 1 {
+		t.Errorf("Payments.List() = %v, %v, want one payment", payments, err)
+	}
+
+	if err := s.Payments.Delete(1); err != nil {
+		t.Fatalf("Payments.Delete() error = %v", err)
+	}
+	if _, err := s.Payments.Get(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Payments.Get(1) after Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if err := s.Payments.Delete(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("second Payments.Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func testInvitations(t *testing.T, s *store.Store) {
+	invitation := &group.Invitation{ID: 1, GroupID: 1, Email: "bob@example.com", Role: group.Member, InvitedBy: 1, Status: group.Pending, TokenHash: "hash"}
+	if err := s.Invitations.Add(invitation); err != nil {
+		t.Fatalf("Invitations.Add() error = %v", err)
+	}
+	if err := s.Invitations.Add(invitation); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Invitations.Add() of a taken ID error = %v, want %v", err, store.ErrExists)
+	}
+
+	if got, err := s.Invitations.FindByToken("hash"); err != nil || got != invitation {
+		t.Errorf("Invitations.FindByToken() = %v, %v, want %v", got, err, invitation)
+	}
+	if _, err := s.Invitations.FindByToken("other"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Invitations.FindByToken() of an unknown token error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	invitation.Status = group.Accepted
+	if err := s.Invitations.Update(invitation); err != nil {
+		t.Fatalf("Invitations.Update() error = %v", err)
+	}
+	if got, err := s.Invitations.Get(1); err != nil || got.Status != group.Accepted {
+		t.Errorf("Invitations.Get(1) = %v, %v, want an accepted invitation", got, err)
+	}
+	if _, err := s.Invitations.Get(2); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Invitations.Get(2) error = %v, want %v", err, store.ErrNotFound)
+	}
+	if invitations, err := s.Invitations.List(); err != nil || len(invitations) != 1 {
+		t.Errorf("Invitations.List() = %v, %v, want one invitation", invitations, err)
+	}
+
+	if err := s.Invitations.Delete(1); err != nil {
+		t.Fatalf("Invitations.Delete() error = %v", err)
+	}
+	if _, err := s.Invitations.FindByToken("hash"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Invitations.FindByToken() after Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+	if err := s.Invitations.Delete(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("second Invitations.Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func testRecurringExpenses(t *testing.T, s *store.Store) {
+	alice := &models.User{Id: 1, Name: "Alice"}
+	if err := s.Users.Add(alice); err != nil {
+		t.Fatalf("Users.Add() error = %v", err)
+	}
+	schedule, err := models.ParseSchedule("FREQ=MONTHLY;BYMONTHDAY=1", time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+	template := &models.Expense{Amount: 1000, PaidBy: alice, SplitBetween: []*models.User{alice}, SplitType: models.SplitEqual}
+	rent := &models.RecurringExpense{ID: 1, GroupID: 1, Template: template, Schedule: schedule, Rule: schedule.Rule()}
+	if err := s.RecurringExpenses.Add(rent); err != nil {
+		t.Fatalf("RecurringExpenses.Add() error = %v", err)
+	}
+	if err := s.RecurringExpenses.Add(rent); !errors.Is(err, store.ErrExists) {
+		t.Errorf("RecurringExpenses.Add() of a taken ID error = %v, want %v", err, store.ErrExists)
+	}
+
+	rent.Occurrences = 2
+	if err := s.RecurringExpenses.Update(rent); err != nil {
+		t.Fatalf("RecurringExpenses.Update() error = %v", err)
+	}
+	if got, err := s.RecurringExpenses.Get(1); err != nil || got.Occurrences != 2 {
+		t.Errorf("RecurringExpenses.Get(1) = %v, %v, want two occurrences", got, err)
+	}
+	if _, err := s.RecurringExpenses.Get(2); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("RecurringExpenses.Get(2) error = %v, want %v", err, store.ErrNotFound)
+	}
+	if recurring, err := s.RecurringExpenses.List(); err != nil || len(recurring) != 1 {
+		t.Errorf("RecurringExpenses.List() = %v, %v, want one recurring expense", recurring, err)
+	}
+
+	if err := s.RecurringExpenses.Delete(1); err != nil {
+		t.Fatalf("RecurringExpenses.Delete() error = %v", err)
+	}
+	if err := s.RecurringExpenses.Delete(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("second RecurringExpenses.Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func testActivities(t *testing.T, s *store.Store) {
+	created := &group.Activity{ID: 1, GroupID: 1, Actor: 1, Verb: group.Created, Subject: group.GroupSubject, SubjectID: 1, Timestamp: time.Now()}
+	joined := &group.Activity{ID: 2, GroupID: 1, Actor: 1, Verb: group.Joined, Subject: group.MemberSubject, SubjectID: 2, Timestamp: time.Now()}
+	for _, activity := range []*group.Activity{created, joined} {
+		if err := s.Activities.Add(activity); err != nil {
+			t.Fatalf("Activities.Add() error = %v", err)
+		}
+	}
+	if err := s.Activities.Add(created); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Activities.Add() of a taken ID error = %v, want %v", err, store.ErrExists)
+	}
+	if got, err := s.Activities.Get(2); err != nil || got.Verb != group.Joined {
+		t.Errorf("Activities.Get(2) = %v, %v, want the member joining", got, err)
+	}
+	if activities, err := s.Activities.List(); err != nil || len(activities) != 2 || activities[0].ID != 1 {
+		t.Errorf("Activities.List() = %v, %v, want both in the order they were added", activities, err)
+	}
+
+	if err := s.Activities.Delete(1); err != nil {
+		t.Fatalf("Activities.Delete() error = %v", err)
+	}
+	if _, err := s.Activities.Get(1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Activities.Get(1) after Delete() error = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func testAuditLog(t *testing.T, s *store.Store) {
+	if _, err := s.AuditLog.Last(); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("AuditLog.Last() of an empty log error = %v, want %v", err, store.ErrNotFound)
+	}
+	created := audit.Next(nil, 1, audit.Created, audit.User, 1, nil, json.RawMessage(`{"id":1}`))
+	deleted := audit.Next(created, 1, audit.Deleted, audit.User, 1, created.After, nil)
+	for _, e := range []*audit.Entry{created, deleted} {
+		if err := s.AuditLog.Append(e); err != nil {
+			t.Fatalf("AuditLog.Append() error = %v", err)
+		}
+	}
+	if err := s.AuditLog.Append(created); !errors.Is(err, store.ErrExists) {
+		t.Errorf("AuditLog.Append() of an earlier entry error = %v, want %v", err, store.ErrExists)
+	}
+	if got, err := s.AuditLog.Last(); err != nil || got.Seq != 2 {
+		t.Errorf("AuditLog.Last() = %v, %v, want the deletion", got, err)
+	}
+	if entries, err := s.AuditLog.List(); err != nil || len(entries) != 2 || entries[0].Seq != 1 {
+		t.Errorf("AuditLog.List() = %v, %v, want both in the order they were appended", entries, err)
+	}
+}
+
+func testJournal(t *testing.T, s *store.Store) {
+	if _, err := s.Journal.Last(); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Journal.Last() of an empty journal error = %v, want %v", err, store.ErrNotFound)
+	}
+	alice := &models.User{Id: 1, Name: "Alice"}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	dinner := &journal.Entry{ID: 1, Kind: journal.ExpenseEntry, Reference: 1, Timestamp: time.Now(), Postings: []journal.Posting{
+		{Group: 1, Account: alice, Counterparty: bob, Amount: 500, Currency: models.DefaultCurrency, Original: 500},
+		{Group: 1, Account: bob, Counterparty: alice, Amount: -500, Currency: models.DefaultCurrency, Original: -500},
+	}}
+	reversal := &journal.Entry{ID: 2, Kind: journal.ReversalEntry, Reference: 1, Reverses: 1, Timestamp: time.Now()}
+	for _, e := range []*journal.Entry{dinner, reversal} {
+		if err := s.Journal.Append(e); err != nil {
+			t.Fatalf("Journal.Append() error = %v", err)
+		}
+	}
+	if err := s.Journal.Append(dinner); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Journal.Append() of an earlier entry error = %v, want %v", err, store.ErrExists)
+	}
+	if got, err := s.Journal.Last(); err != nil || got.ID != 2 {
+		t.Errorf("Journal.Last() = %v, %v, want the reversal", got, err)
+	}
+	if entries, err := s.Journal.List(); err != nil || len(entries) != 2 || entries[0].ID != 1 {
+		t.Errorf("Journal.List() = %v, %v, want both in the order they were appended", entries, err)
+	}
+}
+
+// RunPersistence tests that everything written to a store returned by open is
+// read back, with all references between objects intact, by the next store
+// open returns once the first one is closed.
+func RunPersistence(t *testing.T, open func(t *testing.T) *store.Store) {
+	s := open(t)
+	alice := &models.User{Id: 1, Name: "Alice", Email: "alice@example.com", PasswordHash: []byte("hash")}
+	bob := &models.User{Id: 2, Name: "Bob"}
+	carol := &models.User{Id: 3, Name: "Carol"}
+	for _, user := range []*models.User{alice, bob, carol} {
+		if err := s.Users.Add(user); err != nil {
+			t.Fatalf("Users.Add() error = %v", err)
+		}
+	}
+	expense := &models.Expense{ID: 1, Description: "Dinner", Category: "Food", Tags: []string{"paris"}, Amount: 1000, Currency: "EUR", Rate: 108000000, PaidBy: alice, SplitBetween: []*models.User{alice, bob}, SplitType: models.SplitShares, SplitRate: []int64{1, 1}, RemainingAmount: 1000, Rounding: models.RoundLargestRemainder}
+	if err := s.Expenses.Add(expense); err != nil {
+		t.Fatalf("Expenses.Add() error = %v", err)
+	}
+	trip := group.NewGroup("Trip", []*models.User{alice, bob})
+	trip.Currency, trip.Rates = "USD", map[models.Currency]models.Rate{"EUR": 108000000}
+	trip.Categories = []string{"Food", "Transport"}
+	trip.CategoryRules = []group.CategoryRule{{Category: "Transport", Keywords: []string{"uber", "taxi"}, PaidBy: bob.Id}}
+	trip.SetRole(alice.Id, group.Owner)
+	trip.SetRole(bob.Id, group.Viewer)
+	trip.AddMember(carol)
+	trip.RemoveMember(carol.Id)
+	trip.Transfers = []group.DebtTransfer{{From: carol.Id, To: bob.Id, Amounts: map[int32]models.Money{alice.Id: -500}, Timestamp: time.Now()}}
+	trip.AddExpense(expense)
+	if err := s.Groups.Add(trip); err != nil {
+		t.Fatalf("Groups.Add() error = %v", err)
+	}
+	payment := &models.Payment{ID: 1, Payer: bob, Payee: alice, Amount: 300, Currency: "EUR", Rate: 109000000, Mode: models.Cash, GroupID: trip.ID, Expenses: []*models.Expense{expense}}
+	expense.Attachments = []*models.Attachment{{ID: 1, Name: "receipt.pdf", ContentType: "application/pdf", Size: 1024, Checksum: "abc", UploadedBy: alice.Id, UploadedAt: time.Now()}}
+	payment.Attachments = []*models.Attachment{{ID: 2, Name: "transfer.png", ContentType: "image/png", Size: 2048, Checksum: "def", UploadedBy: bob.Id, UploadedAt: time.Now()}}
+	edited := time.Now()
+	expense.Comments = []*models.Comment{{ID: 1, Author: bob.Id, Body: "Why so much?", CreatedAt: time.Now(), EditedAt: &edited}}
+	payment.Comments = []*models.Comment{{ID: 2, Author: alice.Id, Body: "Thanks!", CreatedAt: time.Now()}}
+	if _, err := payment.SettlePayment(); err != nil {
+		t.Fatalf("SettlePayment() error = %v", err)
+	}
+	if err := s.Payments.Add(payment); err != nil {
+		t.Fatalf("Payments.Add() error = %v", err)
+	}
+	if err := s.Expenses.Update(expense); err != nil {
+		t.Fatalf("Expenses.Update() error = %v", err)
+	}
+	link, token, err := group.NewInvitation(trip.ID, "", group.Member, alice.Id, time.Hour)
+	if err != nil {
+		t.Fatalf("NewInvitation() error = %v", err)
+	}
+	if err := s.Invitations.Add(link); err != nil {
+		t.Fatalf("Invitations.Add() error = %v", err)
+	}
+	schedule, err := models.ParseSchedule("FREQ=WEEKLY;INTERVAL=2;COUNT=5", time.Date(2026, time.January, 1, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+	cleaning := &models.RecurringExpense{ID: 1, GroupID: trip.ID, Template: &models.Expense{Amount: 4000, Currency: "EUR", PaidBy: bob, SplitBetween: []*models.User{alice, bob}, SplitType: models.SplitEqual}, Schedule: schedule, Rule: schedule.Rule()}
+	cleaning.Next = &schedule.Start
+	cleaning.Advance()
+	if err := s.RecurringExpenses.Add(cleaning); err != nil {
+		t.Fatalf("RecurringExpenses.Add() error = %v", err)
+	}
+	activity := &group.Activity{ID: 1, GroupID: trip.ID, Actor: alice.Id, Verb: group.Created, Subject: group.ExpenseSubject, SubjectID: expense.ID, Summary: "Added Dinner", Timestamp: time.Now()}
+	scheduled := &group.Activity{ID: 2, GroupID: trip.ID, Verb: group.Created, Subject: group.ExpenseSubject, SubjectID: 2, Timestamp: time.Now()}
+	for _, a := range []*group.Activity{activity, scheduled} {
+		if err := s.Activities.Add(a); err != nil {
+			t.Fatalf("Activities.Add() error = %v", err)
+		}
+	}
+	var lastAudit *audit.Entry
+	for _, e := range []struct {
+		actor         int32
+		action        audit.Action
+		before, after string
+	}{
+		{alice.Id, audit.Updated, `{"id":1,"name":"Ali"}`, `{"id":1,"name":"Alice"}`},
+		{0, audit.Created, "", `{"id":2, "amount":4000}`},
+	} {
+		lastAudit = audit.Next(lastAudit, e.actor, e.action, audit.Expense, expense.ID, rawJSON(e.before), rawJSON(e.after))
+		if err := s.AuditLog.Append(lastAudit); err != nil {
+			t.Fatalf("AuditLog.Append() error = %v", err)
+		}
+	}
+	dave := &models.User{Id: 4, Name: "Dave"} // Deleted since, so never stored
+	for _, e := range []*journal.Entry{
+		{ID: 1, Kind: journal.ExpenseEntry, Reference: expense.ID, Timestamp: time.Now(), Postings: []journal.Posting{
+			{Group: trip.ID, Account: alice, Counterparty: bob, Amount: 540, Currency: "EUR", Original: 500},
+			{Group: trip.ID, Account: bob, Counterparty: alice, Amount: -540, Currency: "EUR", Original: -500},
+		}},
+		{ID: 2, Kind: journal.PaymentEntry, Reference: 7, Timestamp: time.Now(), Postings: []journal.Posting{
+			{Group: trip.ID, Account: dave, Counterparty: alice, Amount: 100, Currency: "USD", Original: 100},
+			{Group: trip.ID, Account: alice, Counterparty: dave, Amount: -100, Currency: "USD", Original: -100},
+		}},
+		{ID: 3, Kind: journal.ReversalEntry, Reference: 7, Reverses: 2, Timestamp: time.Now(), Postings: []journal.Posting{
+			{Group: trip.ID, Account: dave, Counterparty: alice, Amount: -100, Currency: "USD", Original: -100},
+			{Group: trip.ID, Account: alice, Counterparty: dave, Amount: 100, Currency: "USD", Original: 100},
+		}},
+	} {
+		if err := s.Journal.Append(e); err != nil {
+			t.Fatalf("Journal.Append() error = %v", err)
+		}
+	}
+	house := group.NewGroup("House", nil)
+	if err := s.Groups.Add(house); err != nil {
+		t.Fatalf("Groups.Add() error = %v", err)
+	}
+	if err := s.Groups.Delete(house.ID); err != nil {
+		t.Fatalf("Groups.Delete() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	s = open(t)
+	gotAlice, err := s.Users.Get(1)
+	if err != nil || gotAlice.Name != "Alice" || gotAlice.Email != "alice@example.com" || string(gotAlice.PasswordHash) != "hash" {
+		t.Fatalf("Users.Get(1) after reopening = %v, %v, want Alice with her email and password hash", gotAlice, err)
+	}
+	if got, err := s.Users.FindByEmail("alice@example.com"); err != nil || got != gotAlice {
+		t.Errorf("Users.FindByEmail() after reopening = %v, %v, want Alice", got, err)
+	}
+	gotExpense, err := s.Expenses.Get(1)
+	if err != nil {
+		t.Fatalf("Expenses.Get(1) after reopening error = %v", err)
+	}
+	if gotExpense.Amount != 1000 || gotExpense.RemainingAmount != 700 || gotExpense.PaidBy != gotAlice {
+		t.Errorf("Expenses.Get(1) after reopening = %+v, want 10.00 paid by Alice with 7.00 remaining", gotExpense)
+	}
+	if gotExpense.Description != "Dinner" || gotExpense.Category != "Food" || len(gotExpense.Tags) != 1 || gotExpense.Tags[0] != "paris" {
+		t.Errorf("expense labels after reopening = %q in %q tagged %v, want Dinner in Food tagged paris", gotExpense.Description, gotExpense.Category, gotExpense.Tags)
+	}
+	if gotExpense.Currency != "EUR" || gotExpense.Rate != 108000000 {
+		t.Errorf("expense currency after reopening = %s at %s, want EUR at 1.08", gotExpense.Currency, gotExpense.Rate)
+	}
+	gotPayment, err := s.Payments.Get(1)
+	if err != nil {
+		t.Fatalf("Payments.Get(1) after reopening error = %v", err)
+	}
+	if gotPayment.GroupID != trip.ID {
+		t.Errorf("payment group after reopening = %d, want %d", gotPayment.GroupID, trip.ID)
+	}
+	if gotPayment.Currency != "EUR" || gotPayment.Rate != 109000000 {
+		t.Errorf("payment currency after reopening = %s at %s, want EUR at 1.09", gotPayment.Currency, gotPayment.Rate)
+	}
+	if len(gotExpense.Attachments) != 1 || gotExpense.Attachments[0].Name != "receipt.pdf" || gotExpense.Attachments[0].Size != 1024 || gotExpense.Attachments[0].Checksum != "abc" {
+		t.Errorf("expense attachments after reopening = %+v, want receipt.pdf", gotExpense.Attachments)
+	}
+	if len(gotPayment.Attachments) != 1 || gotPayment.Attachments[0].ContentType != "image/png" || gotPayment.Attachments[0].UploadedBy != bob.Id {
+		t.Errorf("payment attachments after reopening = %+v, want Bob's transfer.png", gotPayment.Attachments)
+	}
+	if len(gotExpense.Comments) != 1 || gotExpense.Comments[0].Author != bob.Id || gotExpense.Comments[0].Body != "Why so much?" || gotExpense.Comments[0].EditedAt == nil {
+		t.Errorf("expense comments after reopening = %+v, want Bob's edited question", gotExpense.Comments)
+	}
+	if len(gotPayment.Comments) != 1 || gotPayment.Comments[0].Body != "Thanks!" || gotPayment.Comments[0].EditedAt != nil {
+		t.Errorf("payment comments after reopening = %+v, want Alice's thanks", gotPayment.Comments)
+	}
+	if len(gotPayment.Expenses) != 1 || gotPayment.Expenses[0] != gotExpense {
+		t.Errorf("payment expenses after reopening = %v, want the stored expense", gotPayment.Expenses)
+	}
+	if len(gotExpense.Payments) != 1 || gotExpense.Payments[0] != gotPayment {
+		t.Errorf("expense payments after reopening = %v, want the stored payment", gotExpense.Payments)
+	}
+	if len(gotPayment.Allocations) != 1 || gotPayment.Allocations[0] != (models.Allocation{Expense: 1, Amount: 300}) {
+		t.Errorf("payment allocations after reopening = %v, want 3.00 to expense 1", gotPayment.Allocations)
+	}
+	gotTrip, err := s.Groups.FindByExpense(1)
+	if err != nil || gotTrip.ID != trip.ID || gotTrip.Name != "Trip" || len(gotTrip.Members) != 2 || gotTrip.Members[0] != gotAlice || gotTrip.Expenses[0] != gotExpense {
+		t.Fatalf("Groups.FindByExpense(1) after reopening = %+v, %v, want Trip with the stored members and expense", gotTrip, err)
+	}
+	if gotTrip.Currency != "USD" || len(gotTrip.Rates) != 1 || gotTrip.Rates["EUR"] != 108000000 {
+		t.Errorf("group currency after reopening = %s with rates %v, want USD with EUR at 1.08", gotTrip.Currency, gotTrip.Rates)
+	}
+	if len(gotTrip.Categories) != 2 || gotTrip.Categories[1] != "Transport" || len(gotTrip.CategoryRules) != 1 || gotTrip.CategoryRules[0].PaidBy != bob.Id || len(gotTrip.CategoryRules[0].Keywords) != 2 {
+		t.Errorf("group categories after reopening = %v with rules %+v, want Food and Transport with Bob's taxis as Transport", gotTrip.Categories, gotTrip.CategoryRules)
+	}
+	if gotTrip.RoleOf(1) != group.Owner || gotTrip.RoleOf(2) != group.Viewer {
+		t.Errorf("member roles after reopening = %v, want Alice owner and Bob viewer", gotTrip.Roles)
+	}
+	if len(gotTrip.FormerMembers) != 1 || gotTrip.FormerMembers[0].Name != "Carol" {
+		t.Errorf("former members after reopening = %v, want Carol", gotTrip.FormerMembers)
+	}
+	if len(gotTrip.Transfers) != 1 || gotTrip.Transfers[0].From != carol.Id || gotTrip.Transfers[0].Amounts[alice.Id] != -500 {
+		t.Errorf("debt transfers after reopening = %+v, want Carol's debt of 5.00 to Alice", gotTrip.Transfers)
+	}
+	if _, err := s.Groups.Get(house.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Groups.Get(%d) of a deleted group after reopening error = %v, want %v", house.ID, err, store.ErrNotFound)
+	}
+	gotLink, err := s.Invitations.FindByToken(group.HashToken(token))
+	if err != nil || gotLink.ID != link.ID || gotLink.GroupID != trip.ID || gotLink.Role != group.Member || gotLink.StatusAt(time.Now()) != group.Pending {
+		t.Errorf("Invitations.FindByToken() after reopening = %+v, %v, want the pending join link to Trip", gotLink, err)
+	}
+	gotCleaning, err := s.RecurringExpenses.Get(1)
+	if err != nil {
+		t.Fatalf("RecurringExpenses.Get(1) after reopening error = %v", err)
+	}
+	if gotCleaning.GroupID != trip.ID || gotCleaning.Template.PaidBy.Name != "Bob" || gotCleaning.Template.Amount != 4000 || gotCleaning.Template.Currency != "EUR" {
+		t.Errorf("recurring expense after reopening = %+v, want 40.00 EUR paid by Bob in Trip", gotCleaning)
+	}
+	if gotCleaning.Rule != cleaning.Rule || !gotCleaning.Schedule.Start.Equal(schedule.Start) || gotCleaning.Schedule.Count != 5 {
+		t.Errorf("recurring expense schedule after reopening = %+v, want %q from %v", gotCleaning.Schedule, cleaning.Rule, schedule.Start)
+	}
+	if gotCleaning.Occurrences != 1 || !gotCleaning.Last.Equal(schedule.Start) || !gotCleaning.Next.Equal(schedule.Start.AddDate(0, 0, 14)) {
+		t.Errorf("recurring expense progress after reopening = %d, last %v, next %v, want one made and the next two weeks on", gotCleaning.Occurrences, gotCleaning.Last, gotCleaning.Next)
+	}
+	activities, err := s.Activities.List()
+	if err != nil || len(activities) != 2 {
+		t.Fatalf("Activities.List() after reopening = %v, %v, want two activities", activities, err)
+	}
+	if got := activities[0]; got.GroupID != trip.ID || got.Actor != alice.Id || got.Verb != group.Created || got.Subject != group.ExpenseSubject || got.SubjectID != expense.ID || got.Summary != "Added Dinner" {
+		t.Errorf("activity after reopening = %+v, want Alice adding Dinner", got)
+	}
+	if activities[1].Actor != 0 {
+		t.Errorf("scheduled activity actor after reopening = %d, want none", activities[1].Actor)
+	}
+	entries, err := s.AuditLog.List()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("AuditLog.List() after reopening = %v, %v, want two entries", entries, err)
+	}
+	if err := audit.Verify(entries); err != nil {
+		t.Errorf("audit.Verify() after reopening error = %v, want the chain unbroken", err)
+	}
+	if got := entries[0]; got.Actor != alice.Id || got.Action != audit.Updated || string(got.Before) != `{"id":1,"name":"Ali"}` || string(got.After) != `{"id":1,"name":"Alice"}` {
+		t.Errorf("audit log entry after reopening = %+v, want Alice's change", got)
+	}
+	if got := entries[1]; got.Hash != lastAudit.Hash || got.Actor != 0 || got.Before != nil || string(got.After) != `{"id":2, "amount":4000}` {
+		t.Errorf("audit log entry after reopening = %+v, want the expense made on schedule as it was", got)
+	}
+	posted, err := s.Journal.List()
+	if err != nil || len(posted) != 3 {
+		t.Fatalf("Journal.List() after reopening = %v, %v, want three entries", posted, err)
+	}
+	if got := posted[0]; got.Kind != journal.ExpenseEntry || got.Reference != expense.ID || len(got.Postings) != 2 {
+		t.Fatalf("journal entry after reopening = %+v, want the dinner", got)
+	}
+	if got := posted[0].Postings[0]; got.Account != gotAlice || got.Counterparty.Name != "Bob" || got.Group != trip.ID || got.Amount != 540 || got.Currency != "EUR" || got.Original != 500 {
+		t.Errorf("journal posting after reopening = %+v, want Bob owing Alice 5.40 for 5.00 EUR", got)
+	}
+	if got := posted[2]; got.Kind != journal.ReversalEntry || got.Reverses != 2 || got.Postings[0].Account.Id != dave.Id {
+		t.Errorf("journal entry after reopening = %+v, want the reversal of Dave's payment", got)
+	}
+	if _, err := s.Users.Get(dave.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Users.Get(%d) of a user only in the journal error = %v, want %v", dave.Id, err, store.ErrNotFound)
+	}
+}
+
+// rawJSON returns the JSON text as a json.RawMessage, nil if it is empty.
+func rawJSON(text string) json.RawMessage {
+	if text == "" {
+		return nil
+	}
+	return json.RawMessage(text)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"splitwise/audit"
+	"splitwise/auth"
+	"splitwise/group"
+	"splitwise/ledger"
+	"splitwise/models"
+	"splitwise/store"
+	"strings"
+)
+
+// createUser handles POST /v1/users, which signs a new user up with their
+// email and password. It responds the same whether or not the email is
+// already signed up, so that it doesn't give away who is; the new user signs
+// in to find out their account. Invitations to their email address still
+// need their token to be accepted, since the address isn't verified.
+func createUser(c echo.Context) error {
+	var req createUserRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+	// The password is hashed before taking dataMu, so that signing up doesn't
+	// hold up other requests
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return internalError("Error hashing password", err)
+	}
+	dataMu.Lock()
+	defer dataMu.Unlock()
+	if findUserByEmail(req.Email) != nil {
+		infoLogger.Println("Ignored Sign Up For An Email Already Signed Up")
+		return c.NoContent(http.StatusAccepted)
+	}
+
+	user := models.NewUser(req.Name)
+	user.Email, user.PasswordHash = req.Email, hash
+	err = db.Transaction(func(tx *store.Store) error {
+		if err := tx.Users.Add(user); err != nil {
+			return err
+		}
+		return recordAudit(tx, user.Id, auditChange{audit.Created, user, nil})
+	})
+	if errors.Is(err, store.ErrExists) {
+		infoLogger.Println("Ignored Sign Up For An Email Already Signed Up")
+		return c.NoContent(http.StatusAccepted)
+	} else if err != nil {
+		return internalError("Error storing user", err)
+	}
+	infoLogger.Println("Created User With Id: ", user.Id)
+	return c.NoContent(http.StatusAccepted)
+}
+
+// getCurrentUser handles GET /v1/users/me, which returns the signed in user.
+func getCurrentUser(c echo.Context) error {
+	return c.JSON(http.StatusOK, currentUser(c))
+}
+
+func getUser(c echo.Context) error {
+	user, err := userFromParam(c)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Retrieved User With Id: ", user.Id)
+	return c.JSON(http.StatusOK, user)
+}
+
+// listUsers handles GET /v1/users, which lists the signed in user and everyone
+// who shares a group with them. ?name= only lists users whose name contains
+// it, ignoring case. Nobody else can be found, not even by email; people
+// outside the user's groups are invited by email instead.
+func listUsers(c echo.Context) error {
+	q := newListQuery(c)
+	name := strings.ToLower(c.QueryParam("name"))
+	users, err := visibleUsers(currentUser(c))
+	if err != nil {
+		return internalError("Error listing users", err)
+	}
+	var matching []*models.User
+	for _, user := range users {
+		if strings.Contains(strings.ToLower(user.Name), name) {
+			matching = append(matching, user)
+		}
+	}
+	result, err := paginate(q, matching, userSorts)
+	if err != nil {
+		return err
+	}
+	infoLogger.Println("Listing Users")
+	return c.JSON(http.StatusOK, result)
+}
+
+// userSorts are the orders users can be listed in.
+var userSorts = sortFields[*models.User]{
+	"id":   func(user *models.User) sortKey { return sortKey{ID: int(user.Id)} },
+	"name": func(user *models.User) sortKey { return sortKey{Text: strings.ToLower(user.Name), ID: int(user.Id)} },
+}
+
+// updateUser handles PUT /v1/users/:id, which renames the user. Users can
+// only rename themselves.
+func updateUser(c echo.Context) error {
+	user, err := userFromParam(c)
+	if err != nil {
+		return err
+	}
+	if user != currentUser(c) {
+		return forbidden("Users can only rename themselves")
+	}
+	var req userRequest
+	if err := bindRequest(c, &req); err != nil {
+		return err
+	}
+
+	state, err := auditState(user)
+	if err != nil {
+		return auditStateError(err)
+	}
+	name := user.Name
+	user.Name = req.Name
+	if err := db.Transaction(func(tx *store.Store) error {
+		if err := tx.Users.Update(user); err != nil {
+			return err
+		}
+		return recordAudit(tx, user.Id, auditChange{audit.Updated, user, state})
+	}); err != nil {
+		user.Name = name
+		return internalError("Error storing user", err)
+	}
+	infoLogger.Println("Updated User With Id: ", user.Id)
+	return c.JSON(http.StatusOK, user)
+}
+
+// deleteUser handles DELETE /v1/users/:id. Users can only delete themselves,
+// and not while they are still a member of a group or part of an expense,
+// payment or debt transfer. Deleting a user signs them out everywhere, and
+// groups they were a member of forget them.
+func deleteUser(c echo.Context) error {
+	user, err := userFromParam(c)
+	if err != nil {
+		return err
+	}
+	if user != currentUser(c) {
+		return forbidden("Users can only delete themselves")
+	}
+	if reason, err := userInUse(user); err != nil {
+		return internalError("Error checking user", err)
+	} else if reason != "" {
+		return conflict("", fmt.Sprintf("Cannot delete user %d: %s", user.Id, reason))
+	}
+
+	groups, err := db.Groups.List()
+	if err != nil {
+		return internalError("Error listing groups", err)
+	}
+	state, err := auditState(user)
+	if err != nil {
+		return auditStateError(err)
+	}
+	var formerGroups []*group.Group
+	var changes []auditChange
+	for _, g := range groups {
+		if g.IsFormerMember(user.Id) {
+			groupState, err := auditState(g)
+			if err != nil {
+				return auditStateError(err)
+			}
+			formerGroups = append(formerGroups, g)
+			changes = append(changes, auditChange{audit.Updated, g, groupState})
+		}
+	}
+	for _, g := range formerGroups {
+		g.ForgetFormerMember(user.Id)
+	}
+	if err := db.Transaction(func(tx *store.Store) error {
+		for _, g := range formerGroups {
+			if err := tx.Groups.Update(g); err != nil {
+				return err
+			}
+		}
+		if err := tx.Users.Delete(user.Id); err != nil {
+			return err
+		}
+		return recordAudit(tx, user.Id, append(changes, auditChange{audit.Deleted, user, state})...)
+	}); err != nil {
+		for _, g := range formerGroups {
+			g.FormerMembers = append(g.FormerMembers, user)
+		}
+		return internalError("Error deleting user", err)
+	}
+	sessions.EndAll(user.Id)
+	infoLogger.Println("Deleted User With Id: ", user.Id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// userInUse describes what still refers to the user, or returns "" if nothing
+// does.
+func userInUse(user *models.User) (string, error) {
+	groups, err := db.Groups.List()
+	if err != nil {
+		return "", err
+	}
+	for _, g := range groups {
+		if g.HasMember(user.Id) {
+			return fmt.Sprintf("they are a member of group %d", g.ID), nil
+		}
+		for _, transfer := range g.Transfers {
+			if _, ok := transfer.Amounts[user.Id]; ok || transfer.From == user.Id || transfer.To == user.Id {
+				return fmt.Sprintf("they are part of a debt transfer in group %d", g.ID), nil
+			}
+		}
+	}
+	expenses, err := db.Expenses.List()
+	if err != nil {
+		return "", err
+	}
+	for _, expense := range expenses {
+		if expense.PaidBy.Id == user.Id || containsUser(expense.SplitBetween, user.Id) {
+			return fmt.Sprintf("they are part of expense %d", expense.ID), nil
+		}
+	}
+	payments, err := db.Payments.List()
+	if err != nil {
+		return "", err
+	}
+	for _, payment := range payments {
+		if payment.Payer.Id == user.Id || payment.Payee.Id == user.Id {
+			return fmt.Sprintf("they are part of payment %d", payment.ID), nil
+		}
+	}
+	return "", nil
+}
+
+func containsUser(users []*models.User, id int32) bool {
+	for _, user := range users {
+		if user.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// userBalances is the response body of getUserBalances.
+type userBalances struct {
+	User     *models.User
+	Balances map[int32]models.Money // Net position in each group by group ID, positive if owed money
+	Debts    []ledger.Debt
+}
+
+// getUserBalances handles GET /v1/users/:id/balances. Only the user's balances
+// in groups the signed in user is also a member of are shown.
+func getUserBalances(c echo.Context) error {
+	user, err := userFromParam(c)
+	if err != nil {
+		return err
+	}
+	groups, err := memberGroups(currentUser(c))
+	if err != nil {
+		return internalError("Error listing groups", err)
+	}
+	result := userBalances{User: user, Balances: make(map[int32]models.Money)}
+	for groupID, balance := range debtLedger.UserBalances(user.Id) {
+		if groups[groupID] != nil {
+			result.Balances[groupID] = balance
+		}
+	}
+	for _, debt := range debtLedger.UserDebts(user.Id) {
+		if groups[debt.Group] != nil {
+			result.Debts = append(result.Debts, debt)
+		}
+	}
+	infoLogger.Println("Retrieved Balances For User: ", user.Id)
+	return c.JSON(http.StatusOK, result)
+}
+
+// userFromParam finds the user named by the :id path parameter. Users who
+// don't share a group with the signed in user are reported as not found.
+func userFromParam(c echo.Context) (*models.User, error) {
+	id, err := id32Param(c, "id")
+	if err != nil {
+		return nil, err
+	}
+	user := findUserByID(id)
+	if user != nil && user != currentUser(c) {
+		if shared, err := sharesGroup(currentUser(c), user); err != nil {
+			return nil, internalError("Error reading groups", err)
+		} else if !shared {
+			user = nil
+		}
+	}
+	if user == nil {
+		return nil, notFound("id", fmt.Sprintf("User %d not found", id))
+	}
+	return user, nil
+}
+
+// sharesGroup reports whether other is a member or former member of one of
+// user's groups.
+func sharesGroup(user, other *models.User) (bool, error) {
+	groups, err := db.Groups.List()
+	if err != nil {
+		return false, err
+	}
+	for _, g := range groups {
+		if g.HasMember(user.Id) && (g.HasMember(other.Id) || g.IsFormerMember(other.Id)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// visibleUsers returns the user and everyone who is or was a member of one of
+// their groups, in the order they signed up.
+func visibleUsers(user *models.User) ([]*models.User, error) {
+	groups, err := db.Groups.List()
+	if err != nil {
+		return nil, err
+	}
+	visible := map[int32]bool{user.Id: true}
+	for _, g := range groups {
+		if g.HasMember(user.Id) {
+			for _, member := range g.Members {
+				visible[member.Id] = true
+			}
+			for _, former := range g.FormerMembers {
+				visible[former.Id] = true
+			}
+		}
+	}
+	users, err := db.Users.List()
+	if err != nil {
+		return nil, err
+	}
+	var result []*models.User
+	for _, each := range users {
+		if visible[each.Id] {
+			result = append(result, each)
+		}
+	}
+	return result, nil
+}